@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"NimbusDb/configurations"
+	"fmt"
+	"strings"
+)
+
+// Action is an operation a policy can grant.
+type Action string
+
+const (
+	// ActionRead grants reading objects.
+	ActionRead Action = "read"
+	// ActionWrite grants writing objects.
+	ActionWrite Action = "write"
+	// ActionDelete grants deleting objects and buckets.
+	ActionDelete Action = "delete"
+	// ActionAdmin grants administering buckets: creating them, and the reports spanning every bucket.
+	ActionAdmin Action = "admin"
+)
+
+const (
+	// Wildcard matches any principal (in Principals) or any bucket (in Bucket).
+	Wildcard = "*"
+)
+
+// rule is a parsed policy grant.
+type rule struct {
+	principals map[string]bool
+	bucket     string
+	prefix     string
+	actions    map[Action]bool
+}
+
+// matches reports whether the rule grants the action on the bucket and key to the principal.
+func (r *rule) matches(principal, bucketName, key string, action Action) bool {
+	if !r.principals[principal] && !r.principals[Wildcard] {
+		return false
+	}
+	if r.bucket != Wildcard && r.bucket != bucketName {
+		return false
+	}
+	return r.actions[action] && strings.HasPrefix(key, r.prefix)
+}
+
+// Policy grants actions on buckets and key prefixes to principals.
+// Anything not granted by a rule is denied.
+// This type is read-only after creation and thread-safe.
+type Policy struct {
+	rules []rule
+}
+
+// NewPolicy builds a policy from configuration.
+//
+// params:
+//   - grants: The configured grants
+//
+// return:
+//   - *Policy: The policy
+//   - error: An error if a grant has no principal, no bucket, or an unknown action
+func NewPolicy(grants []configurations.AuthGrantConfig) (*Policy, error) {
+	p := &Policy{rules: make([]rule, 0, len(grants))}
+	for i, g := range grants {
+		if len(g.Principals) == 0 {
+			return nil, fmt.Errorf("auth grant %d must have at least one principal", i)
+		}
+		if g.Bucket == "" {
+			return nil, fmt.Errorf("auth grant %d must have a bucket (use %q for any bucket)", i, Wildcard)
+		}
+		if len(g.Actions) == 0 {
+			return nil, fmt.Errorf("auth grant %d must have at least one action", i)
+		}
+
+		r := rule{
+			principals: make(map[string]bool, len(g.Principals)),
+			bucket:     g.Bucket,
+			prefix:     g.Prefix,
+			actions:    make(map[Action]bool, len(g.Actions)),
+		}
+		for _, principal := range g.Principals {
+			r.principals[principal] = true
+		}
+		for _, a := range g.Actions {
+			action := Action(strings.ToLower(a))
+			switch action {
+			case ActionRead, ActionWrite, ActionDelete, ActionAdmin:
+				r.actions[action] = true
+			default:
+				return nil, fmt.Errorf("auth grant %d has unknown action %q, must be one of: read, write, delete, admin", i, a)
+			}
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+// Allows reports whether any rule grants the action on the bucket and key to the principal.
+//
+// params:
+//   - principal: The authenticated principal (NATS user or token subject)
+//   - bucketName: The bucket the request targets
+//   - key: The object key the request targets. Empty for bucket level operations.
+//   - action: The action to check
+//
+// return:
+//   - bool: True if the action is granted
+func (p *Policy) Allows(principal, bucketName, key string, action Action) bool {
+	for i := range p.rules {
+		if p.rules[i].matches(principal, bucketName, key, action) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"NimbusDb/configurations"
+	"testing"
+)
+
+func TestPolicy_Allows(t *testing.T) {
+	policy, err := NewPolicy([]configurations.AuthGrantConfig{
+		{Principals: []string{"orders-svc"}, Bucket: "orders", Actions: []string{"read", "write"}},
+		{Principals: []string{"reporting-job"}, Bucket: "orders", Prefix: "reports/", Actions: []string{"READ"}},
+		{Principals: []string{"*"}, Bucket: "public", Actions: []string{"read"}},
+		{Principals: []string{"ops"}, Bucket: "*", Actions: []string{"delete", "admin"}},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		principal string
+		bucket    string
+		key       string
+		action    Action
+		expected  bool
+	}{
+		{"granted write", "orders-svc", "orders", "a/b", ActionWrite, true},
+		{"action not granted", "orders-svc", "orders", "a/b", ActionDelete, false},
+		{"other bucket", "orders-svc", "users", "a/b", ActionRead, false},
+		{"inside prefix", "reporting-job", "orders", "reports/2024", ActionRead, true},
+		{"outside prefix", "reporting-job", "orders", "a/b", ActionRead, false},
+		{"wildcard principal", "anyone", "public", "logo.png", ActionRead, true},
+		{"wildcard bucket", "ops", "users", "", ActionDelete, true},
+		{"admin on any bucket", "ops", "*", "", ActionAdmin, true},
+		{"admin not granted", "orders-svc", "orders", "", ActionAdmin, false},
+		{"unknown principal", "intruder", "orders", "a/b", ActionRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Allows(tt.principal, tt.bucket, tt.key, tt.action); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewPolicy_InvalidGrant(t *testing.T) {
+	tests := []struct {
+		name  string
+		grant configurations.AuthGrantConfig
+	}{
+		{"no principals", configurations.AuthGrantConfig{Bucket: "orders", Actions: []string{"read"}}},
+		{"no bucket", configurations.AuthGrantConfig{Principals: []string{"svc"}, Actions: []string{"read"}}},
+		{"no actions", configurations.AuthGrantConfig{Principals: []string{"svc"}, Bucket: "orders"}},
+		{"unknown action", configurations.AuthGrantConfig{Principals: []string{"svc"}, Bucket: "orders", Actions: []string{"purge"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicy([]configurations.AuthGrantConfig{tt.grant}); err == nil {
+				t.Error("Expected NewPolicy() to fail")
+			}
+		})
+	}
+}
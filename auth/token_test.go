@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerifyToken(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+
+	token, err := SignToken(secret, Claims{Subject: "reporting-job", ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("SignToken() failed: %v", err)
+	}
+
+	claims, err := VerifyToken(secret, token, now)
+	if err != nil {
+		t.Fatalf("VerifyToken() failed: %v", err)
+	}
+	if claims.Subject != "reporting-job" {
+		t.Errorf("Expected subject reporting-job, got %s", claims.Subject)
+	}
+}
+
+func TestVerifyToken_Invalid(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+	token, err := SignToken(secret, Claims{Subject: "reporting-job"})
+	if err != nil {
+		t.Fatalf("SignToken() failed: %v", err)
+	}
+	forged, _ := SignToken([]byte("other-secret"), Claims{Subject: "admin"})
+	payload, _, _ := strings.Cut(forged, ".")
+	_, signature, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong secret", forged},
+		{"swapped claims", payload + "." + signature},
+		{"malformed", "not-a-token"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifyToken(secret, tt.token, now); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyToken_Expired(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+	token, err := SignToken(secret, Claims{Subject: "reporting-job", ExpiresAt: now.Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatalf("SignToken() failed: %v", err)
+	}
+
+	if _, err := VerifyToken(secret, token, now); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runBackupCommand runs the backup, restore or point-in-time restore command: a bucket is exported to a tar archive on local disk,
+// an archive is imported into a bucket, or a bucket is brought back to its state at a point in time.
+// Only the blob storage settings of the configuration are used.
+// Exits the process on failure.
+//
+// params:
+//   - command: configurations.CommandBackup, configurations.CommandRestore or configurations.CommandPointInTimeRestore
+//   - args: The arguments following the command
+func runBackupCommand(command string, args []string) {
+	backupArgs, err := configurations.ParseBackupArguments(command, args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Failed to parse %s arguments", command)
+	}
+
+	cfg := configurations.MustLoad(backupArgs.ConfigPath)
+	configurations.SetupLoggerWithLevel(cfg.LogLevel)
+
+	// Stop on interrupt, a partial archive is removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	storage, err := blob.NewStorage(ctx, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Str("provider", cfg.Blob.Provider).Msg("Failed to create blob storage")
+	}
+
+	switch command {
+	case configurations.CommandBackup:
+		exportBucket(ctx, storage, backupArgs)
+	case configurations.CommandRestore:
+		restoreBucket(ctx, storage, backupArgs)
+	case configurations.CommandPointInTimeRestore:
+		restoreToPointInTime(ctx, storage, backupArgs)
+	}
+}
+
+// exportBucket writes the backup archive of a bucket to the file given in the arguments.
+func exportBucket(ctx context.Context, storage blob.Storage, args *configurations.BackupArguments) {
+	file, err := os.OpenFile(args.File, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", args.File).Msg("Failed to create backup archive")
+	}
+
+	manifest, err := blob.ExportBucket(ctx, storage, args.Bucket, args.Prefix, file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(args.File)
+		log.Fatal().Err(err).Str("bucket", args.Bucket).Str("file", args.File).Msg("Failed to back up bucket")
+	}
+	log.Info().Str("bucket", args.Bucket).Str("prefix", args.Prefix).Str("file", args.File).Int("objects", len(manifest.Objects)).Msg("Bucket backed up")
+}
+
+// restoreBucket imports the backup archive given in the arguments into a bucket.
+func restoreBucket(ctx context.Context, storage blob.Storage, args *configurations.BackupArguments) {
+	file, err := os.Open(args.File)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", args.File).Msg("Failed to open backup archive")
+	}
+	defer file.Close()
+
+	report, err := blob.RestoreBucket(ctx, storage, file, args.Bucket)
+	if err != nil {
+		event := log.Fatal().Err(err).Str("bucket", args.Bucket).Str("file", args.File)
+		if report != nil {
+			event = event.Int64("restoredObjects", report.Objects)
+		}
+		event.Msg("Failed to restore bucket")
+	}
+	log.Info().Str("bucket", args.Bucket).Str("sourceBucket", report.SourceBucket).Str("file", args.File).Int64("objects", report.Objects).Int64("bytes", report.Bytes).Msg("Bucket restored")
+}
+
+// restoreToPointInTime brings a bucket back to its state at the time given in the arguments,
+// and prints the report (the planned changes on a dry run) as JSON on stdout.
+func restoreToPointInTime(ctx context.Context, storage blob.Storage, args *configurations.BackupArguments) {
+	report, err := blob.RestoreToPointInTime(ctx, storage, args.Bucket, args.Prefix, args.At, args.DryRun)
+	if report != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(report); encodeErr != nil {
+			log.Error().Err(encodeErr).Msg("Failed to print point-in-time restore report")
+		}
+	}
+	if err != nil {
+		event := log.Fatal().Err(err).Str("bucket", args.Bucket).Time("at", args.At)
+		if report != nil {
+			event = event.Int("applied", report.Applied).Int("changes", len(report.Changes))
+		}
+		event.Msg("Failed to restore bucket to point in time")
+	}
+	log.Info().Str("bucket", args.Bucket).Str("prefix", args.Prefix).Time("at", args.At).Bool("dryRun", args.DryRun).
+		Int("changes", len(report.Changes)).Int("applied", report.Applied).Int("unchanged", report.Unchanged).Int("unrestorable", len(report.Unrestorable)).
+		Msg("Bucket restored to point in time")
+}
@@ -0,0 +1,956 @@
+package blob
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+const (
+	// azureChecksumMetadataKey is the metadata key of the hex SHA-256 of a blob, Azure metadata keys cannot contain hyphens.
+	azureChecksumMetadataKey = "NimbusSha256"
+	// softDeleteRuleID is the ID the soft delete retention of the storage account is reported with, as a lifecycle rule.
+	softDeleteRuleID = "SoftDelete"
+	// azureDeletedAtTagKey is the blob index tag recording when a blob was deleted, on its last version.
+	azureDeletedAtTagKey = "NimbusDeletedAt"
+	// azureVersioningProbePrefix is the name prefix of the blob written to check that versioning is enabled.
+	azureVersioningProbePrefix = ".nimbus-versioning-probe-"
+	// azureRuleNamePrefix starts the names of the management policy rules Nimbus manages.
+	azureRuleNamePrefix = "Nimbus"
+)
+
+// AzureClient stores objects in Azure Blob Storage, buckets being containers.
+// Versioning and soft delete are settings of the storage account: versioning must be enabled on the account
+// (it cannot be set with an account key, buckets cannot be created or provisioned without it) unless the client is
+// unversioned (Azurite), soft delete is set when buckets are created or provisioned.
+// The version cleanup rules and retention policies are applied by the lifecycle management policy of the storage account,
+// set through Azure Resource Manager. For accounts Nimbus cannot manage there, the version cleanup rules can be applied
+// by CleanVersions instead: Azure does not record when a blob was deleted, so DeleteObject then tags the last version
+// of the blob with the deletion time.
+// Object lock is not supported.
+// This type is thread-safe.
+type AzureClient struct {
+	azureClient azureClientInterface
+	// management sets the lifecycle management policy of the storage account, nil if it is not managed by Nimbus.
+	management azureManagementInterface
+	config     *configurations.Config
+}
+
+// NewAzureClient creates an Azure Blob Storage client with the provided configuration, and checks the connection.
+// With a subscription ID, Azure Resource Manager is authenticated with the default Azure credential chain.
+//
+// params:
+//   - ctx: Context for the operation
+//   - cfg: Configuration containing the connection string, or the account name and key, and the Azure Resource Manager settings
+//
+// return:
+//   - *AzureClient: A new Azure blob client instance
+//   - error: An error if the client could not be initialized
+func NewAzureClient(ctx context.Context, cfg *configurations.Config) (*AzureClient, error) {
+	azure := cfg.Blob.Azure
+	var client *service.Client
+	var err error
+	switch {
+	case azure.ConnectionString != "":
+		client, err = service.NewClientFromConnectionString(azure.ConnectionString, nil)
+	case azure.AccountName != "" && azure.AccountKey != "":
+		var cred *service.SharedKeyCredential
+		cred, err = service.NewSharedKeyCredential(azure.AccountName, azure.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+		}
+		endpoint := azure.Endpoint
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", azure.AccountName)
+		}
+		client, err = service.NewClientWithSharedKeyCredential(endpoint, cred, nil)
+	default:
+		return nil, fmt.Errorf("connection string, or account name and account key, are required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %w", err)
+	}
+
+	// Test connection with timeout
+	adapter := newAzureClientAdapter(client)
+	testCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := adapter.ListContainers(testCtx); err != nil {
+		return nil, fmt.Errorf("failed to connect to Azure Blob Storage: %w", err)
+	}
+
+	var management azureManagementInterface
+	if azure.ManagementPolicies() {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure Resource Manager credential: %w", err)
+		}
+		policies, err := armstorage.NewManagementPoliciesClient(azure.SubscriptionID, cred, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure management policies client: %w", err)
+		}
+		management = newAzureManagementAdapter(policies, azure.ResourceGroup, azure.AccountName)
+	}
+
+	return NewAzureClientWithInterface(adapter, management, cfg), nil
+}
+
+// NewAzureClientWithInterface creates a new AzureClient with custom Azure client interfaces.
+// This is primarily used for testing with mock implementations.
+//
+// params:
+//   - azureClient: An implementation of azureClientInterface (can be a mock)
+//   - management: An implementation of azureManagementInterface (can be a mock), nil if the management policy is not managed by Nimbus
+//   - cfg: Configuration with the version cleanup delays and soft delete retention
+//
+// return:
+//   - *AzureClient: A new Azure blob client instance
+func NewAzureClientWithInterface(azureClient azureClientInterface, management azureManagementInterface, cfg *configurations.Config) *AzureClient {
+	return &AzureClient{
+		azureClient: azureClient,
+		management:  management,
+		config:      cfg,
+	}
+}
+
+// versioned reports whether blobs are versioned, i.e. the client is not in the unversioned mode of Azurite.
+func (c *AzureClient) versioned() bool {
+	return !c.config.Blob.Azure.Unversioned
+}
+
+// validateContainerName checks a bucket name against the naming rules of both S3 buckets and Azure containers:
+// Azure does not allow dots, nor consecutive hyphens.
+func validateContainerName(bucketName string) error {
+	if bucketName == "" {
+		return fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
+	}
+	if err := validateBucketName(bucketName); err != nil {
+		return err
+	}
+	if strings.Contains(bucketName, ".") || strings.Contains(bucketName, "--") {
+		return fmt.Errorf("%w: Azure container names cannot contain dots or consecutive hyphens: %s", ErrInvalidBucketName, bucketName)
+	}
+	return nil
+}
+
+// azureError maps an Azure error to ErrBucketNotFound or ErrObjectNotFound, or wraps it as the error of an operation.
+func azureError(bucketName, fileName, versionID, operation string, err error) error {
+	if bloberror.HasCode(err, bloberror.ContainerNotFound) {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	}
+	// Responses to HEAD requests have no error code, only a status
+	var respErr *azcore.ResponseError
+	if bloberror.HasCode(err, bloberror.BlobNotFound) || (errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound) {
+		return fmt.Errorf("%w: %s (version %q)", ErrObjectNotFound, fileName, versionID)
+	}
+	return fmt.Errorf("failed to %s %s: %w", operation, fileName, err)
+}
+
+// ensureBucketExists returns ErrBucketNotFound if the container does not exist.
+func (c *AzureClient) ensureBucketExists(ctx context.Context, bucketName string) error {
+	if bucketName == "" {
+		return fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
+	}
+	exists, err := c.azureClient.ContainerExists(ctx, bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to check if bucket exists: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	}
+	return nil
+}
+
+// ReadFile reads a blob version.
+// If versionID is empty, it reads the current version.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to read from
+//   - fileName: The name of the blob to read
+//   - versionID: Optional version ID to read a specific version. If empty, reads the current version.
+//
+// return:
+//   - []byte: The blob contents
+//   - error: ErrBucketNotFound, ErrObjectNotFound, or an error if the blob could not be read
+func (c *AzureClient) ReadFile(ctx context.Context, bucketName, fileName, versionID string) ([]byte, error) {
+	if bucketName == "" {
+		return nil, fmt.Errorf("bucket name cannot be empty")
+	}
+	if fileName == "" {
+		return nil, fmt.Errorf("file name cannot be empty")
+	}
+	data, err := c.azureClient.DownloadBlob(ctx, bucketName, fileName, versionID)
+	if err != nil {
+		return nil, azureError(bucketName, fileName, versionID, "read blob", err)
+	}
+	return data, nil
+}
+
+// WriteFile writes a new version of a block blob.
+// The SHA-256 of the data is stored in the blob metadata, so the scrubber can verify it later.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to write to
+//   - fileName: The name of the blob to write
+//   - data: The data to write
+//
+// return:
+//   - string: The version ID of the written blob, empty if versioning is not enabled on the storage account
+//   - error: ErrBucketNotFound, or an error if the blob could not be written
+func (c *AzureClient) WriteFile(ctx context.Context, bucketName, fileName string, data []byte) (string, error) {
+	return c.WriteFileWithOptions(ctx, bucketName, fileName, data, WriteOptions{})
+}
+
+// WriteFileWithOptions writes a new version of a block blob like WriteFile, with the user metadata of the options.
+// Azure metadata keys must be valid C# identifiers. IfNotExists is an If-None-Match: * condition checked by Azure.
+// IfVersion is not supported, Azure conditions only match ETags.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to write to
+//   - fileName: The name of the blob to write
+//   - data: The data to write
+//   - opts: The write options
+//
+// return:
+//   - string: The version ID of the written blob, empty if versioning is not enabled on the storage account
+//   - error: ErrPreconditionFailed if IfNotExists is set and the blob exists, ErrUnsupportedCondition if IfVersion is set, ErrBucketNotFound,
+//     or an error if the blob could not be written
+func (c *AzureClient) WriteFileWithOptions(ctx context.Context, bucketName, fileName string, data []byte, opts WriteOptions) (string, error) {
+	if bucketName == "" {
+		return "", fmt.Errorf("bucket name cannot be empty")
+	}
+	if fileName == "" {
+		return "", fmt.Errorf("file name cannot be empty")
+	}
+	if data == nil {
+		return "", fmt.Errorf("data cannot be nil")
+	}
+	if opts.IfVersion != "" {
+		return "", fmt.Errorf("%w: IfVersion on Azure storage", ErrUnsupportedCondition)
+	}
+	versionID, err := c.azureClient.UploadBlob(ctx, bucketName, fileName, data, withChecksum(opts.UserMetadata, azureChecksumMetadataKey, data), opts.IfNotExists)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.ContainerNotFound) {
+			return "", fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+		}
+		if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+			return "", fmt.Errorf("%w: %s exists in bucket %s", ErrPreconditionFailed, fileName, bucketName)
+		}
+		return "", fmt.Errorf("failed to write blob %s: %w", fileName, err)
+	}
+	return versionID, nil
+}
+
+// StatObject describes a blob version without reading its content.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container
+//   - fileName: The name of the blob
+//   - versionID: Optional version ID. If empty, describes the current version.
+//
+// return:
+//   - *ObjectInfo: The blob version, without ETag
+//   - error: ErrBucketNotFound, ErrObjectNotFound, or an error if it could not be described
+func (c *AzureClient) StatObject(ctx context.Context, bucketName, fileName, versionID string) (*ObjectInfo, error) {
+	b, err := c.azureClient.GetBlobProperties(ctx, bucketName, fileName, versionID)
+	if err != nil {
+		err = azureError(bucketName, fileName, versionID, "stat blob", err)
+		// HEAD responses have no error code, a missing container is a 404 too
+		if errors.Is(err, ErrObjectNotFound) {
+			if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	info := toObjectInfo(b)
+	info.UserMetadata = userMetadata(b.Metadata, azureChecksumMetadataKey)
+	info.Checksum, _ = storedChecksum(b.Metadata, azureChecksumMetadataKey)
+	return &info, nil
+}
+
+// FileExists checks if a blob has a current version.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to check
+//   - fileName: The name of the blob to check
+//
+// return:
+//   - bool: True if the blob exists, false otherwise
+//   - error: ErrBucketNotFound, or an error if the check fails
+func (c *AzureClient) FileExists(ctx context.Context, bucketName, fileName string) (bool, error) {
+	_, err := c.StatObject(ctx, bucketName, fileName, "")
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteObject deletes a blob. Without version ID, the base blob is deleted: with versioning its current version
+// is kept as a previous version, so it can be restored. With a version ID, that version is deleted, it stays
+// recoverable for the soft delete retention. Azure does not promote a previous version when the current one is deleted,
+// so deleting the current version by ID deletes the blob.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container
+//   - fileName: The name of the blob to delete
+//   - versionID: Optional version ID of the version to delete
+//
+// return:
+//   - error: ErrBucketNotFound, ErrObjectNotFound if the version does not exist, or an error if the blob could not be deleted
+func (c *AzureClient) DeleteObject(ctx context.Context, bucketName, fileName, versionID string) error {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return err
+	}
+	if versionID == "" {
+		current, err := c.azureClient.GetBlobProperties(ctx, bucketName, fileName, "")
+		if err != nil {
+			if errors.Is(azureError(bucketName, fileName, "", "", err), ErrObjectNotFound) {
+				return nil
+			}
+			return fmt.Errorf("failed to delete blob %s: %w", fileName, err)
+		}
+		err = c.azureClient.DeleteBlob(ctx, bucketName, fileName, "")
+		if err != nil && !errors.Is(azureError(bucketName, fileName, "", "", err), ErrObjectNotFound) {
+			return fmt.Errorf("failed to delete blob %s: %w", fileName, err)
+		}
+		// The version cleanup rules of CleanVersions expire a deleted blob from its deletion time
+		if current.VersionID != "" && c.config.Blob.Azure.ClientVersionCleanup {
+			return c.tagDeletion(ctx, bucketName, fileName, current.VersionID, time.Now())
+		}
+		return nil
+	}
+
+	b, err := c.azureClient.GetBlobProperties(ctx, bucketName, fileName, versionID)
+	if err != nil {
+		return azureError(bucketName, fileName, versionID, "delete blob", err)
+	}
+	// The current version cannot be deleted by ID, the base blob is deleted first
+	if b.IsCurrentVersion {
+		if err := c.azureClient.DeleteBlob(ctx, bucketName, fileName, ""); err != nil {
+			return azureError(bucketName, fileName, versionID, "delete blob", err)
+		}
+	}
+	if err := c.azureClient.DeleteBlob(ctx, bucketName, fileName, versionID); err != nil {
+		return azureError(bucketName, fileName, versionID, "delete blob", err)
+	}
+	return nil
+}
+
+// tagDeletion records the deletion time of a blob on its last version.
+func (c *AzureClient) tagDeletion(ctx context.Context, bucketName, fileName, versionID string, deletedAt time.Time) error {
+	tags := map[string]string{azureDeletedAtTagKey: deletedAt.UTC().Format(time.RFC3339)}
+	err := c.azureClient.SetBlobTags(ctx, bucketName, fileName, versionID, tags)
+	if err != nil && !errors.Is(azureError(bucketName, fileName, versionID, "", err), ErrObjectNotFound) {
+		return fmt.Errorf("failed to record the deletion time of blob %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// toObjectInfo describes a blob version. Azure ETags are not content hashes, so they are left empty.
+func toObjectInfo(b azureBlob) ObjectInfo {
+	return ObjectInfo{Key: b.Name, VersionID: b.VersionID, Size: b.Size, LastModified: b.LastModified.UTC()}
+}
+
+// ListObjects lists the current blobs of a container, or of a name prefix, sorted by name.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container
+//   - prefix: Only list names starting with it. Empty lists the whole container.
+//
+// return:
+//   - []ObjectInfo: The current blob versions
+//   - error: ErrBucketNotFound if the container does not exist, or an error if the blobs could not be listed
+func (c *AzureClient) ListObjects(ctx context.Context, bucketName, prefix string) ([]ObjectInfo, error) {
+	blobs, err := c.azureClient.ListBlobs(ctx, bucketName, prefix, false)
+	if err != nil {
+		return nil, azureError(bucketName, "", "", "list blobs of bucket", err)
+	}
+	objects := make([]ObjectInfo, 0, len(blobs))
+	for _, b := range blobs {
+		objects = append(objects, toObjectInfo(b))
+	}
+	return objects, nil
+}
+
+// ListObjectVersions lists every version of the blobs of a container, or of a name prefix, by name and newest first.
+// Azure has no delete markers: a deleted blob only has versions that are not the latest.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container
+//   - prefix: Only list names starting with it. Empty lists the whole container.
+//
+// return:
+//   - []ObjectVersion: The blob versions
+//   - error: ErrBucketNotFound if the container does not exist, or an error if the versions could not be listed
+func (c *AzureClient) ListObjectVersions(ctx context.Context, bucketName, prefix string) ([]ObjectVersion, error) {
+	blobs, err := c.azureClient.ListBlobs(ctx, bucketName, prefix, c.versioned())
+	if err != nil {
+		return nil, azureError(bucketName, "", "", "list blob versions of bucket", err)
+	}
+
+	versions := make([]ObjectVersion, 0, len(blobs))
+	for _, keyVersions := range groupVersions(blobs) {
+		for i := len(keyVersions) - 1; i >= 0; i-- {
+			versions = append(versions, ObjectVersion{ObjectInfo: toObjectInfo(keyVersions[i]), IsLatest: keyVersions[i].IsCurrentVersion})
+		}
+	}
+	return versions, nil
+}
+
+// groupVersions splits a listing sorted by name and oldest version first into the versions of each blob.
+func groupVersions(blobs []azureBlob) [][]azureBlob {
+	var groups [][]azureBlob
+	for i, b := range blobs {
+		if i == 0 || blobs[i-1].Name != b.Name {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], b)
+	}
+	return groups
+}
+
+// VerifyObject re-reads a blob version and checks it against its listing and stored checksum.
+// Blobs written without a checksum (e.g. by other clients) are only checked for size.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The container of the blob
+//   - obj: The blob version to verify, as returned by ListObjects
+//
+// return:
+//   - bool: True if a stored checksum was verified
+//   - error: ErrObjectCorrupt if the content does not match, or an error if the blob could not be read
+func (c *AzureClient) VerifyObject(ctx context.Context, bucketName string, obj ObjectInfo) (bool, error) {
+	b, err := c.azureClient.GetBlobProperties(ctx, bucketName, obj.Key, obj.VersionID)
+	if err != nil {
+		return false, azureError(bucketName, obj.Key, obj.VersionID, "stat blob", err)
+	}
+	data, err := c.ReadFile(ctx, bucketName, obj.Key, obj.VersionID)
+	if err != nil {
+		return false, err
+	}
+
+	if int64(len(data)) != obj.Size {
+		return false, fmt.Errorf("%w: %s has size %d, listed with %d", ErrObjectCorrupt, obj.Key, len(data), obj.Size)
+	}
+	for key, expected := range b.Metadata {
+		if !strings.EqualFold(key, azureChecksumMetadataKey) {
+			continue
+		}
+		if actual := checksum(data); !strings.EqualFold(actual, expected) {
+			return true, fmt.Errorf("%w: %s has checksum %s, stored %s", ErrObjectCorrupt, obj.Key, actual, expected)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// ListBuckets lists the containers of the storage account.
+//
+// params:
+//   - ctx: Context for the operation
+//
+// return:
+//   - []BucketInfo: The containers, with their last modification time as creation date (Azure has none)
+//   - error: An error if the containers could not be listed
+func (c *AzureClient) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
+	containers, err := c.azureClient.ListContainers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	result := make([]BucketInfo, 0, len(containers))
+	for _, container := range containers {
+		result = append(result, BucketInfo{Name: container.Name, CreationDate: container.LastModified.UTC()})
+	}
+	return result, nil
+}
+
+// CreateBucket creates a container, sets the soft delete retention of the storage account and the rules of the container
+// in its lifecycle management policy. Creating an existing container only sets them. Object lock is not supported.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to create
+//   - lock: Must be the zero value, object lock is not supported by the Azure provider
+//
+// return:
+//   - error: ErrInvalidBucketName, ErrInvalidObjectLock if object lock is requested, ErrVersioningDisabled,
+//     or an error if the container could not be created
+func (c *AzureClient) CreateBucket(ctx context.Context, bucketName string, lock ObjectLockConfig) error {
+	if err := validateContainerName(bucketName); err != nil {
+		return err
+	}
+	if lock.Enabled {
+		return fmt.Errorf("%w: object lock is not supported by the %s blob provider", ErrInvalidObjectLock, configurations.BlobProviderAzure)
+	}
+	if err := lock.Validate(); err != nil {
+		return err
+	}
+
+	err := c.azureClient.CreateContainer(ctx, bucketName)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+	}
+	if c.versioned() {
+		if err := c.checkVersioning(ctx, bucketName); err != nil {
+			return err
+		}
+	}
+	if _, err := c.applySoftDelete(ctx); err != nil {
+		return err
+	}
+	if _, err := c.applyManagementPolicy(ctx, bucketName); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkVersioning checks that versioning is enabled on the storage account, by writing and deleting a probe blob:
+// without versioning, writes return no version ID.
+//
+// return:
+//   - error: ErrVersioningDisabled, or an error if the probe blob could not be written or deleted
+func (c *AzureClient) checkVersioning(ctx context.Context, bucketName string) error {
+	probe := fmt.Sprintf("%s%d", azureVersioningProbePrefix, time.Now().UnixNano())
+	versionID, err := c.azureClient.UploadBlob(ctx, bucketName, probe, nil, nil, false)
+	if err != nil {
+		return fmt.Errorf("failed to check versioning of bucket %s: %w", bucketName, err)
+	}
+	if err := c.azureClient.DeleteBlob(ctx, bucketName, probe, ""); err != nil {
+		return fmt.Errorf("failed to delete versioning probe of bucket %s: %w", bucketName, err)
+	}
+	if versionID == "" {
+		return fmt.Errorf("%w: blob versioning must be enabled on the storage account of bucket %s", ErrVersioningDisabled, bucketName)
+	}
+	if err := c.azureClient.DeleteBlob(ctx, bucketName, probe, versionID); err != nil {
+		return fmt.Errorf("failed to delete versioning probe of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// applySoftDelete sets the soft delete retention of the storage account to the configured one.
+//
+// return:
+//   - bool: True if the retention was changed
+//   - error: An error if the retention could not be read or set
+func (c *AzureClient) applySoftDelete(ctx context.Context) (bool, error) {
+	days, err := c.azureClient.GetDeleteRetention(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get soft delete retention: %w", err)
+	}
+	if days == c.config.Blob.Azure.SoftDeleteDays {
+		return false, nil
+	}
+	if err := c.azureClient.SetDeleteRetention(ctx, c.config.Blob.Azure.SoftDeleteDays); err != nil {
+		return false, fmt.Errorf("failed to set soft delete retention: %w", err)
+	}
+	return true, nil
+}
+
+// ProvisionBucket makes sure a container exists, versioning is enabled, the soft delete retention of the storage
+// account is set and the rules of the container in its lifecycle management policy are up to date.
+// With client version cleanup, it applies the version cleanup rules to its blobs with CleanVersions instead.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to provision
+//
+// return:
+//   - *BucketProvisionReport: Whether the container was created, and the IDs of the repaired rules (SoftDelete for the retention)
+//   - error: ErrVersioningDisabled, or an error if the container could not be provisioned or its versions cleaned
+func (c *AzureClient) ProvisionBucket(ctx context.Context, bucketName string) (*BucketProvisionReport, error) {
+	if err := validateContainerName(bucketName); err != nil {
+		return nil, err
+	}
+	report := &BucketProvisionReport{Name: bucketName}
+	exists, err := c.azureClient.ContainerExists(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if bucket exists: %w", err)
+	}
+	report.Created = !exists
+
+	err = c.azureClient.CreateContainer(ctx, bucketName)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return nil, fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+	}
+	if c.versioned() {
+		if err := c.checkVersioning(ctx, bucketName); err != nil {
+			return nil, err
+		}
+	}
+	drifted, err := c.applyManagementPolicy(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	repaired, err := c.applySoftDelete(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if repaired {
+		drifted = append(drifted, softDeleteRuleID)
+	}
+	if exists {
+		report.RepairedLifecycleRules = drifted
+	}
+
+	if c.config.Blob.Azure.ClientVersionCleanup {
+		if err := c.CleanVersions(ctx, bucketName, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// azureRuleName returns the name of the management policy rule applying a lifecycle rule to a container.
+// Rule names can only hold alphanumeric characters, so the container and rule ID are hashed.
+func azureRuleName(bucketName, ruleID string) string {
+	return azureRuleNamePrefix + checksum([]byte(bucketName + "/" + ruleID))[:24]
+}
+
+// expectedPolicyRules returns the lifecycle rules Nimbus expects in the management policy for a container:
+// the rule deleting previous versions, and one rule per retention policy of the container.
+func (c *AzureClient) expectedPolicyRules(bucketName string) []LifecycleRule {
+	var rules []LifecycleRule
+	if c.versioned() {
+		rules = append(rules, LifecycleRule{ID: cleanOldVersionsRuleID, Status: "Enabled", NoncurrentVersionExpirationDays: c.config.Blob.NonCurrentVersionCleanupDelayDays})
+	}
+	for _, policy := range retentionPolicies(c.config, bucketName) {
+		rules = append(rules, LifecycleRule{ID: retentionRuleID(policy.Prefix), Status: "Enabled", Prefix: policy.Prefix, ExpirationDays: policy.Days})
+	}
+	return rules
+}
+
+// toAzureLifecycleRule converts a lifecycle rule of a container to a management policy rule.
+// Previous versions are deleted after their creation, Azure has no time they became previous.
+func toAzureLifecycleRule(bucketName string, r LifecycleRule) azureLifecycleRule {
+	return azureLifecycleRule{
+		Name:               azureRuleName(bucketName, r.ID),
+		Enabled:            r.Status == "Enabled",
+		PrefixMatch:        []string{bucketName + "/" + r.Prefix},
+		BaseBlobDeleteDays: r.ExpirationDays,
+		VersionDeleteDays:  r.NoncurrentVersionExpirationDays,
+	}
+}
+
+// policyRules returns the management policy rules Nimbus manages for a container, as lifecycle rules recognized by their actions,
+// and their names.
+func (c *AzureClient) policyRules(ctx context.Context, bucketName string) ([]LifecycleRule, []string, error) {
+	azureRules, err := c.management.GetLifecycleRules(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get lifecycle management policy: %w", err)
+	}
+	var rules []LifecycleRule
+	var names []string
+	for _, r := range azureRules {
+		if !strings.HasPrefix(r.Name, azureRuleNamePrefix) || len(r.PrefixMatch) != 1 || !strings.HasPrefix(r.PrefixMatch[0], bucketName+"/") {
+			continue
+		}
+		rule := LifecycleRule{
+			ID:                              cleanOldVersionsRuleID,
+			Status:                          "Enabled",
+			Prefix:                          strings.TrimPrefix(r.PrefixMatch[0], bucketName+"/"),
+			ExpirationDays:                  r.BaseBlobDeleteDays,
+			NoncurrentVersionExpirationDays: r.VersionDeleteDays,
+		}
+		if r.BaseBlobDeleteDays > 0 {
+			rule.ID = retentionRuleID(rule.Prefix)
+		}
+		if !r.Enabled {
+			rule.Status = "Disabled"
+		}
+		rules = append(rules, rule)
+		names = append(names, r.Name)
+	}
+	return rules, names, nil
+}
+
+// applyManagementPolicy writes the rules of a container to the lifecycle management policy of the storage account,
+// if they are missing or have drifted, and removes the rules of retention policies removed from the config.
+// The rules of other containers, and the rules not managed by Nimbus, are kept. Nothing is done if the policy is not
+// managed by Nimbus.
+//
+// return:
+//   - []string: The IDs of the rules that were missing or had drifted
+//   - error: An error if the policy could not be read or written
+func (c *AzureClient) applyManagementPolicy(ctx context.Context, bucketName string) ([]string, error) {
+	if c.management == nil {
+		return nil, nil
+	}
+	current, currentNames, err := c.policyRules(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	expected := c.expectedPolicyRules(bucketName)
+	drifted := driftedRules(current, expected)
+	if len(drifted) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]azureLifecycleRule, 0, len(expected))
+	expectedNames := make(map[string]bool, len(expected))
+	for _, r := range expected {
+		rule := toAzureLifecycleRule(bucketName, r)
+		rules = append(rules, rule)
+		expectedNames[rule.Name] = true
+	}
+	var stale []string
+	for _, name := range currentNames {
+		if !expectedNames[name] {
+			stale = append(stale, name)
+		}
+	}
+	if err := c.management.SetLifecycleRules(ctx, rules, stale); err != nil {
+		return nil, fmt.Errorf("failed to set lifecycle management policy of bucket %s: %w", bucketName, err)
+	}
+	return drifted, nil
+}
+
+// CleanVersions applies the version cleanup rules to the blobs of a container, when client version cleanup replaces
+// the lifecycle management policy: previous versions are deleted
+// nonCurrentVersionCleanupDelayDays after being replaced, and the last version of a deleted blob
+// deleteMarkerCleanupDelayDays after the deletion time recorded by DeleteObject. A blob deleted outside of Nimbus has no
+// recorded deletion time: the first cleanup that finds it records the current time instead.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The container to clean
+//   - now: The time the cleanup delays are counted to
+//
+// return:
+//   - error: ErrBucketNotFound, or an error if the versions could not be listed, tagged or deleted
+func (c *AzureClient) CleanVersions(ctx context.Context, bucketName string, now time.Time) error {
+	blobs, err := c.azureClient.ListBlobs(ctx, bucketName, "", c.versioned())
+	if err != nil {
+		return azureError(bucketName, "", "", "list blob versions of bucket", err)
+	}
+
+	deleteMarkerDelay := time.Duration(c.config.Blob.DeleteMarkerCleanupDelayDays) * 24 * time.Hour
+	nonCurrentDelay := time.Duration(c.config.Blob.NonCurrentVersionCleanupDelayDays) * 24 * time.Hour
+	for _, keyVersions := range groupVersions(blobs) {
+		latest := keyVersions[len(keyVersions)-1]
+		if latest.VersionID == "" {
+			// Versioning is not enabled, there is nothing to clean
+			continue
+		}
+
+		var expired []azureBlob
+		for i, v := range keyVersions[:len(keyVersions)-1] {
+			// A version is non-current since the next version was written
+			if now.Sub(versionTime(keyVersions[i+1])) >= nonCurrentDelay {
+				expired = append(expired, v)
+			}
+		}
+		if !latest.IsCurrentVersion {
+			deletedAt, err := time.Parse(time.RFC3339, latest.Tags[azureDeletedAtTagKey])
+			if err != nil {
+				if err := c.tagDeletion(ctx, bucketName, latest.Name, latest.VersionID, now); err != nil {
+					return err
+				}
+			} else if now.Sub(deletedAt) >= deleteMarkerDelay {
+				expired = append(expired, latest)
+			}
+		}
+
+		for _, v := range expired {
+			err := c.azureClient.DeleteBlob(ctx, bucketName, v.Name, v.VersionID)
+			if err != nil && !errors.Is(azureError(bucketName, v.Name, v.VersionID, "", err), ErrObjectNotFound) {
+				return fmt.Errorf("failed to clean version %s of blob %s: %w", v.VersionID, v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// versionTime returns the time a blob version was written. Azure version IDs are the write time.
+func versionTime(b azureBlob) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, b.VersionID); err == nil {
+		return t
+	}
+	return b.LastModified
+}
+
+// softDeleteRule returns the soft delete retention of the storage account as a lifecycle rule, disabled if days is 0.
+func softDeleteRule(days int) LifecycleRule {
+	if days == 0 {
+		return LifecycleRule{ID: softDeleteRuleID, Status: "Disabled"}
+	}
+	return LifecycleRule{ID: softDeleteRuleID, Status: "Enabled", ExpirationDays: days}
+}
+
+// clientCleanupRules returns the version cleanup rules applied by CleanVersions.
+func (c *AzureClient) clientCleanupRules() []LifecycleRule {
+	return []LifecycleRule{
+		{ID: cleanDeleteMarkersRuleID, Status: "Enabled", DeleteMarkerExpirationDays: c.config.Blob.DeleteMarkerCleanupDelayDays},
+		{ID: cleanOldVersionsRuleID, Status: "Enabled", NoncurrentVersionExpirationDays: c.config.Blob.NonCurrentVersionCleanupDelayDays},
+	}
+}
+
+// expectedAzureLifecycleRules returns the lifecycle rules expected on a container: its rules in the management policy,
+// or the version cleanup rules applied by CleanVersions, and the soft delete retention of the storage account.
+func (c *AzureClient) expectedAzureLifecycleRules(bucketName string) []LifecycleRule {
+	var rules []LifecycleRule
+	switch {
+	case c.management != nil:
+		rules = c.expectedPolicyRules(bucketName)
+	case c.config.Blob.Azure.ClientVersionCleanup:
+		rules = c.clientCleanupRules()
+	}
+	return append(rules, softDeleteRule(c.config.Blob.Azure.SoftDeleteDays))
+}
+
+// DescribeBucket returns the lifecycle rules applied to a container: its rules in the management policy of the storage
+// account, or the version cleanup rules applied by CleanVersions, and the soft delete retention of the account.
+// Versioning is a setting of the storage account that cannot be read with an account key, so it is left empty.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to describe
+//
+// return:
+//   - *BucketDescription: The container settings, the SoftDelete rule expiring after the soft delete retention of the account
+//   - error: ErrBucketNotFound if the container does not exist, or an error if the retention or policy could not be read
+func (c *AzureClient) DescribeBucket(ctx context.Context, bucketName string) (*BucketDescription, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+	days, err := c.azureClient.GetDeleteRetention(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get soft delete retention: %w", err)
+	}
+
+	var rules []LifecycleRule
+	switch {
+	case c.management != nil:
+		if rules, _, err = c.policyRules(ctx, bucketName); err != nil {
+			return nil, err
+		}
+	case c.config.Blob.Azure.ClientVersionCleanup:
+		rules = c.clientCleanupRules()
+	}
+	rules = append(rules, softDeleteRule(days))
+	return &BucketDescription{Name: bucketName, LifecycleRules: rules}, nil
+}
+
+// CheckBucketSettings reports, without changing anything, whether the rules of a container in the management policy,
+// or the soft delete retention of the storage account, differ from the expected ones.
+// The version cleanup rules applied by CleanVersions cannot drift.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The container to check
+//
+// return:
+//   - *BucketSettingsDrift: The IDs of the drifted rules, SoftDelete if the retention differs
+//   - error: ErrBucketNotFound if the container does not exist, or an error if the retention or policy could not be read
+func (c *AzureClient) CheckBucketSettings(ctx context.Context, bucketName string) (*BucketSettingsDrift, error) {
+	description, err := c.DescribeBucket(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return &BucketSettingsDrift{
+		Name:                  bucketName,
+		DriftedLifecycleRules: driftedRules(description.LifecycleRules, c.expectedAzureLifecycleRules(bucketName)),
+	}, nil
+}
+
+// DeleteBucket deletes an empty container. Containers still holding blobs or blob versions are not deleted.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container to delete
+//
+// return:
+//   - error: ErrBucketNotFound or ErrBucketNotEmpty, or an error if the container could not be deleted
+func (c *AzureClient) DeleteBucket(ctx context.Context, bucketName string) error {
+	blobs, err := c.azureClient.ListBlobs(ctx, bucketName, "", c.versioned())
+	if err != nil {
+		return azureError(bucketName, "", "", "list blobs of bucket", err)
+	}
+	if len(blobs) > 0 {
+		return fmt.Errorf("%w: %s", ErrBucketNotEmpty, bucketName)
+	}
+	if err := c.azureClient.DeleteContainer(ctx, bucketName); err != nil {
+		return azureError(bucketName, "", "", "delete bucket", err)
+	}
+	return nil
+}
+
+// BucketUsage returns the number and total size of the current blobs of a container.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the container
+//
+// return:
+//   - *BucketUsage: The blob count and total size
+//   - error: ErrBucketNotFound if the container does not exist, or an error if the blobs could not be listed
+func (c *AzureClient) BucketUsage(ctx context.Context, bucketName string) (*BucketUsage, error) {
+	objects, err := c.ListObjects(ctx, bucketName, "")
+	if err != nil {
+		return nil, err
+	}
+	usage := &BucketUsage{}
+	for _, obj := range objects {
+		usage.Bytes += obj.Size
+		usage.Objects++
+	}
+	return usage, nil
+}
+
+// PlanRetention reports, without deleting anything, the current blobs of a container that its retention policies expire
+// as of a time. Retention policies are applied by the lifecycle management policy, they need a subscription ID (the
+// configuration rejects them otherwise).
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The container to report on
+//   - now: The time the objects are expired at
+//
+// return:
+//   - *RetentionReport: The expired blobs per policy, no policies if the container has none
+//   - error: ErrBucketNotFound if the container does not exist, or an error if the blobs could not be listed
+func (c *AzureClient) PlanRetention(ctx context.Context, bucketName string, now time.Time) (*RetentionReport, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+	return planRetention(ctx, c, retentionPolicies(c.config, bucketName), bucketName, now)
+}
+
+// SetLegalHold always fails: object lock is not supported by the Azure provider.
+//
+// return:
+//   - error: ErrBucketNotFound, or ErrObjectLockNotEnabled
+func (c *AzureClient) SetLegalHold(ctx context.Context, bucketName, fileName, versionID string, on bool) error {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w on bucket %s, the %s blob provider has no object lock", ErrObjectLockNotEnabled, bucketName, configurations.BlobProviderAzure)
+}
+
+// GetLegalHold always fails: object lock is not supported by the Azure provider.
+//
+// return:
+//   - bool: Always false
+//   - error: ErrBucketNotFound, or ErrObjectLockNotEnabled
+func (c *AzureClient) GetLegalHold(ctx context.Context, bucketName, fileName, versionID string) (bool, error) {
+	return false, c.SetLegalHold(ctx, bucketName, fileName, versionID, false)
+}
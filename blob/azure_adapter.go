@@ -0,0 +1,374 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// azureClientAdapter adapts a real Azure Blob Storage service client to implement azureClientInterface.
+type azureClientAdapter struct {
+	client *service.Client
+}
+
+// newAzureClientAdapter creates a new adapter for a real Azure Blob Storage service client.
+func newAzureClientAdapter(client *service.Client) azureClientInterface {
+	return &azureClientAdapter{client: client}
+}
+
+// blobClient returns the client of a blob version, the base blob if versionID is empty.
+func (a *azureClientAdapter) blobClient(containerName, blobName, versionID string) (*blob.Client, error) {
+	client := a.client.NewContainerClient(containerName).NewBlobClient(blobName)
+	if versionID == "" {
+		return client, nil
+	}
+	return client.WithVersionID(versionID)
+}
+
+// ListContainers lists all containers of the storage account.
+func (a *azureClientAdapter) ListContainers(ctx context.Context) ([]azureContainer, error) {
+	var containers []azureContainer
+	pager := a.client.NewListContainersPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.ContainerItems {
+			c := azureContainer{Name: deref(item.Name)}
+			if item.Properties != nil && item.Properties.LastModified != nil {
+				c.LastModified = *item.Properties.LastModified
+			}
+			containers = append(containers, c)
+		}
+	}
+	return containers, nil
+}
+
+// ContainerExists checks if a container exists.
+func (a *azureClientAdapter) ContainerExists(ctx context.Context, containerName string) (bool, error) {
+	_, err := a.client.NewContainerClient(containerName).GetProperties(ctx, nil)
+	if bloberror.HasCode(err, bloberror.ContainerNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CreateContainer creates a container.
+func (a *azureClientAdapter) CreateContainer(ctx context.Context, containerName string) error {
+	_, err := a.client.NewContainerClient(containerName).Create(ctx, nil)
+	return err
+}
+
+// DeleteContainer deletes a container and all its blobs.
+func (a *azureClientAdapter) DeleteContainer(ctx context.Context, containerName string) error {
+	_, err := a.client.NewContainerClient(containerName).Delete(ctx, nil)
+	return err
+}
+
+// UploadBlob writes a block blob and returns the version ID of the new version, with an If-None-Match: * condition if ifNotExists is true.
+func (a *azureClientAdapter) UploadBlob(ctx context.Context, containerName, blobName string, data []byte, metadata map[string]string, ifNotExists bool) (string, error) {
+	client := a.client.NewContainerClient(containerName).NewBlockBlobClient(blobName)
+	opts := &blockblob.UploadOptions{Metadata: toAzureMetadata(metadata)}
+	if ifNotExists {
+		opts.AccessConditions = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)}}
+	}
+	resp, err := client.Upload(ctx, streaming.NopCloser(bytes.NewReader(data)), opts)
+	if err != nil {
+		return "", err
+	}
+	return deref(resp.VersionID), nil
+}
+
+// DownloadBlob reads a blob version.
+func (a *azureClientAdapter) DownloadBlob(ctx context.Context, containerName, blobName, versionID string) ([]byte, error) {
+	client, err := a.blobClient(containerName, blobName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// GetBlobProperties describes a blob version.
+func (a *azureClientAdapter) GetBlobProperties(ctx context.Context, containerName, blobName, versionID string) (azureBlob, error) {
+	client, err := a.blobClient(containerName, blobName, versionID)
+	if err != nil {
+		return azureBlob{}, err
+	}
+	resp, err := client.GetProperties(ctx, nil)
+	if err != nil {
+		return azureBlob{}, err
+	}
+	b := azureBlob{
+		Name:      blobName,
+		VersionID: deref(resp.VersionID),
+		// Without versioning there are only base blobs
+		IsCurrentVersion: versionID == "" || (resp.IsCurrentVersion != nil && *resp.IsCurrentVersion),
+		Metadata:         fromAzureMetadata(resp.Metadata),
+	}
+	if resp.ContentLength != nil {
+		b.Size = *resp.ContentLength
+	}
+	if resp.LastModified != nil {
+		b.LastModified = *resp.LastModified
+	}
+	return b, nil
+}
+
+// DeleteBlob deletes the base blob, or a previous version.
+func (a *azureClientAdapter) DeleteBlob(ctx context.Context, containerName, blobName, versionID string) error {
+	client, err := a.blobClient(containerName, blobName, versionID)
+	if err != nil {
+		return err
+	}
+	_, err = client.Delete(ctx, nil)
+	return err
+}
+
+// SetBlobTags replaces the blob index tags of a blob version.
+func (a *azureClientAdapter) SetBlobTags(ctx context.Context, containerName, blobName, versionID string, tags map[string]string) error {
+	opts := &blob.SetTagsOptions{}
+	if versionID != "" {
+		opts.VersionID = &versionID
+	}
+	_, err := a.client.NewContainerClient(containerName).NewBlobClient(blobName).SetTags(ctx, tags, opts)
+	return err
+}
+
+// ListBlobs lists the blobs of a container, and their versions if withVersions is true, by name and oldest version first.
+func (a *azureClientAdapter) ListBlobs(ctx context.Context, containerName, prefix string, withVersions bool) ([]azureBlob, error) {
+	opts := &container.ListBlobsFlatOptions{Include: container.ListBlobsInclude{Metadata: true, Tags: true, Versions: withVersions}}
+	if prefix != "" {
+		opts.Prefix = &prefix
+	}
+
+	var blobs []azureBlob
+	pager := a.client.NewContainerClient(containerName).NewListBlobsFlatPager(opts)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			b := azureBlob{
+				Name:      deref(item.Name),
+				VersionID: deref(item.VersionID),
+				// Without versioning (or versions in the listing) only base blobs are listed
+				IsCurrentVersion: item.VersionID == nil || !withVersions || (item.IsCurrentVersion != nil && *item.IsCurrentVersion),
+				Metadata:         fromAzureMetadata(item.Metadata),
+			}
+			if item.BlobTags != nil {
+				b.Tags = make(map[string]string, len(item.BlobTags.BlobTagSet))
+				for _, tag := range item.BlobTags.BlobTagSet {
+					b.Tags[deref(tag.Key)] = deref(tag.Value)
+				}
+			}
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					b.Size = *item.Properties.ContentLength
+				}
+				if item.Properties.LastModified != nil {
+					b.LastModified = *item.Properties.LastModified
+				}
+			}
+			blobs = append(blobs, b)
+		}
+	}
+	// Version IDs are timestamps, they sort in write order
+	sort.SliceStable(blobs, func(i, j int) bool {
+		if blobs[i].Name != blobs[j].Name {
+			return blobs[i].Name < blobs[j].Name
+		}
+		return blobs[i].VersionID < blobs[j].VersionID
+	})
+	return blobs, nil
+}
+
+// GetDeleteRetention returns the soft delete retention of the storage account in days, 0 if disabled.
+func (a *azureClientAdapter) GetDeleteRetention(ctx context.Context) (int, error) {
+	resp, err := a.client.GetProperties(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	policy := resp.DeleteRetentionPolicy
+	if policy == nil || policy.Enabled == nil || !*policy.Enabled || policy.Days == nil {
+		return 0, nil
+	}
+	return int(*policy.Days), nil
+}
+
+// SetDeleteRetention enables soft delete on the storage account.
+func (a *azureClientAdapter) SetDeleteRetention(ctx context.Context, days int) error {
+	_, err := a.client.SetProperties(ctx, &service.SetPropertiesOptions{
+		DeleteRetentionPolicy: &service.RetentionPolicy{Enabled: to.Ptr(true), Days: to.Ptr(int32(days))},
+	})
+	return err
+}
+
+// azureManagementAdapter adapts an Azure Resource Manager management policies client to implement azureManagementInterface.
+type azureManagementAdapter struct {
+	client        *armstorage.ManagementPoliciesClient
+	resourceGroup string
+	accountName   string
+}
+
+// newAzureManagementAdapter creates a new adapter for the management policy of a storage account.
+func newAzureManagementAdapter(client *armstorage.ManagementPoliciesClient, resourceGroup, accountName string) azureManagementInterface {
+	return &azureManagementAdapter{client: client, resourceGroup: resourceGroup, accountName: accountName}
+}
+
+// getPolicy returns the rules of the management policy of the account, none if it has no policy.
+func (a *azureManagementAdapter) getPolicy(ctx context.Context) ([]*armstorage.ManagementPolicyRule, error) {
+	resp, err := a.client.Get(ctx, a.resourceGroup, a.accountName, armstorage.ManagementPolicyNameDefault, nil)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Properties == nil || resp.Properties.Policy == nil {
+		return nil, nil
+	}
+	return resp.Properties.Policy.Rules, nil
+}
+
+// GetLifecycleRules returns the rules of the management policy of the account.
+func (a *azureManagementAdapter) GetLifecycleRules(ctx context.Context) ([]azureLifecycleRule, error) {
+	policyRules, err := a.getPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]azureLifecycleRule, 0, len(policyRules))
+	for _, r := range policyRules {
+		rules = append(rules, fromManagementPolicyRule(r))
+	}
+	return rules, nil
+}
+
+// SetLifecycleRules adds, replaces and removes rules of the management policy of the account, keeping the other rules.
+// The policy is deleted if no rule is left, Azure rejects empty policies.
+func (a *azureManagementAdapter) SetLifecycleRules(ctx context.Context, rules []azureLifecycleRule, remove []string) error {
+	policyRules, err := a.getPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	replaced := make(map[string]bool, len(rules)+len(remove))
+	for _, r := range rules {
+		replaced[r.Name] = true
+	}
+	for _, name := range remove {
+		replaced[name] = true
+	}
+
+	var merged []*armstorage.ManagementPolicyRule
+	for _, r := range policyRules {
+		if !replaced[deref(r.Name)] {
+			merged = append(merged, r)
+		}
+	}
+	for _, r := range rules {
+		merged = append(merged, toManagementPolicyRule(r))
+	}
+
+	if len(merged) == 0 {
+		_, err := a.client.Delete(ctx, a.resourceGroup, a.accountName, armstorage.ManagementPolicyNameDefault, nil)
+		return err
+	}
+	policy := armstorage.ManagementPolicy{Properties: &armstorage.ManagementPolicyProperties{
+		Policy: &armstorage.ManagementPolicySchema{Rules: merged},
+	}}
+	_, err = a.client.CreateOrUpdate(ctx, a.resourceGroup, a.accountName, armstorage.ManagementPolicyNameDefault, policy, nil)
+	return err
+}
+
+// toManagementPolicyRule converts a rule to a block blob rule of the SDK.
+func toManagementPolicyRule(r azureLifecycleRule) *armstorage.ManagementPolicyRule {
+	actions := &armstorage.ManagementPolicyAction{}
+	if r.BaseBlobDeleteDays > 0 {
+		actions.BaseBlob = &armstorage.ManagementPolicyBaseBlob{
+			Delete: &armstorage.DateAfterModification{DaysAfterModificationGreaterThan: to.Ptr(float32(r.BaseBlobDeleteDays))},
+		}
+	}
+	if r.VersionDeleteDays > 0 {
+		actions.Version = &armstorage.ManagementPolicyVersion{
+			Delete: &armstorage.DateAfterCreation{DaysAfterCreationGreaterThan: to.Ptr(float32(r.VersionDeleteDays))},
+		}
+	}
+	filters := &armstorage.ManagementPolicyFilter{BlobTypes: []*string{to.Ptr("blockBlob")}}
+	for _, prefix := range r.PrefixMatch {
+		filters.PrefixMatch = append(filters.PrefixMatch, to.Ptr(prefix))
+	}
+	return &armstorage.ManagementPolicyRule{
+		Name:       to.Ptr(r.Name),
+		Enabled:    to.Ptr(r.Enabled),
+		Type:       to.Ptr(armstorage.RuleTypeLifecycle),
+		Definition: &armstorage.ManagementPolicyDefinition{Actions: actions, Filters: filters},
+	}
+}
+
+// fromManagementPolicyRule converts a rule of the SDK, ignoring the actions Nimbus does not set.
+func fromManagementPolicyRule(r *armstorage.ManagementPolicyRule) azureLifecycleRule {
+	rule := azureLifecycleRule{Name: deref(r.Name), Enabled: r.Enabled == nil || *r.Enabled}
+	if r.Definition == nil {
+		return rule
+	}
+	if filters := r.Definition.Filters; filters != nil {
+		for _, prefix := range filters.PrefixMatch {
+			rule.PrefixMatch = append(rule.PrefixMatch, deref(prefix))
+		}
+	}
+	if actions := r.Definition.Actions; actions != nil {
+		if actions.BaseBlob != nil && actions.BaseBlob.Delete != nil && actions.BaseBlob.Delete.DaysAfterModificationGreaterThan != nil {
+			rule.BaseBlobDeleteDays = int(*actions.BaseBlob.Delete.DaysAfterModificationGreaterThan)
+		}
+		if actions.Version != nil && actions.Version.Delete != nil && actions.Version.Delete.DaysAfterCreationGreaterThan != nil {
+			rule.VersionDeleteDays = int(*actions.Version.Delete.DaysAfterCreationGreaterThan)
+		}
+	}
+	return rule
+}
+
+// deref returns the value of an optional string of the SDK, empty if nil.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// toAzureMetadata converts metadata to the optional values of the SDK.
+func toAzureMetadata(metadata map[string]string) map[string]*string {
+	result := make(map[string]*string, len(metadata))
+	for key, value := range metadata {
+		result[key] = to.Ptr(value)
+	}
+	return result
+}
+
+// fromAzureMetadata converts the optional metadata values of the SDK.
+func fromAzureMetadata(metadata map[string]*string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		result[key] = deref(value)
+	}
+	return result
+}
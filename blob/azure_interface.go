@@ -0,0 +1,94 @@
+package blob
+
+import (
+	"context"
+	"time"
+)
+
+// azureContainer is a container listed by azureClientInterface.
+type azureContainer struct {
+	Name         string
+	LastModified time.Time
+}
+
+// azureBlob is a blob, or a version of a blob, listed or described by azureClientInterface.
+type azureBlob struct {
+	Name string
+	// VersionID is empty if versioning is not enabled on the storage account.
+	VersionID string
+	// IsCurrentVersion is true for the base blob. A deleted blob only has previous versions.
+	IsCurrentVersion bool
+	Size             int64
+	LastModified     time.Time
+	Metadata         map[string]string
+	// Tags are the blob index tags of the version, only listed by ListBlobs.
+	Tags map[string]string
+}
+
+// azureClientInterface defines the interface for the Azure Blob Storage operations used by AzureClient.
+// Errors are the *azcore.ResponseError of the SDK, matched with bloberror.HasCode.
+// This interface allows us to mock Azure behavior in unit tests.
+type azureClientInterface interface {
+	// ListContainers lists all containers of the storage account.
+	ListContainers(ctx context.Context) ([]azureContainer, error)
+
+	// ContainerExists checks if a container exists.
+	ContainerExists(ctx context.Context, containerName string) (bool, error)
+
+	// CreateContainer creates a container. Returns a ContainerAlreadyExists error if it exists.
+	CreateContainer(ctx context.Context, containerName string) error
+
+	// DeleteContainer deletes a container and all its blobs.
+	DeleteContainer(ctx context.Context, containerName string) error
+
+	// UploadBlob writes a block blob and returns the version ID of the new version, empty if versioning is not enabled.
+	// If ifNotExists is true and the blob exists, it returns a BlobAlreadyExists error.
+	UploadBlob(ctx context.Context, containerName, blobName string, data []byte, metadata map[string]string, ifNotExists bool) (string, error)
+
+	// DownloadBlob reads a blob version, the current one if versionID is empty.
+	DownloadBlob(ctx context.Context, containerName, blobName, versionID string) ([]byte, error)
+
+	// GetBlobProperties describes a blob version, the current one if versionID is empty.
+	GetBlobProperties(ctx context.Context, containerName, blobName, versionID string) (azureBlob, error)
+
+	// DeleteBlob deletes a blob: without versionID, the base blob (its current version becomes a previous version
+	// if versioning is enabled), with versionID, that previous version.
+	DeleteBlob(ctx context.Context, containerName, blobName, versionID string) error
+
+	// SetBlobTags replaces the blob index tags of a blob version, the current one if versionID is empty.
+	SetBlobTags(ctx context.Context, containerName, blobName, versionID string, tags map[string]string) error
+
+	// ListBlobs lists the blobs of a container whose name starts with prefix, and all their versions if withVersions is true,
+	// by name and oldest version first.
+	ListBlobs(ctx context.Context, containerName, prefix string, withVersions bool) ([]azureBlob, error)
+
+	// GetDeleteRetention returns the soft delete retention of the storage account in days, 0 if soft delete is disabled.
+	GetDeleteRetention(ctx context.Context) (int, error)
+
+	// SetDeleteRetention enables soft delete on the storage account with a retention in days.
+	SetDeleteRetention(ctx context.Context, days int) error
+}
+
+// azureLifecycleRule is a rule of the lifecycle management policy of a storage account, limited to the actions Nimbus sets.
+type azureLifecycleRule struct {
+	Name    string
+	Enabled bool
+	// PrefixMatch are the "{container}/{blob name prefix}" the rule applies to.
+	PrefixMatch []string
+	// BaseBlobDeleteDays deletes base blobs more than that many days after their last modification, 0 if not set.
+	BaseBlobDeleteDays int
+	// VersionDeleteDays deletes previous versions more than that many days after their creation, 0 if not set.
+	VersionDeleteDays int
+}
+
+// azureManagementInterface defines the Azure Resource Manager operations on the lifecycle management policy of the
+// storage account used by AzureClient. The policy is shared by all the containers of the account.
+// This interface allows us to mock Azure behavior in unit tests.
+type azureManagementInterface interface {
+	// GetLifecycleRules returns the rules of the lifecycle management policy, none if the account has no policy.
+	GetLifecycleRules(ctx context.Context) ([]azureLifecycleRule, error)
+
+	// SetLifecycleRules adds or replaces rules by name and removes the rules named in remove,
+	// the other rules of the policy are kept as they are.
+	SetLifecycleRules(ctx context.Context, rules []azureLifecycleRule, remove []string) error
+}
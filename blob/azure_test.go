@@ -0,0 +1,524 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"NimbusDb/configurations"
+)
+
+// azuriteConnectionString is the well-known connection string of the Azurite emulator.
+const azuriteConnectionString = "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==;BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;"
+
+var (
+	// azuriteOnce checks once whether Azurite is listening, the Azure SDK retries for seconds before failing.
+	azuriteOnce      sync.Once
+	azuriteAvailable bool
+)
+
+// setupAzurite creates an unversioned client on the Azurite emulator, or skips the test if Azurite is not listening.
+// Run Azurite with: docker run -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0 --skipApiVersionCheck
+func setupAzurite(t *testing.T) *AzureClient {
+	azuriteOnce.Do(func() {
+		if conn, err := net.DialTimeout("tcp", "127.0.0.1:10000", time.Second); err == nil {
+			conn.Close()
+			azuriteAvailable = true
+		}
+	})
+	if !azuriteAvailable {
+		t.Skip("Azurite not available on 127.0.0.1:10000, skipping test")
+	}
+
+	cfg := getAzureUnversionedTestConfig()
+	cfg.Blob.Azure.ConnectionString = azuriteConnectionString
+	client, err := NewAzureClient(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewAzureClient() failed: %v", err)
+	}
+	return client
+}
+
+// getAzureTestConfig returns a test configuration for the Azure provider, with the lifecycle management policy managed by Nimbus.
+func getAzureTestConfig() *configurations.Config {
+	cfg := getTestConfig()
+	cfg.Blob.Azure.SoftDeleteDays = 7
+	cfg.Blob.Azure.AccountName = "nimbus"
+	cfg.Blob.Azure.SubscriptionID = "subscription"
+	cfg.Blob.Azure.ResourceGroup = "storage"
+	return cfg
+}
+
+// getAzureClientCleanupTestConfig returns a test configuration for the Azure provider, with versions cleaned by the client.
+func getAzureClientCleanupTestConfig() *configurations.Config {
+	cfg := getAzureTestConfig()
+	cfg.Blob.Azure.SubscriptionID = ""
+	cfg.Blob.Azure.ResourceGroup = ""
+	cfg.Blob.Azure.ClientVersionCleanup = true
+	return cfg
+}
+
+// getAzureUnversionedTestConfig returns a test configuration for the Azure provider without versioning, like for Azurite.
+func getAzureUnversionedTestConfig() *configurations.Config {
+	cfg := getAzureClientCleanupTestConfig()
+	cfg.Blob.Azure.ClientVersionCleanup = false
+	cfg.Blob.Azure.Unversioned = true
+	return cfg
+}
+
+// setupAzureClient creates an Azure client on a mock storage account, with a container named orders.
+// The mock management policy is used if cfg has a subscription ID. A versioned client cannot create buckets on an account
+// without versioning, the container is then created on the mock.
+func setupAzureClient(t *testing.T, cfg *configurations.Config, versioning bool) (*AzureClient, *mockAzureClient, *mockAzureManagement) {
+	mockClient := newMockAzureClient(versioning)
+	mockManagement := newMockAzureManagement()
+	var management azureManagementInterface
+	if cfg.Blob.Azure.ManagementPolicies() {
+		management = mockManagement
+	}
+	client := NewAzureClientWithInterface(mockClient, management, cfg)
+	if !versioning && !cfg.Blob.Azure.Unversioned {
+		if err := mockClient.CreateContainer(context.Background(), "orders"); err != nil {
+			t.Fatalf("CreateContainer() failed: %v", err)
+		}
+		return client, mockClient, mockManagement
+	}
+	if err := client.CreateBucket(context.Background(), "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	return client, mockClient, mockManagement
+}
+
+func TestAzureClient_WithoutVersioning(t *testing.T) {
+	client, _, _ := setupAzureClient(t, getAzureTestConfig(), false)
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "invoices", ObjectLockConfig{}); !errors.Is(err, ErrVersioningDisabled) {
+		t.Errorf("Expected ErrVersioningDisabled, got %v", err)
+	}
+	if _, err := client.ProvisionBucket(ctx, "orders"); !errors.Is(err, ErrVersioningDisabled) {
+		t.Errorf("Expected ErrVersioningDisabled, got %v", err)
+	}
+	objects, err := client.ListObjects(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("Expected the versioning probe to be deleted, got %+v", objects)
+	}
+}
+
+func TestAzureClient_WriteIfVersion(t *testing.T) {
+	client, _, _ := setupAzureClient(t, getAzureTestConfig(), true)
+	ctx := context.Background()
+	first, err := client.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("second"), WriteOptions{IfVersion: first}); !errors.Is(err, ErrUnsupportedCondition) {
+		t.Errorf("Expected ErrUnsupportedCondition, got %v", err)
+	}
+}
+
+func TestAzureClient_Unversioned(t *testing.T) {
+	client, _, _ := setupAzureClient(t, getAzureUnversionedTestConfig(), false)
+	ctx := context.Background()
+	report, err := client.ProvisionBucket(ctx, "invoices")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if !report.Created {
+		t.Errorf("Expected the bucket to be created, got %+v", report)
+	}
+	drift, err := client.CheckBucketSettings(ctx, "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Drifted() {
+		t.Errorf("Expected no drift, got %+v", drift)
+	}
+
+	versionID, err := client.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if versionID != "" {
+		t.Errorf("Expected no version ID without versioning, got %s", versionID)
+	}
+	if _, err := client.WriteFile(ctx, "orders", "order-1", []byte("second")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	versions, err := client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 1 || !versions[0].IsLatest {
+		t.Errorf("Expected only the current blob, got %+v", versions)
+	}
+	if err := client.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if err := client.DeleteBucket(ctx, "orders"); err != nil {
+		t.Errorf("DeleteBucket() failed: %v", err)
+	}
+}
+
+func TestAzureClient_DeleteObject(t *testing.T) {
+	client, _, _ := setupAzureClient(t, getAzureTestConfig(), true)
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, "orders", "order-1", []byte("first")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	// Azure has no delete markers, a deleted blob only has previous versions
+	if err := client.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	versions, err := client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].IsLatest || versions[0].IsDeleteMarker {
+		t.Errorf("Expected a single previous version, got %+v", versions)
+	}
+
+	// The current version cannot be deleted by ID on Azure, the client deletes the base blob first
+	current, err := client.WriteFile(ctx, "orders", "order-1", []byte("second"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := client.DeleteObject(ctx, "orders", "order-1", current); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if exists, err := client.FileExists(ctx, "orders", "order-1"); err != nil || exists {
+		t.Errorf("Expected the blob to be deleted, got %v (%v)", exists, err)
+	}
+	if _, err := client.ReadFile(ctx, "orders", "order-1", current); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+}
+
+func TestAzureClient_VerifyObject(t *testing.T) {
+	client, mockClient, _ := setupAzureClient(t, getAzureTestConfig(), true)
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, "orders", "order-1", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	objects, err := client.ListObjects(ctx, "orders", "")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	// Azure ETags are not content hashes
+	if objects[0].ETag != "" {
+		t.Errorf("Expected no ETag, got %s", objects[0].ETag)
+	}
+
+	// Flip the content in storage, keeping its size
+	mockClient.containers["orders"].blobs["order-1"][0].data = []byte("ORDER")
+	if _, err := client.VerifyObject(ctx, "orders", objects[0]); !errors.Is(err, ErrObjectCorrupt) {
+		t.Errorf("Expected ErrObjectCorrupt, got %v", err)
+	}
+
+	// Blobs written by other clients have no checksum
+	if _, err := mockClient.UploadBlob(ctx, "orders", "foreign", []byte("data"), nil, false); err != nil {
+		t.Fatalf("UploadBlob() failed: %v", err)
+	}
+	info, err := client.StatObject(ctx, "orders", "foreign", "")
+	if err != nil {
+		t.Fatalf("StatObject() failed: %v", err)
+	}
+	if verified, err := client.VerifyObject(ctx, "orders", *info); err != nil || verified {
+		t.Errorf("Expected the blob to be checked for size only, got %v (%v)", verified, err)
+	}
+}
+
+func TestAzureClient_CleanVersions(t *testing.T) {
+	client, _, _ := setupAzureClient(t, getAzureClientCleanupTestConfig(), true)
+	ctx := context.Background()
+	first, err := client.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := client.WriteFile(ctx, "orders", "order-1", []byte("second")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := client.WriteFile(ctx, "orders", "order-2", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := client.DeleteObject(ctx, "orders", "order-2", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+
+	// Within the cleanup delays nothing is removed
+	if _, err := client.ProvisionBucket(ctx, "orders"); err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if _, err := client.ReadFile(ctx, "orders", "order-1", first); err != nil {
+		t.Errorf("Expected the first version to be kept, got %v", err)
+	}
+
+	// After the delays, the previous version and the deleted blob are removed
+	if err := client.CleanVersions(ctx, "orders", time.Now().Add(48*time.Hour)); err != nil {
+		t.Fatalf("CleanVersions() failed: %v", err)
+	}
+	versions, err := client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Key != "order-1" || !versions[0].IsLatest {
+		t.Errorf("Expected only the current version of order-1, got %+v", versions)
+	}
+}
+
+func TestAzureClient_CleanVersionsFromDeletionTime(t *testing.T) {
+	client, mockClient, _ := setupAzureClient(t, getAzureClientCleanupTestConfig(), true)
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, "orders", "order-1", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	mockClient.backdate("orders", "order-1", 10*24*time.Hour)
+	if err := client.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+
+	// Written 10 days ago but deleted now, the blob is kept for the delete marker cleanup delay
+	now := time.Now()
+	if err := client.CleanVersions(ctx, "orders", now); err != nil {
+		t.Fatalf("CleanVersions() failed: %v", err)
+	}
+	versions, err := client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].IsLatest {
+		t.Errorf("Expected the deleted blob to be kept, got %+v", versions)
+	}
+
+	if err := client.CleanVersions(ctx, "orders", now.Add(25*time.Hour)); err != nil {
+		t.Fatalf("CleanVersions() failed: %v", err)
+	}
+	versions, err = client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected the deleted blob to be cleaned, got %+v", versions)
+	}
+
+	// A blob deleted outside of Nimbus is kept from the first cleanup that finds it
+	if _, err := client.WriteFile(ctx, "orders", "order-2", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	mockClient.backdate("orders", "order-2", 10*24*time.Hour)
+	if err := mockClient.DeleteBlob(ctx, "orders", "order-2", ""); err != nil {
+		t.Fatalf("DeleteBlob() failed: %v", err)
+	}
+	for _, at := range []time.Time{now, now.Add(12 * time.Hour)} {
+		if err := client.CleanVersions(ctx, "orders", at); err != nil {
+			t.Fatalf("CleanVersions() failed: %v", err)
+		}
+	}
+	versions, err = client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].IsLatest {
+		t.Errorf("Expected the blob deleted outside of Nimbus to be kept, got %+v", versions)
+	}
+	if err := client.CleanVersions(ctx, "orders", now.Add(25*time.Hour)); err != nil {
+		t.Fatalf("CleanVersions() failed: %v", err)
+	}
+	versions, err = client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected the blob deleted outside of Nimbus to be cleaned, got %+v", versions)
+	}
+}
+
+func TestAzureClient_BucketSettings(t *testing.T) {
+	client, mockClient, _ := setupAzureClient(t, getAzureTestConfig(), true)
+	ctx := context.Background()
+
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{Enabled: true}); !errors.Is(err, ErrInvalidObjectLock) {
+		t.Errorf("Expected ErrInvalidObjectLock, got %v", err)
+	}
+	for _, name := range []string{"orders.eu", "orders--eu", "Orders"} {
+		if err := client.CreateBucket(ctx, name, ObjectLockConfig{}); !errors.Is(err, ErrInvalidBucketName) {
+			t.Errorf("Expected ErrInvalidBucketName for %s, got %v", name, err)
+		}
+	}
+
+	// Creating a bucket sets the soft delete retention of the account
+	if mockClient.deleteRetentionDays != 7 {
+		t.Errorf("Expected soft delete retention of 7 days, got %d", mockClient.deleteRetentionDays)
+	}
+	drift, err := client.CheckBucketSettings(ctx, "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Drifted() {
+		t.Errorf("Expected no drift, got %+v", drift)
+	}
+
+	// Soft delete disabled on the account drifts, and is repaired by provisioning
+	mockClient.deleteRetentionDays = 0
+	drift, err = client.CheckBucketSettings(ctx, "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if len(drift.DriftedLifecycleRules) != 1 || drift.DriftedLifecycleRules[0] != softDeleteRuleID {
+		t.Errorf("Expected the %s rule to drift, got %+v", softDeleteRuleID, drift)
+	}
+	report, err := client.ProvisionBucket(ctx, "orders")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if report.Created || len(report.RepairedLifecycleRules) != 1 || report.RepairedLifecycleRules[0] != softDeleteRuleID {
+		t.Errorf("Expected the %s rule to be repaired, got %+v", softDeleteRuleID, report)
+	}
+
+	report, err = client.ProvisionBucket(ctx, "invoices")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if !report.Created || !report.Changed() || len(report.RepairedLifecycleRules) != 0 {
+		t.Errorf("Expected the bucket to be created only, got %+v", report)
+	}
+	buckets, err := client.ListBuckets(ctx)
+	if err != nil {
+		t.Fatalf("ListBuckets() failed: %v", err)
+	}
+	if len(buckets) != 2 {
+		t.Errorf("Expected 2 buckets, got %+v", buckets)
+	}
+
+	if err := client.SetLegalHold(ctx, "orders", "order-1", "", true); !errors.Is(err, ErrObjectLockNotEnabled) {
+		t.Errorf("Expected ErrObjectLockNotEnabled, got %v", err)
+	}
+}
+
+func TestAzureClient_ManagementPolicy(t *testing.T) {
+	cfg := getAzureTestConfig()
+	cfg.Blob.Retention = []configurations.RetentionConfig{{Bucket: "orders", Prefix: "logs/", Days: 30}}
+	client, _, mockManagement := setupAzureClient(t, cfg, true)
+	ctx := context.Background()
+
+	// Rules of other containers and of operators are kept
+	other := azureLifecycleRule{Name: "archive", Enabled: true, PrefixMatch: []string{"orders/archive/"}, BaseBlobDeleteDays: 365}
+	if err := mockManagement.SetLifecycleRules(ctx, []azureLifecycleRule{other}, nil); err != nil {
+		t.Fatalf("SetLifecycleRules() failed: %v", err)
+	}
+	if err := client.CreateBucket(ctx, "invoices", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	rules, err := mockManagement.GetLifecycleRules(ctx)
+	if err != nil {
+		t.Fatalf("GetLifecycleRules() failed: %v", err)
+	}
+	byName := make(map[string]azureLifecycleRule)
+	for _, r := range rules {
+		byName[r.Name] = r
+	}
+	if len(rules) != 4 || byName["archive"].BaseBlobDeleteDays != 365 {
+		t.Fatalf("Expected 3 rules of Nimbus and the operator rule, got %+v", rules)
+	}
+	versions := byName[azureRuleName("orders", cleanOldVersionsRuleID)]
+	if versions.VersionDeleteDays != 1 || versions.BaseBlobDeleteDays != 0 || len(versions.PrefixMatch) != 1 || versions.PrefixMatch[0] != "orders/" {
+		t.Errorf("Expected previous versions of orders to be deleted after 1 day, got %+v", versions)
+	}
+	retention := byName[azureRuleName("orders", retentionRuleID("logs/"))]
+	if retention.BaseBlobDeleteDays != 30 || retention.VersionDeleteDays != 0 || len(retention.PrefixMatch) != 1 || retention.PrefixMatch[0] != "orders/logs/" {
+		t.Errorf("Expected orders/logs/ to expire after 30 days, got %+v", retention)
+	}
+	if _, ok := byName[azureRuleName("invoices", cleanOldVersionsRuleID)]; !ok {
+		t.Errorf("Expected a version rule for invoices, got %+v", rules)
+	}
+
+	description, err := client.DescribeBucket(ctx, "orders")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if len(description.LifecycleRules) != 3 || description.LifecycleRules[2].ID != softDeleteRuleID {
+		t.Errorf("Expected the version, retention and soft delete rules, got %+v", description.LifecycleRules)
+	}
+	drift, err := client.CheckBucketSettings(ctx, "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Drifted() {
+		t.Errorf("Expected no drift, got %+v", drift)
+	}
+
+	// A rule changed in the policy drifts and is repaired by provisioning
+	versions.VersionDeleteDays = 90
+	if err := mockManagement.SetLifecycleRules(ctx, []azureLifecycleRule{versions}, nil); err != nil {
+		t.Fatalf("SetLifecycleRules() failed: %v", err)
+	}
+	drift, err = client.CheckBucketSettings(ctx, "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if len(drift.DriftedLifecycleRules) != 1 || drift.DriftedLifecycleRules[0] != cleanOldVersionsRuleID {
+		t.Errorf("Expected the %s rule to drift, got %+v", cleanOldVersionsRuleID, drift)
+	}
+
+	// A retention policy removed from the config has its rule removed, the other rules are kept
+	cfg.Blob.Retention = nil
+	report, err := client.ProvisionBucket(ctx, "orders")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if len(report.RepairedLifecycleRules) != 2 || report.RepairedLifecycleRules[0] != cleanOldVersionsRuleID || report.RepairedLifecycleRules[1] != retentionRuleID("logs/") {
+		t.Errorf("Expected the version rule to be repaired and the retention rule removed, got %+v", report)
+	}
+	rules, err = mockManagement.GetLifecycleRules(ctx)
+	if err != nil {
+		t.Fatalf("GetLifecycleRules() failed: %v", err)
+	}
+	if len(rules) != 3 {
+		t.Errorf("Expected the version rules of both buckets and the operator rule, got %+v", rules)
+	}
+	report, err = client.ProvisionBucket(ctx, "orders")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if report.Changed() {
+		t.Errorf("Expected nothing to be repaired, got %+v", report)
+	}
+}
+
+func TestNewAzureClient_Azurite(t *testing.T) {
+	client := setupAzurite(t)
+	ctx := context.Background()
+
+	// Azurite has no versioning, the unversioned client provisions buckets without it
+	bucketName := "nimbus-test-" + time.Now().UTC().Format("20060102150405")
+	report, err := client.ProvisionBucket(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if !report.Created {
+		t.Errorf("Expected the bucket to be created, got %+v", report)
+	}
+	drift, err := client.CheckBucketSettings(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Drifted() {
+		t.Errorf("Expected no drift, got %+v", drift)
+	}
+	if _, err := client.WriteFile(ctx, bucketName, "order-1", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := client.DeleteObject(ctx, bucketName, "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if err := client.DeleteBucket(ctx, bucketName); err != nil {
+		t.Errorf("DeleteBucket() failed: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package blob
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupFormatVersion is the version of the backup archive layout, recorded in the manifest.
+	BackupFormatVersion = 1
+	// BackupManifestName is the name of the manifest entry, always the first entry of a backup archive.
+	BackupManifestName = "manifest.json"
+	// backupObjectsDir is the directory of the archive holding the object contents, by key.
+	backupObjectsDir = "objects/"
+	// backupChecksumRecord is the PAX record of an entry holding the hex SHA-256 of its content.
+	backupChecksumRecord = "NIMBUS.sha256"
+)
+
+var (
+	// ErrInvalidBackup is returned when restoring an archive that is not a valid backup, or does not match its manifest.
+	ErrInvalidBackup = errors.New("invalid backup archive")
+)
+
+// ExportBucket writes every current object of a bucket (or of a key prefix) to a tar archive.
+// The archive starts with a manifest describing the objects (version ID, size, ETag, last modified, user metadata),
+// followed by one entry per object. The versions listed in the manifest are the ones exported,
+// so objects overwritten during the export are still consistent with it.
+//
+// params:
+//   - ctx: Context for the operation
+//   - storage: The blob storage of the bucket
+//   - bucketName: The bucket to export
+//   - prefix: Only export keys starting with it. Empty exports the whole bucket.
+//   - w: The writer the tar archive is streamed to
+//
+// return:
+//   - *BackupManifest: The manifest written to the archive
+//   - error: ErrBucketNotFound, or an error if an object could not be read or the archive could not be written
+func ExportBucket(ctx context.Context, storage Storage, bucketName, prefix string, w io.Writer) (*BackupManifest, error) {
+	objects, err := storage.ListObjects(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BackupManifest{
+		FormatVersion: BackupFormatVersion,
+		Bucket:        bucketName,
+		Prefix:        prefix,
+		CreatedAt:     time.Now().UTC(),
+		Objects:       make([]BackupObject, 0, len(objects)),
+	}
+	for _, obj := range objects {
+		// Listings do not return user metadata
+		info, err := storage.StatObject(ctx, bucketName, obj.Key, obj.VersionID)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Objects = append(manifest.Objects, BackupObject{
+			Key:          obj.Key,
+			VersionID:    obj.VersionID,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			LastModified: obj.LastModified,
+			UserMetadata: info.UserMetadata,
+		})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode backup manifest: %w", err)
+	}
+	tw := tar.NewWriter(w)
+	if err := writeTarEntry(tw, BackupManifestName, manifest.CreatedAt, manifestData); err != nil {
+		return nil, err
+	}
+	for _, obj := range manifest.Objects {
+		data, err := storage.ReadFile(ctx, bucketName, obj.Key, obj.VersionID)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) != obj.Size {
+			return nil, fmt.Errorf("object %s has size %d, listed with %d", obj.Key, len(data), obj.Size)
+		}
+		if err := writeTarEntry(tw, backupObjectsDir+obj.Key, obj.LastModified, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish backup archive: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeTarEntry writes a regular file entry to a tar archive, with the SHA-256 of its content as a PAX record.
+func writeTarEntry(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	header := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       name,
+		Size:       int64(len(data)),
+		Mode:       0o644,
+		ModTime:    modTime,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{backupChecksumRecord: entryChecksum(data)},
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write backup entry %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write backup entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// entryChecksum returns the hex SHA-256 of the content of an archive entry.
+func entryChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// RestoreBucket writes the objects of a backup archive to a bucket with their user metadata, creating new versions.
+// Each entry is checked against the manifest and its checksum before it is written. The target bucket must exist,
+// and can differ from the exported one, as can its blob storage provider.
+//
+// params:
+//   - ctx: Context for the operation
+//   - storage: The blob storage of the bucket
+//   - r: The reader the tar archive is read from
+//   - bucketName: The bucket to restore to
+//
+// return:
+//   - *RestoreReport: The restored objects, also returned (partially filled) on error
+//   - error: ErrBucketNotFound, ErrInvalidBackup if the archive does not match its manifest or an entry its checksum,
+//     or an error if an object could not be written
+func RestoreBucket(ctx context.Context, storage Storage, r io.Reader, bucketName string) (*RestoreReport, error) {
+	if _, err := storage.DescribeBucket(ctx, bucketName); err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil || header.Name != BackupManifestName {
+		return nil, fmt.Errorf("%w: archive does not start with %s", ErrInvalidBackup, BackupManifestName)
+	}
+	manifest := &BackupManifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode manifest: %v", ErrInvalidBackup, err)
+	}
+	if manifest.FormatVersion != BackupFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidBackup, manifest.FormatVersion)
+	}
+
+	expected := make(map[string]BackupObject, len(manifest.Objects))
+	for _, obj := range manifest.Objects {
+		expected[obj.Key] = obj
+	}
+
+	report := &RestoreReport{Bucket: bucketName, SourceBucket: manifest.Bucket}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		key, ok := strings.CutPrefix(header.Name, backupObjectsDir)
+		obj, listed := expected[key]
+		if !ok || !listed || header.Size != obj.Size {
+			return report, fmt.Errorf("%w: entry %s does not match the manifest", ErrInvalidBackup, header.Name)
+		}
+		delete(expected, key)
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return report, fmt.Errorf("%w: failed to read entry %s: %v", ErrInvalidBackup, header.Name, err)
+		}
+		if checksum, ok := header.PAXRecords[backupChecksumRecord]; !ok || checksum != entryChecksum(data) {
+			return report, fmt.Errorf("%w: entry %s does not match its checksum", ErrInvalidBackup, header.Name)
+		}
+		if _, err := storage.WriteFileWithOptions(ctx, bucketName, key, data, WriteOptions{UserMetadata: obj.UserMetadata}); err != nil {
+			return report, err
+		}
+		report.Objects++
+		report.Bytes += int64(len(data))
+	}
+
+	if len(expected) > 0 {
+		return report, fmt.Errorf("%w: %d objects of the manifest are missing from the archive", ErrInvalidBackup, len(expected))
+	}
+	return report, nil
+}
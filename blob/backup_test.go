@@ -0,0 +1,204 @@
+package blob
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestExportAndRestoreBucket(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	files := map[string]string{"orders/1": "first", "orders/2": "second", "users/1": "other"}
+	for name, data := range files {
+		if _, err := client.WriteFile(ctx, bucketName, name, []byte(data)); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	// Only the current version is exported
+	if _, err := client.WriteFile(ctx, bucketName, "orders/1", []byte("updated")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	var archive bytes.Buffer
+	manifest, err := ExportBucket(ctx, client, bucketName, "orders/", &archive)
+	if err != nil {
+		t.Fatalf("ExportBucket() failed: %v", err)
+	}
+	if len(manifest.Objects) != 2 {
+		t.Fatalf("Expected 2 objects in the manifest, got %d", len(manifest.Objects))
+	}
+	if manifest.Objects[0].VersionID == "" {
+		t.Error("Expected the manifest to record version IDs")
+	}
+
+	if err := client.CreateBucket(ctx, "restored-bucket", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	report, err := RestoreBucket(ctx, client, &archive, "restored-bucket")
+	if err != nil {
+		t.Fatalf("RestoreBucket() failed: %v", err)
+	}
+	if report.Objects != 2 || report.SourceBucket != bucketName {
+		t.Errorf("Expected 2 objects restored from %s, got %+v", bucketName, report)
+	}
+
+	data, err := client.ReadFile(ctx, "restored-bucket", "orders/1", "")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if string(data) != "updated" {
+		t.Errorf("Expected restored content 'updated', got %q", data)
+	}
+	if exists, _ := client.FileExists(ctx, "restored-bucket", "users/1"); exists {
+		t.Error("Expected keys outside the prefix not to be exported")
+	}
+}
+
+func TestExportBucket_BucketNotFound(t *testing.T) {
+	client, _ := setupMockClient(t)
+	if _, err := ExportBucket(context.Background(), client, "missing-bucket", "", &bytes.Buffer{}); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestRestoreBucket_InvalidArchive(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+
+	// An archive without manifest
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := writeTarEntry(tw, backupObjectsDir+"orders/1", time.Unix(0, 0), []byte("data")); err != nil {
+		t.Fatalf("writeTarEntry() failed: %v", err)
+	}
+	_ = tw.Close()
+
+	if _, err := RestoreBucket(context.Background(), client, &archive, bucketName); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expected ErrInvalidBackup, got %v", err)
+	}
+}
+
+func TestRestoreBucket_EntryNotInManifest(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+	ctx := context.Background()
+
+	var archive bytes.Buffer
+	if _, err := ExportBucket(ctx, client, bucketName, "", &archive); err != nil {
+		t.Fatalf("ExportBucket() failed: %v", err)
+	}
+	// Drop the end-of-archive marker and append an unlisted entry
+	tampered := bytes.NewBuffer(archive.Bytes()[:archive.Len()-1024])
+	tw := tar.NewWriter(tampered)
+	if err := writeTarEntry(tw, backupObjectsDir+"injected", time.Unix(0, 0), []byte("data")); err != nil {
+		t.Fatalf("writeTarEntry() failed: %v", err)
+	}
+	_ = tw.Close()
+
+	if _, err := RestoreBucket(ctx, client, tampered, bucketName); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expected ErrInvalidBackup, got %v", err)
+	}
+	if exists, _ := client.FileExists(ctx, bucketName, "injected"); exists {
+		t.Error("Expected the unlisted entry not to be restored")
+	}
+}
+
+func TestClient_RestoreBucket_Checksums(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(header *tar.Header, data []byte) []byte
+	}{
+		{"corrupt content", func(header *tar.Header, data []byte) []byte {
+			return bytes.ToUpper(data)
+		}},
+		{"missing checksum", func(header *tar.Header, data []byte) []byte {
+			delete(header.PAXRecords, backupChecksumRecord)
+			return data
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, bucketName := setupMockClient(t)
+			ctx := context.Background()
+			if _, err := client.WriteFile(ctx, bucketName, "orders/1", []byte("first")); err != nil {
+				t.Fatalf("WriteFile() failed: %v", err)
+			}
+			var archive bytes.Buffer
+			if _, err := ExportBucket(ctx, client, bucketName, "", &archive); err != nil {
+				t.Fatalf("ExportBucket() failed: %v", err)
+			}
+
+			// Copy the archive, tampering with the object entries
+			var tampered bytes.Buffer
+			tr, tw := tar.NewReader(&archive), tar.NewWriter(&tampered)
+			for {
+				header, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Failed to read the archive: %v", err)
+				}
+				data, _ := io.ReadAll(tr)
+				if header.Name != BackupManifestName {
+					data = tt.tamper(header, data)
+				}
+				_ = tw.WriteHeader(header)
+				_, _ = tw.Write(data)
+			}
+			_ = tw.Close()
+
+			restoreBucket := "restore-bucket"
+			if err := client.CreateBucket(ctx, restoreBucket, ObjectLockConfig{}); err != nil {
+				t.Fatalf("CreateBucket() failed: %v", err)
+			}
+			if _, err := RestoreBucket(ctx, client, &tampered, restoreBucket); !errors.Is(err, ErrInvalidBackup) {
+				t.Errorf("Expected ErrInvalidBackup, got %v", err)
+			}
+			if exists, _ := client.FileExists(ctx, restoreBucket, "orders/1"); exists {
+				t.Error("Expected the tampered entry not to be restored")
+			}
+		})
+	}
+}
+
+func TestExportAndRestoreBucket_OtherProvider(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+	gcsClient, _ := setupGCSClient(t)
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	if _, err := client.WriteFileWithOptions(ctx, bucketName, "orders/1", []byte("first"), WriteOptions{UserMetadata: map[string]string{"Owner": "billing"}}); err != nil {
+		t.Fatalf("WriteFileWithOptions() failed: %v", err)
+	}
+
+	var archive bytes.Buffer
+	manifest, err := ExportBucket(ctx, client, bucketName, "", &archive)
+	if err != nil {
+		t.Fatalf("ExportBucket() failed: %v", err)
+	}
+	if got := manifest.Objects[0].UserMetadata; len(got) != 1 || got["Owner"] != "billing" {
+		t.Errorf("Expected the manifest to record the user metadata without the checksum, got %v", got)
+	}
+	if _, err := RestoreBucket(ctx, gcsClient, &archive, "orders"); err != nil {
+		t.Fatalf("RestoreBucket() failed: %v", err)
+	}
+
+	info, err := gcsClient.StatObject(ctx, "orders", "orders/1", "")
+	if err != nil {
+		t.Fatalf("StatObject() failed: %v", err)
+	}
+	if info.UserMetadata["Owner"] != "billing" {
+		t.Errorf("Expected the user metadata to be restored, got %v", info.UserMetadata)
+	}
+	if ok, err := gcsClient.VerifyObject(ctx, "orders", *info); err != nil || !ok {
+		t.Errorf("Expected the restored object to have a valid checksum, got %t (%v)", ok, err)
+	}
+}
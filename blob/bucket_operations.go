@@ -0,0 +1,311 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// ListBuckets lists all buckets reachable with the configured credentials.
+//
+// params:
+//   - ctx: Context for the operation
+//
+// return:
+//   - []BucketInfo: The buckets
+//   - error: An error if the buckets could not be listed
+func (c *Client) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
+	buckets, err := c.minioClient.ListBuckets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	result := make([]BucketInfo, 0, len(buckets))
+	for _, b := range buckets {
+		result = append(result, BucketInfo{
+			Name:         b.Name,
+			CreationDate: b.CreationDate,
+		})
+	}
+	return result, nil
+}
+
+// DescribeBucket returns the versioning, lifecycle and object lock settings of a bucket.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to describe
+//
+// return:
+//   - *BucketDescription: The bucket settings
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the settings could not be read
+func (c *Client) DescribeBucket(ctx context.Context, bucketName string) (*BucketDescription, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+
+	versioning, err := c.minioClient.GetBucketVersioning(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get versioning of bucket %s: %w", bucketName, err)
+	}
+
+	lifecycleConfig, err := c.getBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := c.getObjectLock(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BucketDescription{
+		Name:           bucketName,
+		Versioning:     versioningStatus(versioning),
+		LifecycleRules: toLifecycleRules(lifecycleConfig),
+		ObjectLock:     lock,
+	}, nil
+}
+
+// DeleteBucket deletes an empty bucket.
+// Buckets still holding objects or object versions are not deleted.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to delete
+//
+// return:
+//   - error: ErrBucketNotFound or ErrBucketNotEmpty, or an error if the bucket could not be deleted
+func (c *Client) DeleteBucket(ctx context.Context, bucketName string) error {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return err
+	}
+
+	if err := c.minioClient.RemoveBucket(ctx, bucketName); err != nil {
+		if minio.ToErrorResponse(err).Code == "BucketNotEmpty" {
+			return fmt.Errorf("%w: %s", ErrBucketNotEmpty, bucketName)
+		}
+		return fmt.Errorf("failed to delete bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// BucketUsage counts the objects of a bucket and their total size.
+// Only current object versions are counted, non-current versions are cleaned up by the lifecycle rules.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//
+// return:
+//   - *BucketUsage: The object count and total size
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the objects could not be listed
+func (c *Client) BucketUsage(ctx context.Context, bucketName string) (*BucketUsage, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+
+	usage := &BucketUsage{}
+	for obj := range c.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list objects of bucket %s: %w", bucketName, obj.Err)
+		}
+		usage.Bytes += obj.Size
+		usage.Objects++
+	}
+	return usage, nil
+}
+
+// ListObjects lists the current object versions of a bucket, or of a key prefix, sorted by key.
+// Deleted objects (whose current version is a delete marker) are not listed.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - prefix: Only list keys starting with it. Empty lists the whole bucket.
+//
+// return:
+//   - []ObjectInfo: The current object versions
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the objects could not be listed
+func (c *Client) ListObjects(ctx context.Context, bucketName, prefix string) ([]ObjectInfo, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithVersions: true}
+	for obj := range c.minioClient.ListObjects(ctx, bucketName, opts) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list objects of bucket %s: %w", bucketName, obj.Err)
+		}
+		if !obj.IsLatest || obj.IsDeleteMarker {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          obj.Key,
+			VersionID:    obj.VersionID,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			LastModified: obj.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// ListObjectVersions lists every version and delete marker of the objects of a bucket, or of a key prefix.
+// Versions are listed in the order blob storage returns them: by key, newest first for S3 compatible storage.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - prefix: Only list keys starting with it. Empty lists the whole bucket.
+//
+// return:
+//   - []ObjectVersion: The object versions and delete markers
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the versions could not be listed
+func (c *Client) ListObjectVersions(ctx context.Context, bucketName, prefix string) ([]ObjectVersion, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+
+	var versions []ObjectVersion
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithVersions: true}
+	for obj := range c.minioClient.ListObjects(ctx, bucketName, opts) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list object versions of bucket %s: %w", bucketName, obj.Err)
+		}
+		versions = append(versions, ObjectVersion{
+			ObjectInfo: ObjectInfo{
+				Key:          obj.Key,
+				VersionID:    obj.VersionID,
+				Size:         obj.Size,
+				ETag:         obj.ETag,
+				LastModified: obj.LastModified,
+			},
+			IsLatest:       obj.IsLatest,
+			IsDeleteMarker: obj.IsDeleteMarker,
+		})
+	}
+	return versions, nil
+}
+
+// ProvisionBucket makes sure a bucket exists with versioning enabled and the expected lifecycle rules.
+// Buckets created outside Nimbus (e.g. by hand with versioning off) are repaired, and the report tells what was changed.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to provision
+//
+// return:
+//   - *BucketProvisionReport: What was created or repaired
+//   - error: An error if the bucket could not be provisioned, or versioning is still not enabled afterwards
+func (c *Client) ProvisionBucket(ctx context.Context, bucketName string) (*BucketProvisionReport, error) {
+	if err := validateBucketName(bucketName); err != nil {
+		return nil, err
+	}
+	if c.config == nil {
+		return nil, fmt.Errorf("config is required to provision bucket %s", bucketName)
+	}
+
+	report := &BucketProvisionReport{Name: bucketName}
+	exists, err := c.minioClient.BucketExists(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if bucket exists: %w", err)
+	}
+
+	// Capture the drift before CreateBucket repairs it
+	if exists {
+		versioning, err := c.minioClient.GetBucketVersioning(ctx, bucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get versioning of bucket %s: %w", bucketName, err)
+		}
+		report.PreviousVersioning = versioningStatus(versioning)
+
+		current, err := c.getBucketLifecycle(ctx, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		report.RepairedLifecycleRules = driftedLifecycleRules(current, c.expectedLifecycleRules(bucketName))
+	} else {
+		report.Created = true
+	}
+
+	if err := c.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		return nil, err
+	}
+
+	// Verify versioning actually took effect, it is what recovery relies on
+	versioning, err := c.minioClient.GetBucketVersioning(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify versioning of bucket %s: %w", bucketName, err)
+	}
+	if status := versioningStatus(versioning); status != VersioningEnabled {
+		return nil, fmt.Errorf("versioning of bucket %s is %s after enabling it", bucketName, status)
+	}
+
+	return report, nil
+}
+
+// ensureBucketExists returns ErrBucketNotFound if the bucket does not exist.
+func (c *Client) ensureBucketExists(ctx context.Context, bucketName string) error {
+	if bucketName == "" {
+		return fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
+	}
+	exists, err := c.minioClient.BucketExists(ctx, bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to check if bucket exists: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	}
+	return nil
+}
+
+// getBucketLifecycle returns the lifecycle configuration of a bucket, or an empty configuration if it has none.
+func (c *Client) getBucketLifecycle(ctx context.Context, bucketName string) (*lifecycle.Configuration, error) {
+	config, err := c.minioClient.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return lifecycle.NewConfiguration(), nil
+		}
+		return nil, fmt.Errorf("failed to get lifecycle of bucket %s: %w", bucketName, err)
+	}
+	return config, nil
+}
+
+// versioningStatus maps a MinIO versioning configuration to VersioningEnabled, VersioningSuspended or VersioningDisabled.
+func versioningStatus(config minio.BucketVersioningConfiguration) string {
+	switch config.Status {
+	case "Enabled":
+		return VersioningEnabled
+	case "Suspended":
+		return VersioningSuspended
+	default:
+		return VersioningDisabled
+	}
+}
+
+// toLifecycleRules converts a MinIO lifecycle configuration to provider-neutral lifecycle rules.
+func toLifecycleRules(config *lifecycle.Configuration) []LifecycleRule {
+	rules := make([]LifecycleRule, 0)
+	if config == nil {
+		return rules
+	}
+	for _, r := range config.Rules {
+		prefix := r.RuleFilter.Prefix
+		if prefix == "" {
+			prefix = r.Prefix
+		}
+		rules = append(rules, LifecycleRule{
+			ID:                              r.ID,
+			Status:                          r.Status,
+			Prefix:                          prefix,
+			ExpirationDays:                  int(r.Expiration.Days),
+			DeleteMarkerExpirationDays:      r.DelMarkerExpiration.Days,
+			NoncurrentVersionExpirationDays: int(r.NoncurrentVersionExpiration.NoncurrentDays),
+		})
+	}
+	return rules
+}
@@ -0,0 +1,324 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+func TestClient_ListBuckets(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "test-list-bucket", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	buckets, err := client.ListBuckets(ctx)
+	if err != nil {
+		t.Fatalf("ListBuckets() failed: %v", err)
+	}
+
+	found := make(map[string]bool)
+	for _, b := range buckets {
+		found[b.Name] = true
+	}
+	if !found[bucketName] || !found["test-list-bucket"] {
+		t.Errorf("Expected both buckets to be listed, got %v", buckets)
+	}
+}
+
+func TestClient_ListBuckets_Error(t *testing.T) {
+	mockClient := newMockMinioClient()
+	mockClient.setListBucketsError(errors.New("connection refused"))
+	client := NewClientWithInterface(mockClient, getTestConfig())
+
+	if _, err := client.ListBuckets(context.Background()); err == nil {
+		t.Error("ListBuckets() should have failed")
+	}
+}
+
+func TestClient_DescribeBucket_AfterCreate(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	ctx := context.Background()
+	bucketName := "test-describe-bucket"
+	if err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	description, err := client.DescribeBucket(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.Versioning != VersioningEnabled {
+		t.Errorf("Expected versioning %s, got %s", VersioningEnabled, description.Versioning)
+	}
+	if len(description.LifecycleRules) != 2 {
+		t.Fatalf("Expected 2 lifecycle rules, got %d", len(description.LifecycleRules))
+	}
+
+	rules := make(map[string]LifecycleRule)
+	for _, r := range description.LifecycleRules {
+		rules[r.ID] = r
+	}
+	if rules["CleanDeleteMarkers"].DeleteMarkerExpirationDays != 1 {
+		t.Errorf("Expected CleanDeleteMarkers to expire delete markers after 1 day, got %+v", rules["CleanDeleteMarkers"])
+	}
+	if rules["CleanOldVersions"].NoncurrentVersionExpirationDays != 1 {
+		t.Errorf("Expected CleanOldVersions to expire non-current versions after 1 day, got %+v", rules["CleanOldVersions"])
+	}
+}
+
+func TestClient_DescribeBucket_NoLifecycle(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+
+	description, err := client.DescribeBucket(context.Background(), bucketName)
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if len(description.LifecycleRules) != 0 {
+		t.Errorf("Expected no lifecycle rules, got %v", description.LifecycleRules)
+	}
+}
+
+func TestClient_DescribeBucket_NotFound(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	_, err := client.DescribeBucket(context.Background(), "missing-bucket")
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestClient_DeleteBucket_Success(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	ctx := context.Background()
+	bucketName := "test-delete-bucket"
+	if err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	if err := client.DeleteBucket(ctx, bucketName); err != nil {
+		t.Fatalf("DeleteBucket() failed: %v", err)
+	}
+
+	exists, err := client.minioClient.BucketExists(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("BucketExists() failed: %v", err)
+	}
+	if exists {
+		t.Error("Bucket should not exist after DeleteBucket()")
+	}
+}
+
+func TestClient_DeleteBucket_NotEmpty(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, bucketName, "some-file", []byte("data")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	err := client.DeleteBucket(ctx, bucketName)
+	if !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected ErrBucketNotEmpty, got %v", err)
+	}
+}
+
+func TestClient_DeleteBucket_NotFound(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	err := client.DeleteBucket(context.Background(), "missing-bucket")
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestClient_CreateBucket_InvalidName(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	err := client.CreateBucket(context.Background(), "Invalid_Bucket", ObjectLockConfig{})
+	if !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("Expected ErrInvalidBucketName, got %v", err)
+	}
+}
+
+func TestClient_ProvisionBucket_Creates(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	report, err := client.ProvisionBucket(context.Background(), "test-provision-bucket")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if !report.Created {
+		t.Error("Expected bucket to be reported as created")
+	}
+	if report.VersioningRepaired() || len(report.RepairedLifecycleRules) != 0 {
+		t.Errorf("Expected no repairs for a created bucket, got %+v", report)
+	}
+
+	description, err := client.DescribeBucket(context.Background(), "test-provision-bucket")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.Versioning != VersioningEnabled {
+		t.Errorf("Expected versioning %s, got %s", VersioningEnabled, description.Versioning)
+	}
+}
+
+func TestClient_ProvisionBucket_RepairsDrift(t *testing.T) {
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getTestConfig())
+	bucketName := "test-drifted-bucket"
+	ctx := context.Background()
+
+	// Simulate a bucket created by hand: versioning off, one rule drifted and one foreign rule
+	mockClient.createBucketForTesting(bucketName)
+	mockClient.versioning[bucketName] = false
+	drifted := &lifecycle.Configuration{Rules: []lifecycle.Rule{
+		{
+			ID:                  "CleanDeleteMarkers",
+			Status:              "Enabled",
+			DelMarkerExpiration: lifecycle.DelMarkerExpiration{Days: 30},
+		},
+		{
+			ID:         "OperatorRule",
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: "tmp/"},
+			Expiration: lifecycle.Expiration{Days: 7},
+		},
+	}}
+	if err := mockClient.SetBucketLifecycle(ctx, bucketName, drifted); err != nil {
+		t.Fatalf("SetBucketLifecycle() failed: %v", err)
+	}
+
+	report, err := client.ProvisionBucket(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if report.Created {
+		t.Error("Expected existing bucket not to be reported as created")
+	}
+	if !report.VersioningRepaired() || report.PreviousVersioning != VersioningDisabled {
+		t.Errorf("Expected versioning to be repaired from %s, got %+v", VersioningDisabled, report)
+	}
+	if len(report.RepairedLifecycleRules) != 2 {
+		t.Errorf("Expected 2 repaired lifecycle rules, got %v", report.RepairedLifecycleRules)
+	}
+
+	description, err := client.DescribeBucket(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.Versioning != VersioningEnabled {
+		t.Errorf("Expected versioning %s, got %s", VersioningEnabled, description.Versioning)
+	}
+	rules := make(map[string]LifecycleRule)
+	for _, r := range description.LifecycleRules {
+		rules[r.ID] = r
+	}
+	if rules["CleanDeleteMarkers"].DeleteMarkerExpirationDays != 1 {
+		t.Errorf("Expected CleanDeleteMarkers to be repaired to 1 day, got %+v", rules["CleanDeleteMarkers"])
+	}
+	if _, ok := rules["CleanOldVersions"]; !ok {
+		t.Error("Expected missing CleanOldVersions rule to be added")
+	}
+	if rules["OperatorRule"].ExpirationDays != 7 {
+		t.Errorf("Expected foreign rule to be kept, got %+v", rules["OperatorRule"])
+	}
+}
+
+func TestClient_ProvisionBucket_UpToDate(t *testing.T) {
+	client, _ := setupMockClient(t)
+	ctx := context.Background()
+	bucketName := "test-compliant-bucket"
+
+	if _, err := client.ProvisionBucket(ctx, bucketName); err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+
+	report, err := client.ProvisionBucket(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if report.Changed() {
+		t.Errorf("Expected no changes on an up to date bucket, got %+v", report)
+	}
+}
+
+func TestClient_ProvisionBucket_InvalidName(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	_, err := client.ProvisionBucket(context.Background(), "ab")
+	if !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("Expected ErrInvalidBucketName, got %v", err)
+	}
+}
+
+func TestClient_BucketUsage(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+	ctx := context.Background()
+
+	writes := map[string]string{"a": "12345", "b/c": "123", "b/d": "1"}
+	for key, data := range writes {
+		if _, err := client.WriteFile(ctx, bucketName, key, []byte(data)); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	// Overwriting creates a non-current version, which is not counted
+	if _, err := client.WriteFile(ctx, bucketName, "a", []byte("12")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	usage, err := client.BucketUsage(ctx, bucketName)
+	if err != nil {
+		t.Fatalf("BucketUsage() failed: %v", err)
+	}
+	if usage.Objects != 3 || usage.Bytes != 6 {
+		t.Errorf("Expected 3 objects and 6 bytes, got %d objects and %d bytes", usage.Objects, usage.Bytes)
+	}
+}
+
+func TestClient_BucketUsage_NotFound(t *testing.T) {
+	client, _ := setupMockClient(t)
+
+	_, err := client.BucketUsage(context.Background(), "missing-bucket")
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestClient_ListObjects(t *testing.T) {
+	client, bucketName := setupMockClient(t)
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	for _, name := range []string{"orders/1", "orders/1", "users/1"} {
+		if _, err := client.WriteFile(ctx, bucketName, name, []byte("data")); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	objects, err := client.ListObjects(ctx, bucketName, "orders/")
+	if err != nil {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != "orders/1" || objects[0].Size != 4 {
+		t.Errorf("Expected only the current version of orders/1, got %+v", objects)
+	}
+	if objects[0].VersionID == "" {
+		t.Error("Expected the version ID of the current version")
+	}
+}
+
+func TestClient_ListObjects_BucketNotFound(t *testing.T) {
+	client, _ := setupMockClient(t)
+	if _, err := client.ListObjects(context.Background(), "missing-bucket", ""); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
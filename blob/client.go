@@ -26,19 +26,39 @@ type Client struct {
 //   - *Client: A new blob client instance
 //   - error: An error if the client could not be initialized
 func NewClient(ctx context.Context, cfg *configurations.Config) (*Client, error) {
-	if cfg.Blob.Endpoint == "" {
+	return newClient(ctx, cfg, cfg.Blob.Endpoint, cfg.Blob.AccessKeyID, cfg.Blob.SecretAccessKey, cfg.Blob.UseSSL)
+}
+
+// NewReplicaClient creates a MinIO client for the replica endpoint of the configuration.
+// Bucket settings (versioning, lifecycle rules) applied through it are the same as for the primary endpoint.
+//
+// params:
+//   - ctx: Context for the operation
+//   - cfg: Configuration containing the replica endpoint and credentials
+//
+// return:
+//   - *Client: A new blob client instance for the replica
+//   - error: An error if the client could not be initialized
+func NewReplicaClient(ctx context.Context, cfg *configurations.Config) (*Client, error) {
+	replica := cfg.Blob.Replica
+	return newClient(ctx, cfg, replica.Endpoint, replica.AccessKeyID, replica.SecretAccessKey, replica.UseSSL)
+}
+
+// newClient creates a MinIO client for an endpoint and checks the connection.
+func newClient(ctx context.Context, cfg *configurations.Config, endpoint, accessKeyID, secretAccessKey string, useSSL bool) (*Client, error) {
+	if endpoint == "" {
 		return nil, fmt.Errorf("endpoint is required")
 	}
-	if cfg.Blob.AccessKeyID == "" {
+	if accessKeyID == "" {
 		return nil, fmt.Errorf("access key ID is required")
 	}
-	if cfg.Blob.SecretAccessKey == "" {
+	if secretAccessKey == "" {
 		return nil, fmt.Errorf("secret access key is required")
 	}
 
-	minioClient, err := minio.New(cfg.Blob.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(cfg.Blob.AccessKeyID, cfg.Blob.SecretAccessKey, ""),
-		Secure: cfg.Blob.UseSSL,
+	minioClient, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
+		Secure: useSSL,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
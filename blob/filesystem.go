@@ -0,0 +1,1102 @@
+package blob
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// fsObjectsDir is the directory of a bucket holding the current version of every object, one directory per key.
+	fsObjectsDir = "objects"
+	// fsVersionsDir is the sidecar directory of a bucket holding previous versions and delete markers, one directory per key.
+	fsVersionsDir = "versions"
+	// fsTmpDir is the directory of a bucket new versions are written to before being renamed into place.
+	fsTmpDir = "tmp"
+	// fsDeleteMarker replaces the checksum in the file name of delete markers.
+	fsDeleteMarker = "deleted"
+	// fsVersionIDLength is the length of version IDs: 16 hex digits of the write time in unix nanoseconds, and 8 random hex digits.
+	fsVersionIDLength = 24
+	// fsLockStripes is the number of locks the writes and deletes of keys are serialized with.
+	fsLockStripes = 64
+	// fsReadAttempts is the number of times a read looks a version up again when it was moved by a concurrent write.
+	fsReadAttempts = 3
+	// fsMaxKeyNameLength is the longest escaped key used as a directory name, most filesystems limit names to 255 bytes.
+	fsMaxKeyNameLength = 255
+	// fsHashedKeyPrefix starts the directory names of keys too long to be escaped, followed by the hex SHA-256 of the key.
+	// Escaped keys never start with it.
+	fsHashedKeyPrefix = "~"
+	// fsKeyFile is the file of a hashed key directory holding the key.
+	fsKeyFile = "key"
+)
+
+// Filesystem stores objects in a local directory tree, for development, CI and small edge deployments.
+// Each bucket is a directory of the root. The current version of an object is a single file named {versionID}.{sha256}
+// in objects/{escaped key}/, written to tmp/ first and renamed into place, so readers never see a partial write.
+// Keys are escaped so that no two keys differ only by case, and keys too long to be escaped are hashed (see encodeKey).
+// With KeepVersions, the previous versions and delete markers ({versionID}.deleted) are moved to the versions/{escaped key}/
+// sidecar directory, and removed after the lifecycle cleanup delays when the key is written or deleted, or the bucket provisioned.
+// Version IDs sort in write order. Object lock, retention policies and user metadata are not supported.
+// This type is thread-safe: writes and deletes of a key are serialized, reads take no lock.
+type Filesystem struct {
+	root         string
+	keepVersions bool
+	config       *configurations.Config
+	locks        [fsLockStripes]sync.Mutex
+	// lastVersion is the time of the last version ID, so version IDs are strictly increasing even within a clock tick.
+	lastVersion atomic.Int64
+}
+
+// fsVersion is a version or delete marker of an object, stored as a file.
+type fsVersion struct {
+	id string
+	// checksum is the hex SHA-256 of the content, empty for delete markers.
+	checksum     string
+	deleteMarker bool
+	path         string
+	// current is true if the version is the file of the objects directory.
+	current bool
+}
+
+// modified returns the time the version was written, from its ID.
+func (v fsVersion) modified() time.Time {
+	ns, _ := strconv.ParseInt(v.id[:16], 16, 64)
+	return time.Unix(0, ns).UTC()
+}
+
+// NewFilesystem creates a filesystem storage in the configured root directory, created if missing.
+//
+// params:
+//   - cfg: Configuration containing the filesystem root and version settings
+//
+// return:
+//   - *Filesystem: A new filesystem storage
+//   - error: An error if the root directory could not be created
+func NewFilesystem(cfg *configurations.Config) (*Filesystem, error) {
+	root := cfg.Blob.Filesystem.Root
+	if root == "" {
+		return nil, fmt.Errorf("filesystem root is required")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create filesystem root %s: %w", root, err)
+	}
+	return &Filesystem{
+		root:         root,
+		keepVersions: cfg.Blob.Filesystem.KeepVersions,
+		config:       cfg,
+	}, nil
+}
+
+// encodeKey escapes an object key into a single file name. Leading dots and tildes are escaped too, so "." and ".."
+// stay valid keys and escaped keys never look hashed. Upper case letters are escaped, so keys differing only by case
+// get names that differ by more than case on case-insensitive filesystems (macOS, Windows).
+// A key longer than fsMaxKeyNameLength once escaped is named after its hash, and its directory holds the key in fsKeyFile.
+func encodeKey(key string) string {
+	escaped := url.PathEscape(key)
+	var name strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		switch {
+		case c == '%':
+			// The hex digits of an escape are always upper case, and never mistaken for letters of the key
+			name.WriteString(escaped[i : i+3])
+			i += 2
+		case ('A' <= c && c <= 'Z') || (i == 0 && (c == '.' || c == '~')):
+			fmt.Fprintf(&name, "%%%02X", c)
+		default:
+			name.WriteByte(c)
+		}
+	}
+	if name.Len() > fsMaxKeyNameLength {
+		sum := sha256.Sum256([]byte(key))
+		return fsHashedKeyPrefix + hex.EncodeToString(sum[:])
+	}
+	return name.String()
+}
+
+// decodeKey returns the object key of a directory named with encodeKey.
+//
+// params:
+//   - dir: The directory holding the key directory
+//   - name: The name of the key directory
+//
+// return:
+//   - string: The object key
+//   - error: An error if the name is not escaped, or the key of a hashed name could not be read
+func decodeKey(dir, name string) (string, error) {
+	if strings.HasPrefix(name, fsHashedKeyPrefix) {
+		key, err := os.ReadFile(filepath.Join(dir, name, fsKeyFile))
+		return string(key), err
+	}
+	return url.PathUnescape(name)
+}
+
+// makeKeyDir creates a key directory (objects or versions) named with encodeKey, and the key file of a hashed name
+// if it is missing. Must be called with the key locked.
+//
+// return:
+//   - bool: True if the directory was created
+//   - error: An error if the directory or the key file could not be created
+func makeKeyDir(dir, fileName string) (bool, error) {
+	_, err := os.Stat(dir)
+	created := errors.Is(err, fs.ErrNotExist)
+	if created {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return false, err
+		}
+	}
+	if strings.HasPrefix(filepath.Base(dir), fsHashedKeyPrefix) {
+		keyFile := filepath.Join(dir, fsKeyFile)
+		if _, err := os.Stat(keyFile); errors.Is(err, fs.ErrNotExist) {
+			if err := os.WriteFile(keyFile, []byte(fileName), 0o644); err != nil {
+				return false, err
+			}
+		}
+	}
+	return created, nil
+}
+
+// syncDir flushes a directory, so the entries renamed into it or created in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// bucketDir returns the directory of a bucket.
+func (f *Filesystem) bucketDir(bucketName string) string {
+	return filepath.Join(f.root, bucketName)
+}
+
+// objectDir returns the directory holding the current version of an object.
+func (f *Filesystem) objectDir(bucketName, fileName string) string {
+	return filepath.Join(f.root, bucketName, fsObjectsDir, encodeKey(fileName))
+}
+
+// versionsDir returns the sidecar directory holding the previous versions of an object.
+func (f *Filesystem) versionsDir(bucketName, fileName string) string {
+	return filepath.Join(f.root, bucketName, fsVersionsDir, encodeKey(fileName))
+}
+
+// lockKey returns the lock serializing the writes and deletes of a key.
+func (f *Filesystem) lockKey(bucketName, fileName string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(bucketName + "/" + fileName))
+	return &f.locks[h.Sum32()%fsLockStripes]
+}
+
+// newVersionID returns a version ID sorting after every version ID returned before.
+func (f *Filesystem) newVersionID() string {
+	now := time.Now().UnixNano()
+	for {
+		last := f.lastVersion.Load()
+		if now <= last {
+			now = last + 1
+		}
+		if f.lastVersion.CompareAndSwap(last, now) {
+			break
+		}
+	}
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	return fmt.Sprintf("%016x%s", now, hex.EncodeToString(suffix[:]))
+}
+
+// versioning returns the versioning status of the buckets, set by the provider configuration.
+func (f *Filesystem) versioning() string {
+	if f.keepVersions {
+		return VersioningEnabled
+	}
+	return VersioningDisabled
+}
+
+// ensureBucketExists returns ErrInvalidBucketName if the name is not a valid bucket name, and ErrBucketNotFound if the bucket does not exist.
+func (f *Filesystem) ensureBucketExists(bucketName string) error {
+	if bucketName == "" {
+		return fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
+	}
+	// Also keeps paths inside the root
+	if err := validateBucketName(bucketName); err != nil {
+		return err
+	}
+	info, err := os.Stat(f.bucketDir(bucketName))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check if bucket exists: %w", err)
+	}
+	return nil
+}
+
+// readVersions returns the versions stored in a directory, oldest first. A missing directory holds no versions.
+func readVersions(dir string, current bool) ([]fsVersion, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	versions := make([]fsVersion, 0, len(entries))
+	for _, entry := range entries {
+		id, suffix, ok := strings.Cut(entry.Name(), ".")
+		if !ok || len(id) != fsVersionIDLength || entry.IsDir() {
+			continue
+		}
+		v := fsVersion{id: id, path: filepath.Join(dir, entry.Name()), current: current}
+		if suffix == fsDeleteMarker {
+			v.deleteMarker = true
+		} else {
+			v.checksum = suffix
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].id < versions[j].id })
+	return versions, nil
+}
+
+// keyVersions returns every version and delete marker of an object, oldest first.
+// A write interrupted between the rename and the retirement of the previous version can leave several files
+// in the objects directory, only the newest is current.
+func (f *Filesystem) keyVersions(bucketName, fileName string) ([]fsVersion, error) {
+	previous, err := readVersions(f.versionsDir(bucketName, fileName), false)
+	if err != nil {
+		return nil, err
+	}
+	current, err := readVersions(f.objectDir(bucketName, fileName), true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range current[:max(len(current)-1, 0)] {
+		current[i].current = false
+	}
+	versions := append(previous, current...)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].id < versions[j].id })
+	return versions, nil
+}
+
+// currentVersion returns the current version of an object, nil if the object does not exist.
+func (f *Filesystem) currentVersion(bucketName, fileName string) (*fsVersion, error) {
+	current, err := readVersions(f.objectDir(bucketName, fileName), true)
+	if err != nil || len(current) == 0 {
+		return nil, err
+	}
+	return &current[len(current)-1], nil
+}
+
+// findVersion returns a version of an object, the current one if versionID is empty.
+//
+// return:
+//   - *fsVersion: The version
+//   - error: ErrObjectNotFound if the version does not exist, or is a delete marker and markers are not included
+func (f *Filesystem) findVersion(bucketName, fileName, versionID string, includeMarkers bool) (*fsVersion, error) {
+	if versionID == "" {
+		current, err := f.currentVersion(bucketName, fileName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find object %s: %w", fileName, err)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("%w: %s (version %q)", ErrObjectNotFound, fileName, versionID)
+		}
+		return current, nil
+	}
+
+	versions, err := f.keyVersions(bucketName, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find object %s: %w", fileName, err)
+	}
+	for i := range versions {
+		if versions[i].id == versionID && (includeMarkers || !versions[i].deleteMarker) {
+			return &versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s (version %q)", ErrObjectNotFound, fileName, versionID)
+}
+
+// objectInfo describes a stored version.
+func objectInfo(fileName string, v fsVersion) (ObjectInfo, error) {
+	info := ObjectInfo{Key: fileName, VersionID: v.id, LastModified: v.modified(), Checksum: v.checksum}
+	if v.deleteMarker {
+		return info, nil
+	}
+	stat, err := os.Stat(v.path)
+	if err != nil {
+		return info, err
+	}
+	info.Size = stat.Size()
+	return info, nil
+}
+
+// ReadFile reads an object version.
+// If versionID is empty, it reads the current version.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to read from
+//   - fileName: The name of the file to read
+//   - versionID: Optional version ID to read a specific version. If empty, reads the current version.
+//
+// return:
+//   - []byte: The file contents
+//   - error: ErrBucketNotFound, ErrObjectNotFound, or an error if the file could not be read
+func (f *Filesystem) ReadFile(ctx context.Context, bucketName, fileName, versionID string) ([]byte, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("file name cannot be empty")
+	}
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		v, err := f.findVersion(bucketName, fileName, versionID, false)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(v.path)
+		if err == nil {
+			return data, nil
+		}
+		// The version was replaced or moved to the versions directory since it was found
+		if !errors.Is(err, fs.ErrNotExist) || attempt == fsReadAttempts {
+			return nil, fmt.Errorf("failed to read object %s: %w", fileName, err)
+		}
+	}
+}
+
+// WriteFile writes a new version of an object. The content is written to a temporary file, synced,
+// and renamed into place, so the object is never seen partially written. The previous version is kept in the
+// versions directory with KeepVersions, and removed otherwise.
+// The SHA-256 of the data is stored in the file name, so the scrubber can verify it later.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to write to
+//   - fileName: The name of the file to write
+//   - data: The data to write
+//
+// return:
+//   - string: The version ID of the written file
+//   - error: ErrBucketNotFound, or an error if the file could not be written
+func (f *Filesystem) WriteFile(ctx context.Context, bucketName, fileName string, data []byte) (string, error) {
+	return f.WriteFileWithOptions(ctx, bucketName, fileName, data, WriteOptions{})
+}
+
+// WriteFileWithOptions writes a new version of an object like WriteFile.
+// User metadata is not supported, so the metadata of the options is not stored. IfNotExists is checked with the key
+// locked, like an exclusive create, so concurrent writers of the directory tree in this process cannot both create the object.
+// IfVersion is checked with the key locked too.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to write to
+//   - fileName: The name of the file to write
+//   - data: The data to write
+//   - opts: The write options
+//
+// return:
+//   - string: The version ID of the written file
+//   - error: ErrPreconditionFailed if IfNotExists is set and the object exists, or IfVersion is set and is not the current version,
+//     ErrBucketNotFound, or an error if the file could not be written
+func (f *Filesystem) WriteFileWithOptions(ctx context.Context, bucketName, fileName string, data []byte, opts WriteOptions) (string, error) {
+	if bucketName == "" {
+		return "", fmt.Errorf("bucket name cannot be empty")
+	}
+	if fileName == "" {
+		return "", fmt.Errorf("file name cannot be empty")
+	}
+	if data == nil {
+		return "", fmt.Errorf("data cannot be nil")
+	}
+	if opts.IfNotExists && opts.IfVersion != "" {
+		return "", fmt.Errorf("IfNotExists and IfVersion cannot both be set")
+	}
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return "", err
+	}
+
+	tmp, err := f.writeTemp(bucketName, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to write object %s: %w", fileName, err)
+	}
+	defer os.Remove(tmp) // no-op once renamed
+
+	mu := f.lockKey(bucketName, fileName)
+	mu.Lock()
+	defer mu.Unlock()
+
+	dir := f.objectDir(bucketName, fileName)
+	previous, err := readVersions(dir, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to write object %s: %w", fileName, err)
+	}
+	current := len(previous) > 0 && !previous[len(previous)-1].deleteMarker
+	if opts.IfNotExists && current {
+		return "", fmt.Errorf("%w: %s exists in bucket %s", ErrPreconditionFailed, fileName, bucketName)
+	}
+	if opts.IfVersion != "" && (!current || previous[len(previous)-1].id != opts.IfVersion) {
+		return "", fmt.Errorf("%w: %s is not at version %q in bucket %s", ErrPreconditionFailed, fileName, opts.IfVersion, bucketName)
+	}
+	created, err := makeKeyDir(dir, fileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to write object %s: %w", fileName, err)
+	}
+	versionID := f.newVersionID()
+	if err := os.Rename(tmp, filepath.Join(dir, versionID+"."+checksum(data))); err != nil {
+		return "", fmt.Errorf("failed to write object %s: %w", fileName, err)
+	}
+	// The rename, and the key directory if new, are only durable once their directories are synced
+	err = syncDir(dir)
+	if err == nil && created {
+		err = syncDir(filepath.Dir(dir))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sync object %s: %w", fileName, err)
+	}
+
+	// The new version is current from here, a failure to retire the previous one is fixed by the next write
+	if err := f.retire(bucketName, fileName, previous); err != nil {
+		return versionID, fmt.Errorf("failed to keep previous version of object %s: %w", fileName, err)
+	}
+	if err := f.pruneKey(bucketName, fileName, time.Now()); err != nil {
+		return versionID, fmt.Errorf("failed to clean versions of object %s: %w", fileName, err)
+	}
+	return versionID, nil
+}
+
+// writeTemp writes data to a new synced file of the temporary directory of a bucket, and returns its path.
+func (f *Filesystem) writeTemp(bucketName string, data []byte) (string, error) {
+	dir := filepath.Join(f.bucketDir(bucketName), fsTmpDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dir, "write-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// retire moves versions that are not current anymore to the versions directory with KeepVersions, and removes them otherwise.
+// Must be called with the key locked.
+func (f *Filesystem) retire(bucketName, fileName string, versions []fsVersion) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	if !f.keepVersions {
+		for _, v := range versions {
+			if err := os.Remove(v.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	dir := f.versionsDir(bucketName, fileName)
+	if _, err := makeKeyDir(dir, fileName); err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if err := os.Rename(v.path, filepath.Join(dir, filepath.Base(v.path))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneKey applies the lifecycle cleanup rules to the versions of an object: non-current versions are removed
+// nonCurrentVersionCleanupDelayDays after being replaced, and an object whose current version is a delete marker
+// is removed with all its versions deleteMarkerCleanupDelayDays after its deletion. Must be called with the key locked.
+func (f *Filesystem) pruneKey(bucketName, fileName string, now time.Time) error {
+	if !f.keepVersions {
+		return nil
+	}
+	versions, err := f.keyVersions(bucketName, fileName)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+
+	latest := versions[len(versions)-1]
+	deleteMarkerDelay := time.Duration(f.config.Blob.DeleteMarkerCleanupDelayDays) * 24 * time.Hour
+	if latest.deleteMarker && now.Sub(latest.modified()) >= deleteMarkerDelay {
+		if err := os.RemoveAll(f.versionsDir(bucketName, fileName)); err != nil {
+			return err
+		}
+		return f.removeEmptyDirs(bucketName, fileName)
+	}
+
+	nonCurrentDelay := time.Duration(f.config.Blob.NonCurrentVersionCleanupDelayDays) * 24 * time.Hour
+	for i, v := range versions[:len(versions)-1] {
+		// A version is non-current since the next version was written
+		if v.deleteMarker || now.Sub(versions[i+1].modified()) < nonCurrentDelay {
+			continue
+		}
+		if err := os.Remove(v.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return f.removeEmptyDirs(bucketName, fileName)
+}
+
+// removeEmptyDirs removes the objects and versions directories of a key if they hold no version anymore
+// (only the key file of a hashed name).
+func (f *Filesystem) removeEmptyDirs(bucketName, fileName string) error {
+	for _, dir := range []string{f.objectDir(bucketName, fileName), f.versionsDir(bucketName, fileName)} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if len(entries) == 0 || (len(entries) == 1 && entries[0].Name() == fsKeyFile) {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StatObject describes an object version without reading its content.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - fileName: The name of the file
+//   - versionID: Optional version ID. If empty, describes the current version.
+//
+// return:
+//   - *ObjectInfo: The object version
+//   - error: ErrBucketNotFound, ErrObjectNotFound, or an error if it could not be described
+func (f *Filesystem) StatObject(ctx context.Context, bucketName, fileName, versionID string) (*ObjectInfo, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		v, err := f.findVersion(bucketName, fileName, versionID, false)
+		if err != nil {
+			return nil, err
+		}
+		info, err := objectInfo(fileName, *v)
+		if err == nil {
+			return &info, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) || attempt == fsReadAttempts {
+			return nil, fmt.Errorf("failed to stat object %s: %w", fileName, err)
+		}
+	}
+}
+
+// FileExists checks if an object has a current version.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to check
+//   - fileName: The name of the file to check
+//
+// return:
+//   - bool: True if the file exists, false otherwise
+//   - error: ErrBucketNotFound, or an error if the check fails
+func (f *Filesystem) FileExists(ctx context.Context, bucketName, fileName string) (bool, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return false, err
+	}
+	current, err := f.currentVersion(bucketName, fileName)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat object %s: %w", fileName, err)
+	}
+	return current != nil, nil
+}
+
+// DeleteObject deletes an object. Without version ID, the object is deleted: with KeepVersions,
+// its current version is kept in the versions directory behind a delete marker, so it can be restored.
+// With a version ID, that version is deleted permanently, and if it was current the newest remaining version becomes current.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - fileName: The name of the file to delete
+//   - versionID: Optional version ID of the version to delete permanently
+//
+// return:
+//   - error: ErrBucketNotFound, ErrObjectNotFound if the version does not exist, or an error if the object could not be deleted
+func (f *Filesystem) DeleteObject(ctx context.Context, bucketName, fileName, versionID string) error {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return err
+	}
+	mu := f.lockKey(bucketName, fileName)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if versionID == "" {
+		current, err := readVersions(f.objectDir(bucketName, fileName), true)
+		if err != nil {
+			return fmt.Errorf("failed to delete object %s: %w", fileName, err)
+		}
+		if len(current) == 0 {
+			return nil
+		}
+		if err := f.retire(bucketName, fileName, current); err != nil {
+			return fmt.Errorf("failed to delete object %s: %w", fileName, err)
+		}
+		if f.keepVersions {
+			marker := filepath.Join(f.versionsDir(bucketName, fileName), f.newVersionID()+"."+fsDeleteMarker)
+			if err := os.WriteFile(marker, nil, 0o644); err != nil {
+				return fmt.Errorf("failed to delete object %s: %w", fileName, err)
+			}
+		}
+		if err := f.pruneKey(bucketName, fileName, time.Now()); err != nil {
+			return fmt.Errorf("failed to clean versions of object %s: %w", fileName, err)
+		}
+		return f.removeEmptyDirs(bucketName, fileName)
+	}
+
+	v, err := f.findVersion(bucketName, fileName, versionID, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(v.path); err != nil {
+		return fmt.Errorf("failed to delete version %s of object %s: %w", versionID, fileName, err)
+	}
+	if v.current {
+		// The newest remaining version becomes current, the object stays deleted if it is a delete marker
+		previous, err := readVersions(f.versionsDir(bucketName, fileName), false)
+		if err != nil {
+			return fmt.Errorf("failed to delete version %s of object %s: %w", versionID, fileName, err)
+		}
+		if n := len(previous); n > 0 && !previous[n-1].deleteMarker {
+			if err := os.Rename(previous[n-1].path, filepath.Join(f.objectDir(bucketName, fileName), filepath.Base(previous[n-1].path))); err != nil {
+				return fmt.Errorf("failed to restore previous version of object %s: %w", fileName, err)
+			}
+		}
+	}
+	return f.removeEmptyDirs(bucketName, fileName)
+}
+
+// listKeys returns the keys of a bucket starting with prefix that have a directory in dir (objects or versions), sorted.
+func (f *Filesystem) listKeys(bucketName, dir, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(f.bucketDir(bucketName), dir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		key, err := decodeKey(filepath.Join(f.bucketDir(bucketName), dir), entry.Name())
+		if err != nil && strings.HasPrefix(entry.Name(), fsHashedKeyPrefix) && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		// Not a key directory, or a hashed one being created or removed
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListObjects lists the current object versions of a bucket, or of a key prefix, sorted by key.
+// Deleted objects are not listed.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - prefix: Only list keys starting with it. Empty lists the whole bucket.
+//
+// return:
+//   - []ObjectInfo: The current object versions
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the objects could not be listed
+func (f *Filesystem) ListObjects(ctx context.Context, bucketName, prefix string) ([]ObjectInfo, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+	keys, err := f.listKeys(bucketName, fsObjectsDir, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects of bucket %s: %w", bucketName, err)
+	}
+
+	var objects []ObjectInfo
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		current, err := f.currentVersion(bucketName, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of bucket %s: %w", bucketName, err)
+		}
+		if current == nil {
+			continue
+		}
+		info, err := objectInfo(key, *current)
+		if err != nil {
+			// Replaced or deleted since listed
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to list objects of bucket %s: %w", bucketName, err)
+		}
+		objects = append(objects, info)
+	}
+	return objects, nil
+}
+
+// ListObjectVersions lists every version and delete marker of the objects of a bucket, or of a key prefix,
+// by key and newest first.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - prefix: Only list keys starting with it. Empty lists the whole bucket.
+//
+// return:
+//   - []ObjectVersion: The object versions and delete markers
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the versions could not be listed
+func (f *Filesystem) ListObjectVersions(ctx context.Context, bucketName, prefix string) ([]ObjectVersion, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+	current, err := f.listKeys(bucketName, fsObjectsDir, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list object versions of bucket %s: %w", bucketName, err)
+	}
+	previous, err := f.listKeys(bucketName, fsVersionsDir, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list object versions of bucket %s: %w", bucketName, err)
+	}
+	keys := append(current, previous...)
+	sort.Strings(keys)
+
+	var versions []ObjectVersion
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		keyVersions, err := f.keyVersions(bucketName, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object versions of bucket %s: %w", bucketName, err)
+		}
+		for j := len(keyVersions) - 1; j >= 0; j-- {
+			info, err := objectInfo(key, keyVersions[j])
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to list object versions of bucket %s: %w", bucketName, err)
+			}
+			versions = append(versions, ObjectVersion{
+				ObjectInfo:     info,
+				IsLatest:       j == len(keyVersions)-1,
+				IsDeleteMarker: keyVersions[j].deleteMarker,
+			})
+		}
+	}
+	return versions, nil
+}
+
+// VerifyObject re-reads an object version and checks it against its listing and the checksum in its file name.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket of the object
+//   - obj: The object version to verify, as returned by ListObjects
+//
+// return:
+//   - bool: True if the checksum was verified
+//   - error: ErrObjectCorrupt if the content does not match, or an error if the object could not be read
+func (f *Filesystem) VerifyObject(ctx context.Context, bucketName string, obj ObjectInfo) (bool, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return false, err
+	}
+	v, err := f.findVersion(bucketName, obj.Key, obj.VersionID, false)
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read object %s: %w", obj.Key, err)
+	}
+
+	if int64(len(data)) != obj.Size {
+		return false, fmt.Errorf("%w: %s has size %d, listed with %d", ErrObjectCorrupt, obj.Key, len(data), obj.Size)
+	}
+	if actual := checksum(data); !strings.EqualFold(actual, v.checksum) {
+		return true, fmt.Errorf("%w: %s has checksum %s, stored %s", ErrObjectCorrupt, obj.Key, actual, v.checksum)
+	}
+	return true, nil
+}
+
+// ListBuckets lists the bucket directories of the root.
+//
+// params:
+//   - ctx: Context for the operation
+//
+// return:
+//   - []BucketInfo: The buckets, with the modification time of their directory as creation date
+//   - error: An error if the root could not be read
+func (f *Filesystem) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
+	entries, err := os.ReadDir(f.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	result := make([]BucketInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || validateBucketName(entry.Name()) != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		result = append(result, BucketInfo{Name: entry.Name(), CreationDate: info.ModTime().UTC()})
+	}
+	return result, nil
+}
+
+// CreateBucket creates the directory of a bucket. Creating an existing bucket does nothing.
+// Object lock is not supported.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to create
+//   - lock: Must be the zero value, the filesystem has no object lock
+//
+// return:
+//   - error: ErrInvalidBucketName, ErrInvalidObjectLock if object lock is requested, or an error if the directory could not be created
+func (f *Filesystem) CreateBucket(ctx context.Context, bucketName string, lock ObjectLockConfig) error {
+	if bucketName == "" {
+		return fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
+	}
+	if err := validateBucketName(bucketName); err != nil {
+		return err
+	}
+	if lock.Enabled {
+		return fmt.Errorf("%w: object lock is not supported by the %s blob provider", ErrInvalidObjectLock, configurations.BlobProviderFilesystem)
+	}
+	if err := lock.Validate(); err != nil {
+		return err
+	}
+
+	for _, dir := range []string{fsObjectsDir, fsVersionsDir, fsTmpDir} {
+		if err := os.MkdirAll(filepath.Join(f.bucketDir(bucketName), dir), 0o755); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+		}
+	}
+	return nil
+}
+
+// ProvisionBucket makes sure a bucket exists, and applies the version cleanup to all its objects.
+// Versioning is set for all buckets by the provider configuration, so it is never reported as repaired.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to provision
+//
+// return:
+//   - *BucketProvisionReport: Whether the bucket was created
+//   - error: An error if the bucket could not be created or its versions cleaned
+func (f *Filesystem) ProvisionBucket(ctx context.Context, bucketName string) (*BucketProvisionReport, error) {
+	report := &BucketProvisionReport{Name: bucketName}
+	err := f.ensureBucketExists(bucketName)
+	switch {
+	case errors.Is(err, ErrBucketNotFound):
+		report.Created = true
+	case err != nil:
+		return nil, err
+	}
+	if err := f.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		return nil, err
+	}
+	// Clean leftovers of interrupted writes
+	if err := os.RemoveAll(filepath.Join(f.bucketDir(bucketName), fsTmpDir)); err != nil {
+		return nil, fmt.Errorf("failed to clean temporary files of bucket %s: %w", bucketName, err)
+	}
+	if err := os.MkdirAll(filepath.Join(f.bucketDir(bucketName), fsTmpDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+	}
+
+	keys, err := f.listKeys(bucketName, fsVersionsDir, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean versions of bucket %s: %w", bucketName, err)
+	}
+	now := time.Now()
+	for _, key := range keys {
+		mu := f.lockKey(bucketName, key)
+		mu.Lock()
+		err := f.pruneKey(bucketName, key, now)
+		mu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to clean versions of object %s: %w", key, err)
+		}
+	}
+	return report, nil
+}
+
+// DescribeBucket returns the versioning settings of a bucket, and the version cleanup rules applied with KeepVersions.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to describe
+//
+// return:
+//   - *BucketDescription: The bucket settings
+//   - error: ErrBucketNotFound if the bucket does not exist
+func (f *Filesystem) DescribeBucket(ctx context.Context, bucketName string) (*BucketDescription, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+	description := &BucketDescription{Name: bucketName, Versioning: f.versioning(), LifecycleRules: []LifecycleRule{}}
+	if f.keepVersions {
+		description.LifecycleRules = []LifecycleRule{
+			{ID: cleanDeleteMarkersRuleID, Status: "Enabled", DeleteMarkerExpirationDays: f.config.Blob.DeleteMarkerCleanupDelayDays},
+			{ID: cleanOldVersionsRuleID, Status: "Enabled", NoncurrentVersionExpirationDays: f.config.Blob.NonCurrentVersionCleanupDelayDays},
+		}
+	}
+	return description, nil
+}
+
+// CheckBucketSettings checks that a bucket exists. Its settings are set by the provider configuration and cannot drift.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket to check
+//
+// return:
+//   - *BucketSettingsDrift: No drift
+//   - error: ErrBucketNotFound if the bucket does not exist
+func (f *Filesystem) CheckBucketSettings(ctx context.Context, bucketName string) (*BucketSettingsDrift, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+	return &BucketSettingsDrift{Name: bucketName}, nil
+}
+
+// DeleteBucket deletes an empty bucket. Buckets still holding objects or object versions are not deleted.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to delete
+//
+// return:
+//   - error: ErrBucketNotFound or ErrBucketNotEmpty, or an error if the bucket could not be deleted
+func (f *Filesystem) DeleteBucket(ctx context.Context, bucketName string) error {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return err
+	}
+	for _, dir := range []string{fsObjectsDir, fsVersionsDir} {
+		keys, err := f.listKeys(bucketName, dir, "")
+		if err != nil {
+			return fmt.Errorf("failed to delete bucket %s: %w", bucketName, err)
+		}
+		if len(keys) > 0 {
+			return fmt.Errorf("%w: %s", ErrBucketNotEmpty, bucketName)
+		}
+	}
+	if err := os.RemoveAll(f.bucketDir(bucketName)); err != nil {
+		return fmt.Errorf("failed to delete bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// BucketUsage returns the number and total size of the current objects of a bucket.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//
+// return:
+//   - *BucketUsage: The object count and total size
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the objects could not be listed
+func (f *Filesystem) BucketUsage(ctx context.Context, bucketName string) (*BucketUsage, error) {
+	objects, err := f.ListObjects(ctx, bucketName, "")
+	if err != nil {
+		return nil, err
+	}
+	usage := &BucketUsage{}
+	for _, obj := range objects {
+		usage.Bytes += obj.Size
+		usage.Objects++
+	}
+	return usage, nil
+}
+
+// PlanRetention reports the retention policies of a bucket. Retention policies are not supported by the filesystem
+// provider (the configuration rejects them), so the report has no policies.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket to report on
+//   - now: The time the objects are expired at
+//
+// return:
+//   - *RetentionReport: A report without policies
+//   - error: ErrBucketNotFound if the bucket does not exist
+func (f *Filesystem) PlanRetention(ctx context.Context, bucketName string, now time.Time) (*RetentionReport, error) {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+	return &RetentionReport{Bucket: bucketName, GeneratedAt: now.UTC(), Policies: []RetentionPolicyReport{}}, nil
+}
+
+// SetLegalHold always fails: the filesystem has no object lock.
+//
+// return:
+//   - error: ErrBucketNotFound, or ErrObjectLockNotEnabled
+func (f *Filesystem) SetLegalHold(ctx context.Context, bucketName, fileName, versionID string, on bool) error {
+	if err := f.ensureBucketExists(bucketName); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w on bucket %s, the %s blob provider has no object lock", ErrObjectLockNotEnabled, bucketName, configurations.BlobProviderFilesystem)
+}
+
+// GetLegalHold always fails: the filesystem has no object lock.
+//
+// return:
+//   - bool: Always false
+//   - error: ErrBucketNotFound, or ErrObjectLockNotEnabled
+func (f *Filesystem) GetLegalHold(ctx context.Context, bucketName, fileName, versionID string) (bool, error) {
+	return false, f.SetLegalHold(ctx, bucketName, fileName, versionID, false)
+}
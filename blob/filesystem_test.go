@@ -0,0 +1,296 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupFilesystem creates a filesystem storage in a temporary directory, with a bucket named orders.
+func setupFilesystem(t *testing.T, keepVersions bool) *Filesystem {
+	cfg := getTestConfig()
+	cfg.Blob.Filesystem.Root = t.TempDir()
+	cfg.Blob.Filesystem.KeepVersions = keepVersions
+	storage, err := NewFilesystem(cfg)
+	if err != nil {
+		t.Fatalf("NewFilesystem() failed: %v", err)
+	}
+	if err := storage.CreateBucket(context.Background(), "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	return storage
+}
+
+func TestFilesystem_WriteFile(t *testing.T) {
+	storage := setupFilesystem(t, true)
+	ctx := context.Background()
+	first, err := storage.WriteFile(ctx, "orders", "eu/order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	second, err := storage.WriteFile(ctx, "orders", "eu/order-1", []byte("second!"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if second <= first {
+		t.Errorf("Expected version IDs to sort in write order, got %s then %s", first, second)
+	}
+
+	// Temporary files are renamed into place
+	entries, err := os.ReadDir(filepath.Join(storage.root, "orders", fsTmpDir))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected no temporary files left, got %d (%v)", len(entries), err)
+	}
+}
+
+func TestFilesystem_WriteIfVersion(t *testing.T) {
+	storage := setupFilesystem(t, true)
+	ctx := context.Background()
+	first, err := storage.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := storage.WriteFileWithOptions(ctx, "orders", "order-1", []byte("second"), WriteOptions{IfVersion: first}); err != nil {
+		t.Fatalf("WriteFileWithOptions() failed: %v", err)
+	}
+	// A writer still holding the first version lost the race
+	if _, err := storage.WriteFileWithOptions(ctx, "orders", "order-1", []byte("stale"), WriteOptions{IfVersion: first}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a stale version, got %v", err)
+	}
+	if _, err := storage.WriteFileWithOptions(ctx, "orders", "order-2", []byte("new"), WriteOptions{IfVersion: first}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a missing object, got %v", err)
+	}
+	if data, err := storage.ReadFile(ctx, "orders", "order-1", ""); err != nil || string(data) != "second" {
+		t.Errorf("Expected %q, got %q (%v)", "second", data, err)
+	}
+}
+
+func TestFilesystem_WithoutKeepVersions(t *testing.T) {
+	storage := setupFilesystem(t, false)
+	ctx := context.Background()
+	first, err := storage.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := storage.WriteFile(ctx, "orders", "order-1", []byte("second")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	if _, err := storage.ReadFile(ctx, "orders", "order-1", first); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected the previous version to be removed, got %v", err)
+	}
+	if err := storage.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	versions, err := storage.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected no versions left, got %+v", versions)
+	}
+	// The bucket is empty again
+	if err := storage.DeleteBucket(ctx, "orders"); err != nil {
+		t.Errorf("DeleteBucket() failed: %v", err)
+	}
+}
+
+func TestFilesystem_ListObjects(t *testing.T) {
+	storage := setupFilesystem(t, true)
+	ctx := context.Background()
+	// Keys that are not valid file names are escaped
+	for _, key := range []string{"eu/order-1", "..", "a b%c"} {
+		if _, err := storage.WriteFile(ctx, "orders", key, []byte(key)); err != nil {
+			t.Fatalf("WriteFile(%q) failed: %v", key, err)
+		}
+	}
+
+	objects, err := storage.ListObjects(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	want := []string{"..", "a b%c", "eu/order-1"}
+	if len(objects) != len(want) {
+		t.Fatalf("Expected %d objects, got %+v", len(want), objects)
+	}
+	for i, key := range want {
+		if objects[i].Key != key {
+			t.Errorf("Expected object %d to be %q, got %q", i, key, objects[i].Key)
+		}
+	}
+}
+
+func TestFilesystem_KeyNames(t *testing.T) {
+	storage := setupFilesystem(t, false)
+	ctx := context.Background()
+	long := strings.Repeat("é/", 100)
+	keys := []string{"Order", "order", "~order", long, long + "x"}
+	for _, key := range keys {
+		if _, err := storage.WriteFile(ctx, "orders", key, []byte(key)); err != nil {
+			t.Fatalf("WriteFile(%q) failed: %v", key, err)
+		}
+	}
+
+	// No two names differ only by case, and none is too long
+	entries, err := os.ReadDir(filepath.Join(storage.bucketDir("orders"), fsObjectsDir))
+	if err != nil {
+		t.Fatalf("ReadDir() failed: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		if len(entry.Name()) > fsMaxKeyNameLength || names[strings.ToLower(entry.Name())] {
+			t.Errorf("Expected a short name unique regardless of case, got %q", entry.Name())
+		}
+		names[strings.ToLower(entry.Name())] = true
+	}
+	if len(names) != len(keys) {
+		t.Errorf("Expected %d key directories, got %d", len(keys), len(names))
+	}
+
+	for _, key := range keys {
+		if data, err := storage.ReadFile(ctx, "orders", key, ""); err != nil || string(data) != key {
+			t.Errorf("Expected %.20q to read back, got %.20q (%v)", key, data, err)
+		}
+	}
+	objects, err := storage.ListObjects(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	want := []string{"Order", "order", "~order", long, long + "x"}
+	sort.Strings(want)
+	if len(objects) != len(want) {
+		t.Fatalf("Expected %d objects, got %d", len(want), len(objects))
+	}
+	for i, key := range want {
+		if objects[i].Key != key {
+			t.Errorf("Expected object %d to be %.20q, got %.20q", i, key, objects[i].Key)
+		}
+	}
+
+	// The directory of a hashed key is removed with its last version
+	if err := storage.DeleteObject(ctx, "orders", long, ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if _, err := os.Stat(storage.objectDir("orders", long)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the key directory to be removed, got %v", err)
+	}
+}
+
+func TestFilesystem_VerifyObject(t *testing.T) {
+	storage := setupFilesystem(t, false)
+	ctx := context.Background()
+	if _, err := storage.WriteFile(ctx, "orders", "order-1", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	objects, err := storage.ListObjects(ctx, "orders", "")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+
+	// Flip the content on disk, keeping its size
+	current, err := storage.currentVersion("orders", "order-1")
+	if err != nil || current == nil {
+		t.Fatalf("currentVersion() failed: %v", err)
+	}
+	if err := os.WriteFile(current.path, []byte("ORDER"), 0o644); err != nil {
+		t.Fatalf("Failed to corrupt object: %v", err)
+	}
+	if _, err := storage.VerifyObject(ctx, "orders", objects[0]); !errors.Is(err, ErrObjectCorrupt) {
+		t.Errorf("Expected ErrObjectCorrupt, got %v", err)
+	}
+}
+
+func TestFilesystem_PruneVersions(t *testing.T) {
+	storage := setupFilesystem(t, true)
+	ctx := context.Background()
+	first, err := storage.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := storage.WriteFile(ctx, "orders", "order-1", []byte("second")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := storage.WriteFile(ctx, "orders", "order-2", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := storage.DeleteObject(ctx, "orders", "order-2", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+
+	// Within the cleanup delays nothing is removed
+	if err := storage.pruneKey("orders", "order-1", time.Now()); err != nil {
+		t.Fatalf("pruneKey() failed: %v", err)
+	}
+	if _, err := storage.ReadFile(ctx, "orders", "order-1", first); err != nil {
+		t.Errorf("Expected the first version to be kept, got %v", err)
+	}
+
+	// After the delays, the non-current version and the deleted object are removed
+	later := time.Now().Add(48 * time.Hour)
+	if err := storage.pruneKey("orders", "order-1", later); err != nil {
+		t.Fatalf("pruneKey() failed: %v", err)
+	}
+	if err := storage.pruneKey("orders", "order-2", later); err != nil {
+		t.Fatalf("pruneKey() failed: %v", err)
+	}
+	versions, err := storage.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Key != "order-1" || !versions[0].IsLatest {
+		t.Errorf("Expected only the current version of order-1, got %+v", versions)
+	}
+}
+
+func TestFilesystem_BucketSettings(t *testing.T) {
+	storage := setupFilesystem(t, true)
+	ctx := context.Background()
+
+	if err := storage.CreateBucket(ctx, "audit", ObjectLockConfig{Enabled: true}); !errors.Is(err, ErrInvalidObjectLock) {
+		t.Errorf("Expected ErrInvalidObjectLock, got %v", err)
+	}
+	if err := storage.CreateBucket(ctx, "Invalid_Bucket", ObjectLockConfig{}); !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("Expected ErrInvalidBucketName, got %v", err)
+	}
+
+	description, err := storage.DescribeBucket(ctx, "orders")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.Versioning != VersioningEnabled || len(description.LifecycleRules) != 2 {
+		t.Errorf("Expected versioning with 2 cleanup rules, got %+v", description)
+	}
+
+	report, err := storage.ProvisionBucket(ctx, "invoices")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if !report.Created || report.VersioningRepaired() || len(report.RepairedLifecycleRules) > 0 {
+		t.Errorf("Expected the bucket to be created only, got %+v", report)
+	}
+	drift, err := storage.CheckBucketSettings(ctx, "invoices")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Drifted() {
+		t.Errorf("Expected no drift, got %+v", drift)
+	}
+
+	buckets, err := storage.ListBuckets(ctx)
+	if err != nil {
+		t.Fatalf("ListBuckets() failed: %v", err)
+	}
+	if len(buckets) != 2 {
+		t.Errorf("Expected 2 buckets, got %+v", buckets)
+	}
+
+	if err := storage.SetLegalHold(ctx, "orders", "order-1", "", true); !errors.Is(err, ErrObjectLockNotEnabled) {
+		t.Errorf("Expected ErrObjectLockNotEnabled, got %v", err)
+	}
+}
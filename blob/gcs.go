@@ -0,0 +1,785 @@
+package blob
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// GCSClient stores objects in Google Cloud Storage. Version IDs are object generations.
+// Buckets are created with object versioning, and the version cleanup and retention rules are applied as
+// GCS lifecycle rules, like the S3 lifecycle rules of Client. GCS has no delete markers: deleting an object
+// makes its live generation noncurrent, so CleanOldVersions also cleans deleted objects.
+// Object lock is not supported.
+// This type is thread-safe.
+type GCSClient struct {
+	gcsClient gcsClientInterface
+	config    *configurations.Config
+}
+
+// NewGCSClient creates a Google Cloud Storage client with the provided configuration, and checks the connection.
+// With an endpoint and no credentials file (e.g. fake-gcs-server), requests are not authenticated.
+//
+// params:
+//   - ctx: Context for the operation
+//   - cfg: Configuration containing the project ID, and the credentials file or endpoint
+//
+// return:
+//   - *GCSClient: A new GCS client instance
+//   - error: An error if the client could not be initialized
+func NewGCSClient(ctx context.Context, cfg *configurations.Config) (*GCSClient, error) {
+	gcs := cfg.Blob.GCS
+	var opts []option.ClientOption
+	if gcs.CredentialsFile != "" {
+		opts = append(opts, option.WithAuthCredentialsFile(option.ServiceAccount, gcs.CredentialsFile))
+	}
+	if gcs.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(gcs.Endpoint))
+		if gcs.CredentialsFile == "" {
+			opts = append(opts, option.WithoutAuthentication())
+		}
+	}
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+
+	// Test connection with timeout
+	adapter := newGCSClientAdapter(client)
+	testCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := adapter.ListBuckets(testCtx, gcs.ProjectID); err != nil {
+		return nil, fmt.Errorf("failed to connect to Google Cloud Storage: %w", err)
+	}
+
+	return NewGCSClientWithInterface(adapter, cfg), nil
+}
+
+// NewGCSClientWithInterface creates a new GCSClient with a custom GCS client interface.
+// This is primarily used for testing with mock implementations.
+//
+// params:
+//   - gcsClient: An implementation of gcsClientInterface (can be a mock)
+//   - cfg: Configuration with the project ID, version cleanup delays and retention policies
+//
+// return:
+//   - *GCSClient: A new GCS client instance
+func NewGCSClientWithInterface(gcsClient gcsClientInterface, cfg *configurations.Config) *GCSClient {
+	return &GCSClient{
+		gcsClient: gcsClient,
+		config:    cfg,
+	}
+}
+
+// validateGCSBucketName checks a bucket name against the naming rules of both S3 and GCS buckets:
+// GCS does not allow names starting with "goog" or containing "google".
+func validateGCSBucketName(bucketName string) error {
+	if bucketName == "" {
+		return fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
+	}
+	if err := validateBucketName(bucketName); err != nil {
+		return err
+	}
+	if strings.HasPrefix(bucketName, "goog") || strings.Contains(bucketName, "google") {
+		return fmt.Errorf("%w: GCS bucket names cannot start with goog or contain google: %s", ErrInvalidBucketName, bucketName)
+	}
+	return nil
+}
+
+// gcsStatus returns the HTTP status of a GCS error, 0 if it has none.
+func gcsStatus(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// gcsError maps a GCS error to ErrBucketNotFound, ErrObjectNotFound or ErrPreconditionFailed, or wraps it as the error of an operation.
+func gcsError(bucketName, fileName, versionID, operation string, err error) error {
+	switch {
+	case errors.Is(err, storage.ErrBucketNotExist):
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	case errors.Is(err, storage.ErrObjectNotExist) || gcsStatus(err) == http.StatusNotFound:
+		return fmt.Errorf("%w: %s (version %q)", ErrObjectNotFound, fileName, versionID)
+	case gcsStatus(err) == http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %s is not at version %q", ErrPreconditionFailed, fileName, versionID)
+	}
+	return fmt.Errorf("failed to %s %s: %w", operation, fileName, err)
+}
+
+// objectError maps the error of an operation on an object like gcsError. GCS reports a missing bucket
+// as a missing object, so the bucket is checked when the object is not found.
+func (c *GCSClient) objectError(ctx context.Context, bucketName, fileName, versionID, operation string, err error) error {
+	err = gcsError(bucketName, fileName, versionID, operation, err)
+	if errors.Is(err, ErrObjectNotFound) {
+		if bucketErr := c.ensureBucketExists(ctx, bucketName); bucketErr != nil {
+			return bucketErr
+		}
+	}
+	return err
+}
+
+// ensureBucketExists returns ErrBucketNotFound if the bucket does not exist.
+func (c *GCSClient) ensureBucketExists(ctx context.Context, bucketName string) error {
+	_, err := c.bucketAttrs(ctx, bucketName)
+	return err
+}
+
+// bucketAttrs returns the settings of a bucket, ErrBucketNotFound if it does not exist.
+func (c *GCSClient) bucketAttrs(ctx context.Context, bucketName string) (*storage.BucketAttrs, error) {
+	if bucketName == "" {
+		return nil, fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
+	}
+	attrs, err := c.gcsClient.GetBucketAttrs(ctx, bucketName)
+	if err != nil {
+		if errors.Is(err, storage.ErrBucketNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+		}
+		return nil, fmt.Errorf("failed to get bucket %s: %w", bucketName, err)
+	}
+	return attrs, nil
+}
+
+// parseGeneration returns the generation of a version ID, 0 (the live generation) if it is empty.
+func parseGeneration(fileName, versionID string) (int64, error) {
+	if versionID == "" {
+		return 0, nil
+	}
+	generation, err := strconv.ParseInt(versionID, 10, 64)
+	if err != nil || generation <= 0 {
+		return 0, fmt.Errorf("%w: %s (version %q is not a GCS generation)", ErrObjectNotFound, fileName, versionID)
+	}
+	return generation, nil
+}
+
+// gcsObjectInfo describes an object generation. The ETag is the hex MD5 of the content, like the ETag of
+// S3 objects uploaded in one part, empty for composite objects.
+func gcsObjectInfo(attrs *storage.ObjectAttrs) ObjectInfo {
+	return ObjectInfo{
+		Key:          attrs.Name,
+		VersionID:    strconv.FormatInt(attrs.Generation, 10),
+		Size:         attrs.Size,
+		ETag:         hex.EncodeToString(attrs.MD5),
+		LastModified: attrs.Created.UTC(),
+	}
+}
+
+// ReadFile reads an object generation.
+// If versionID is empty, it reads the live generation.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to read from
+//   - fileName: The name of the object to read
+//   - versionID: Optional generation to read. If empty, reads the live generation.
+//
+// return:
+//   - []byte: The object contents
+//   - error: ErrBucketNotFound, ErrObjectNotFound, or an error if the object could not be read
+func (c *GCSClient) ReadFile(ctx context.Context, bucketName, fileName, versionID string) ([]byte, error) {
+	if bucketName == "" {
+		return nil, fmt.Errorf("bucket name cannot be empty")
+	}
+	if fileName == "" {
+		return nil, fmt.Errorf("file name cannot be empty")
+	}
+	generation, err := parseGeneration(fileName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.gcsClient.ReadObject(ctx, bucketName, fileName, generation)
+	if err != nil {
+		return nil, c.objectError(ctx, bucketName, fileName, versionID, "read object", err)
+	}
+	return data, nil
+}
+
+// WriteFile writes a new generation of an object.
+// The SHA-256 of the data is stored in the object metadata, so the scrubber can verify it later.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to write to
+//   - fileName: The name of the object to write
+//   - data: The data to write
+//
+// return:
+//   - string: The generation of the written object
+//   - error: ErrBucketNotFound, or an error if the object could not be written
+func (c *GCSClient) WriteFile(ctx context.Context, bucketName, fileName string, data []byte) (string, error) {
+	return c.writeFile(ctx, bucketName, fileName, data, nil, nil)
+}
+
+// WriteFileWithOptions writes a new generation of an object like WriteFile, with the user metadata of the options.
+// IfNotExists is a DoesNotExist precondition checked by GCS, IfVersion a GenerationMatch precondition.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to write to
+//   - fileName: The name of the object to write
+//   - data: The data to write
+//   - opts: The write options
+//
+// return:
+//   - string: The generation of the written object
+//   - error: ErrPreconditionFailed if IfNotExists is set and the object exists, or IfVersion is set and is not the live generation,
+//     ErrBucketNotFound, or an error if the object could not be written
+func (c *GCSClient) WriteFileWithOptions(ctx context.Context, bucketName, fileName string, data []byte, opts WriteOptions) (string, error) {
+	var conds *storage.Conditions
+	switch {
+	case opts.IfNotExists && opts.IfVersion != "":
+		return "", fmt.Errorf("IfNotExists and IfVersion cannot both be set")
+	case opts.IfNotExists:
+		conds = &storage.Conditions{DoesNotExist: true}
+	case opts.IfVersion != "":
+		generation, err := strconv.ParseInt(opts.IfVersion, 10, 64)
+		if err != nil || generation <= 0 {
+			return "", fmt.Errorf("%w: %s is not at version %q, it is not a GCS generation", ErrPreconditionFailed, fileName, opts.IfVersion)
+		}
+		conds = &storage.Conditions{GenerationMatch: generation}
+	}
+	return c.writeFile(ctx, bucketName, fileName, data, opts.UserMetadata, conds)
+}
+
+// writeFile writes a new generation of an object with user metadata, if the preconditions hold (nil for none).
+func (c *GCSClient) writeFile(ctx context.Context, bucketName, fileName string, data []byte, metadata map[string]string, conds *storage.Conditions) (string, error) {
+	if bucketName == "" {
+		return "", fmt.Errorf("bucket name cannot be empty")
+	}
+	if fileName == "" {
+		return "", fmt.Errorf("file name cannot be empty")
+	}
+	if data == nil {
+		return "", fmt.Errorf("data cannot be nil")
+	}
+	attrs, err := c.gcsClient.WriteObject(ctx, bucketName, fileName, data, withChecksum(metadata, ChecksumMetadataKey, data), conds)
+	if err != nil {
+		switch gcsStatus(err) {
+		case http.StatusNotFound:
+			return "", fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+		case http.StatusPreconditionFailed:
+			return "", fmt.Errorf("%w: %s in bucket %s: %v", ErrPreconditionFailed, fileName, bucketName, err)
+		}
+		return "", fmt.Errorf("failed to write object %s: %w", fileName, err)
+	}
+	return strconv.FormatInt(attrs.Generation, 10), nil
+}
+
+// StatObject describes an object generation without reading its content.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - fileName: The name of the object
+//   - versionID: Optional generation. If empty, describes the live generation.
+//
+// return:
+//   - *ObjectInfo: The object generation
+//   - error: ErrBucketNotFound, ErrObjectNotFound, or an error if it could not be described
+func (c *GCSClient) StatObject(ctx context.Context, bucketName, fileName, versionID string) (*ObjectInfo, error) {
+	generation, err := parseGeneration(fileName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := c.gcsClient.GetObjectAttrs(ctx, bucketName, fileName, generation)
+	if err != nil {
+		return nil, c.objectError(ctx, bucketName, fileName, versionID, "stat object", err)
+	}
+	info := gcsObjectInfo(attrs)
+	info.UserMetadata = userMetadata(attrs.Metadata, ChecksumMetadataKey)
+	info.Checksum, _ = storedChecksum(attrs.Metadata, ChecksumMetadataKey)
+	return &info, nil
+}
+
+// FileExists checks if an object has a live generation.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to check
+//   - fileName: The name of the object to check
+//
+// return:
+//   - bool: True if the object exists, false otherwise
+//   - error: ErrBucketNotFound, or an error if the check fails
+func (c *GCSClient) FileExists(ctx context.Context, bucketName, fileName string) (bool, error) {
+	_, err := c.StatObject(ctx, bucketName, fileName, "")
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteObject deletes an object. Without version ID, its live generation becomes noncurrent and is kept,
+// so the object can be restored. With a version ID, that generation is deleted permanently. GCS does not promote
+// a noncurrent generation when the live one is deleted, so deleting the live generation by ID deletes the object.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - fileName: The name of the object to delete
+//   - versionID: Optional generation to delete permanently
+//
+// return:
+//   - error: ErrBucketNotFound, ErrObjectNotFound if the generation does not exist, or an error if the object could not be deleted
+func (c *GCSClient) DeleteObject(ctx context.Context, bucketName, fileName, versionID string) error {
+	generation, err := parseGeneration(fileName, versionID)
+	if err != nil {
+		return err
+	}
+	err = c.gcsClient.DeleteObject(ctx, bucketName, fileName, generation)
+	if err == nil {
+		return nil
+	}
+	err = c.objectError(ctx, bucketName, fileName, versionID, "delete object", err)
+	// Like S3, deleting an object that does not exist succeeds
+	if versionID == "" && errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ListObjects lists the live objects of a bucket, or of a name prefix, sorted by name.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - prefix: Only list names starting with it. Empty lists the whole bucket.
+//
+// return:
+//   - []ObjectInfo: The live object generations
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the objects could not be listed
+func (c *GCSClient) ListObjects(ctx context.Context, bucketName, prefix string) ([]ObjectInfo, error) {
+	list, err := c.gcsClient.ListObjects(ctx, bucketName, prefix, false)
+	if err != nil {
+		return nil, gcsError(bucketName, "", "", "list objects of bucket", err)
+	}
+	objects := make([]ObjectInfo, 0, len(list))
+	for _, attrs := range list {
+		objects = append(objects, gcsObjectInfo(attrs))
+	}
+	return objects, nil
+}
+
+// ListObjectVersions lists every generation of the objects of a bucket, or of a name prefix, by name and newest first.
+// GCS has no delete markers: a deleted object only has generations that are not the latest.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - prefix: Only list names starting with it. Empty lists the whole bucket.
+//
+// return:
+//   - []ObjectVersion: The object generations
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the generations could not be listed
+func (c *GCSClient) ListObjectVersions(ctx context.Context, bucketName, prefix string) ([]ObjectVersion, error) {
+	list, err := c.gcsClient.ListObjects(ctx, bucketName, prefix, true)
+	if err != nil {
+		return nil, gcsError(bucketName, "", "", "list object versions of bucket", err)
+	}
+
+	versions := make([]ObjectVersion, 0, len(list))
+	// The listing is by name and oldest generation first, each name is reversed once complete
+	start := 0
+	for i, attrs := range list {
+		versions = append(versions, ObjectVersion{ObjectInfo: gcsObjectInfo(attrs), IsLatest: attrs.Deleted.IsZero()})
+		if i == len(list)-1 || list[i+1].Name != attrs.Name {
+			for l, r := start, i; l < r; l, r = l+1, r-1 {
+				versions[l], versions[r] = versions[r], versions[l]
+			}
+			start = i + 1
+		}
+	}
+	return versions, nil
+}
+
+// VerifyObject re-reads an object generation and checks it against its listing and stored checksum.
+// Objects written without a checksum (e.g. by other clients) are only checked for size.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket of the object
+//   - obj: The object generation to verify, as returned by ListObjects
+//
+// return:
+//   - bool: True if a stored checksum was verified
+//   - error: ErrObjectCorrupt if the content does not match, or an error if the object could not be read
+func (c *GCSClient) VerifyObject(ctx context.Context, bucketName string, obj ObjectInfo) (bool, error) {
+	generation, err := parseGeneration(obj.Key, obj.VersionID)
+	if err != nil {
+		return false, err
+	}
+	attrs, err := c.gcsClient.GetObjectAttrs(ctx, bucketName, obj.Key, generation)
+	if err != nil {
+		return false, c.objectError(ctx, bucketName, obj.Key, obj.VersionID, "stat object", err)
+	}
+	data, err := c.ReadFile(ctx, bucketName, obj.Key, obj.VersionID)
+	if err != nil {
+		return false, err
+	}
+
+	if int64(len(data)) != obj.Size {
+		return false, fmt.Errorf("%w: %s has size %d, listed with %d", ErrObjectCorrupt, obj.Key, len(data), obj.Size)
+	}
+	expected, ok := storedChecksum(attrs.Metadata, ChecksumMetadataKey)
+	if !ok {
+		return false, nil
+	}
+	if actual := checksum(data); !strings.EqualFold(actual, expected) {
+		return true, fmt.Errorf("%w: %s has checksum %s, stored %s", ErrObjectCorrupt, obj.Key, actual, expected)
+	}
+	return true, nil
+}
+
+// ListBuckets lists the buckets of the configured project.
+//
+// params:
+//   - ctx: Context for the operation
+//
+// return:
+//   - []BucketInfo: The buckets
+//   - error: An error if the buckets could not be listed
+func (c *GCSClient) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
+	buckets, err := c.gcsClient.ListBuckets(ctx, c.config.Blob.GCS.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	result := make([]BucketInfo, 0, len(buckets))
+	for _, b := range buckets {
+		result = append(result, BucketInfo{Name: b.Name, CreationDate: b.Created.UTC()})
+	}
+	return result, nil
+}
+
+// CreateBucket creates a bucket in the configured project with object versioning and the expected lifecycle rules.
+// If the bucket already exists, versioning is enabled and missing or drifted lifecycle rules are re-applied.
+// Object lock is not supported.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to create
+//   - lock: Must be the zero value, object lock is not supported by the GCS provider
+//
+// return:
+//   - error: ErrInvalidBucketName, ErrInvalidObjectLock if object lock is requested, or an error if the bucket could not be created or repaired
+func (c *GCSClient) CreateBucket(ctx context.Context, bucketName string, lock ObjectLockConfig) error {
+	if err := validateGCSBucketName(bucketName); err != nil {
+		return err
+	}
+	if lock.Enabled {
+		return fmt.Errorf("%w: object lock is not supported by the %s blob provider", ErrInvalidObjectLock, configurations.BlobProviderGCS)
+	}
+	if err := lock.Validate(); err != nil {
+		return err
+	}
+
+	attrs, err := c.bucketAttrs(ctx, bucketName)
+	if errors.Is(err, ErrBucketNotFound) {
+		expected := c.expectedGCSLifecycleRules(bucketName)
+		err = c.gcsClient.CreateBucket(ctx, c.config.Blob.GCS.ProjectID, bucketName, &storage.BucketAttrs{
+			VersioningEnabled: true,
+			Lifecycle:         storage.Lifecycle{Rules: expected},
+		})
+		if err == nil {
+			return nil
+		}
+		// Created concurrently, it is repaired below
+		if gcsStatus(err) != http.StatusConflict {
+			return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+		}
+		attrs, err = c.bucketAttrs(ctx, bucketName)
+	}
+	if err != nil {
+		return err
+	}
+	return c.repairBucket(ctx, bucketName, attrs)
+}
+
+// repairBucket enables versioning on a bucket and re-applies its missing or drifted lifecycle rules.
+// Lifecycle rules that are not managed by Nimbus (e.g. added by an operator) are kept.
+func (c *GCSClient) repairBucket(ctx context.Context, bucketName string, attrs *storage.BucketAttrs) error {
+	expected := c.expectedGCSLifecycleRules(bucketName)
+	drifted := driftedRules(fromGCSLifecycleRules(attrs.Lifecycle.Rules), fromGCSLifecycleRules(expected))
+	if attrs.VersioningEnabled && len(drifted) == 0 {
+		return nil
+	}
+
+	rules := make([]storage.LifecycleRule, 0, len(attrs.Lifecycle.Rules)+len(expected))
+	for i, r := range attrs.Lifecycle.Rules {
+		if !isManagedRuleID(gcsLifecycleRule(r, i).ID) {
+			rules = append(rules, r)
+		}
+	}
+	rules = append(rules, expected...)
+	// Versioning is always sent: a lifecycle update must not disable it
+	err := c.gcsClient.UpdateBucket(ctx, bucketName, storage.BucketAttrsToUpdate{
+		VersioningEnabled: true,
+		Lifecycle:         &storage.Lifecycle{Rules: rules},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable versioning and lifecycle rules on bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// ProvisionBucket makes sure a bucket exists with versioning enabled and the expected lifecycle rules.
+// Buckets created outside Nimbus (e.g. by hand with versioning off) are repaired, and the report tells what was changed.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to provision
+//
+// return:
+//   - *BucketProvisionReport: What was created or repaired
+//   - error: An error if the bucket could not be provisioned, or versioning is still not enabled afterwards
+func (c *GCSClient) ProvisionBucket(ctx context.Context, bucketName string) (*BucketProvisionReport, error) {
+	if err := validateGCSBucketName(bucketName); err != nil {
+		return nil, err
+	}
+
+	report := &BucketProvisionReport{Name: bucketName}
+	// Capture the drift before CreateBucket repairs it
+	attrs, err := c.bucketAttrs(ctx, bucketName)
+	switch {
+	case errors.Is(err, ErrBucketNotFound):
+		report.Created = true
+	case err != nil:
+		return nil, err
+	default:
+		report.PreviousVersioning = gcsVersioningStatus(attrs)
+		report.RepairedLifecycleRules = driftedRules(fromGCSLifecycleRules(attrs.Lifecycle.Rules), fromGCSLifecycleRules(c.expectedGCSLifecycleRules(bucketName)))
+	}
+
+	if err := c.CreateBucket(ctx, bucketName, ObjectLockConfig{}); err != nil {
+		return nil, err
+	}
+
+	// Verify versioning actually took effect, it is what recovery relies on
+	attrs, err = c.bucketAttrs(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify versioning of bucket %s: %w", bucketName, err)
+	}
+	if status := gcsVersioningStatus(attrs); status != VersioningEnabled {
+		return nil, fmt.Errorf("versioning of bucket %s is %s after enabling it", bucketName, status)
+	}
+	return report, nil
+}
+
+// gcsVersioningStatus returns VersioningEnabled or VersioningDisabled, GCS does not tell suspended versioning apart.
+func gcsVersioningStatus(attrs *storage.BucketAttrs) string {
+	if attrs.VersioningEnabled {
+		return VersioningEnabled
+	}
+	return VersioningDisabled
+}
+
+// expectedGCSLifecycleRules builds the lifecycle rules Nimbus expects on a bucket: the version cleanup rule
+// deleting noncurrent generations, and one rule per retention policy of the bucket deleting live objects older than its days
+// (the live generation becomes noncurrent, like an S3 expiration adds a delete marker).
+// GCS has no delete markers, so there is no CleanDeleteMarkers rule.
+func (c *GCSClient) expectedGCSLifecycleRules(bucketName string) []storage.LifecycleRule {
+	rules := []storage.LifecycleRule{
+		{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{Liveness: storage.Archived, DaysSinceNoncurrentTime: int64(c.config.Blob.NonCurrentVersionCleanupDelayDays)},
+		},
+	}
+	for _, policy := range retentionPolicies(c.config, bucketName) {
+		rule := storage.LifecycleRule{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{Liveness: storage.Live, AgeInDays: int64(policy.Days)},
+		}
+		if policy.Prefix != "" {
+			rule.Condition.MatchesPrefix = []string{policy.Prefix}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// isManagedRuleID reports whether a lifecycle rule ID is the ID of a rule managed by Nimbus on GCS buckets.
+func isManagedRuleID(id string) bool {
+	return id == cleanOldVersionsRuleID || isRetentionRuleID(id)
+}
+
+// fromGCSLifecycleRules converts GCS lifecycle rules to provider-neutral lifecycle rules, see gcsLifecycleRule.
+func fromGCSLifecycleRules(rules []storage.LifecycleRule) []LifecycleRule {
+	result := make([]LifecycleRule, 0, len(rules))
+	for i, r := range rules {
+		result = append(result, gcsLifecycleRule(r, i))
+	}
+	return result
+}
+
+// gcsLifecycleRule converts a GCS lifecycle rule to a provider-neutral lifecycle rule. GCS rules have no ID:
+// the rules managed by Nimbus are recognized by their action and conditions, and get the ID of the matching
+// S3 rule. Other rules are identified by their action and position, e.g. SetStorageClass:2.
+func gcsLifecycleRule(r storage.LifecycleRule, index int) LifecycleRule {
+	cond := r.Condition
+	if r.Action.Type == storage.DeleteAction {
+		// The conditions a managed rule may set, any other condition makes it an operator rule
+		other := cond
+		other.Liveness, other.DaysSinceNoncurrentTime, other.AgeInDays, other.MatchesPrefix = storage.LiveAndArchived, 0, 0, nil
+		onlyManagedConditions := reflect.DeepEqual(other, storage.LifecycleCondition{})
+
+		switch {
+		case onlyManagedConditions && cond.Liveness == storage.Archived && cond.DaysSinceNoncurrentTime > 0 && cond.AgeInDays == 0 && len(cond.MatchesPrefix) == 0:
+			return LifecycleRule{ID: cleanOldVersionsRuleID, Status: "Enabled", NoncurrentVersionExpirationDays: int(cond.DaysSinceNoncurrentTime)}
+		case onlyManagedConditions && cond.Liveness == storage.Live && cond.AgeInDays > 0 && cond.DaysSinceNoncurrentTime == 0 && len(cond.MatchesPrefix) <= 1:
+			prefix := ""
+			if len(cond.MatchesPrefix) == 1 {
+				prefix = cond.MatchesPrefix[0]
+			}
+			return LifecycleRule{ID: retentionRuleID(prefix), Status: "Enabled", Prefix: prefix, ExpirationDays: int(cond.AgeInDays)}
+		}
+	}
+
+	rule := LifecycleRule{ID: fmt.Sprintf("%s:%d", r.Action.Type, index), Status: "Enabled"}
+	if len(cond.MatchesPrefix) > 0 {
+		rule.Prefix = cond.MatchesPrefix[0]
+	}
+	if r.Action.Type == storage.DeleteAction {
+		rule.ExpirationDays = int(cond.AgeInDays)
+		rule.NoncurrentVersionExpirationDays = int(cond.DaysSinceNoncurrentTime)
+	}
+	return rule
+}
+
+// DescribeBucket returns the versioning and lifecycle settings of a bucket.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to describe
+//
+// return:
+//   - *BucketDescription: The bucket settings, without object lock
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the settings could not be read
+func (c *GCSClient) DescribeBucket(ctx context.Context, bucketName string) (*BucketDescription, error) {
+	attrs, err := c.bucketAttrs(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return &BucketDescription{
+		Name:           bucketName,
+		Versioning:     gcsVersioningStatus(attrs),
+		LifecycleRules: fromGCSLifecycleRules(attrs.Lifecycle.Rules),
+	}, nil
+}
+
+// CheckBucketSettings checks, without changing anything, that a bucket still has versioning enabled
+// and the expected lifecycle rules. Use ProvisionBucket to repair the drift.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket to check
+//
+// return:
+//   - *BucketSettingsDrift: The settings that differ from the expected ones
+//   - error: ErrBucketNotFound, or an error if the settings could not be read
+func (c *GCSClient) CheckBucketSettings(ctx context.Context, bucketName string) (*BucketSettingsDrift, error) {
+	attrs, err := c.bucketAttrs(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return &BucketSettingsDrift{
+		Name:                  bucketName,
+		Versioning:            gcsVersioningStatus(attrs),
+		DriftedLifecycleRules: driftedRules(fromGCSLifecycleRules(attrs.Lifecycle.Rules), fromGCSLifecycleRules(c.expectedGCSLifecycleRules(bucketName))),
+	}, nil
+}
+
+// DeleteBucket deletes an empty bucket. Buckets still holding objects or noncurrent generations are not deleted.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to delete
+//
+// return:
+//   - error: ErrBucketNotFound or ErrBucketNotEmpty, or an error if the bucket could not be deleted
+func (c *GCSClient) DeleteBucket(ctx context.Context, bucketName string) error {
+	list, err := c.gcsClient.ListObjects(ctx, bucketName, "", true)
+	if err != nil {
+		return gcsError(bucketName, "", "", "list objects of bucket", err)
+	}
+	if len(list) > 0 {
+		return fmt.Errorf("%w: %s", ErrBucketNotEmpty, bucketName)
+	}
+	if err := c.gcsClient.DeleteBucket(ctx, bucketName); err != nil {
+		if gcsStatus(err) == http.StatusConflict {
+			return fmt.Errorf("%w: %s", ErrBucketNotEmpty, bucketName)
+		}
+		return gcsError(bucketName, "", "", "delete bucket", err)
+	}
+	return nil
+}
+
+// BucketUsage returns the number and total size of the live objects of a bucket.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//
+// return:
+//   - *BucketUsage: The object count and total size
+//   - error: ErrBucketNotFound if the bucket does not exist, or an error if the objects could not be listed
+func (c *GCSClient) BucketUsage(ctx context.Context, bucketName string) (*BucketUsage, error) {
+	objects, err := c.ListObjects(ctx, bucketName, "")
+	if err != nil {
+		return nil, err
+	}
+	usage := &BucketUsage{}
+	for _, obj := range objects {
+		usage.Bytes += obj.Size
+		usage.Objects++
+	}
+	return usage, nil
+}
+
+// PlanRetention reports, without deleting anything, the live objects of a bucket that its retention policies expire as of a time.
+// Expiration is applied by GCS through the lifecycle rules of the policies, usually once a day.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket to report on
+//   - now: The time the objects are expired at
+//
+// return:
+//   - *RetentionReport: The expired objects per policy, no policies if the bucket has none
+//   - error: ErrBucketNotFound, or an error if the objects could not be listed
+func (c *GCSClient) PlanRetention(ctx context.Context, bucketName string, now time.Time) (*RetentionReport, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+	return planRetention(ctx, c, retentionPolicies(c.config, bucketName), bucketName, now)
+}
+
+// SetLegalHold always fails: object lock is not supported by the GCS provider.
+//
+// return:
+//   - error: ErrBucketNotFound, or ErrObjectLockNotEnabled
+func (c *GCSClient) SetLegalHold(ctx context.Context, bucketName, fileName, versionID string, on bool) error {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w on bucket %s, the %s blob provider has no object lock", ErrObjectLockNotEnabled, bucketName, configurations.BlobProviderGCS)
+}
+
+// GetLegalHold always fails: object lock is not supported by the GCS provider.
+//
+// return:
+//   - bool: Always false
+//   - error: ErrBucketNotFound, or ErrObjectLockNotEnabled
+func (c *GCSClient) GetLegalHold(ctx context.Context, bucketName, fileName, versionID string) (bool, error) {
+	return false, c.SetLegalHold(ctx, bucketName, fileName, versionID, false)
+}
@@ -0,0 +1,134 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// gcsClientAdapter adapts a real Google Cloud Storage client to implement gcsClientInterface.
+type gcsClientAdapter struct {
+	client *storage.Client
+}
+
+// newGCSClientAdapter creates a new adapter for a real Google Cloud Storage client.
+func newGCSClientAdapter(client *storage.Client) gcsClientInterface {
+	return &gcsClientAdapter{client: client}
+}
+
+// object returns the handle of a generation of an object, the live one if generation is 0.
+func (a *gcsClientAdapter) object(bucketName, objectName string, generation int64) *storage.ObjectHandle {
+	handle := a.client.Bucket(bucketName).Object(objectName)
+	if generation != 0 {
+		handle = handle.Generation(generation)
+	}
+	return handle
+}
+
+// ListBuckets lists the buckets of a project.
+func (a *gcsClientAdapter) ListBuckets(ctx context.Context, projectID string) ([]*storage.BucketAttrs, error) {
+	var buckets []*storage.BucketAttrs
+	it := a.client.Buckets(ctx, projectID)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return buckets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, attrs)
+	}
+}
+
+// GetBucketAttrs returns the settings of a bucket.
+func (a *gcsClientAdapter) GetBucketAttrs(ctx context.Context, bucketName string) (*storage.BucketAttrs, error) {
+	return a.client.Bucket(bucketName).Attrs(ctx)
+}
+
+// CreateBucket creates a bucket in a project.
+func (a *gcsClientAdapter) CreateBucket(ctx context.Context, projectID, bucketName string, attrs *storage.BucketAttrs) error {
+	return a.client.Bucket(bucketName).Create(ctx, projectID, attrs)
+}
+
+// UpdateBucket changes the settings of a bucket.
+func (a *gcsClientAdapter) UpdateBucket(ctx context.Context, bucketName string, attrs storage.BucketAttrsToUpdate) error {
+	_, err := a.client.Bucket(bucketName).Update(ctx, attrs)
+	return err
+}
+
+// DeleteBucket deletes an empty bucket.
+func (a *gcsClientAdapter) DeleteBucket(ctx context.Context, bucketName string) error {
+	return a.client.Bucket(bucketName).Delete(ctx)
+}
+
+// WriteObject writes a new generation of an object, if the preconditions hold.
+func (a *gcsClientAdapter) WriteObject(ctx context.Context, bucketName, objectName string, data []byte, metadata map[string]string, conds *storage.Conditions) (*storage.ObjectAttrs, error) {
+	handle := a.object(bucketName, objectName, 0)
+	if conds != nil {
+		handle = handle.If(*conds)
+	}
+	// Cancelling the context aborts the upload if it fails midway
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := handle.NewWriter(writeCtx)
+	w.ContentType = "application/octet-stream"
+	w.Metadata = metadata
+	// Objects are small enough to be sent in a single request
+	w.ChunkSize = 0
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Attrs(), nil
+}
+
+// ReadObject reads a generation of an object.
+func (a *gcsClientAdapter) ReadObject(ctx context.Context, bucketName, objectName string, generation int64) ([]byte, error) {
+	r, err := a.object(bucketName, objectName, generation).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// GetObjectAttrs describes a generation of an object.
+func (a *gcsClientAdapter) GetObjectAttrs(ctx context.Context, bucketName, objectName string, generation int64) (*storage.ObjectAttrs, error) {
+	return a.object(bucketName, objectName, generation).Attrs(ctx)
+}
+
+// DeleteObject deletes an object, or a generation of it.
+func (a *gcsClientAdapter) DeleteObject(ctx context.Context, bucketName, objectName string, generation int64) error {
+	return a.object(bucketName, objectName, generation).Delete(ctx)
+}
+
+// ListObjects lists the live objects of a bucket, and their noncurrent generations if versions is true, by name and oldest generation first.
+func (a *gcsClientAdapter) ListObjects(ctx context.Context, bucketName, prefix string, versions bool) ([]*storage.ObjectAttrs, error) {
+	var objects []*storage.ObjectAttrs
+	it := a.client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: prefix, Versions: versions})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, attrs)
+	}
+	// Generations are assigned in write order
+	sort.SliceStable(objects, func(i, j int) bool {
+		if objects[i].Name != objects[j].Name {
+			return objects[i].Name < objects[j].Name
+		}
+		return objects[i].Generation < objects[j].Generation
+	})
+	return objects, nil
+}
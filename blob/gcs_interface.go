@@ -0,0 +1,46 @@
+package blob
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsClientInterface defines the interface for the Google Cloud Storage operations used by GCSClient.
+// Generations identify object versions, 0 meaning the live generation. Errors are the ones of the SDK:
+// storage.ErrBucketNotExist, storage.ErrObjectNotExist, or a *googleapi.Error with the HTTP status.
+// This interface allows us to mock GCS behavior in unit tests.
+type gcsClientInterface interface {
+	// ListBuckets lists the buckets of a project.
+	ListBuckets(ctx context.Context, projectID string) ([]*storage.BucketAttrs, error)
+
+	// GetBucketAttrs returns the settings of a bucket, storage.ErrBucketNotExist if it does not exist.
+	GetBucketAttrs(ctx context.Context, bucketName string) (*storage.BucketAttrs, error)
+
+	// CreateBucket creates a bucket in a project. Returns a 409 error if it exists.
+	CreateBucket(ctx context.Context, projectID, bucketName string, attrs *storage.BucketAttrs) error
+
+	// UpdateBucket changes the settings of a bucket.
+	UpdateBucket(ctx context.Context, bucketName string, attrs storage.BucketAttrsToUpdate) error
+
+	// DeleteBucket deletes an empty bucket.
+	DeleteBucket(ctx context.Context, bucketName string) error
+
+	// WriteObject writes a new generation of an object, if the preconditions hold (nil for none).
+	// Returns a 412 error if they do not.
+	WriteObject(ctx context.Context, bucketName, objectName string, data []byte, metadata map[string]string, conds *storage.Conditions) (*storage.ObjectAttrs, error)
+
+	// ReadObject reads a generation of an object.
+	ReadObject(ctx context.Context, bucketName, objectName string, generation int64) ([]byte, error)
+
+	// GetObjectAttrs describes a generation of an object.
+	GetObjectAttrs(ctx context.Context, bucketName, objectName string, generation int64) (*storage.ObjectAttrs, error)
+
+	// DeleteObject deletes an object: the live generation becomes noncurrent if versioning is enabled.
+	// With a generation, that generation is deleted permanently.
+	DeleteObject(ctx context.Context, bucketName, objectName string, generation int64) error
+
+	// ListObjects lists the live objects of a bucket whose name starts with prefix, and their noncurrent generations
+	// if versions is true, by name and oldest generation first.
+	ListObjects(ctx context.Context, bucketName, prefix string, versions bool) ([]*storage.ObjectAttrs, error)
+}
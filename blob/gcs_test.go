@@ -0,0 +1,284 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"NimbusDb/configurations"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+// getGCSTestConfig returns a test configuration for the GCS provider, with the retention policies of getRetentionTestConfig.
+func getGCSTestConfig() *configurations.Config {
+	cfg := getRetentionTestConfig()
+	cfg.Blob.GCS.ProjectID = "nimbus-test"
+	return cfg
+}
+
+// setupGCSClient creates a GCS client on a mock project, with a bucket named orders.
+func setupGCSClient(t *testing.T) (*GCSClient, *mockGCSClient) {
+	mockClient := newMockGCSClient()
+	client := NewGCSClientWithInterface(mockClient, getGCSTestConfig())
+	if err := client.CreateBucket(context.Background(), "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	return client, mockClient
+}
+
+func TestGCSClient_Generations(t *testing.T) {
+	client, _ := setupGCSClient(t)
+	ctx := context.Background()
+	first, err := client.WriteFile(ctx, "orders", "eu/order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := parseGeneration("eu/order-1", first); err != nil {
+		t.Errorf("Expected the version ID to be a generation, got %s (%v)", first, err)
+	}
+	info, err := client.StatObject(ctx, "orders", "eu/order-1", "")
+	if err != nil {
+		t.Fatalf("StatObject() failed: %v", err)
+	}
+	if info.ETag == "" {
+		t.Errorf("Expected an ETag, got %+v", info)
+	}
+	if _, err := client.ReadFile(ctx, "orders", "eu/order-1", "not-a-generation"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound for an invalid version ID, got %v", err)
+	}
+}
+
+func TestGCSClient_WriteIfVersion(t *testing.T) {
+	client, _ := setupGCSClient(t)
+	ctx := context.Background()
+
+	first, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("first"), WriteOptions{IfNotExists: true})
+	if err != nil {
+		t.Fatalf("WriteFileWithOptions() failed: %v", err)
+	}
+	second, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("second"), WriteOptions{IfVersion: first})
+	if err != nil {
+		t.Fatalf("WriteFileWithOptions() failed: %v", err)
+	}
+	// A writer still holding the first generation lost the race
+	if _, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("stale"), WriteOptions{IfVersion: first}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a stale generation, got %v", err)
+	}
+	if _, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("stale"), WriteOptions{IfVersion: "latest"}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for an invalid version ID, got %v", err)
+	}
+	if _, err := client.WriteFileWithOptions(ctx, "orders", "order-2", []byte("new"), WriteOptions{IfVersion: first}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a missing object, got %v", err)
+	}
+
+	data, err := client.ReadFile(ctx, "orders", "order-1", "")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if string(data) != "second" {
+		t.Errorf("Expected %q, got %q", "second", data)
+	}
+	info, err := client.StatObject(ctx, "orders", "order-1", "")
+	if err != nil {
+		t.Fatalf("StatObject() failed: %v", err)
+	}
+	if info.VersionID != second {
+		t.Errorf("Expected live generation %s, got %s", second, info.VersionID)
+	}
+}
+
+func TestGCSClient_VerifyObject(t *testing.T) {
+	client, mockClient := setupGCSClient(t)
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, "orders", "order-1", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	objects, err := client.ListObjects(ctx, "orders", "")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("Expected 1 object, got %+v (%v)", objects, err)
+	}
+
+	generation, _ := parseGeneration("order-1", objects[0].VersionID)
+	mockClient.corrupt("orders", generation, []byte("ORDER"))
+	if _, err := client.VerifyObject(ctx, "orders", objects[0]); !errors.Is(err, ErrObjectCorrupt) {
+		t.Errorf("Expected ErrObjectCorrupt, got %v", err)
+	}
+}
+
+func TestGCSClient_BucketSettings(t *testing.T) {
+	client, mockClient := setupGCSClient(t)
+	ctx := context.Background()
+
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{Enabled: true}); !errors.Is(err, ErrInvalidObjectLock) {
+		t.Errorf("Expected ErrInvalidObjectLock, got %v", err)
+	}
+	for _, name := range []string{"google-orders", "goog-orders", "Orders"} {
+		if err := client.CreateBucket(ctx, name, ObjectLockConfig{}); !errors.Is(err, ErrInvalidBucketName) {
+			t.Errorf("Expected ErrInvalidBucketName for %s, got %v", name, err)
+		}
+	}
+
+	// The retention policies of the bucket become lifecycle rules
+	report, err := client.ProvisionBucket(ctx, "audit")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if !report.Created {
+		t.Errorf("Expected the bucket to be created, got %+v", report)
+	}
+	description, err := client.DescribeBucket(ctx, "audit")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.Versioning != VersioningEnabled {
+		t.Errorf("Expected versioning %s, got %s", VersioningEnabled, description.Versioning)
+	}
+	if rule, ok := findLifecycleRule(description.LifecycleRules, "Retention:logs/"); !ok || rule.ExpirationDays != 2555 {
+		t.Errorf("Expected the Retention:logs/ rule with 2555 days, got %+v", description.LifecycleRules)
+	}
+	if rule, ok := findLifecycleRule(description.LifecycleRules, cleanOldVersionsRuleID); !ok || rule.NoncurrentVersionExpirationDays != 1 {
+		t.Errorf("Expected the %s rule with 1 day, got %+v", cleanOldVersionsRuleID, description.LifecycleRules)
+	}
+
+	// A bucket changed by hand drifts, and is repaired by provisioning without dropping the operator rules
+	operatorRule := storage.LifecycleRule{
+		Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: "NEARLINE"},
+		Condition: storage.LifecycleCondition{AgeInDays: 30},
+	}
+	mockClient.buckets["audit"].attrs.VersioningEnabled = false
+	mockClient.buckets["audit"].attrs.Lifecycle.Rules = []storage.LifecycleRule{operatorRule, mockClient.buckets["audit"].attrs.Lifecycle.Rules[1]}
+	drift, err := client.CheckBucketSettings(ctx, "audit")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if !drift.Drifted() || drift.Versioning != VersioningDisabled {
+		t.Errorf("Expected versioning to drift, got %+v", drift)
+	}
+	if len(drift.DriftedLifecycleRules) != 2 || drift.DriftedLifecycleRules[0] != cleanOldVersionsRuleID || drift.DriftedLifecycleRules[1] != "Retention:feeds/" {
+		t.Errorf("Expected the %s and Retention:feeds/ rules to drift, got %+v", cleanOldVersionsRuleID, drift.DriftedLifecycleRules)
+	}
+	report, err = client.ProvisionBucket(ctx, "audit")
+	if err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	if report.Created || report.PreviousVersioning != VersioningDisabled || len(report.RepairedLifecycleRules) != 2 {
+		t.Errorf("Expected versioning and 2 rules to be repaired, got %+v", report)
+	}
+	drift, err = client.CheckBucketSettings(ctx, "audit")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Drifted() {
+		t.Errorf("Expected no drift after provisioning, got %+v", drift)
+	}
+	if rules := mockClient.buckets["audit"].attrs.Lifecycle.Rules; len(rules) != 4 || rules[0].Action.Type != storage.SetStorageClassAction {
+		t.Errorf("Expected the operator rule to be kept, got %+v", rules)
+	}
+
+	if err := client.SetLegalHold(ctx, "audit", "logs/1", "", true); !errors.Is(err, ErrObjectLockNotEnabled) {
+		t.Errorf("Expected ErrObjectLockNotEnabled, got %v", err)
+	}
+	if err := client.DeleteBucket(ctx, "audit"); err != nil {
+		t.Errorf("DeleteBucket() failed: %v", err)
+	}
+	if err := client.DeleteBucket(ctx, "audit"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func TestGCSClient_PlanRetention(t *testing.T) {
+	mockClient := newMockGCSClient()
+	client := NewGCSClientWithInterface(mockClient, getGCSTestConfig())
+	ctx := context.Background()
+	if _, err := client.PlanRetention(ctx, "audit", time.Now()); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	for _, name := range []string{"logs/1", "feeds/1", "other/1"} {
+		if _, err := client.WriteFile(ctx, "audit", name, []byte("entry")); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	report, err := client.PlanRetention(ctx, "audit", time.Now().Add(100*24*time.Hour))
+	if err != nil {
+		t.Fatalf("PlanRetention() failed: %v", err)
+	}
+	if len(report.Policies) != 2 {
+		t.Fatalf("Expected 2 policies, got %+v", report.Policies)
+	}
+	for _, policy := range report.Policies {
+		var expired int64
+		if policy.Prefix == "feeds/" {
+			expired = 1
+		}
+		if policy.ExpiredObjects != expired {
+			t.Errorf("Expected %d expired objects for %s, got %+v", expired, policy.Prefix, policy)
+		}
+	}
+}
+
+func TestGCSClient_FakeServer(t *testing.T) {
+	// Runs the real adapter against an in-process fake-gcs-server, which does not apply lifecycle rules
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	if err != nil {
+		t.Fatalf("Failed to start fake GCS server: %v", err)
+	}
+	defer server.Stop()
+	client := NewGCSClientWithInterface(newGCSClientAdapter(server.Client()), getGCSTestConfig())
+	ctx := context.Background()
+
+	if _, err := client.ProvisionBucket(ctx, "orders"); err != nil {
+		t.Fatalf("ProvisionBucket() failed: %v", err)
+	}
+	first, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("first"), WriteOptions{IfNotExists: true})
+	if err != nil {
+		t.Fatalf("WriteFileWithOptions() failed: %v", err)
+	}
+	if _, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("again"), WriteOptions{IfNotExists: true}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed, got %v", err)
+	}
+	if _, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("second"), WriteOptions{IfVersion: first}); err != nil {
+		t.Fatalf("WriteFileWithOptions() failed: %v", err)
+	}
+	if _, err := client.WriteFileWithOptions(ctx, "orders", "order-1", []byte("stale"), WriteOptions{IfVersion: first}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for a stale generation, got %v", err)
+	}
+
+	data, err := client.ReadFile(ctx, "orders", "order-1", first)
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if string(data) != "first" {
+		t.Errorf("Expected %q, got %q", "first", data)
+	}
+	objects, err := client.ListObjects(ctx, "orders", "")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("Expected 1 object, got %+v (%v)", objects, err)
+	}
+	if verified, err := client.VerifyObject(ctx, "orders", objects[0]); err != nil || !verified {
+		t.Errorf("Expected the object to be verified, got %v (%v)", verified, err)
+	}
+
+	if err := client.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if _, err := client.ReadFile(ctx, "orders", "order-1", ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+	versions, err := client.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 2 || versions[0].IsLatest || versions[1].VersionID != first {
+		t.Errorf("Expected 2 noncurrent generations, newest first, got %+v", versions)
+	}
+	if _, err := client.ReadFile(ctx, "invoices", "order-1", ""); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// ChecksumMetadataKey is the user metadata key holding the hex SHA-256 of the object content, set by WriteFile.
+	ChecksumMetadataKey = "Nimbus-Sha256"
+	// userMetadataPrefix is the header prefix of user metadata, kept in the keys by some S3 providers.
+	userMetadataPrefix = "X-Amz-Meta-"
+)
+
+var (
+	// ErrObjectCorrupt is returned when the content of an object does not match its listed size or stored checksum.
+	ErrObjectCorrupt = errors.New("object corrupt")
+)
+
+// checksum returns the hex SHA-256 of data, as stored under ChecksumMetadataKey.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// storedChecksum returns the checksum stored under checksumKey in the user metadata of an object.
+// Keys are matched case-insensitively, with or without the user metadata header prefix.
+func storedChecksum(metadata map[string]string, checksumKey string) (string, bool) {
+	for key, value := range metadata {
+		key = strings.TrimPrefix(strings.ToLower(key), strings.ToLower(userMetadataPrefix))
+		if key == strings.ToLower(checksumKey) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// userMetadata returns the user metadata of an object without the checksum stored under checksumKey,
+// nil if there is none. Keys are matched like in storedChecksum.
+func userMetadata(metadata map[string]string, checksumKey string) map[string]string {
+	var result map[string]string
+	for key, value := range metadata {
+		if strings.TrimPrefix(strings.ToLower(key), strings.ToLower(userMetadataPrefix)) == strings.ToLower(checksumKey) {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string, len(metadata))
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// withChecksum returns the user metadata of a new version: the metadata of the write options,
+// and the checksum of data stored under checksumKey.
+func withChecksum(metadata map[string]string, checksumKey string, data []byte) map[string]string {
+	result := userMetadata(metadata, checksumKey)
+	if result == nil {
+		result = make(map[string]string, 1)
+	}
+	result[checksumKey] = checksum(data)
+	return result
+}
+
+// VerifyObject re-reads an object version and checks it against its listing and stored checksum.
+// Objects written before checksums were stored (or by other clients) are only checked for size.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket of the object
+//   - obj: The object version to verify, as returned by ListObjects
+//
+// return:
+//   - bool: True if a stored checksum was verified
+//   - error: ErrObjectCorrupt if the content does not match, or an error if the object could not be read
+func (c *Client) VerifyObject(ctx context.Context, bucketName string, obj ObjectInfo) (bool, error) {
+	info, err := c.minioClient.StatObject(ctx, bucketName, obj.Key, minio.StatObjectOptions{VersionID: obj.VersionID})
+	if err != nil {
+		return false, fmt.Errorf("failed to stat object %s: %w", obj.Key, err)
+	}
+	data, err := c.ReadFile(ctx, bucketName, obj.Key, obj.VersionID)
+	if err != nil {
+		return false, err
+	}
+
+	if int64(len(data)) != obj.Size {
+		return false, fmt.Errorf("%w: %s has size %d, listed with %d", ErrObjectCorrupt, obj.Key, len(data), obj.Size)
+	}
+	expected, ok := storedChecksum(info.UserMetadata, ChecksumMetadataKey)
+	if !ok {
+		return false, nil
+	}
+	if actual := checksum(data); !strings.EqualFold(actual, expected) {
+		return true, fmt.Errorf("%w: %s has checksum %s, stored %s", ErrObjectCorrupt, obj.Key, actual, expected)
+	}
+	return true, nil
+}
+
+// CheckBucketSettings checks, without changing anything, that a bucket still has versioning enabled
+// and the expected lifecycle rules. Use ProvisionBucket to repair the drift.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket to check
+//
+// return:
+//   - *BucketSettingsDrift: The settings that differ from the expected ones
+//   - error: ErrBucketNotFound, or an error if the settings could not be read
+func (c *Client) CheckBucketSettings(ctx context.Context, bucketName string) (*BucketSettingsDrift, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+	if c.config == nil {
+		return nil, fmt.Errorf("config is required to check bucket %s", bucketName)
+	}
+
+	versioning, err := c.minioClient.GetBucketVersioning(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get versioning of bucket %s: %w", bucketName, err)
+	}
+	current, err := c.getBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return &BucketSettingsDrift{
+		Name:                  bucketName,
+		Versioning:            versioningStatus(versioning),
+		DriftedLifecycleRules: driftedLifecycleRules(current, c.expectedLifecycleRules(bucketName)),
+	}, nil
+}
@@ -0,0 +1,165 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+func setupIntegrityClient(t *testing.T) (*Client, *mockMinioClient) {
+	t.Helper()
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getTestConfig())
+	if err := client.CreateBucket(context.Background(), "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	return client, mockClient
+}
+
+func listSingleObject(t *testing.T, client *Client, bucketName string) ObjectInfo {
+	t.Helper()
+	objects, err := client.ListObjects(context.Background(), bucketName, "")
+	if err != nil {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("Expected 1 object, got %d", len(objects))
+	}
+	return objects[0]
+}
+
+func TestClient_VerifyObject_Valid(t *testing.T) {
+	client, _ := setupIntegrityClient(t)
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, "orders", "a", []byte("content")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	verified, err := client.VerifyObject(ctx, "orders", listSingleObject(t, client, "orders"))
+	if err != nil {
+		t.Fatalf("VerifyObject() failed: %v", err)
+	}
+	if !verified {
+		t.Error("Expected the stored checksum to be verified")
+	}
+}
+
+func TestClient_VerifyObject_ChecksumMismatch(t *testing.T) {
+	client, mockClient := setupIntegrityClient(t)
+	ctx := context.Background()
+	versionID, err := client.WriteFile(ctx, "orders", "a", []byte("content"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	// Same size, different content
+	mockClient.corruptVersion("orders", "a", versionID, []byte("c0ntent"))
+
+	_, err = client.VerifyObject(ctx, "orders", listSingleObject(t, client, "orders"))
+	if !errors.Is(err, ErrObjectCorrupt) {
+		t.Errorf("Expected ErrObjectCorrupt, got %v", err)
+	}
+}
+
+func TestClient_VerifyObject_SizeMismatch(t *testing.T) {
+	client, _ := setupIntegrityClient(t)
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, "orders", "a", []byte("content")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	obj := listSingleObject(t, client, "orders")
+	obj.Size++
+
+	if _, err := client.VerifyObject(ctx, "orders", obj); !errors.Is(err, ErrObjectCorrupt) {
+		t.Errorf("Expected ErrObjectCorrupt, got %v", err)
+	}
+}
+
+func TestClient_VerifyObject_NoStoredChecksum(t *testing.T) {
+	client, mockClient := setupIntegrityClient(t)
+	ctx := context.Background()
+	data := []byte("written by another client")
+	if _, err := mockClient.PutObject(ctx, "orders", "a", bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{}); err != nil {
+		t.Fatalf("PutObject() failed: %v", err)
+	}
+
+	verified, err := client.VerifyObject(ctx, "orders", listSingleObject(t, client, "orders"))
+	if err != nil {
+		t.Fatalf("VerifyObject() failed: %v", err)
+	}
+	if verified {
+		t.Error("Expected no checksum to be verified")
+	}
+}
+
+func TestClient_VerifyObject_Unreadable(t *testing.T) {
+	client, mockClient := setupIntegrityClient(t)
+	ctx := context.Background()
+	if _, err := client.WriteFile(ctx, "orders", "a", []byte("content")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	obj := listSingleObject(t, client, "orders")
+	mockClient.setGetObjectError("orders", "a", errors.New("disk failure"))
+
+	_, err := client.VerifyObject(ctx, "orders", obj)
+	if err == nil || errors.Is(err, ErrObjectCorrupt) {
+		t.Errorf("Expected a read error, got %v", err)
+	}
+}
+
+func TestStoredChecksum_PrefixedKey(t *testing.T) {
+	value, ok := storedChecksum(map[string]string{"x-amz-meta-nimbus-sha256": "abc"}, ChecksumMetadataKey)
+	if !ok || value != "abc" {
+		t.Errorf("Expected checksum abc, got %q (found %v)", value, ok)
+	}
+}
+
+func TestClient_CheckBucketSettings(t *testing.T) {
+	client, mockClient := setupIntegrityClient(t)
+	ctx := context.Background()
+
+	drift, err := client.CheckBucketSettings(ctx, "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Drifted() {
+		t.Errorf("Expected no drift on a provisioned bucket, got %+v", drift)
+	}
+
+	if err := mockClient.SetBucketLifecycle(ctx, "orders", lifecycle.NewConfiguration()); err != nil {
+		t.Fatalf("SetBucketLifecycle() failed: %v", err)
+	}
+	drift, err = client.CheckBucketSettings(ctx, "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if !drift.Drifted() || len(drift.DriftedLifecycleRules) != 2 {
+		t.Errorf("Expected 2 drifted lifecycle rules, got %+v", drift)
+	}
+}
+
+func TestClient_CheckBucketSettings_VersioningDisabled(t *testing.T) {
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getTestConfig())
+	if err := mockClient.MakeBucket(context.Background(), "orders", minio.MakeBucketOptions{}); err != nil {
+		t.Fatalf("MakeBucket() failed: %v", err)
+	}
+
+	drift, err := client.CheckBucketSettings(context.Background(), "orders")
+	if err != nil {
+		t.Fatalf("CheckBucketSettings() failed: %v", err)
+	}
+	if drift.Versioning != VersioningDisabled || !drift.Drifted() {
+		t.Errorf("Expected versioning drift, got %+v", drift)
+	}
+}
+
+func TestClient_CheckBucketSettings_BucketNotFound(t *testing.T) {
+	client, _ := setupIntegrityClient(t)
+	if _, err := client.CheckBucketSettings(context.Background(), "missing"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
@@ -68,7 +68,37 @@ func (a *minioClientAdapter) SetBucketLifecycle(ctx context.Context, bucketName
 	return a.client.SetBucketLifecycle(ctx, bucketName, config)
 }
 
+// GetBucketLifecycle gets the lifecycle configuration of a bucket.
+func (a *minioClientAdapter) GetBucketLifecycle(ctx context.Context, bucketName string) (*lifecycle.Configuration, error) {
+	return a.client.GetBucketLifecycle(ctx, bucketName)
+}
+
+// ListObjects lists the objects (or object versions) of a bucket.
+func (a *minioClientAdapter) ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	return a.client.ListObjects(ctx, bucketName, opts)
+}
+
 // StatObject retrieves object metadata without reading the object.
 func (a *minioClientAdapter) StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
 	return a.client.StatObject(ctx, bucketName, objectName, opts)
 }
+
+// GetObjectLockConfig gets the object lock configuration of a bucket.
+func (a *minioClientAdapter) GetObjectLockConfig(ctx context.Context, bucketName string) (string, *minio.RetentionMode, *uint, *minio.ValidityUnit, error) {
+	return a.client.GetObjectLockConfig(ctx, bucketName)
+}
+
+// SetObjectLockConfig sets the default retention of a bucket.
+func (a *minioClientAdapter) SetObjectLockConfig(ctx context.Context, bucketName string, mode *minio.RetentionMode, validity *uint, unit *minio.ValidityUnit) error {
+	return a.client.SetObjectLockConfig(ctx, bucketName, mode, validity, unit)
+}
+
+// PutObjectLegalHold applies or removes the legal hold of an object version.
+func (a *minioClientAdapter) PutObjectLegalHold(ctx context.Context, bucketName, objectName string, opts minio.PutObjectLegalHoldOptions) error {
+	return a.client.PutObjectLegalHold(ctx, bucketName, objectName, opts)
+}
+
+// GetObjectLegalHold gets the legal hold status of an object version.
+func (a *minioClientAdapter) GetObjectLegalHold(ctx context.Context, bucketName, objectName string, opts minio.GetObjectLegalHoldOptions) (*minio.LegalHoldStatus, error) {
+	return a.client.GetObjectLegalHold(ctx, bucketName, objectName, opts)
+}
@@ -42,6 +42,28 @@ type minioClientInterface interface {
 	// SetBucketLifecycle sets the lifecycle configuration for a bucket.
 	SetBucketLifecycle(ctx context.Context, bucketName string, config *lifecycle.Configuration) error
 
+	// GetBucketLifecycle gets the lifecycle configuration of a bucket.
+	// Returns a NoSuchLifecycleConfiguration error if the bucket has none.
+	GetBucketLifecycle(ctx context.Context, bucketName string) (*lifecycle.Configuration, error)
+
+	// ListObjects lists the objects (or object versions, with opts.WithVersions) of a bucket.
+	// Errors are reported through the Err field of the listed objects.
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+
 	// StatObject retrieves object metadata without reading the object.
 	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+
+	// GetObjectLockConfig gets the object lock configuration of a bucket ("Enabled" if object lock is enabled)
+	// and its default retention, nil if none is set.
+	// Returns an ObjectLockConfigurationNotFoundError error if object lock is not enabled on the bucket.
+	GetObjectLockConfig(ctx context.Context, bucketName string) (string, *minio.RetentionMode, *uint, *minio.ValidityUnit, error)
+
+	// SetObjectLockConfig sets the default retention of a bucket with object lock enabled. Nil values remove it.
+	SetObjectLockConfig(ctx context.Context, bucketName string, mode *minio.RetentionMode, validity *uint, unit *minio.ValidityUnit) error
+
+	// PutObjectLegalHold applies or removes the legal hold of an object version.
+	PutObjectLegalHold(ctx context.Context, bucketName, objectName string, opts minio.PutObjectLegalHoldOptions) error
+
+	// GetObjectLegalHold gets the legal hold status of an object version.
+	GetObjectLegalHold(ctx context.Context, bucketName, objectName string, opts minio.GetObjectLegalHoldOptions) (*minio.LegalHoldStatus, error)
 }
@@ -0,0 +1,341 @@
+package blob
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// azureVersionIDLayout is the layout of Azure version IDs, the write time with 7 decimal digits.
+const azureVersionIDLayout = "2006-01-02T15:04:05.0000000Z"
+
+// mockAzureClient is a mock implementation of azureClientInterface for testing.
+// Versioning is a setting of the mock storage account, like on Azure.
+type mockAzureClient struct {
+	mu                  sync.Mutex
+	versioning          bool
+	deleteRetentionDays int
+	containers          map[string]*mockAzureContainer
+	lastVersion         time.Time
+	uploadErr           map[string]error // container/blob -> error
+}
+
+// mockAzureContainer is a container of the mock storage account.
+type mockAzureContainer struct {
+	lastModified time.Time
+	blobs        map[string][]*mockAzureVersion // blob -> versions, oldest first
+}
+
+// mockAzureVersion is a version of a blob, or the base blob without versioning.
+type mockAzureVersion struct {
+	versionID string
+	data      []byte
+	metadata  map[string]string
+	tags      map[string]string
+	modified  time.Time
+	current   bool
+}
+
+// newMockAzureClient creates a new mock Azure client, for a storage account with or without versioning.
+func newMockAzureClient(versioning bool) *mockAzureClient {
+	return &mockAzureClient{
+		versioning: versioning,
+		containers: make(map[string]*mockAzureContainer),
+		uploadErr:  make(map[string]error),
+	}
+}
+
+// azureResponseError returns an error like the ones of the Azure SDK.
+func azureResponseError(status int, code bloberror.Code) error {
+	return &azcore.ResponseError{ErrorCode: string(code), StatusCode: status}
+}
+
+// setUploadError makes the uploads of a blob fail with err.
+func (m *mockAzureClient) setUploadError(containerName, blobName string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploadErr[containerName+"/"+blobName] = err
+}
+
+// container returns a container, or a ContainerNotFound error.
+func (m *mockAzureClient) container(containerName string) (*mockAzureContainer, error) {
+	c, ok := m.containers[containerName]
+	if !ok {
+		return nil, azureResponseError(http.StatusNotFound, bloberror.ContainerNotFound)
+	}
+	return c, nil
+}
+
+// find returns a blob version, the current one if versionID is empty, or a BlobNotFound error.
+func (m *mockAzureClient) find(containerName, blobName, versionID string) (*mockAzureVersion, error) {
+	c, err := m.container(containerName)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range c.blobs[blobName] {
+		if (versionID == "" && v.current) || (versionID != "" && v.versionID == versionID) {
+			return v, nil
+		}
+	}
+	return nil, azureResponseError(http.StatusNotFound, bloberror.BlobNotFound)
+}
+
+// newVersionID returns a version ID later than every version ID returned before.
+func (m *mockAzureClient) newVersionID() string {
+	now := time.Now().UTC().Truncate(100 * time.Nanosecond)
+	if !now.After(m.lastVersion) {
+		now = m.lastVersion.Add(100 * time.Nanosecond)
+	}
+	m.lastVersion = now
+	return now.Format(azureVersionIDLayout)
+}
+
+// backdate moves the versions of a blob back in time by age, as if they had been written age earlier.
+func (m *mockAzureClient) backdate(containerName, blobName string, age time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.containers[containerName].blobs[blobName] {
+		v.modified = v.modified.Add(-age)
+		if t, err := time.Parse(azureVersionIDLayout, v.versionID); err == nil {
+			v.versionID = t.Add(-age).Format(azureVersionIDLayout)
+		}
+	}
+}
+
+// ListContainers lists all containers of the storage account.
+func (m *mockAzureClient) ListContainers(ctx context.Context) ([]azureContainer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var containers []azureContainer
+	for name, c := range m.containers {
+		containers = append(containers, azureContainer{Name: name, LastModified: c.lastModified})
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, nil
+}
+
+// ContainerExists checks if a container exists.
+func (m *mockAzureClient) ContainerExists(ctx context.Context, containerName string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.containers[containerName]
+	return ok, nil
+}
+
+// CreateContainer creates a container.
+func (m *mockAzureClient) CreateContainer(ctx context.Context, containerName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.containers[containerName]; ok {
+		return azureResponseError(http.StatusConflict, bloberror.ContainerAlreadyExists)
+	}
+	m.containers[containerName] = &mockAzureContainer{lastModified: time.Now().UTC(), blobs: make(map[string][]*mockAzureVersion)}
+	return nil
+}
+
+// DeleteContainer deletes a container and all its blobs.
+func (m *mockAzureClient) DeleteContainer(ctx context.Context, containerName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.container(containerName); err != nil {
+		return err
+	}
+	delete(m.containers, containerName)
+	return nil
+}
+
+// UploadBlob writes a block blob and returns the version ID of the new version, empty without versioning.
+func (m *mockAzureClient) UploadBlob(ctx context.Context, containerName, blobName string, data []byte, metadata map[string]string, ifNotExists bool) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.uploadErr[containerName+"/"+blobName]; err != nil {
+		return "", err
+	}
+	c, err := m.container(containerName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := m.find(containerName, blobName, ""); ifNotExists && err == nil {
+		return "", azureResponseError(http.StatusConflict, bloberror.BlobAlreadyExists)
+	}
+
+	v := &mockAzureVersion{data: append([]byte(nil), data...), metadata: metadata, modified: time.Now().UTC(), current: true}
+	if !m.versioning {
+		c.blobs[blobName] = []*mockAzureVersion{v}
+		return "", nil
+	}
+	for _, previous := range c.blobs[blobName] {
+		previous.current = false
+	}
+	v.versionID = m.newVersionID()
+	c.blobs[blobName] = append(c.blobs[blobName], v)
+	return v.versionID, nil
+}
+
+// DownloadBlob reads a blob version.
+func (m *mockAzureClient) DownloadBlob(ctx context.Context, containerName, blobName, versionID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.find(containerName, blobName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), v.data...), nil
+}
+
+// GetBlobProperties describes a blob version. Like HEAD responses of Azure, errors have a status but no code.
+func (m *mockAzureClient) GetBlobProperties(ctx context.Context, containerName, blobName, versionID string) (azureBlob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.find(containerName, blobName, versionID)
+	if err != nil {
+		return azureBlob{}, &azcore.ResponseError{StatusCode: http.StatusNotFound}
+	}
+	return v.describe(blobName), nil
+}
+
+// DeleteBlob deletes the base blob (its current version is kept as a previous version with versioning), or a previous version.
+// Like on Azure, the current version cannot be deleted by version ID.
+func (m *mockAzureClient) DeleteBlob(ctx context.Context, containerName, blobName, versionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.find(containerName, blobName, versionID)
+	if err != nil {
+		return err
+	}
+	if versionID != "" && v.current {
+		return azureResponseError(http.StatusBadRequest, bloberror.OperationNotAllowedOnRootBlob)
+	}
+
+	c := m.containers[containerName]
+	if versionID == "" && m.versioning {
+		v.current = false
+		return nil
+	}
+	var kept []*mockAzureVersion
+	for _, other := range c.blobs[blobName] {
+		if other != v {
+			kept = append(kept, other)
+		}
+	}
+	if len(kept) == 0 {
+		delete(c.blobs, blobName)
+	} else {
+		c.blobs[blobName] = kept
+	}
+	return nil
+}
+
+// SetBlobTags replaces the blob index tags of a blob version.
+func (m *mockAzureClient) SetBlobTags(ctx context.Context, containerName, blobName, versionID string, tags map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.find(containerName, blobName, versionID)
+	if err != nil {
+		return err
+	}
+	v.tags = tags
+	return nil
+}
+
+// ListBlobs lists the blobs of a container, and their versions if withVersions is true, by name and oldest version first.
+func (m *mockAzureClient) ListBlobs(ctx context.Context, containerName, prefix string, withVersions bool) ([]azureBlob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, err := m.container(containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.blobs))
+	for name := range c.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var blobs []azureBlob
+	for _, name := range names {
+		for _, v := range c.blobs[name] {
+			if withVersions || v.current {
+				blobs = append(blobs, v.describe(name))
+			}
+		}
+	}
+	return blobs, nil
+}
+
+// GetDeleteRetention returns the soft delete retention of the storage account in days, 0 if disabled.
+func (m *mockAzureClient) GetDeleteRetention(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deleteRetentionDays, nil
+}
+
+// SetDeleteRetention enables soft delete on the storage account.
+func (m *mockAzureClient) SetDeleteRetention(ctx context.Context, days int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteRetentionDays = days
+	return nil
+}
+
+// describe returns the listing of a version.
+func (v *mockAzureVersion) describe(blobName string) azureBlob {
+	return azureBlob{
+		Name:             blobName,
+		VersionID:        v.versionID,
+		IsCurrentVersion: v.current,
+		Size:             int64(len(v.data)),
+		LastModified:     v.modified,
+		Metadata:         v.metadata,
+		Tags:             v.tags,
+	}
+}
+
+// mockAzureManagement is a mock implementation of azureManagementInterface for testing,
+// holding the lifecycle management policy of the mock storage account.
+type mockAzureManagement struct {
+	mu    sync.Mutex
+	rules []azureLifecycleRule
+}
+
+// newMockAzureManagement creates a new mock Azure management client, for a storage account without policy.
+func newMockAzureManagement() *mockAzureManagement {
+	return &mockAzureManagement{}
+}
+
+// GetLifecycleRules returns the rules of the management policy.
+func (m *mockAzureManagement) GetLifecycleRules(ctx context.Context) ([]azureLifecycleRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]azureLifecycleRule(nil), m.rules...), nil
+}
+
+// SetLifecycleRules adds, replaces and removes rules of the management policy, keeping the other rules.
+func (m *mockAzureManagement) SetLifecycleRules(ctx context.Context, rules []azureLifecycleRule, remove []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replaced := make(map[string]bool)
+	for _, r := range rules {
+		replaced[r.Name] = true
+	}
+	for _, name := range remove {
+		replaced[name] = true
+	}
+	var kept []azureLifecycleRule
+	for _, r := range m.rules {
+		if !replaced[r.Name] {
+			kept = append(kept, r)
+		}
+	}
+	m.rules = append(kept, rules...)
+	return nil
+}
@@ -0,0 +1,267 @@
+package blob
+
+import (
+	"context"
+	"crypto/md5"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// mockGCSClient is a mock implementation of gcsClientInterface for testing.
+type mockGCSClient struct {
+	mu         sync.Mutex
+	buckets    map[string]*mockGCSBucket
+	generation int64
+}
+
+// mockGCSBucket is a bucket of the mock project.
+type mockGCSBucket struct {
+	attrs   storage.BucketAttrs
+	objects map[string][]*storage.ObjectAttrs // object -> generations, oldest first
+	data    map[int64][]byte                  // generation -> content
+}
+
+// newMockGCSClient creates a new mock GCS client.
+func newMockGCSClient() *mockGCSClient {
+	return &mockGCSClient{
+		buckets: make(map[string]*mockGCSBucket),
+	}
+}
+
+// corrupt replaces the content of an object generation, keeping its attributes.
+func (m *mockGCSClient) corrupt(bucketName string, generation int64, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets[bucketName].data[generation] = data
+}
+
+// find returns an object generation, the live one if generation is 0, or storage.ErrObjectNotExist.
+func (m *mockGCSClient) find(bucketName, objectName string, generation int64) (*mockGCSBucket, *storage.ObjectAttrs, error) {
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		// Like GCS, a missing bucket is reported as a missing object
+		return nil, nil, storage.ErrObjectNotExist
+	}
+	for _, attrs := range b.objects[objectName] {
+		if (generation == 0 && attrs.Deleted.IsZero()) || attrs.Generation == generation {
+			return b, attrs, nil
+		}
+	}
+	return nil, nil, storage.ErrObjectNotExist
+}
+
+// ListBuckets lists the buckets, the mock has a single project.
+func (m *mockGCSClient) ListBuckets(ctx context.Context, projectID string) ([]*storage.BucketAttrs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buckets := make([]*storage.BucketAttrs, 0, len(m.buckets))
+	for _, b := range m.buckets {
+		attrs := b.attrs
+		buckets = append(buckets, &attrs)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets, nil
+}
+
+// GetBucketAttrs returns the settings of a bucket.
+func (m *mockGCSClient) GetBucketAttrs(ctx context.Context, bucketName string) (*storage.BucketAttrs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, storage.ErrBucketNotExist
+	}
+	attrs := b.attrs
+	attrs.Lifecycle.Rules = append([]storage.LifecycleRule(nil), b.attrs.Lifecycle.Rules...)
+	return &attrs, nil
+}
+
+// CreateBucket creates a bucket.
+func (m *mockGCSClient) CreateBucket(ctx context.Context, projectID, bucketName string, attrs *storage.BucketAttrs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[bucketName]; ok {
+		return &googleapi.Error{Code: http.StatusConflict, Message: "bucket already exists"}
+	}
+	b := &mockGCSBucket{objects: make(map[string][]*storage.ObjectAttrs), data: make(map[int64][]byte)}
+	if attrs != nil {
+		b.attrs = *attrs
+	}
+	b.attrs.Name = bucketName
+	b.attrs.Created = time.Now()
+	m.buckets[bucketName] = b
+	return nil
+}
+
+// UpdateBucket changes the settings of a bucket that are set in attrs.
+func (m *mockGCSClient) UpdateBucket(ctx context.Context, bucketName string, attrs storage.BucketAttrsToUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return storage.ErrBucketNotExist
+	}
+	if attrs.VersioningEnabled != nil {
+		b.attrs.VersioningEnabled = attrs.VersioningEnabled.(bool)
+	}
+	if attrs.Lifecycle != nil {
+		b.attrs.Lifecycle = *attrs.Lifecycle
+	}
+	return nil
+}
+
+// DeleteBucket deletes a bucket without any object generation.
+func (m *mockGCSClient) DeleteBucket(ctx context.Context, bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return storage.ErrBucketNotExist
+	}
+	if len(b.objects) > 0 {
+		return &googleapi.Error{Code: http.StatusConflict, Message: "bucket is not empty"}
+	}
+	delete(m.buckets, bucketName)
+	return nil
+}
+
+// WriteObject writes a new generation of an object. With versioning, the live generation becomes noncurrent, otherwise it is replaced.
+func (m *mockGCSClient) WriteObject(ctx context.Context, bucketName, objectName string, data []byte, metadata map[string]string, conds *storage.Conditions) (*storage.ObjectAttrs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "bucket not found"}
+	}
+
+	var live *storage.ObjectAttrs
+	for _, attrs := range b.objects[objectName] {
+		if attrs.Deleted.IsZero() {
+			live = attrs
+		}
+	}
+	if conds != nil {
+		if (conds.DoesNotExist && live != nil) || (conds.GenerationMatch != 0 && (live == nil || live.Generation != conds.GenerationMatch)) {
+			return nil, &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "precondition failed"}
+		}
+	}
+
+	now := time.Now()
+	if live != nil {
+		if b.attrs.VersioningEnabled {
+			live.Deleted = now
+		} else {
+			m.remove(b, objectName, live.Generation)
+		}
+	}
+	// Generations must be unique and increasing, even within the same nanosecond
+	m.generation++
+	if g := now.UnixNano(); g > m.generation {
+		m.generation = g
+	}
+	sum := md5.Sum(data)
+	attrs := &storage.ObjectAttrs{
+		Bucket:     bucketName,
+		Name:       objectName,
+		Generation: m.generation,
+		Size:       int64(len(data)),
+		MD5:        sum[:],
+		Metadata:   metadata,
+		Created:    now,
+	}
+	b.objects[objectName] = append(b.objects[objectName], attrs)
+	b.data[attrs.Generation] = append([]byte(nil), data...)
+	copied := *attrs
+	return &copied, nil
+}
+
+// remove deletes an object generation permanently.
+func (m *mockGCSClient) remove(b *mockGCSBucket, objectName string, generation int64) {
+	generations := b.objects[objectName]
+	for i, attrs := range generations {
+		if attrs.Generation == generation {
+			generations = append(generations[:i], generations[i+1:]...)
+			break
+		}
+	}
+	if len(generations) == 0 {
+		delete(b.objects, objectName)
+	} else {
+		b.objects[objectName] = generations
+	}
+	delete(b.data, generation)
+}
+
+// ReadObject reads a generation of an object.
+func (m *mockGCSClient) ReadObject(ctx context.Context, bucketName, objectName string, generation int64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, attrs, err := m.find(bucketName, objectName, generation)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b.data[attrs.Generation]...), nil
+}
+
+// GetObjectAttrs describes a generation of an object.
+func (m *mockGCSClient) GetObjectAttrs(ctx context.Context, bucketName, objectName string, generation int64) (*storage.ObjectAttrs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, attrs, err := m.find(bucketName, objectName, generation)
+	if err != nil {
+		return nil, err
+	}
+	copied := *attrs
+	return &copied, nil
+}
+
+// DeleteObject deletes an object, or a generation of it permanently.
+func (m *mockGCSClient) DeleteObject(ctx context.Context, bucketName, objectName string, generation int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, attrs, err := m.find(bucketName, objectName, generation)
+	if err != nil {
+		return err
+	}
+	if generation == 0 && b.attrs.VersioningEnabled {
+		attrs.Deleted = time.Now()
+		return nil
+	}
+	m.remove(b, objectName, attrs.Generation)
+	return nil
+}
+
+// ListObjects lists the live objects of a bucket, and their noncurrent generations if versions is true.
+func (m *mockGCSClient) ListObjects(ctx context.Context, bucketName, prefix string, versions bool) ([]*storage.ObjectAttrs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, storage.ErrBucketNotExist
+	}
+	var objects []*storage.ObjectAttrs
+	for name, generations := range b.objects {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		for _, attrs := range generations {
+			if versions || attrs.Deleted.IsZero() {
+				copied := *attrs
+				objects = append(objects, &copied)
+			}
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Name != objects[j].Name {
+			return objects[i].Name < objects[j].Name
+		}
+		return objects[i].Generation < objects[j].Generation
+	})
+	return objects, nil
+}
@@ -5,8 +5,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/lifecycle"
@@ -19,6 +24,9 @@ type mockMinioClient struct {
 	objects             map[string]map[string][]byte            // bucket -> object -> latest data
 	objectVersions      map[string]map[string]map[string][]byte // bucket -> object -> versionID -> data
 	latestVersions      map[string]map[string]string            // bucket -> object -> latest versionID
+	versionTimes        map[string]map[string]time.Time         // bucket -> versionID -> last modified
+	deleteMarkers       map[string]map[string]bool              // bucket -> versionID -> is a delete marker
+	userMetadata        map[string]map[string]map[string]string // bucket -> versionID -> user metadata
 	versioning          map[string]bool                         // bucket -> versioning enabled
 	versionCounter      atomic.Int64                            // counter for generating version IDs
 	listBucketsErr      error
@@ -31,6 +39,15 @@ type mockMinioClient struct {
 	removeBucketErr     map[string]error                    // bucket -> error
 	setLifecycleErr     map[string]error                    // bucket -> error
 	lifecycleConfigs    map[string]*lifecycle.Configuration // bucket -> lifecycle config
+	objectLock          map[string]*mockObjectLock          // bucket -> object lock, only for buckets created with object locking
+	legalHolds          map[string]map[string]bool          // bucket -> versionID -> legal hold on
+}
+
+// mockObjectLock is the object lock configuration of a mock bucket.
+type mockObjectLock struct {
+	mode     *minio.RetentionMode
+	validity *uint
+	unit     *minio.ValidityUnit
 }
 
 // newMockMinioClient creates a new mock MinIO client.
@@ -40,6 +57,9 @@ func newMockMinioClient() *mockMinioClient {
 		objects:             make(map[string]map[string][]byte),
 		objectVersions:      make(map[string]map[string]map[string][]byte),
 		latestVersions:      make(map[string]map[string]string),
+		versionTimes:        make(map[string]map[string]time.Time),
+		deleteMarkers:       make(map[string]map[string]bool),
+		userMetadata:        make(map[string]map[string]map[string]string),
 		versioning:          make(map[string]bool),
 		getObjectErr:        make(map[string]error),
 		putObjectErr:        make(map[string]error),
@@ -50,6 +70,8 @@ func newMockMinioClient() *mockMinioClient {
 		removeBucketErr:     make(map[string]error),
 		setLifecycleErr:     make(map[string]error),
 		lifecycleConfigs:    make(map[string]*lifecycle.Configuration),
+		objectLock:          make(map[string]*mockObjectLock),
+		legalHolds:          make(map[string]map[string]bool),
 	}
 }
 
@@ -84,7 +106,7 @@ func (m *mockMinioClient) GetObject(ctx context.Context, bucketName, objectName
 
 	// Check if bucket exists
 	if !m.buckets[bucketName] {
-		return nil, fmt.Errorf("bucket %s does not exist", bucketName)
+		return nil, minio.ErrorResponse{Code: "NoSuchBucket", BucketName: bucketName}
 	}
 
 	var data []byte
@@ -96,14 +118,14 @@ func (m *mockMinioClient) GetObject(ctx context.Context, bucketName, objectName
 			data, found = m.objectVersions[bucketName][objectName][opts.VersionID]
 		}
 		if !found {
-			return nil, fmt.Errorf("version %s does not exist for object %s in bucket %s", opts.VersionID, objectName, bucketName)
+			return nil, minio.ErrorResponse{Code: "NoSuchVersion", BucketName: bucketName, Key: objectName}
 		}
 	} else {
 		// No version specified, read latest version
 		// For versioned buckets, use latestVersions to find current version and read from objectVersions
 		if m.versioning[bucketName] && m.latestVersions[bucketName] != nil {
 			latestVersionID, hasLatest := m.latestVersions[bucketName][objectName]
-			if hasLatest && m.objectVersions[bucketName] != nil && m.objectVersions[bucketName][objectName] != nil {
+			if hasLatest && !m.deleteMarkers[bucketName][latestVersionID] && m.objectVersions[bucketName] != nil && m.objectVersions[bucketName][objectName] != nil {
 				data, found = m.objectVersions[bucketName][objectName][latestVersionID]
 			}
 		} else {
@@ -114,7 +136,7 @@ func (m *mockMinioClient) GetObject(ctx context.Context, bucketName, objectName
 			}
 		}
 		if !found {
-			return nil, fmt.Errorf("object %s does not exist in bucket %s", objectName, bucketName)
+			return nil, minio.ErrorResponse{Code: "NoSuchKey", BucketName: bucketName, Key: objectName}
 		}
 	}
 
@@ -123,6 +145,17 @@ func (m *mockMinioClient) GetObject(ctx context.Context, bucketName, objectName
 	return io.NopCloser(bytes.NewReader(data)), nil
 }
 
+// existsLocked reports whether an object has a current version. Must be called with the lock held.
+func (m *mockMinioClient) existsLocked(bucketName, objectName string) bool {
+	if m.versioning[bucketName] {
+		latestVersionID, hasLatest := m.latestVersions[bucketName][objectName]
+		_, found := m.objectVersions[bucketName][objectName][latestVersionID]
+		return hasLatest && found && !m.deleteMarkers[bucketName][latestVersionID]
+	}
+	_, found := m.objects[bucketName][objectName]
+	return found
+}
+
 // PutObject uploads an object to a bucket.
 func (m *mockMinioClient) PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
 	key := fmt.Sprintf("%s/%s", bucketName, objectName)
@@ -134,7 +167,12 @@ func (m *mockMinioClient) PutObject(ctx context.Context, bucketName, objectName
 	defer m.mu.Unlock()
 
 	if !m.buckets[bucketName] {
-		return minio.UploadInfo{}, fmt.Errorf("bucket %s does not exist", bucketName)
+		return minio.UploadInfo{}, minio.ErrorResponse{Code: "NoSuchBucket", BucketName: bucketName}
+	}
+
+	// If-None-Match: * only writes objects without a current version
+	if opts.Header().Get("If-None-Match") == "*" && m.existsLocked(bucketName, objectName) {
+		return minio.UploadInfo{}, minio.ErrorResponse{Code: "PreconditionFailed", StatusCode: http.StatusPreconditionFailed, BucketName: bucketName, Key: objectName}
 	}
 
 	data, err := io.ReadAll(reader)
@@ -161,6 +199,13 @@ func (m *mockMinioClient) PutObject(ctx context.Context, bucketName, objectName
 		// Store the versioned data (only in objectVersions for versioned buckets)
 		m.objectVersions[bucketName][objectName][versionID] = data
 		m.latestVersions[bucketName][objectName] = versionID
+		m.setVersionTimeLocked(bucketName, versionID, time.Now())
+		if len(opts.UserMetadata) > 0 {
+			if m.userMetadata[bucketName] == nil {
+				m.userMetadata[bucketName] = make(map[string]map[string]string)
+			}
+			m.userMetadata[bucketName][versionID] = opts.UserMetadata
+		}
 	} else {
 		// Store in objects map only for non-versioned buckets
 		if m.objects[bucketName] == nil {
@@ -204,6 +249,11 @@ func (m *mockMinioClient) MakeBucket(ctx context.Context, bucketName string, opt
 
 	m.buckets[bucketName] = true
 	m.objects[bucketName] = make(map[string][]byte)
+	// Object locking requires versioning, it is enabled with it
+	if opts.ObjectLocking {
+		m.objectLock[bucketName] = &mockObjectLock{}
+		m.versioning[bucketName] = true
+	}
 
 	return nil
 }
@@ -256,7 +306,45 @@ func (m *mockMinioClient) RemoveObject(ctx context.Context, bucketName, objectNa
 
 	bucket, exists := m.objects[bucketName]
 	if !exists {
-		return fmt.Errorf("bucket %s does not exist", bucketName)
+		return minio.ErrorResponse{Code: "NoSuchBucket", BucketName: bucketName}
+	}
+
+	// Mimic versioned buckets, where removing an object without version ID adds a delete marker
+	if m.versioning[bucketName] && opts.VersionID == "" {
+		if _, hasLatest := m.latestVersions[bucketName][objectName]; !hasLatest {
+			return nil
+		}
+		versionID := fmt.Sprintf("version-%d", m.versionCounter.Add(1))
+		m.objectVersions[bucketName][objectName][versionID] = nil
+		m.latestVersions[bucketName][objectName] = versionID
+		if m.deleteMarkers[bucketName] == nil {
+			m.deleteMarkers[bucketName] = make(map[string]bool)
+		}
+		m.deleteMarkers[bucketName][versionID] = true
+		m.setVersionTimeLocked(bucketName, versionID, time.Now())
+		return nil
+	}
+
+	// Removing a version deletes it permanently, the newest remaining version becomes current
+	if opts.VersionID != "" {
+		versions := m.objectVersions[bucketName][objectName]
+		if _, ok := versions[opts.VersionID]; !ok {
+			return minio.ErrorResponse{Code: "NoSuchVersion", BucketName: bucketName, Key: objectName}
+		}
+		if m.legalHolds[bucketName][opts.VersionID] {
+			// Like MinIO, a version under legal hold cannot be removed
+			return minio.ErrorResponse{Code: "InvalidRequest", BucketName: bucketName, Key: objectName, Message: "Object is WORM protected and cannot be overwritten"}
+		}
+		delete(versions, opts.VersionID)
+		if m.latestVersions[bucketName][objectName] == opts.VersionID {
+			delete(m.latestVersions[bucketName], objectName)
+			for versionID := range versions {
+				if latest, ok := m.latestVersions[bucketName][objectName]; !ok || versionNumber(versionID) > versionNumber(latest) {
+					m.latestVersions[bucketName][objectName] = versionID
+				}
+			}
+		}
+		return nil
 	}
 
 	delete(bucket, objectName)
@@ -276,6 +364,14 @@ func (m *mockMinioClient) RemoveBucket(ctx context.Context, bucketName string) e
 		return fmt.Errorf("bucket %s does not exist", bucketName)
 	}
 
+	// Mimic MinIO, which refuses to remove a bucket that still has objects (or object versions)
+	if len(m.objects[bucketName]) > 0 || len(m.objectVersions[bucketName]) > 0 {
+		return minio.ErrorResponse{
+			Code:       "BucketNotEmpty",
+			BucketName: bucketName,
+		}
+	}
+
 	delete(m.buckets, bucketName)
 	delete(m.objects, bucketName)
 	delete(m.versioning, bucketName)
@@ -303,6 +399,86 @@ func (m *mockMinioClient) SetBucketLifecycle(ctx context.Context, bucketName str
 	return nil
 }
 
+// GetBucketLifecycle gets the lifecycle configuration of a bucket.
+func (m *mockMinioClient) GetBucketLifecycle(ctx context.Context, bucketName string) (*lifecycle.Configuration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.buckets[bucketName] {
+		return nil, fmt.Errorf("bucket %s does not exist", bucketName)
+	}
+
+	config, ok := m.lifecycleConfigs[bucketName]
+	if !ok {
+		// Return error that mimics MinIO's NoSuchLifecycleConfiguration error
+		return nil, minio.ErrorResponse{
+			Code:       "NoSuchLifecycleConfiguration",
+			BucketName: bucketName,
+		}
+	}
+	return config, nil
+}
+
+// ListObjects lists the objects (or object versions, with opts.WithVersions) of a bucket, sorted by key.
+// Versions of a key are listed newest first.
+func (m *mockMinioClient) ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var objects []minio.ObjectInfo
+	if !m.buckets[bucketName] {
+		objects = append(objects, minio.ObjectInfo{Err: minio.ErrorResponse{Code: "NoSuchBucket", BucketName: bucketName}})
+	} else if m.versioning[bucketName] || len(m.objectVersions[bucketName]) > 0 {
+		for key, versions := range m.objectVersions[bucketName] {
+			if !strings.HasPrefix(key, opts.Prefix) {
+				continue
+			}
+			latest, hasLatest := m.latestVersions[bucketName][key]
+			for versionID, data := range versions {
+				isLatest := hasLatest && versionID == latest
+				isDeleteMarker := m.deleteMarkers[bucketName][versionID]
+				if !opts.WithVersions && (!isLatest || isDeleteMarker) {
+					continue
+				}
+				objects = append(objects, minio.ObjectInfo{
+					Key:            key,
+					Size:           int64(len(data)),
+					VersionID:      versionID,
+					IsLatest:       isLatest,
+					IsDeleteMarker: isDeleteMarker,
+					LastModified:   m.versionTimes[bucketName][versionID],
+				})
+			}
+		}
+	} else {
+		for key, data := range m.objects[bucketName] {
+			if strings.HasPrefix(key, opts.Prefix) {
+				objects = append(objects, minio.ObjectInfo{Key: key, Size: int64(len(data)), IsLatest: true})
+			}
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Key != objects[j].Key {
+			return objects[i].Key < objects[j].Key
+		}
+		return versionNumber(objects[i].VersionID) > versionNumber(objects[j].VersionID)
+	})
+
+	ch := make(chan minio.ObjectInfo, len(objects))
+	for _, o := range objects {
+		ch <- o
+	}
+	close(ch)
+	return ch
+}
+
+// versionNumber returns the counter of a mock version ID ("version-N"), so versions can be ordered by age.
+func versionNumber(versionID string) int64 {
+	n, _ := strconv.ParseInt(strings.TrimPrefix(versionID, "version-"), 10, 64)
+	return n
+}
+
 // StatObject retrieves object metadata without reading the object.
 func (m *mockMinioClient) StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
 	m.mu.RLock()
@@ -310,7 +486,7 @@ func (m *mockMinioClient) StatObject(ctx context.Context, bucketName, objectName
 
 	// Check if bucket exists
 	if !m.buckets[bucketName] {
-		return minio.ObjectInfo{}, fmt.Errorf("bucket %s does not exist", bucketName)
+		return minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchBucket", BucketName: bucketName}
 	}
 
 	// Check if object exists
@@ -318,9 +494,16 @@ func (m *mockMinioClient) StatObject(ctx context.Context, bucketName, objectName
 	var size int64
 	var versionID string
 
-	if m.versioning[bucketName] && m.latestVersions[bucketName] != nil {
+	if opts.VersionID != "" {
+		// A specific version was requested
+		if data, found := m.objectVersions[bucketName][objectName][opts.VersionID]; found && !m.deleteMarkers[bucketName][opts.VersionID] {
+			exists = true
+			size = int64(len(data))
+			versionID = opts.VersionID
+		}
+	} else if m.versioning[bucketName] && m.latestVersions[bucketName] != nil {
 		// For versioned buckets, check if there's a latest version
-		if latestVersion, hasLatest := m.latestVersions[bucketName][objectName]; hasLatest {
+		if latestVersion, hasLatest := m.latestVersions[bucketName][objectName]; hasLatest && !m.deleteMarkers[bucketName][latestVersion] {
 			if m.objectVersions[bucketName] != nil && m.objectVersions[bucketName][objectName] != nil {
 				if data, found := m.objectVersions[bucketName][objectName][latestVersion]; found {
 					exists = true
@@ -349,14 +532,116 @@ func (m *mockMinioClient) StatObject(ctx context.Context, bucketName, objectName
 	}
 
 	return minio.ObjectInfo{
-		Key:       objectName,
-		Size:      size,
-		VersionID: versionID,
+		Key:          objectName,
+		Size:         size,
+		VersionID:    versionID,
+		UserMetadata: m.userMetadata[bucketName][versionID],
 	}, nil
 }
 
+// GetObjectLockConfig gets the object lock configuration of a bucket.
+func (m *mockMinioClient) GetObjectLockConfig(ctx context.Context, bucketName string) (string, *minio.RetentionMode, *uint, *minio.ValidityUnit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.buckets[bucketName] {
+		return "", nil, nil, nil, fmt.Errorf("bucket %s does not exist", bucketName)
+	}
+	lock, ok := m.objectLock[bucketName]
+	if !ok {
+		// Return error that mimics MinIO's ObjectLockConfigurationNotFoundError error
+		return "", nil, nil, nil, minio.ErrorResponse{Code: "ObjectLockConfigurationNotFoundError", BucketName: bucketName}
+	}
+	return "Enabled", lock.mode, lock.validity, lock.unit, nil
+}
+
+// SetObjectLockConfig sets the default retention of a bucket with object lock enabled.
+func (m *mockMinioClient) SetObjectLockConfig(ctx context.Context, bucketName string, mode *minio.RetentionMode, validity *uint, unit *minio.ValidityUnit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.objectLock[bucketName]
+	if !ok {
+		return minio.ErrorResponse{Code: "InvalidBucketState", BucketName: bucketName, Message: "Object Lock configuration cannot be enabled on existing buckets"}
+	}
+	lock.mode, lock.validity, lock.unit = mode, validity, unit
+	return nil
+}
+
+// PutObjectLegalHold applies or removes the legal hold of an object version (the latest one without version ID).
+func (m *mockMinioClient) PutObjectLegalHold(ctx context.Context, bucketName, objectName string, opts minio.PutObjectLegalHoldOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.objectLock[bucketName]; !ok {
+		return minio.ErrorResponse{Code: "InvalidRequest", BucketName: bucketName, Message: "Bucket is missing ObjectLockConfiguration"}
+	}
+	versionID, err := m.resolveVersionLocked(bucketName, objectName, opts.VersionID)
+	if err != nil {
+		return err
+	}
+	if m.legalHolds[bucketName] == nil {
+		m.legalHolds[bucketName] = make(map[string]bool)
+	}
+	m.legalHolds[bucketName][versionID] = opts.Status != nil && *opts.Status == minio.LegalHoldEnabled
+	return nil
+}
+
+// GetObjectLegalHold gets the legal hold status of an object version (the latest one without version ID).
+func (m *mockMinioClient) GetObjectLegalHold(ctx context.Context, bucketName, objectName string, opts minio.GetObjectLegalHoldOptions) (*minio.LegalHoldStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.objectLock[bucketName]; !ok {
+		return nil, minio.ErrorResponse{Code: "InvalidRequest", BucketName: bucketName, Message: "Bucket is missing ObjectLockConfiguration"}
+	}
+	versionID, err := m.resolveVersionLocked(bucketName, objectName, opts.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	status := minio.LegalHoldDisabled
+	if m.legalHolds[bucketName][versionID] {
+		status = minio.LegalHoldEnabled
+	}
+	return &status, nil
+}
+
+// resolveVersionLocked returns the version ID of an existing object version, the latest one if versionID is empty.
+// Must be called with mu held.
+func (m *mockMinioClient) resolveVersionLocked(bucketName, objectName, versionID string) (string, error) {
+	if versionID == "" {
+		versionID = m.latestVersions[bucketName][objectName]
+	}
+	if _, ok := m.objectVersions[bucketName][objectName][versionID]; !ok || m.deleteMarkers[bucketName][versionID] {
+		return "", minio.ErrorResponse{Code: "NoSuchKey", BucketName: bucketName, Key: objectName}
+	}
+	return versionID, nil
+}
+
 // Helper methods for test setup
 
+// setVersionTimeLocked records the last modified time of an object version. Must be called with mu held.
+func (m *mockMinioClient) setVersionTimeLocked(bucketName, versionID string, t time.Time) {
+	if m.versionTimes[bucketName] == nil {
+		m.versionTimes[bucketName] = make(map[string]time.Time)
+	}
+	m.versionTimes[bucketName][versionID] = t
+}
+
+// setVersionTime overrides the last modified time of an object version.
+func (m *mockMinioClient) setVersionTime(bucketName, versionID string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setVersionTimeLocked(bucketName, versionID, t)
+}
+
+// corruptVersion overwrites the content of an object version in place, without changing its metadata.
+func (m *mockMinioClient) corruptVersion(bucketName, objectName, versionID string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objectVersions[bucketName][objectName][versionID] = data
+}
+
 // setListBucketsError sets an error to return from ListBuckets.
 func (m *mockMinioClient) setListBucketsError(err error) {
 	m.mu.Lock()
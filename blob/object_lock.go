@@ -0,0 +1,180 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// ObjectLockModeGovernance protects object versions from deletion, except by users with the bypass governance permission.
+	ObjectLockModeGovernance = "GOVERNANCE"
+	// ObjectLockModeCompliance protects object versions from deletion by anyone, including the root account, until retention expires.
+	ObjectLockModeCompliance = "COMPLIANCE"
+)
+
+var (
+	// ErrInvalidObjectLock is returned when an object lock configuration is invalid.
+	ErrInvalidObjectLock = errors.New("invalid object lock configuration")
+	// ErrObjectLockNotEnabled is returned when a bucket needs object lock but does not have it.
+	// Object lock can only be enabled when a bucket is created.
+	ErrObjectLockNotEnabled = errors.New("object lock is not enabled")
+	// ErrObjectLocked is returned when blob storage refuses to change an object version protected by retention or a legal hold.
+	ErrObjectLocked = errors.New("object is locked")
+)
+
+// Validate checks an object lock configuration: a default retention needs object lock, a valid mode and a positive number of days.
+//
+// return:
+//   - error: ErrInvalidObjectLock if the configuration is invalid
+func (l ObjectLockConfig) Validate() error {
+	if l.Mode == "" && l.RetentionDays == 0 {
+		return nil
+	}
+	if !l.Enabled {
+		return fmt.Errorf("%w: a default retention requires object lock", ErrInvalidObjectLock)
+	}
+	if l.Mode != ObjectLockModeGovernance && l.Mode != ObjectLockModeCompliance {
+		return fmt.Errorf("%w: retention mode must be %s or %s, got %q", ErrInvalidObjectLock, ObjectLockModeGovernance, ObjectLockModeCompliance, l.Mode)
+	}
+	if l.RetentionDays < 1 {
+		return fmt.Errorf("%w: retention days must be at least 1, got %d", ErrInvalidObjectLock, l.RetentionDays)
+	}
+	return nil
+}
+
+// applyObjectLock makes sure a bucket has the requested object lock configuration.
+// Object lock must already be enabled on the bucket (it is set at creation), the default retention is set or replaced.
+func (c *Client) applyObjectLock(ctx context.Context, bucketName string, lock ObjectLockConfig) error {
+	current, err := c.getObjectLock(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	if !current.Enabled {
+		return fmt.Errorf("%w on existing bucket %s, it can only be enabled when the bucket is created", ErrObjectLockNotEnabled, bucketName)
+	}
+	if lock.Mode == "" || current == lock {
+		return nil
+	}
+
+	mode := minio.RetentionMode(lock.Mode)
+	validity := uint(lock.RetentionDays)
+	unit := minio.Days
+	if err := c.minioClient.SetObjectLockConfig(ctx, bucketName, &mode, &validity, &unit); err != nil {
+		return fmt.Errorf("failed to set default retention of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// getObjectLock returns the object lock configuration of a bucket, not enabled if the bucket has none.
+func (c *Client) getObjectLock(ctx context.Context, bucketName string) (ObjectLockConfig, error) {
+	status, mode, validity, unit, err := c.minioClient.GetObjectLockConfig(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "ObjectLockConfigurationNotFoundError" {
+			return ObjectLockConfig{}, nil
+		}
+		return ObjectLockConfig{}, fmt.Errorf("failed to get object lock of bucket %s: %w", bucketName, err)
+	}
+
+	lock := ObjectLockConfig{Enabled: status == "Enabled"}
+	if mode != nil && validity != nil {
+		lock.Mode = string(*mode)
+		lock.RetentionDays = int(*validity)
+		if unit != nil && *unit == minio.Years {
+			lock.RetentionDays *= 365
+		}
+	}
+	return lock, nil
+}
+
+// SetLegalHold applies or removes the legal hold of an object version. While the hold is on, the version cannot be deleted,
+// regardless of its retention. New versions can still be written to the key.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket of the object, it must have object lock enabled
+//   - fileName: The key of the object
+//   - versionID: The version to hold. If empty, the current version.
+//   - on: True to apply the hold, false to remove it
+//
+// return:
+//   - error: ErrBucketNotFound, ErrObjectLockNotEnabled, ErrObjectNotFound if the object version does not exist, or an error if the hold could not be set
+func (c *Client) SetLegalHold(ctx context.Context, bucketName, fileName, versionID string, on bool) error {
+	if err := c.requireObjectLock(ctx, bucketName); err != nil {
+		return err
+	}
+
+	status := minio.LegalHoldDisabled
+	if on {
+		status = minio.LegalHoldEnabled
+	}
+	err := c.minioClient.PutObjectLegalHold(ctx, bucketName, fileName, minio.PutObjectLegalHoldOptions{VersionID: versionID, Status: &status})
+	if err != nil {
+		return objectVersionError(bucketName, fileName, versionID, "set legal hold of", err)
+	}
+	return nil
+}
+
+// GetLegalHold returns whether an object version is under legal hold.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket of the object, it must have object lock enabled
+//   - fileName: The key of the object
+//   - versionID: The version to check. If empty, the current version.
+//
+// return:
+//   - bool: True if the legal hold is on
+//   - error: ErrBucketNotFound, ErrObjectLockNotEnabled, ErrObjectNotFound if the object version does not exist, or an error if the hold could not be read
+func (c *Client) GetLegalHold(ctx context.Context, bucketName, fileName, versionID string) (bool, error) {
+	if err := c.requireObjectLock(ctx, bucketName); err != nil {
+		return false, err
+	}
+
+	status, err := c.minioClient.GetObjectLegalHold(ctx, bucketName, fileName, minio.GetObjectLegalHoldOptions{VersionID: versionID})
+	if err != nil {
+		return false, objectVersionError(bucketName, fileName, versionID, "get legal hold of", err)
+	}
+	return status != nil && *status == minio.LegalHoldEnabled, nil
+}
+
+// requireObjectLock returns ErrBucketNotFound if the bucket does not exist, and ErrObjectLockNotEnabled if it has no object lock.
+func (c *Client) requireObjectLock(ctx context.Context, bucketName string) error {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return err
+	}
+	lock, err := c.getObjectLock(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	if !lock.Enabled {
+		return fmt.Errorf("%w on bucket %s", ErrObjectLockNotEnabled, bucketName)
+	}
+	return nil
+}
+
+// objectVersionError wraps the error of an operation on an object version, as ErrBucketNotFound if the bucket does not exist
+// and ErrObjectNotFound if the version does not exist.
+func objectVersionError(bucketName, fileName, versionID, operation string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchBucket":
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+	case "NoSuchKey", "NoSuchVersion":
+		return fmt.Errorf("%w: %s (version %q)", ErrObjectNotFound, fileName, versionID)
+	}
+	return fmt.Errorf("failed to %s %s: %w", operation, fileName, err)
+}
+
+// isObjectLockedError reports whether blob storage refused an operation because the object version is locked
+// (S3 answers AccessDenied, MinIO InvalidRequest, both mentioning the lock).
+func isObjectLockedError(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "ObjectLocked" {
+		return true
+	}
+	message := strings.ToLower(resp.Message)
+	return strings.Contains(message, "worm protected") || strings.Contains(message, "object lock")
+}
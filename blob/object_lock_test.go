@@ -0,0 +1,234 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestClient_CreateBucket_ObjectLock(t *testing.T) {
+	client := NewClientWithInterface(newMockMinioClient(), getTestConfig())
+	ctx := context.Background()
+	lock := ObjectLockConfig{Enabled: true, Mode: ObjectLockModeCompliance, RetentionDays: 2555}
+	if err := client.CreateBucket(ctx, "audit", lock); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	description, err := client.DescribeBucket(ctx, "audit")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.ObjectLock != lock {
+		t.Errorf("Expected object lock %+v, got %+v", lock, description.ObjectLock)
+	}
+	if description.Versioning != VersioningEnabled {
+		t.Errorf("Expected versioning %s, got %s", VersioningEnabled, description.Versioning)
+	}
+
+	// The default retention of a locked bucket can be changed afterwards
+	lock.Mode = ObjectLockModeGovernance
+	lock.RetentionDays = 30
+	if err := client.CreateBucket(ctx, "audit", lock); err != nil {
+		t.Fatalf("CreateBucket() failed on existing bucket: %v", err)
+	}
+	description, err = client.DescribeBucket(ctx, "audit")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.ObjectLock != lock {
+		t.Errorf("Expected object lock %+v, got %+v", lock, description.ObjectLock)
+	}
+}
+
+func TestClient_CreateBucket_WithoutObjectLock(t *testing.T) {
+	client := NewClientWithInterface(newMockMinioClient(), getTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	description, err := client.DescribeBucket(ctx, "orders")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if description.ObjectLock.Enabled {
+		t.Error("Expected object lock to be disabled")
+	}
+
+	// Object lock cannot be enabled on an existing bucket
+	err = client.CreateBucket(ctx, "orders", ObjectLockConfig{Enabled: true})
+	if !errors.Is(err, ErrObjectLockNotEnabled) {
+		t.Errorf("Expected ErrObjectLockNotEnabled, got %v", err)
+	}
+}
+
+func TestObjectLockConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		lock    ObjectLockConfig
+		wantErr bool
+	}{
+		{"no lock", ObjectLockConfig{}, false},
+		{"lock without retention", ObjectLockConfig{Enabled: true}, false},
+		{"governance", ObjectLockConfig{Enabled: true, Mode: ObjectLockModeGovernance, RetentionDays: 1}, false},
+		{"retention without lock", ObjectLockConfig{Mode: ObjectLockModeGovernance, RetentionDays: 1}, true},
+		{"unknown mode", ObjectLockConfig{Enabled: true, Mode: "LEGAL", RetentionDays: 1}, true},
+		{"mode without days", ObjectLockConfig{Enabled: true, Mode: ObjectLockModeCompliance}, true},
+		{"days without mode", ObjectLockConfig{Enabled: true, RetentionDays: 7}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.lock.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidObjectLock) {
+				t.Errorf("Expected ErrInvalidObjectLock, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_SetLegalHold(t *testing.T) {
+	client := NewClientWithInterface(newMockMinioClient(), getTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	first, err := client.WriteFile(ctx, "audit", "trail", []byte("v1"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := client.WriteFile(ctx, "audit", "trail", []byte("v2")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	if err := client.SetLegalHold(ctx, "audit", "trail", first, true); err != nil {
+		t.Fatalf("SetLegalHold() failed: %v", err)
+	}
+	if held, err := client.GetLegalHold(ctx, "audit", "trail", first); err != nil || !held {
+		t.Errorf("Expected the first version to be held, got %v (%v)", held, err)
+	}
+	if held, err := client.GetLegalHold(ctx, "audit", "trail", ""); err != nil || held {
+		t.Errorf("Expected the current version not to be held, got %v (%v)", held, err)
+	}
+
+	if err := client.SetLegalHold(ctx, "audit", "trail", first, false); err != nil {
+		t.Fatalf("SetLegalHold() failed: %v", err)
+	}
+	if held, err := client.GetLegalHold(ctx, "audit", "trail", first); err != nil || held {
+		t.Errorf("Expected the hold to be removed, got %v (%v)", held, err)
+	}
+}
+
+func TestClient_SetLegalHold_Errors(t *testing.T) {
+	client := NewClientWithInterface(newMockMinioClient(), getTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	if err := client.CreateBucket(ctx, "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	if err := client.SetLegalHold(ctx, "missing", "trail", "", true); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if err := client.SetLegalHold(ctx, "orders", "trail", "", true); !errors.Is(err, ErrObjectLockNotEnabled) {
+		t.Errorf("Expected ErrObjectLockNotEnabled, got %v", err)
+	}
+	if err := client.SetLegalHold(ctx, "audit", "missing", "", true); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+}
+
+func TestClient_WriteFile_ObjectLocked(t *testing.T) {
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	mockClient.setPutObjectError("audit", "trail", minio.ErrorResponse{Code: "AccessDenied", Message: "Object is WORM protected and cannot be overwritten"})
+	if _, err := client.WriteFile(ctx, "audit", "trail", []byte("data")); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked, got %v", err)
+	}
+
+	mockClient.setPutObjectError("audit", "other", errors.New("connection reset"))
+	if _, err := client.WriteFile(ctx, "audit", "other", []byte("data")); errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected a non lock error not to be ErrObjectLocked, got %v", err)
+	}
+}
+
+func TestClient_DeleteObject_LegalHold(t *testing.T) {
+	client := NewClientWithInterface(newMockMinioClient(), getTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	versionID, err := client.WriteFile(ctx, "audit", "trail", []byte("v1"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := client.SetLegalHold(ctx, "audit", "trail", versionID, true); err != nil {
+		t.Fatalf("SetLegalHold() failed: %v", err)
+	}
+
+	if err := client.DeleteObject(ctx, "audit", "trail", versionID); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked deleting a held version, got %v", err)
+	}
+	// Deleting the key only adds a delete marker, the held version stays readable
+	if err := client.DeleteObject(ctx, "audit", "trail", ""); err != nil {
+		t.Errorf("Expected deleting the key to succeed, got %v", err)
+	}
+	if data, err := client.ReadFile(ctx, "audit", "trail", versionID); err != nil || string(data) != "v1" {
+		t.Errorf("Expected the held version to be kept, got %q (%v)", data, err)
+	}
+
+	if err := client.SetLegalHold(ctx, "audit", "trail", versionID, false); err != nil {
+		t.Fatalf("SetLegalHold() failed: %v", err)
+	}
+	if err := client.DeleteObject(ctx, "audit", "trail", versionID); err != nil {
+		t.Errorf("Expected the version to be deleted once released, got %v", err)
+	}
+}
+
+func TestClient_DeleteObject_LegalHold_MinIO(t *testing.T) {
+	// This test requires a running MinIO instance, it checks that MinIO's refusal is recognized as ErrObjectLocked
+	cfg := getTestConfig()
+	cfg.Blob.Endpoint = "localhost:9000"
+	cfg.Blob.AccessKeyID = "minioadmin"
+	cfg.Blob.SecretAccessKey = "minioadmin"
+	ctx := context.Background()
+	client, err := NewClient(ctx, cfg)
+	if err != nil {
+		t.Skipf("MinIO not available, skipping test: %v", err)
+	}
+
+	bucketName := fmt.Sprintf("audit-%d", time.Now().UnixNano())
+	if err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	versionID, err := client.WriteFile(ctx, bucketName, "trail", []byte("v1"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := client.SetLegalHold(ctx, bucketName, "trail", versionID, true); err != nil {
+		t.Fatalf("SetLegalHold() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		client.SetLegalHold(ctx, bucketName, "trail", versionID, false)
+		client.DeleteObject(ctx, bucketName, "trail", versionID)
+		client.DeleteBucket(ctx, bucketName)
+	})
+
+	if err := client.DeleteObject(ctx, bucketName, "trail", versionID); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("Expected ErrObjectLocked deleting a held version, got %v", err)
+	}
+}
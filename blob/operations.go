@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,6 +14,13 @@ import (
 	"github.com/minio/minio-go/v7/pkg/lifecycle"
 )
 
+const (
+	// cleanDeleteMarkersRuleID is the ID of the lifecycle rule removing delete markers (and the versions they hide).
+	cleanDeleteMarkersRuleID = "CleanDeleteMarkers"
+	// cleanOldVersionsRuleID is the ID of the lifecycle rule removing non-current versions.
+	cleanOldVersionsRuleID = "CleanOldVersions"
+)
+
 var (
 	// bucketNameRegex validates bucket names according to S3/MinIO naming rules:
 	// - 3-63 characters
@@ -26,16 +34,16 @@ var (
 // only used during create bucket operation
 func validateBucketName(bucketName string) error {
 	if len(bucketName) < 3 || len(bucketName) > 63 {
-		return fmt.Errorf("bucket name must be between 3 and 63 characters, got length %d", len(bucketName))
+		return fmt.Errorf("%w: bucket name must be between 3 and 63 characters, got length %d", ErrInvalidBucketName, len(bucketName))
 	}
 
 	if !bucketNameRegex.MatchString(bucketName) {
-		return fmt.Errorf("bucket name contains invalid characters or format: %s", bucketName)
+		return fmt.Errorf("%w: bucket name contains invalid characters or format: %s", ErrInvalidBucketName, bucketName)
 	}
 
 	// Check for consecutive dots
 	if strings.Contains(bucketName, "..") {
-		return fmt.Errorf("bucket name cannot contain consecutive dots: %s", bucketName)
+		return fmt.Errorf("%w: bucket name cannot contain consecutive dots: %s", ErrInvalidBucketName, bucketName)
 	}
 
 	return nil
@@ -53,7 +61,7 @@ func validateBucketName(bucketName string) error {
 //
 // return:
 //   - []byte: The file contents
-//   - error: An error if the file could not be read
+//   - error: ErrBucketNotFound, ErrObjectNotFound if the file version does not exist, or an error if the file could not be read
 func (c *Client) ReadFile(ctx context.Context, bucketName, fileName, versionID string) ([]byte, error) {
 	if bucketName == "" {
 		return nil, fmt.Errorf("bucket name cannot be empty")
@@ -69,19 +77,22 @@ func (c *Client) ReadFile(ctx context.Context, bucketName, fileName, versionID s
 
 	object, err := c.minioClient.GetObject(ctx, bucketName, fileName, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get object %s: %w", fileName, err)
+		return nil, objectVersionError(bucketName, fileName, versionID, "get object", err)
 	}
 	defer object.Close()
 
 	data, err := io.ReadAll(object)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object %s: %w", fileName, err)
+		return nil, objectVersionError(bucketName, fileName, versionID, "read object", err)
 	}
 
 	return data, nil
 }
 
 // WriteFile writes a byte array to a file in MinIO.
+// The SHA-256 of the data is stored in the object metadata, so the scrubber can verify it later.
+// Buckets are versioned, so a write adds a version and is not refused by the object lock of the current one:
+// ErrObjectLocked is only returned if blob storage refuses the write anyway, e.g. because of a bucket policy of S3.
 //
 // params:
 //   - ctx: Context for the operation
@@ -91,8 +102,26 @@ func (c *Client) ReadFile(ctx context.Context, bucketName, fileName, versionID s
 //
 // return:
 //   - string: The version ID of the written file
-//   - error: An error if the file could not be written
+//   - error: ErrBucketNotFound, ErrObjectLocked if blob storage refused the write because of an object lock, or an error if the file could not be written
 func (c *Client) WriteFile(ctx context.Context, bucketName, fileName string, data []byte) (string, error) {
+	return c.WriteFileWithOptions(ctx, bucketName, fileName, data, WriteOptions{})
+}
+
+// WriteFileWithOptions writes a byte array to a file in MinIO like WriteFile, with the user metadata of the options.
+// IfNotExists is an If-None-Match: * condition checked by blob storage. IfVersion is not supported, S3 conditions only match ETags.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket to write to
+//   - fileName: The name of the file to write
+//   - data: The data to write
+//   - opts: The write options
+//
+// return:
+//   - string: The version ID of the written file
+//   - error: ErrPreconditionFailed if IfNotExists is set and the file exists, ErrUnsupportedCondition if IfVersion is set, ErrBucketNotFound,
+//     ErrObjectLocked if blob storage refused the write because of an object lock, or an error if the file could not be written
+func (c *Client) WriteFileWithOptions(ctx context.Context, bucketName, fileName string, data []byte, opts WriteOptions) (string, error) {
 	if bucketName == "" {
 		return "", fmt.Errorf("bucket name cannot be empty")
 	}
@@ -102,11 +131,28 @@ func (c *Client) WriteFile(ctx context.Context, bucketName, fileName string, dat
 	if data == nil {
 		return "", fmt.Errorf("data cannot be nil")
 	}
+	if opts.IfVersion != "" {
+		return "", fmt.Errorf("%w: IfVersion on S3 storage", ErrUnsupportedCondition)
+	}
 
-	uploadInfo, err := c.minioClient.PutObject(ctx, bucketName, fileName, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
-	})
+	putOpts := minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: withChecksum(opts.UserMetadata, ChecksumMetadataKey, data),
+	}
+	if opts.IfNotExists {
+		putOpts.SetMatchETagExcept("*")
+	}
+	uploadInfo, err := c.minioClient.PutObject(ctx, bucketName, fileName, bytes.NewReader(data), int64(len(data)), putOpts)
 	if err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return "", fmt.Errorf("%w: %s exists in bucket %s", ErrPreconditionFailed, fileName, bucketName)
+		}
+		if isObjectLockedError(err) {
+			return "", fmt.Errorf("%w: %s in bucket %s: %v", ErrObjectLocked, fileName, bucketName, err)
+		}
+		if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+			return "", fmt.Errorf("%w: %s", ErrBucketNotFound, bucketName)
+		}
 		return "", fmt.Errorf("failed to put object %s: %w", fileName, err)
 	}
 
@@ -114,20 +160,27 @@ func (c *Client) WriteFile(ctx context.Context, bucketName, fileName string, dat
 }
 
 // CreateBucket creates a new bucket in MinIO with versioning enabled.
+// If the bucket already exists, versioning is enabled and missing or drifted lifecycle rules are re-applied.
+// Object lock can only be enabled when the bucket is created, its default retention can be set on an existing locked bucket.
 //
 // params:
 //   - ctx: Context for the operation
 //   - bucketName: The name of the bucket to create
+//   - lock: The object lock configuration of the bucket. The zero value creates a bucket without object lock.
 //
 // return:
-//   - error: An error if the bucket could not be created or versioning could not be enabled
-func (c *Client) CreateBucket(ctx context.Context, bucketName string) error {
+//   - error: ErrInvalidObjectLock, ErrObjectLockNotEnabled if object lock is requested on an existing bucket without it,
+//     or an error if the bucket could not be created or versioning could not be enabled
+func (c *Client) CreateBucket(ctx context.Context, bucketName string, lock ObjectLockConfig) error {
 	if bucketName == "" {
-		return fmt.Errorf("bucket name cannot be empty")
+		return fmt.Errorf("%w: bucket name cannot be empty", ErrInvalidBucketName)
 	}
 	if err := validateBucketName(bucketName); err != nil {
 		return err
 	}
+	if err := lock.Validate(); err != nil {
+		return err
+	}
 
 	// Check if bucket already exists
 	exists, err := c.minioClient.BucketExists(ctx, bucketName)
@@ -137,12 +190,18 @@ func (c *Client) CreateBucket(ctx context.Context, bucketName string) error {
 
 	if !exists {
 		// Create the bucket
-		err = c.minioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
+		err = c.minioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{ObjectLocking: lock.Enabled})
 		if err != nil {
 			return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
 		}
 	}
 
+	if lock.Enabled {
+		if err := c.applyObjectLock(ctx, bucketName, lock); err != nil {
+			return err
+		}
+	}
+
 	// Enable versioning on the bucket
 	err = c.minioClient.EnableVersioning(ctx, bucketName)
 	if err != nil {
@@ -157,7 +216,7 @@ func (c *Client) CreateBucket(ctx context.Context, bucketName string) error {
 	// Use context with timeout for lifecycle operations to prevent hanging
 	lifecycleCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
-	err = c.applyLifecycleRules(lifecycleCtx, bucketName)
+	_, err = c.applyLifecycleRules(lifecycleCtx, bucketName)
 	if err != nil {
 		return fmt.Errorf("failed to apply lifecycle rules to bucket %s: %w", bucketName, err)
 	}
@@ -166,33 +225,112 @@ func (c *Client) CreateBucket(ctx context.Context, bucketName string) error {
 }
 
 // applyLifecycleRules applies lifecycle management rules to a bucket.
-// It configures deletion of delete markers and non-current versions based on config settings.
-func (c *Client) applyLifecycleRules(ctx context.Context, bucketName string) error {
+// It configures deletion of delete markers and non-current versions based on config settings, and the retention policies of the bucket.
+// Rules are only written if they are missing or have drifted, and rules with other IDs are kept.
+// Retention rules of policies removed from the config are deleted.
+//
+// return:
+//   - []string: The IDs of the rules that were missing or had drifted
+//   - error: An error if the lifecycle could not be read or written
+func (c *Client) applyLifecycleRules(ctx context.Context, bucketName string) ([]string, error) {
+	current, err := c.getBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := c.expectedLifecycleRules(bucketName)
+	drifted := driftedLifecycleRules(current, expected)
+	if len(drifted) == 0 {
+		return nil, nil
+	}
+
+	if err := c.minioClient.SetBucketLifecycle(ctx, bucketName, mergeLifecycleRules(current, expected)); err != nil {
+		return nil, err
+	}
+	return drifted, nil
+}
+
+// expectedLifecycleRules builds the lifecycle rules Nimbus expects on a bucket:
+// the version cleanup rules applied to every bucket, and one expiration rule per retention policy of the bucket.
+func (c *Client) expectedLifecycleRules(bucketName string) []lifecycle.Rule {
 	// Get days from config (already in days, no conversion needed)
 	deleteMarkerDays := c.config.Blob.DeleteMarkerCleanupDelayDays
 	nonCurrentVersionDays := c.config.Blob.NonCurrentVersionCleanupDelayDays
 
-	// Build lifecycle configuration
-	lifecycleConfig := &lifecycle.Configuration{
-		Rules: []lifecycle.Rule{
-			{
-				ID:     "CleanDeleteMarkers",
-				Status: "Enabled",
-				DelMarkerExpiration: lifecycle.DelMarkerExpiration{
-					Days: deleteMarkerDays,
-				},
+	rules := []lifecycle.Rule{
+		{
+			ID:     cleanDeleteMarkersRuleID,
+			Status: "Enabled",
+			DelMarkerExpiration: lifecycle.DelMarkerExpiration{
+				Days: deleteMarkerDays,
 			},
-			{
-				ID:     "CleanOldVersions",
-				Status: "Enabled",
-				NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
-					NoncurrentDays: lifecycle.ExpirationDays(nonCurrentVersionDays),
-				},
+		},
+		{
+			ID:     cleanOldVersionsRuleID,
+			Status: "Enabled",
+			NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+				NoncurrentDays: lifecycle.ExpirationDays(nonCurrentVersionDays),
 			},
 		},
 	}
+	for _, policy := range retentionPolicies(c.config, bucketName) {
+		rules = append(rules, lifecycle.Rule{
+			ID:         retentionRuleID(policy.Prefix),
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: policy.Prefix},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(policy.Days)},
+		})
+	}
+	return rules
+}
+
+// driftedLifecycleRules returns the IDs of the expected rules that are missing from current or differ from it,
+// and of the retention rules of current that are not expected anymore.
+// Rules are compared on the fields Nimbus manages (see LifecycleRule).
+func driftedLifecycleRules(current *lifecycle.Configuration, expected []lifecycle.Rule) []string {
+	return driftedRules(toLifecycleRules(current), toLifecycleRules(&lifecycle.Configuration{Rules: expected}))
+}
+
+// driftedRules compares provider-neutral lifecycle rules by ID, see driftedLifecycleRules.
+func driftedRules(current, expected []LifecycleRule) []string {
+	actual := make(map[string]LifecycleRule)
+	for _, r := range current {
+		actual[r.ID] = r
+	}
+
+	var drifted []string
+	for _, r := range expected {
+		if got, ok := actual[r.ID]; !ok || got != r {
+			drifted = append(drifted, r.ID)
+		}
+		delete(actual, r.ID)
+	}
+	var stale []string
+	for id := range actual {
+		if isRetentionRuleID(id) {
+			stale = append(stale, id)
+		}
+	}
+	sort.Strings(stale)
+	return append(drifted, stale...)
+}
+
+// mergeLifecycleRules returns a copy of current with the expected rules added or replaced by ID.
+// Retention rules that are not expected anymore are removed, rules with other IDs (e.g. added by an operator) are kept as they are.
+func mergeLifecycleRules(current *lifecycle.Configuration, expected []lifecycle.Rule) *lifecycle.Configuration {
+	expectedByID := make(map[string]lifecycle.Rule, len(expected))
+	for _, r := range expected {
+		expectedByID[r.ID] = r
+	}
 
-	return c.minioClient.SetBucketLifecycle(ctx, bucketName, lifecycleConfig)
+	merged := lifecycle.NewConfiguration()
+	for _, r := range current.Rules {
+		if _, ok := expectedByID[r.ID]; !ok && !isRetentionRuleID(r.ID) {
+			merged.Rules = append(merged.Rules, r)
+		}
+	}
+	merged.Rules = append(merged.Rules, expected...)
+	return merged
 }
 
 // FileExists checks if a file exists in the specified bucket.
@@ -218,3 +356,53 @@ func (c *Client) FileExists(ctx context.Context, bucketName, fileName string) (b
 
 	return true, nil
 }
+
+// StatObject describes an object version without reading its content.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - fileName: The name of the file
+//   - versionID: Optional version ID. If empty, describes the current version.
+//
+// return:
+//   - *ObjectInfo: The object version
+//   - error: ErrBucketNotFound, ErrObjectNotFound if the object version does not exist, or an error if it could not be described
+func (c *Client) StatObject(ctx context.Context, bucketName, fileName, versionID string) (*ObjectInfo, error) {
+	info, err := c.minioClient.StatObject(ctx, bucketName, fileName, minio.StatObjectOptions{VersionID: versionID})
+	if err != nil {
+		return nil, objectVersionError(bucketName, fileName, versionID, "stat object", err)
+	}
+	stored, _ := storedChecksum(info.UserMetadata, ChecksumMetadataKey)
+	return &ObjectInfo{
+		Key:          info.Key,
+		VersionID:    info.VersionID,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		UserMetadata: userMetadata(info.UserMetadata, ChecksumMetadataKey),
+		Checksum:     stored,
+	}, nil
+}
+
+// DeleteObject deletes an object. Without version ID, a delete marker becomes the current version and
+// the previous versions are kept, so the object can be restored. With a version ID, that version is deleted permanently.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The name of the bucket
+//   - fileName: The name of the file to delete
+//   - versionID: Optional version ID of the version to delete permanently
+//
+// return:
+//   - error: ErrBucketNotFound, ErrObjectNotFound if the version does not exist, ErrObjectLocked if the version is protected
+//     by an object lock, or an error if the object could not be deleted
+func (c *Client) DeleteObject(ctx context.Context, bucketName, fileName, versionID string) error {
+	if err := c.minioClient.RemoveObject(ctx, bucketName, fileName, minio.RemoveObjectOptions{VersionID: versionID}); err != nil {
+		if isObjectLockedError(err) {
+			return fmt.Errorf("%w: %s in bucket %s: %v", ErrObjectLocked, fileName, bucketName, err)
+		}
+		return objectVersionError(bucketName, fileName, versionID, "delete object", err)
+	}
+	return nil
+}
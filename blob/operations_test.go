@@ -201,7 +201,7 @@ func TestClient_CreateBucket_Success(t *testing.T) {
 	ctx := context.Background()
 	bucketName := "test-create-bucket"
 
-	err := client.CreateBucket(ctx, bucketName)
+	err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{})
 	if err != nil {
 		t.Fatalf("CreateBucket() failed: %v", err)
 	}
@@ -229,7 +229,7 @@ func TestClient_CreateBucket_EmptyBucketName(t *testing.T) {
 	client, _ := setupMockClient(t)
 
 	ctx := context.Background()
-	err := client.CreateBucket(ctx, "")
+	err := client.CreateBucket(ctx, "", ObjectLockConfig{})
 	if err == nil {
 		t.Error("CreateBucket() should have failed with empty bucket name")
 	}
@@ -242,13 +242,13 @@ func TestClient_CreateBucket_AlreadyExists(t *testing.T) {
 	bucketName := "test-existing-bucket"
 
 	// Create bucket first time
-	err := client.CreateBucket(ctx, bucketName)
+	err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{})
 	if err != nil {
 		t.Fatalf("CreateBucket() failed on first call: %v", err)
 	}
 
 	// Create bucket second time (should not error)
-	err = client.CreateBucket(ctx, bucketName)
+	err = client.CreateBucket(ctx, bucketName, ObjectLockConfig{})
 	if err != nil {
 		t.Fatalf("CreateBucket() should succeed when bucket already exists, got: %v", err)
 	}
@@ -269,7 +269,7 @@ func TestClient_CreateBucket_WithVersioning(t *testing.T) {
 	ctx := context.Background()
 	bucketName := "test-versioning-bucket"
 
-	err := client.CreateBucket(ctx, bucketName)
+	err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{})
 	if err != nil {
 		t.Fatalf("CreateBucket() failed: %v", err)
 	}
@@ -370,7 +370,7 @@ func TestClient_CreateBucket_NilConfig(t *testing.T) {
 	bucketName := "test-bucket-no-config"
 
 	// CreateBucket should fail when config is nil
-	err := client.CreateBucket(ctx, bucketName)
+	err := client.CreateBucket(ctx, bucketName, ObjectLockConfig{})
 	if err == nil {
 		t.Error("CreateBucket() should have failed with nil config")
 		return
@@ -0,0 +1,179 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// PointInTimeActionRestore makes the version that was current at the restore time current again, by writing it as a new version.
+	PointInTimeActionRestore = "restore"
+	// PointInTimeActionDelete deletes a key that did not exist at the restore time, by adding a delete marker.
+	PointInTimeActionDelete = "delete"
+)
+
+var (
+	// ErrVersioningDisabled is returned when an operation needs object versions on a bucket without versioning.
+	ErrVersioningDisabled = errors.New("versioning is not enabled")
+)
+
+// RestoreToPointInTime brings every key of a versioned bucket (or of a key prefix) back to its state at a point in time:
+// the version that was current then is made current again, and keys created since (or deleted then) are deleted.
+// Nothing is overwritten or removed: restored versions are written as new versions, and deletes add delete markers,
+// so a restore can itself be undone. Versions already expired by the lifecycle rules cannot be restored:
+// when the restore time is older than the noncurrent version expiration of the bucket, keys without any version from that time
+// are reported as unrestorable and left alone, as they may have existed then.
+//
+// params:
+//   - ctx: Context for the operation
+//   - storage: The blob storage of the bucket
+//   - bucketName: The bucket to restore
+//   - prefix: Only restore keys starting with it. Empty restores the whole bucket.
+//   - at: The point in time to restore to
+//   - dryRun: If true, only report the changes a restore would make
+//
+// return:
+//   - *PointInTimeRestoreReport: The changes made (or planned, on dry run), also returned (partially applied) on error
+//   - error: ErrBucketNotFound, ErrVersioningDisabled, or an error if versions could not be listed or a change could not be applied
+func RestoreToPointInTime(ctx context.Context, storage Storage, bucketName, prefix string, at time.Time, dryRun bool) (*PointInTimeRestoreReport, error) {
+	description, err := storage.DescribeBucket(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if description.Versioning == VersioningDisabled {
+		return nil, fmt.Errorf("%w on bucket %s", ErrVersioningDisabled, bucketName)
+	}
+
+	report, err := planPointInTimeRestore(ctx, storage, bucketName, prefix, at, nonCurrentExpiration(description.LifecycleRules))
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+
+	for _, change := range report.Changes {
+		switch change.Action {
+		case PointInTimeActionRestore:
+			if err := restoreVersion(ctx, storage, bucketName, change.Key, change.RestoredVersionID); err != nil {
+				return report, err
+			}
+		case PointInTimeActionDelete:
+			if err := storage.DeleteObject(ctx, bucketName, change.Key, ""); err != nil {
+				return report, err
+			}
+		}
+		report.Applied++
+	}
+	return report, nil
+}
+
+// restoreVersion makes a previous version of an object current again, by writing its content and user metadata as a new version.
+func restoreVersion(ctx context.Context, storage Storage, bucketName, fileName, versionID string) error {
+	info, err := storage.StatObject(ctx, bucketName, fileName, versionID)
+	if err != nil {
+		return err
+	}
+	data, err := storage.ReadFile(ctx, bucketName, fileName, versionID)
+	if err != nil {
+		return err
+	}
+	_, err = storage.WriteFileWithOptions(ctx, bucketName, fileName, data, WriteOptions{UserMetadata: info.UserMetadata})
+	return err
+}
+
+// nonCurrentExpiration returns how long versions are kept once replaced, according to the lifecycle rules of a bucket.
+// 0 if no rule expires them.
+func nonCurrentExpiration(rules []LifecycleRule) time.Duration {
+	days := 0
+	for _, rule := range rules {
+		if rule.Status == "Enabled" && rule.Prefix == "" && rule.NoncurrentVersionExpirationDays > 0 && (days == 0 || rule.NoncurrentVersionExpirationDays < days) {
+			days = rule.NoncurrentVersionExpirationDays
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// planPointInTimeRestore lists the versions of the keys of a bucket, and returns the change each key
+// needs to be back to its state at a point in time.
+// If the versions replaced before that time may have expired (the time is older than nonCurrentExpiration),
+// a key without any version from that time is unrestorable: it may have existed then, so it is not deleted.
+//
+// params:
+//   - ctx: Context for the operation
+//   - storage: The blob storage of the bucket
+//   - bucketName: The bucket to restore
+//   - prefix: Only restore keys starting with it
+//   - at: The point in time to restore to
+//   - nonCurrentExpiration: How long versions are kept once replaced, 0 if they never expire
+//
+// return:
+//   - *PointInTimeRestoreReport: The changes sorted by key, and the unchanged and unrestorable keys, nothing applied
+//   - error: An error if the versions could not be listed
+func planPointInTimeRestore(ctx context.Context, storage Storage, bucketName, prefix string, at time.Time, nonCurrentExpiration time.Duration) (*PointInTimeRestoreReport, error) {
+	listed, err := storage.ListObjectVersions(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+	expired := nonCurrentExpiration > 0 && at.Before(time.Now().Add(-nonCurrentExpiration))
+	versions := make(map[string][]ObjectVersion)
+	for _, v := range listed {
+		versions[v.Key] = append(versions[v.Key], v)
+	}
+
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	report := &PointInTimeRestoreReport{
+		Bucket:       bucketName,
+		Prefix:       prefix,
+		At:           at.UTC(),
+		Changes:      []PointInTimeChange{},
+		Unrestorable: []string{},
+	}
+	for _, key := range keys {
+		keyVersions := versions[key]
+		// Newest first, the listing order is kept for versions modified at the same time
+		sort.SliceStable(keyVersions, func(i, j int) bool { return keyVersions[i].LastModified.After(keyVersions[j].LastModified) })
+
+		var current, then *ObjectVersion
+		for i := range keyVersions {
+			if keyVersions[i].IsLatest {
+				current = &keyVersions[i]
+			}
+			if then == nil && !keyVersions[i].LastModified.After(at) {
+				then = &keyVersions[i]
+			}
+		}
+		existsNow := current != nil && !current.IsDeleteMarker
+		existedThen := then != nil && !then.IsDeleteMarker
+
+		change := PointInTimeChange{Key: key}
+		if existsNow {
+			change.CurrentVersionID = current.VersionID
+		}
+		switch {
+		case existedThen && (!existsNow || current.VersionID != then.VersionID):
+			change.Action = PointInTimeActionRestore
+			change.RestoredVersionID = then.VersionID
+			change.RestoredLastModified = then.LastModified
+		case then == nil && existsNow && expired:
+			report.Unrestorable = append(report.Unrestorable, key)
+			continue
+		case !existedThen && existsNow:
+			change.Action = PointInTimeActionDelete
+		default:
+			report.Unchanged++
+			continue
+		}
+		report.Changes = append(report.Changes, change)
+	}
+	return report, nil
+}
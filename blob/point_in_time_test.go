@@ -0,0 +1,174 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// writeVersionAt writes an object and sets the last modified time of the created version.
+func writeVersionAt(t *testing.T, client *Client, mockClient *mockMinioClient, bucketName, fileName, data string, at time.Time) string {
+	t.Helper()
+	versionID, err := client.WriteFile(context.Background(), bucketName, fileName, []byte(data))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	mockClient.setVersionTime(bucketName, versionID, at)
+	return versionID
+}
+
+func setupPointInTimeClient(t *testing.T) (*Client, *mockMinioClient, time.Time) {
+	t.Helper()
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getTestConfig())
+	if err := client.CreateBucket(context.Background(), "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	// Within the noncurrent version cleanup delay, nothing has expired
+	base := time.Now().Add(-4 * time.Hour).Truncate(time.Second)
+	writeVersionAt(t, client, mockClient, "orders", "a", "a1", base)
+	writeVersionAt(t, client, mockClient, "orders", "a", "a2-corrupt", base.Add(2*time.Hour))
+	writeVersionAt(t, client, mockClient, "orders", "b", "b1", base)
+	writeVersionAt(t, client, mockClient, "orders", "c", "c1-new", base.Add(2*time.Hour))
+	writeVersionAt(t, client, mockClient, "orders", "d", "d1", base)
+	if err := mockClient.RemoveObject(context.Background(), "orders", "d", minio.RemoveObjectOptions{}); err != nil {
+		t.Fatalf("RemoveObject() failed: %v", err)
+	}
+	return client, mockClient, base.Add(time.Hour)
+}
+
+func TestRestoreToPointInTime_DryRun(t *testing.T) {
+	client, _, at := setupPointInTimeClient(t)
+
+	report, err := RestoreToPointInTime(context.Background(), client, "orders", "", at, true)
+	if err != nil {
+		t.Fatalf("RestoreToPointInTime() failed: %v", err)
+	}
+	expected := map[string]string{"a": PointInTimeActionRestore, "c": PointInTimeActionDelete, "d": PointInTimeActionRestore}
+	if len(report.Changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %+v", len(expected), report.Changes)
+	}
+	for _, change := range report.Changes {
+		if expected[change.Key] != change.Action {
+			t.Errorf("Expected action %q for key %s, got %q", expected[change.Key], change.Key, change.Action)
+		}
+	}
+	if report.Unchanged != 1 || report.Applied != 0 {
+		t.Errorf("Expected 1 unchanged key and nothing applied, got %d and %d", report.Unchanged, report.Applied)
+	}
+
+	data, err := client.ReadFile(context.Background(), "orders", "a", "")
+	if err != nil || string(data) != "a2-corrupt" {
+		t.Errorf("Expected a dry run to change nothing, got %q (%v)", data, err)
+	}
+}
+
+func TestRestoreToPointInTime_Apply(t *testing.T) {
+	client, _, at := setupPointInTimeClient(t)
+	ctx := context.Background()
+
+	report, err := RestoreToPointInTime(ctx, client, "orders", "", at, false)
+	if err != nil {
+		t.Fatalf("RestoreToPointInTime() failed: %v", err)
+	}
+	if report.Applied != 3 {
+		t.Errorf("Expected 3 changes applied, got %d", report.Applied)
+	}
+
+	for key, want := range map[string]string{"a": "a1", "b": "b1", "d": "d1"} {
+		data, err := client.ReadFile(ctx, "orders", key, "")
+		if err != nil || string(data) != want {
+			t.Errorf("Expected %s to be %q, got %q (%v)", key, want, data, err)
+		}
+	}
+	if exists, _ := client.FileExists(ctx, "orders", "c"); exists {
+		t.Error("Expected c, created after the restore time, to be deleted")
+	}
+}
+
+func TestRestoreToPointInTime_Prefix(t *testing.T) {
+	client, _, at := setupPointInTimeClient(t)
+
+	report, err := RestoreToPointInTime(context.Background(), client, "orders", "a", at, true)
+	if err != nil {
+		t.Fatalf("RestoreToPointInTime() failed: %v", err)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Key != "a" {
+		t.Errorf("Expected only key a to change, got %+v", report.Changes)
+	}
+}
+
+func TestRestoreToPointInTime_ExpiredVersions(t *testing.T) {
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "orders", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	// The versions of "a" replaced more than a day ago are expired, it may have existed 3 days ago
+	now := time.Now().Truncate(time.Second)
+	writeVersionAt(t, client, mockClient, "orders", "a", "a1", now.Add(-2*24*time.Hour))
+	writeVersionAt(t, client, mockClient, "orders", "b", "b1", now.Add(-4*24*time.Hour))
+	writeVersionAt(t, client, mockClient, "orders", "b", "b2", now.Add(-time.Hour))
+
+	report, err := RestoreToPointInTime(ctx, client, "orders", "", now.Add(-3*24*time.Hour), false)
+	if err != nil {
+		t.Fatalf("RestoreToPointInTime() failed: %v", err)
+	}
+	if len(report.Unrestorable) != 1 || report.Unrestorable[0] != "a" {
+		t.Errorf("Expected key a to be unrestorable, got %v", report.Unrestorable)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Key != "b" || report.Changes[0].Action != PointInTimeActionRestore {
+		t.Errorf("Expected only key b to be restored, got %+v", report.Changes)
+	}
+	if exists, _ := client.FileExists(ctx, "orders", "a"); !exists {
+		t.Error("Expected unrestorable key a to be kept")
+	}
+}
+
+func TestRestoreToPointInTime_VersioningDisabled(t *testing.T) {
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getTestConfig())
+	if err := mockClient.MakeBucket(context.Background(), "unversioned", minio.MakeBucketOptions{}); err != nil {
+		t.Fatalf("MakeBucket() failed: %v", err)
+	}
+
+	_, err := RestoreToPointInTime(context.Background(), client, "unversioned", "", time.Now(), true)
+	if !errors.Is(err, ErrVersioningDisabled) {
+		t.Errorf("Expected ErrVersioningDisabled, got %v", err)
+	}
+}
+
+func TestRestoreToPointInTime_Filesystem(t *testing.T) {
+	storage := setupFilesystem(t, true)
+	ctx := context.Background()
+	if _, err := storage.WriteFile(ctx, "orders", "a", []byte("a1")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	at := time.Now()
+	time.Sleep(time.Millisecond)
+	if _, err := storage.WriteFile(ctx, "orders", "a", []byte("a2-corrupt")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := storage.WriteFile(ctx, "orders", "b", []byte("b1-new")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	report, err := RestoreToPointInTime(ctx, storage, "orders", "", at, false)
+	if err != nil {
+		t.Fatalf("RestoreToPointInTime() failed: %v", err)
+	}
+	if report.Applied != 2 {
+		t.Errorf("Expected 2 changes applied, got %+v", report.Changes)
+	}
+	if data, err := storage.ReadFile(ctx, "orders", "a", ""); err != nil || string(data) != "a1" {
+		t.Errorf("Expected a to be %q, got %q (%v)", "a1", data, err)
+	}
+	if exists, _ := storage.FileExists(ctx, "orders", "b"); exists {
+		t.Error("Expected b, created after the restore time, to be deleted")
+	}
+}
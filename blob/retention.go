@@ -0,0 +1,93 @@
+package blob
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// retentionRuleIDPrefix is the ID of the lifecycle rule of a whole-bucket retention policy,
+	// followed by ":" and the key prefix for a prefix policy.
+	retentionRuleIDPrefix = "Retention"
+	// maxRetentionReportKeys is the number of expired keys listed per policy in a retention report.
+	maxRetentionReportKeys = 1000
+)
+
+// retentionRuleID returns the ID of the lifecycle rule of a retention policy.
+func retentionRuleID(prefix string) string {
+	if prefix == "" {
+		return retentionRuleIDPrefix
+	}
+	return retentionRuleIDPrefix + ":" + prefix
+}
+
+// isRetentionRuleID reports whether a lifecycle rule ID is the ID of a retention rule managed by Nimbus.
+func isRetentionRuleID(id string) bool {
+	return id == retentionRuleIDPrefix || strings.HasPrefix(id, retentionRuleIDPrefix+":")
+}
+
+// retentionPolicies returns the retention policies of a bucket.
+func retentionPolicies(cfg *configurations.Config, bucketName string) []configurations.RetentionConfig {
+	if cfg == nil {
+		return nil
+	}
+	var policies []configurations.RetentionConfig
+	for _, p := range cfg.Blob.Retention {
+		if p.Bucket == bucketName {
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+// PlanRetention reports, without deleting anything, the current objects of a bucket that its retention policies expire as of a time.
+// Expiration is applied by blob storage through the lifecycle rules of the policies, usually once a day,
+// so objects listed here are deleted by the next lifecycle run.
+//
+// params:
+//   - ctx: Context for the operation
+//   - bucketName: The bucket to report on
+//   - now: The time the objects are expired at
+//
+// return:
+//   - *RetentionReport: The expired objects per policy, no policies if the bucket has none
+//   - error: ErrBucketNotFound, or an error if the objects could not be listed
+func (c *Client) PlanRetention(ctx context.Context, bucketName string, now time.Time) (*RetentionReport, error) {
+	if err := c.ensureBucketExists(ctx, bucketName); err != nil {
+		return nil, err
+	}
+
+	return planRetention(ctx, c, retentionPolicies(c.config, bucketName), bucketName, now)
+}
+
+// planRetention lists the current objects of a bucket expired by its retention policies as of now, see PlanRetention.
+func planRetention(ctx context.Context, storage Storage, policies []configurations.RetentionConfig, bucketName string, now time.Time) (*RetentionReport, error) {
+	report := &RetentionReport{Bucket: bucketName, GeneratedAt: now.UTC(), Policies: []RetentionPolicyReport{}}
+	for _, policy := range policies {
+		objects, err := storage.ListObjects(ctx, bucketName, policy.Prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		cutoff := now.UTC().AddDate(0, 0, -policy.Days)
+		policyReport := RetentionPolicyReport{Prefix: policy.Prefix, Days: policy.Days, Cutoff: cutoff, Keys: []string{}}
+		for _, obj := range objects {
+			if obj.LastModified.After(cutoff) {
+				continue
+			}
+			policyReport.ExpiredObjects++
+			policyReport.ExpiredBytes += obj.Size
+			if len(policyReport.Keys) < maxRetentionReportKeys {
+				policyReport.Keys = append(policyReport.Keys, obj.Key)
+			} else {
+				policyReport.Truncated = true
+			}
+		}
+		sort.Strings(policyReport.Keys)
+		report.Policies = append(report.Policies, policyReport)
+	}
+	return report, nil
+}
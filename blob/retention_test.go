@@ -0,0 +1,140 @@
+package blob
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+func getRetentionTestConfig() *configurations.Config {
+	cfg := getTestConfig()
+	cfg.Blob.Retention = []configurations.RetentionConfig{
+		{Bucket: "audit", Prefix: "logs/", Days: 2555},
+		{Bucket: "audit", Prefix: "feeds/", Days: 90},
+		{Bucket: "other", Days: 30},
+	}
+	return cfg
+}
+
+func findLifecycleRule(rules []LifecycleRule, id string) (LifecycleRule, bool) {
+	for _, r := range rules {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return LifecycleRule{}, false
+}
+
+func TestClient_CreateBucket_RetentionRules(t *testing.T) {
+	client := NewClientWithInterface(newMockMinioClient(), getRetentionTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	description, err := client.DescribeBucket(ctx, "audit")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if len(description.LifecycleRules) != 4 {
+		t.Errorf("Expected 4 lifecycle rules, got %+v", description.LifecycleRules)
+	}
+	rule, ok := findLifecycleRule(description.LifecycleRules, "Retention:logs/")
+	if !ok || rule.Prefix != "logs/" || rule.ExpirationDays != 2555 {
+		t.Errorf("Expected a 2555 days retention rule on logs/, got %+v", rule)
+	}
+	if _, ok := findLifecycleRule(description.LifecycleRules, "Retention"); ok {
+		t.Error("Expected the retention policy of another bucket not to be applied")
+	}
+}
+
+func TestClient_ApplyLifecycleRules_RemovesStaleRetention(t *testing.T) {
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getRetentionTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	current, err := client.getBucketLifecycle(ctx, "audit")
+	if err != nil {
+		t.Fatalf("getBucketLifecycle() failed: %v", err)
+	}
+	current.Rules = append(current.Rules, lifecycle.Rule{ID: "OperatorRule", Status: "Enabled", Expiration: lifecycle.Expiration{Days: 7}})
+	if err := mockClient.SetBucketLifecycle(ctx, "audit", current); err != nil {
+		t.Fatalf("SetBucketLifecycle() failed: %v", err)
+	}
+
+	// The feeds/ policy is removed from the config
+	client.config.Blob.Retention = client.config.Blob.Retention[:1]
+	drifted, err := client.applyLifecycleRules(ctx, "audit")
+	if err != nil {
+		t.Fatalf("applyLifecycleRules() failed: %v", err)
+	}
+	if len(drifted) != 1 || drifted[0] != "Retention:feeds/" {
+		t.Errorf("Expected the stale retention rule to be reported, got %v", drifted)
+	}
+
+	description, err := client.DescribeBucket(ctx, "audit")
+	if err != nil {
+		t.Fatalf("DescribeBucket() failed: %v", err)
+	}
+	if _, ok := findLifecycleRule(description.LifecycleRules, "Retention:feeds/"); ok {
+		t.Error("Expected the stale retention rule to be removed")
+	}
+	if _, ok := findLifecycleRule(description.LifecycleRules, "OperatorRule"); !ok {
+		t.Error("Expected the operator rule to be kept")
+	}
+}
+
+func TestClient_PlanRetention(t *testing.T) {
+	mockClient := newMockMinioClient()
+	client := NewClientWithInterface(mockClient, getRetentionTestConfig())
+	ctx := context.Background()
+	if err := client.CreateBucket(ctx, "audit", ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	writeVersionAt(t, client, mockClient, "audit", "feeds/old", "old", now.AddDate(0, 0, -91))
+	writeVersionAt(t, client, mockClient, "audit", "feeds/new", "new", now.AddDate(0, 0, -10))
+	writeVersionAt(t, client, mockClient, "audit", "logs/old", "kept", now.AddDate(-1, 0, 0))
+	writeVersionAt(t, client, mockClient, "audit", "untracked", "x", now.AddDate(-5, 0, 0))
+
+	report, err := client.PlanRetention(ctx, "audit", now)
+	if err != nil {
+		t.Fatalf("PlanRetention() failed: %v", err)
+	}
+	if len(report.Policies) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(report.Policies))
+	}
+	for _, policy := range report.Policies {
+		switch policy.Prefix {
+		case "feeds/":
+			if policy.ExpiredObjects != 1 || policy.ExpiredBytes != 3 || len(policy.Keys) != 1 || policy.Keys[0] != "feeds/old" {
+				t.Errorf("Expected feeds/old to be expired, got %+v", policy)
+			}
+		case "logs/":
+			if policy.ExpiredObjects != 0 {
+				t.Errorf("Expected no expired logs, got %+v", policy)
+			}
+		default:
+			t.Errorf("Unexpected policy %+v", policy)
+		}
+	}
+
+	// Nothing is deleted
+	if _, err := client.ReadFile(ctx, "audit", "feeds/old", ""); err != nil {
+		t.Errorf("Expected feeds/old to still exist, got %v", err)
+	}
+}
+
+func TestClient_PlanRetention_BucketNotFound(t *testing.T) {
+	client := NewClientWithInterface(newMockMinioClient(), getRetentionTestConfig())
+	if _, err := client.PlanRetention(context.Background(), "audit", time.Now()); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
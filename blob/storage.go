@@ -0,0 +1,112 @@
+package blob
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Storage is the blob storage NimbusDb applies reads and writes to, independent of the provider.
+// Errors are reported with the errors of this package (ErrBucketNotFound, ErrObjectNotFound, ErrObjectLocked...),
+// so callers never depend on provider error types. Every bucket is versioned: each write creates a new version.
+// Client (S3 compatible storage through MinIO) is the reference implementation.
+// Implementations must be thread-safe.
+type Storage interface {
+	// ReadFile reads an object version, the current one if versionID is empty.
+	ReadFile(ctx context.Context, bucketName, fileName, versionID string) ([]byte, error)
+	// WriteFile writes a new version of an object and returns its version ID.
+	WriteFile(ctx context.Context, bucketName, fileName string, data []byte) (string, error)
+	// WriteFileWithOptions writes a new version of an object like WriteFile, with the given options.
+	// With IfNotExists, it returns ErrPreconditionFailed if the object has a current version.
+	WriteFileWithOptions(ctx context.Context, bucketName, fileName string, data []byte, opts WriteOptions) (string, error)
+	// StatObject describes an object version without reading it, the current one if versionID is empty.
+	StatObject(ctx context.Context, bucketName, fileName, versionID string) (*ObjectInfo, error)
+	// FileExists reports whether an object has a current version.
+	FileExists(ctx context.Context, bucketName, fileName string) (bool, error)
+	// DeleteObject deletes an object: without versionID, its previous versions are kept and can be restored.
+	// With versionID, that version is deleted permanently.
+	DeleteObject(ctx context.Context, bucketName, fileName, versionID string) error
+	// ListObjects lists the current object versions of a bucket or key prefix, sorted by key.
+	ListObjects(ctx context.Context, bucketName, prefix string) ([]ObjectInfo, error)
+	// ListObjectVersions lists every version and delete marker of the objects of a bucket or key prefix.
+	ListObjectVersions(ctx context.Context, bucketName, prefix string) ([]ObjectVersion, error)
+	// VerifyObject re-reads an object version and checks it against its listing and stored checksum.
+	VerifyObject(ctx context.Context, bucketName string, obj ObjectInfo) (bool, error)
+
+	// ListBuckets lists all buckets.
+	ListBuckets(ctx context.Context) ([]BucketInfo, error)
+	// CreateBucket creates a versioned bucket with the expected lifecycle rules, or repairs an existing one.
+	CreateBucket(ctx context.Context, bucketName string, lock ObjectLockConfig) error
+	// ProvisionBucket makes sure a bucket exists with the expected settings, and reports what it changed.
+	ProvisionBucket(ctx context.Context, bucketName string) (*BucketProvisionReport, error)
+	// DescribeBucket returns the versioning, lifecycle and object lock settings of a bucket.
+	DescribeBucket(ctx context.Context, bucketName string) (*BucketDescription, error)
+	// CheckBucketSettings reports, without changing anything, the settings of a bucket that differ from the expected ones.
+	CheckBucketSettings(ctx context.Context, bucketName string) (*BucketSettingsDrift, error)
+	// DeleteBucket deletes an empty bucket.
+	DeleteBucket(ctx context.Context, bucketName string) error
+	// BucketUsage returns the number and total size of the current objects of a bucket.
+	BucketUsage(ctx context.Context, bucketName string) (*BucketUsage, error)
+	// PlanRetention lists the objects of a bucket its retention policies expire, without deleting them.
+	PlanRetention(ctx context.Context, bucketName string, now time.Time) (*RetentionReport, error)
+
+	// SetLegalHold applies or removes the legal hold of an object version in a bucket with object lock.
+	SetLegalHold(ctx context.Context, bucketName, fileName, versionID string, on bool) error
+	// GetLegalHold returns whether an object version is under legal hold.
+	GetLegalHold(ctx context.Context, bucketName, fileName, versionID string) (bool, error)
+}
+
+// VersionCleaner is implemented by the storages that can apply the version cleanup rules of the lifecycle configuration
+// themselves, instead of the provider. When enabled, CleanVersions must be run periodically on every bucket.
+type VersionCleaner interface {
+	// CleanVersions deletes the object versions of a bucket expired by the version cleanup rules as of now.
+	CleanVersions(ctx context.Context, bucketName string, now time.Time) error
+}
+
+// Client stores objects in S3 compatible storage.
+var _ Storage = (*Client)(nil)
+
+// Filesystem stores objects in a local directory tree.
+var _ Storage = (*Filesystem)(nil)
+
+// AzureClient stores objects in Azure Blob Storage.
+var _ Storage = (*AzureClient)(nil)
+
+// AzureClient applies the version cleanup rules itself with blob.azure.clientVersionCleanup, for storage accounts
+// whose lifecycle management policy Nimbus cannot set.
+var _ VersionCleaner = (*AzureClient)(nil)
+
+// GCSClient stores objects in Google Cloud Storage.
+var _ Storage = (*GCSClient)(nil)
+
+// NewStorage creates the blob storage of the configured provider.
+//
+// params:
+//   - ctx: Context for the operation
+//   - cfg: Configuration containing the blob provider and its settings
+//
+// return:
+//   - Storage: A new blob storage
+//   - error: An error if the provider is unknown or the storage could not be initialized
+func NewStorage(ctx context.Context, cfg *configurations.Config) (Storage, error) {
+	var storage Storage
+	var err error
+	// Assigned through err so a failed constructor never returns a non-nil interface holding a nil pointer
+	switch cfg.Blob.Provider {
+	case configurations.BlobProviderMinIO, "":
+		storage, err = NewClient(ctx, cfg)
+	case configurations.BlobProviderFilesystem:
+		storage, err = NewFilesystem(cfg)
+	case configurations.BlobProviderAzure:
+		storage, err = NewAzureClient(ctx, cfg)
+	case configurations.BlobProviderGCS:
+		storage, err = NewGCSClient(ctx, cfg)
+	default:
+		err = fmt.Errorf("unknown blob provider %q", cfg.Blob.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
@@ -0,0 +1,363 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// storageProvider creates a storage of a provider for the Storage suite, with an empty bucket named orders.
+type storageProvider struct {
+	name string
+	// versions is true if each write creates a new version and previous versions are kept.
+	versions bool
+	// deleteMarkers is true if deleting an object without version ID adds a delete marker.
+	deleteMarkers bool
+	setup         func(t *testing.T) Storage
+}
+
+// storageCase is a case of the Storage suite, run against every provider.
+type storageCase struct {
+	name string
+	// versions is true if the case only applies to providers keeping versions.
+	versions bool
+	// deleteMarkers is true if the case only applies to providers with delete markers.
+	deleteMarkers bool
+	run           func(t *testing.T, storage Storage)
+}
+
+var storageProviders = []storageProvider{
+	{"minio", true, true, func(t *testing.T) Storage {
+		client := NewClientWithInterface(newMockMinioClient(), getTestConfig())
+		if err := client.CreateBucket(context.Background(), "orders", ObjectLockConfig{}); err != nil {
+			t.Fatalf("CreateBucket() failed: %v", err)
+		}
+		return client
+	}},
+	{"filesystem", true, true, func(t *testing.T) Storage { return setupFilesystem(t, true) }},
+	{"azure", true, false, func(t *testing.T) Storage {
+		client, _, _ := setupAzureClient(t, getAzureTestConfig(), true)
+		return client
+	}},
+	// Skipped unless the Azurite emulator is listening, see setupAzurite
+	{"azurite", false, false, func(t *testing.T) Storage {
+		client := setupAzurite(t)
+		ctx := context.Background()
+		if err := client.azureClient.DeleteContainer(ctx, "orders"); err != nil && !errors.Is(azureError("orders", "", "", "", err), ErrBucketNotFound) {
+			t.Fatalf("DeleteContainer() failed: %v", err)
+		}
+		if err := client.CreateBucket(ctx, "orders", ObjectLockConfig{}); err != nil {
+			t.Fatalf("CreateBucket() failed: %v", err)
+		}
+		t.Cleanup(func() { _ = client.azureClient.DeleteContainer(ctx, "orders") })
+		return client
+	}},
+	{"gcs", true, false, func(t *testing.T) Storage {
+		client, _ := setupGCSClient(t)
+		return client
+	}},
+}
+
+var storageCases = []storageCase{
+	{"ReadWrite", false, false, testStorageReadWrite},
+	{"Versions", true, false, testStorageVersions},
+	{"WriteIfNotExists", false, false, testStorageWriteIfNotExists},
+	{"DeleteObject", true, false, testStorageDeleteObject},
+	{"DeleteMarkers", true, true, testStorageDeleteMarkers},
+	{"ListObjects", false, false, testStorageListObjects},
+	{"VerifyObject", false, false, testStorageVerifyObject},
+}
+
+func TestStorage(t *testing.T) {
+	for _, provider := range storageProviders {
+		for _, tc := range storageCases {
+			if (tc.versions && !provider.versions) || (tc.deleteMarkers && !provider.deleteMarkers) {
+				continue
+			}
+			t.Run(provider.name+"/"+tc.name, func(t *testing.T) {
+				tc.run(t, provider.setup(t))
+			})
+		}
+	}
+}
+
+func testStorageReadWrite(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	if _, err := storage.WriteFile(ctx, "orders", "eu/order-1", []byte("first")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	second, err := storage.WriteFile(ctx, "orders", "eu/order-1", []byte("second!"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	data, err := storage.ReadFile(ctx, "orders", "eu/order-1", "")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if string(data) != "second!" {
+		t.Errorf("Expected current version to be %q, got %q", "second!", data)
+	}
+	info, err := storage.StatObject(ctx, "orders", "eu/order-1", "")
+	if err != nil {
+		t.Fatalf("StatObject() failed: %v", err)
+	}
+	if info.Key != "eu/order-1" || info.VersionID != second || info.Size != 7 {
+		t.Errorf("Expected version %q of 7 bytes, got %+v", second, info)
+	}
+	if info.Checksum != checksum([]byte("second!")) {
+		t.Errorf("Expected the stored checksum of the content, got %+v", info)
+	}
+	if exists, err := storage.FileExists(ctx, "orders", "eu/order-1"); err != nil || !exists {
+		t.Errorf("Expected the object to exist, got %v (%v)", exists, err)
+	}
+
+	if _, err := storage.ReadFile(ctx, "orders", "missing", ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+	if _, err := storage.StatObject(ctx, "orders", "missing", ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+	if exists, err := storage.FileExists(ctx, "orders", "missing"); err != nil || exists {
+		t.Errorf("Expected the object not to exist, got %v (%v)", exists, err)
+	}
+	if _, err := storage.ReadFile(ctx, "missing", "eu/order-1", ""); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if _, err := storage.WriteFile(ctx, "missing", "eu/order-1", []byte("order")); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if _, err := storage.WriteFile(ctx, "orders", "", []byte("order")); err == nil {
+		t.Error("Expected an error for an empty file name")
+	}
+}
+
+func testStorageVersions(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	first, err := storage.WriteFile(ctx, "orders", "eu/order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	second, err := storage.WriteFile(ctx, "orders", "eu/order-1", []byte("second!"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if first == "" || second == first {
+		t.Errorf("Expected each write to create a version, got %q then %q", first, second)
+	}
+
+	data, err := storage.ReadFile(ctx, "orders", "eu/order-1", first)
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if string(data) != "first" {
+		t.Errorf("Expected first version to be %q, got %q", "first", data)
+	}
+	info, err := storage.StatObject(ctx, "orders", "eu/order-1", first)
+	if err != nil {
+		t.Fatalf("StatObject() failed: %v", err)
+	}
+	if info.VersionID != first || info.Size != 5 {
+		t.Errorf("Expected version %s of 5 bytes, got %+v", first, info)
+	}
+}
+
+func testStorageWriteIfNotExists(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	opts := WriteOptions{IfNotExists: true}
+	if _, err := storage.WriteFileWithOptions(ctx, "orders", "order-1", []byte("first"), opts); err != nil {
+		t.Fatalf("WriteFileWithOptions() failed: %v", err)
+	}
+	if _, err := storage.WriteFileWithOptions(ctx, "orders", "order-1", []byte("second"), opts); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Expected ErrPreconditionFailed for an existing object, got %v", err)
+	}
+	if data, err := storage.ReadFile(ctx, "orders", "order-1", ""); err != nil || string(data) != "first" {
+		t.Errorf("Expected %q to be kept, got %q (%v)", "first", data, err)
+	}
+
+	// A deleted object can be created again
+	if err := storage.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if _, err := storage.WriteFileWithOptions(ctx, "orders", "order-1", []byte("third"), opts); err != nil {
+		t.Errorf("Expected a deleted object to be created again, got %v", err)
+	}
+	if data, err := storage.ReadFile(ctx, "orders", "order-1", ""); err != nil || string(data) != "third" {
+		t.Errorf("Expected %q, got %q (%v)", "third", data, err)
+	}
+}
+
+func testStorageDeleteObject(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	first, err := storage.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	second, err := storage.WriteFile(ctx, "orders", "order-1", []byte("second"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	// Deleting the object keeps its versions, none of them current
+	if err := storage.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if exists, err := storage.FileExists(ctx, "orders", "order-1"); err != nil || exists {
+		t.Errorf("Expected the object to be deleted, got %v (%v)", exists, err)
+	}
+	if _, err := storage.ReadFile(ctx, "orders", "order-1", ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+	versions, err := storage.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	var kept []string
+	for _, v := range versions {
+		if v.IsDeleteMarker {
+			continue
+		}
+		if v.IsLatest {
+			t.Errorf("Expected no current version, got %+v", v)
+		}
+		kept = append(kept, v.VersionID)
+	}
+	if len(kept) != 2 || kept[0] != second || kept[1] != first {
+		t.Errorf("Expected both versions newest first, got %+v", versions)
+	}
+	if err := storage.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Errorf("Expected deleting a deleted object to succeed, got %v", err)
+	}
+	if err := storage.DeleteBucket(ctx, "orders"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected ErrBucketNotEmpty, got %v", err)
+	}
+
+	// Deleting a version by ID removes it permanently
+	if err := storage.DeleteObject(ctx, "orders", "order-1", first); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if _, err := storage.ReadFile(ctx, "orders", "order-1", first); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound, got %v", err)
+	}
+	if err := storage.DeleteObject(ctx, "orders", "order-1", first); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound for a deleted version, got %v", err)
+	}
+	if err := storage.DeleteObject(ctx, "missing", "order-1", ""); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func testStorageDeleteMarkers(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	first, err := storage.WriteFile(ctx, "orders", "order-1", []byte("first"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	second, err := storage.WriteFile(ctx, "orders", "order-1", []byte("second"))
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	// Deleting a version permanently makes the previous one current
+	if err := storage.DeleteObject(ctx, "orders", "order-1", second); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	data, err := storage.ReadFile(ctx, "orders", "order-1", "")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if string(data) != "first" {
+		t.Errorf("Expected the first version to be current, got %q", data)
+	}
+
+	// Deleting the object keeps its versions, behind a delete marker
+	if err := storage.DeleteObject(ctx, "orders", "order-1", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if exists, err := storage.FileExists(ctx, "orders", "order-1"); err != nil || exists {
+		t.Errorf("Expected the object to be deleted, got %v (%v)", exists, err)
+	}
+	versions, err := storage.ListObjectVersions(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %+v", versions)
+	}
+	if !versions[0].IsLatest || !versions[0].IsDeleteMarker {
+		t.Errorf("Expected the current version to be a delete marker, got %+v", versions[0])
+	}
+	if versions[1].VersionID != first || versions[1].IsLatest || versions[1].IsDeleteMarker {
+		t.Errorf("Expected the first version to be kept, got %+v", versions[1])
+	}
+	if err := storage.DeleteBucket(ctx, "orders"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("Expected ErrBucketNotEmpty, got %v", err)
+	}
+
+	// Deleting the delete marker does not restore the object, the previous version stays non-current
+	if err := storage.DeleteObject(ctx, "orders", "order-1", versions[0].VersionID); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+	if _, err := storage.ReadFile(ctx, "orders", "order-1", first); err != nil {
+		t.Errorf("Expected the first version to be readable, got %v", err)
+	}
+
+	if err := storage.DeleteObject(ctx, "missing-bucket", "order-1", ""); err == nil {
+		t.Error("DeleteObject() should have failed for a missing bucket")
+	}
+}
+
+func testStorageListObjects(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	for _, key := range []string{"eu/order-2", "eu/order-1", "us/order-1"} {
+		if _, err := storage.WriteFile(ctx, "orders", key, []byte(key)); err != nil {
+			t.Fatalf("WriteFile(%q) failed: %v", key, err)
+		}
+	}
+	if err := storage.DeleteObject(ctx, "orders", "eu/order-2", ""); err != nil {
+		t.Fatalf("DeleteObject() failed: %v", err)
+	}
+
+	objects, err := storage.ListObjects(ctx, "orders", "eu/")
+	if err != nil {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != "eu/order-1" || objects[0].Size != int64(len("eu/order-1")) {
+		t.Errorf("Expected only eu/order-1, got %+v", objects)
+	}
+	objects, err = storage.ListObjects(ctx, "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjects() failed: %v", err)
+	}
+	if len(objects) != 2 || objects[0].Key != "eu/order-1" || objects[1].Key != "us/order-1" {
+		t.Errorf("Expected eu/order-1 and us/order-1, got %+v", objects)
+	}
+
+	usage, err := storage.BucketUsage(ctx, "orders")
+	if err != nil {
+		t.Fatalf("BucketUsage() failed: %v", err)
+	}
+	if usage.Objects != 2 || usage.Bytes != int64(len("eu/order-1")+len("us/order-1")) {
+		t.Errorf("Expected 2 objects of 20 bytes, got %+v", usage)
+	}
+	if _, err := storage.ListObjects(ctx, "missing", ""); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+	if _, err := storage.ListObjectVersions(ctx, "missing", ""); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound, got %v", err)
+	}
+}
+
+func testStorageVerifyObject(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	if _, err := storage.WriteFile(ctx, "orders", "order-1", []byte("order")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	objects, err := storage.ListObjects(ctx, "orders", "")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("Expected 1 object, got %+v (%v)", objects, err)
+	}
+	if verified, err := storage.VerifyObject(ctx, "orders", objects[0]); err != nil || !verified {
+		t.Errorf("Expected the object to be verified, got %v (%v)", verified, err)
+	}
+}
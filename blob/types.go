@@ -0,0 +1,230 @@
+package blob
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidBucketName is returned when a bucket name does not follow the S3/MinIO naming rules.
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+	// ErrBucketNotFound is returned when an operation targets a bucket that does not exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketNotEmpty is returned when deleting a bucket that still holds objects or object versions.
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+	// ErrObjectNotFound is returned when an operation targets an object or object version that does not exist.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrPreconditionFailed is returned by a conditional write whose condition does not hold: the object exists
+	// (WriteOptions.IfNotExists), or is not at the expected version.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrUnsupportedCondition is returned by a conditional write whose condition the storage provider cannot check
+	// atomically with the write (WriteOptions.IfVersion on S3 and Azure).
+	ErrUnsupportedCondition = errors.New("unsupported write condition")
+)
+
+const (
+	// VersioningEnabled means every write creates a new object version.
+	VersioningEnabled = "Enabled"
+	// VersioningSuspended means versioning was enabled once and then suspended.
+	VersioningSuspended = "Suspended"
+	// VersioningDisabled means versioning was never enabled on the bucket.
+	VersioningDisabled = "Disabled"
+)
+
+// BucketInfo describes a bucket as returned by ListBuckets.
+type BucketInfo struct {
+	Name         string    `json:"name"`
+	CreationDate time.Time `json:"creationDate"`
+}
+
+// BucketDescription describes the versioning, lifecycle and object lock settings of a bucket.
+type BucketDescription struct {
+	Name string `json:"name"`
+	// Versioning is one of VersioningEnabled, VersioningSuspended or VersioningDisabled.
+	Versioning string `json:"versioning"`
+	// LifecycleRules are the lifecycle rules applied to the bucket. Empty if the bucket has no lifecycle configuration.
+	LifecycleRules []LifecycleRule `json:"lifecycleRules"`
+	// ObjectLock is the object lock configuration of the bucket. Not enabled if the bucket was created without object lock.
+	ObjectLock ObjectLockConfig `json:"objectLock"`
+}
+
+// ObjectLockConfig is the object lock (WORM) configuration of a bucket.
+// The zero value is a bucket without object lock.
+type ObjectLockConfig struct {
+	// Enabled protects object versions from being deleted or overwritten while retained or under legal hold.
+	// It can only be set when the bucket is created, and implies versioning.
+	Enabled bool `json:"enabled"`
+	// Mode is the default retention mode of new object versions, ObjectLockModeGovernance or ObjectLockModeCompliance.
+	// Empty if new versions have no default retention.
+	Mode string `json:"mode,omitempty"`
+	// RetentionDays is the default retention period of new object versions, set with Mode.
+	RetentionDays int `json:"retentionDays,omitempty"`
+}
+
+// LifecycleRule is a provider-neutral view of a bucket lifecycle rule.
+// Day counts are 0 when the rule does not set the corresponding action.
+type LifecycleRule struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Prefix limits the rule to keys starting with it. Empty means the whole bucket.
+	Prefix                          string `json:"prefix,omitempty"`
+	ExpirationDays                  int    `json:"expirationDays,omitempty"`
+	DeleteMarkerExpirationDays      int    `json:"deleteMarkerExpirationDays,omitempty"`
+	NoncurrentVersionExpirationDays int    `json:"noncurrentVersionExpirationDays,omitempty"`
+}
+
+// BucketUsage is the storage used by the current object versions of a bucket.
+type BucketUsage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// ObjectInfo describes a version of an object, as returned by ListObjects and StatObject.
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	VersionID    string    `json:"versionId,omitempty"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+	// UserMetadata is the user metadata of the version, without the checksum. Only set by StatObject.
+	UserMetadata map[string]string `json:"userMetadata,omitempty"`
+	// Checksum is the hex SHA-256 of the content stored with the version, empty if it has none
+	// (written before checksums were stored, or by other clients). Set by StatObject.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// WriteOptions are the options of a write with WriteFileWithOptions. The zero value is a plain WriteFile.
+type WriteOptions struct {
+	// UserMetadata is stored with the new version, next to the checksum.
+	UserMetadata map[string]string
+	// IfNotExists only writes the object if it has no current version, checked by blob storage atomically with the write.
+	// The write fails with ErrPreconditionFailed otherwise.
+	IfNotExists bool
+	// IfVersion only writes the object if its current version is IfVersion, checked by blob storage atomically with the write,
+	// so concurrent writers cannot overwrite each other's changes. The write fails with ErrPreconditionFailed otherwise.
+	// It cannot be combined with IfNotExists. Only GCS (a generation match) and the filesystem support it, the other
+	// providers fail with ErrUnsupportedCondition.
+	IfVersion string
+}
+
+// ObjectVersion describes a version or delete marker of an object, as returned by ListObjectVersions.
+type ObjectVersion struct {
+	ObjectInfo
+	// IsLatest is true for the current version of the object.
+	IsLatest bool `json:"isLatest"`
+	// IsDeleteMarker is true if the version records the deletion of the object. It has no content.
+	IsDeleteMarker bool `json:"isDeleteMarker"`
+}
+
+// BucketProvisionReport describes what ProvisionBucket changed to bring a bucket in line with the expected settings.
+type BucketProvisionReport struct {
+	Name string
+	// Created is true if the bucket did not exist.
+	Created bool
+	// PreviousVersioning is the versioning status found on an existing bucket.
+	// Empty if the bucket was created, or if versioning is not a bucket setting of the provider (filesystem).
+	PreviousVersioning string
+	// RepairedLifecycleRules are the IDs of the expected lifecycle rules that were missing or had drifted on an existing bucket.
+	RepairedLifecycleRules []string
+}
+
+// VersioningRepaired reports whether versioning had to be (re-)enabled on an existing bucket.
+func (r *BucketProvisionReport) VersioningRepaired() bool {
+	return !r.Created && r.PreviousVersioning != "" && r.PreviousVersioning != VersioningEnabled
+}
+
+// Changed reports whether provisioning created or repaired anything.
+func (r *BucketProvisionReport) Changed() bool {
+	return r.Created || r.VersioningRepaired() || len(r.RepairedLifecycleRules) > 0
+}
+
+// BucketSettingsDrift describes how the settings of a bucket differ from the ones Nimbus expects, as returned by CheckBucketSettings.
+type BucketSettingsDrift struct {
+	Name string `json:"name"`
+	// Versioning is the versioning status found on the bucket, expected VersioningEnabled.
+	// Empty if versioning is not a bucket setting of the provider (filesystem).
+	Versioning string `json:"versioning"`
+	// DriftedLifecycleRules are the IDs of the expected lifecycle rules that are missing or differ.
+	DriftedLifecycleRules []string `json:"driftedLifecycleRules,omitempty"`
+}
+
+// Drifted reports whether any setting differs from the expected ones.
+func (d *BucketSettingsDrift) Drifted() bool {
+	return (d.Versioning != "" && d.Versioning != VersioningEnabled) || len(d.DriftedLifecycleRules) > 0
+}
+
+// RetentionReport lists the objects of a bucket expired by its retention policies, as returned by PlanRetention.
+type RetentionReport struct {
+	Bucket      string                  `json:"bucket"`
+	GeneratedAt time.Time               `json:"generatedAt"`
+	Policies    []RetentionPolicyReport `json:"policies"`
+}
+
+// RetentionPolicyReport lists the objects expired by a retention policy.
+type RetentionPolicyReport struct {
+	Prefix string `json:"prefix"`
+	Days   int    `json:"days"`
+	// Cutoff is the time objects were last modified before to be expired.
+	Cutoff         time.Time `json:"cutoff"`
+	ExpiredObjects int64     `json:"expiredObjects"`
+	ExpiredBytes   int64     `json:"expiredBytes"`
+	// Keys are the keys of the expired objects, sorted. Only the first 1000 expired objects are listed.
+	Keys      []string `json:"keys"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// BackupManifest describes the objects of a backup archive. It is the first entry of the archive.
+type BackupManifest struct {
+	FormatVersion int       `json:"formatVersion"`
+	Bucket        string    `json:"bucket"`
+	Prefix        string    `json:"prefix,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	// Objects are the exported objects, in archive order.
+	Objects []BackupObject `json:"objects"`
+}
+
+// BackupObject describes an exported object version.
+type BackupObject struct {
+	Key          string            `json:"key"`
+	VersionID    string            `json:"versionId,omitempty"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified time.Time         `json:"lastModified"`
+	UserMetadata map[string]string `json:"userMetadata,omitempty"`
+}
+
+// RestoreReport describes what RestoreBucket wrote.
+type RestoreReport struct {
+	Bucket string `json:"bucket"`
+	// SourceBucket is the bucket the archive was exported from.
+	SourceBucket string `json:"sourceBucket"`
+	Objects      int64  `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+}
+
+// PointInTimeChange is the change a point-in-time restore makes to a key.
+type PointInTimeChange struct {
+	Key string `json:"key"`
+	// Action is PointInTimeActionRestore or PointInTimeActionDelete.
+	Action string `json:"action"`
+	// CurrentVersionID is the current version before the restore. Empty if the key is deleted.
+	CurrentVersionID string `json:"currentVersionId,omitempty"`
+	// RestoredVersionID is the version made current again. Only set for PointInTimeActionRestore.
+	RestoredVersionID    string    `json:"restoredVersionId,omitempty"`
+	RestoredLastModified time.Time `json:"restoredLastModified,omitempty"`
+}
+
+// PointInTimeRestoreReport describes what RestoreToPointInTime changed, or would change on a dry run.
+type PointInTimeRestoreReport struct {
+	Bucket  string              `json:"bucket"`
+	Prefix  string              `json:"prefix,omitempty"`
+	At      time.Time           `json:"at"`
+	DryRun  bool                `json:"dryRun"`
+	Changes []PointInTimeChange `json:"changes"`
+	// Unchanged is the number of keys already in their state at the restore time.
+	Unchanged int `json:"unchanged"`
+	// Unrestorable are the keys left alone because their versions from the restore time may have expired.
+	Unrestorable []string `json:"unrestorable"`
+	// Applied is the number of changes applied, always 0 on a dry run.
+	Applied int `json:"applied"`
+}
@@ -11,23 +11,169 @@ type Config struct {
 	HealthPort int    `koanf:"healthPort" env:"HEALTH_PORT"`
 	// LogLevel specifies the logging level.
 	// Valid values: "trace", "debug", "info", "warn", "error", "fatal", "panic"
-	LogLevel string     `koanf:"logLevel" env:"LOG_LEVEL"`
-	Blob     BlobConfig `koanf:"blob"`
-	NATS     NATSConfig `koanf:"nats"`
-	Db       DbConfig   `koanf:"db"`
+	LogLevel string `koanf:"logLevel" env:"LOG_LEVEL"`
+	// Buckets are created on startup if missing, and repaired if their versioning or lifecycle rules have drifted.
+	// Set via env as a comma separated list.
+	Buckets []string `koanf:"buckets" env:"BUCKETS"`
+	// Tenants restrict which buckets a client may access. Tenancy is disabled if empty (any bucket can be accessed).
+	// Only configurable via YAML.
+	Tenants []TenantConfig `koanf:"tenants"`
+	Auth    AuthConfig     `koanf:"auth"`
+	Limits  LimitsConfig   `koanf:"limits"`
+	CDC     CDCConfig      `koanf:"cdc"`
+	WAL     WALConfig      `koanf:"wal"`
+	// WriteBuffer is the local disk write-behind buffer. Mutually exclusive with WAL.
+	WriteBuffer WriteBufferConfig `koanf:"writeBuffer"`
+	Scrub       ScrubConfig       `koanf:"scrub"`
+	Blob        BlobConfig        `koanf:"blob"`
+	NATS        NATSConfig        `koanf:"nats"`
+	Db          DbConfig          `koanf:"db"`
+}
+
+// CDCConfig holds the change data capture settings.
+// When enabled, an event is published for every successful write to {SubjectPrefix}.{bucket}.
+type CDCConfig struct {
+	Enabled bool `koanf:"enabled" env:"CDC_ENABLED"`
+	// SubjectPrefix is the subject prefix of change events, default {nats.subjectPrefix}.cdc
+	SubjectPrefix string `koanf:"subjectPrefix" env:"CDC_SUBJECT_PREFIX"`
+	// JetStream publishes events to a JetStream stream (created if missing) and waits for the ack, for durability.
+	// If false, events are published with core NATS (at most once).
+	JetStream bool `koanf:"jetStream" env:"CDC_JETSTREAM"`
+	// Stream is the name of the JetStream stream capturing the events, default NIMBUS_CDC
+	Stream string `koanf:"stream" env:"CDC_STREAM"`
+	// MaxAge is how long the stream keeps events, default 24h
+	MaxAge time.Duration `koanf:"maxAge" env:"CDC_MAX_AGE"`
+	// WatchLease is how long a watch lives without being renewed by its client, default 5m
+	WatchLease time.Duration `koanf:"watchLease" env:"CDC_WATCH_LEASE"`
+}
+
+// WALConfig holds the write-ahead log settings.
+// When enabled, writes are acknowledged once appended to a JetStream stream, and applied to blob storage in the background.
+type WALConfig struct {
+	Enabled bool `koanf:"enabled" env:"WAL_ENABLED"`
+	// SubjectPrefix is the subject prefix of logged writes, followed by the shard ID, default {nats.subjectPrefix}.wal
+	SubjectPrefix string `koanf:"subjectPrefix" env:"WAL_SUBJECT_PREFIX"`
+	// Stream is the name of the JetStream stream holding the pending writes, default NIMBUS_WAL
+	Stream string `koanf:"stream" env:"WAL_STREAM"`
+	// RetryDelay is how long the flusher waits before retrying a write that failed to reach blob storage, default 1s
+	RetryDelay time.Duration `koanf:"retryDelay" env:"WAL_RETRY_DELAY"`
+	// SigningKey is the secret of the HMAC signing each logged write, flushers drop writes without a valid signature.
+	// Default auth.tokenSecret. Required when enabled.
+	SigningKey string `koanf:"signingKey" env:"WAL_SIGNING_KEY"`
+	// CatchUpTimeout bounds how long startup waits for the writes left pending by a previous run, default 10m
+	CatchUpTimeout time.Duration `koanf:"catchUpTimeout" env:"WAL_CATCH_UP_TIMEOUT"`
+}
+
+// WriteBufferConfig holds the local disk write-behind buffer settings.
+// When enabled, writes are acknowledged once appended and fsynced to a segment log on local disk,
+// and uploaded to blob storage in the background.
+type WriteBufferConfig struct {
+	Enabled bool `koanf:"enabled" env:"WRITE_BUFFER_ENABLED"`
+	// Dir is the directory holding the segment logs, one sub directory per shard. Default data/write-buffer.
+	Dir string `koanf:"dir" env:"WRITE_BUFFER_DIR"`
+	// SegmentSize is the size in bytes after which a new segment file is started. Default 64 MiB.
+	SegmentSize int64 `koanf:"segmentSize" env:"WRITE_BUFFER_SEGMENT_SIZE"`
+	// RetryDelay is how long the uploader waits before retrying a write that failed to reach blob storage, default 1s
+	RetryDelay time.Duration `koanf:"retryDelay" env:"WRITE_BUFFER_RETRY_DELAY"`
+	// MaxPendingBytes limits the data size of the writes not yet uploaded, further writes are rejected with a 503. Default 1 GiB.
+	MaxPendingBytes int64 `koanf:"maxPendingBytes" env:"WRITE_BUFFER_MAX_PENDING_BYTES"`
+}
+
+// ScrubConfig holds the background integrity scrubber settings.
+// When enabled, every object is re-read and checked against its stored checksum, and bucket settings are checked, once per interval.
+type ScrubConfig struct {
+	Enabled bool `koanf:"enabled" env:"SCRUB_ENABLED"`
+	// Interval is the time between the start of two scrubs, default 24h
+	Interval time.Duration `koanf:"interval" env:"SCRUB_INTERVAL"`
+	// BytesPerSecond throttles how fast objects are re-read from blob storage, default 10 MiB/s
+	BytesPerSecond int64 `koanf:"bytesPerSecond" env:"SCRUB_BYTES_PER_SECOND"`
+}
+
+// LimitsConfig holds the rate limits and storage quotas applied to shard operations.
+// Limits are disabled unless configured.
+type LimitsConfig struct {
+	// Shard is the rate limit applied to each shard (Tenant and Bucket are ignored).
+	Shard RateLimitConfig `koanf:"shard"`
+	// Rates are rate limits per tenant or per bucket. Only configurable via YAML.
+	Rates []RateLimitConfig `koanf:"rates"`
+	// Quotas are storage quotas per bucket, enforced on writes. Only configurable via YAML.
+	Quotas []QuotaConfig `koanf:"quotas"`
+	// QuotaRefreshInterval is how often bucket usage is recounted from blob storage, default 5m.
+	QuotaRefreshInterval time.Duration `koanf:"quotaRefreshInterval" env:"LIMITS_QUOTA_REFRESH_INTERVAL"`
+}
+
+// RateLimitConfig is a token bucket rate limit. A zero rate disables that limit.
+// Bursts of up to one second worth of operations (or bytes) are allowed.
+type RateLimitConfig struct {
+	// Tenant the limit applies to. Exactly one of Tenant and Bucket must be set in LimitsConfig.Rates.
+	Tenant string `koanf:"tenant"`
+	// Bucket the limit applies to. Exactly one of Tenant and Bucket must be set in LimitsConfig.Rates.
+	Bucket         string  `koanf:"bucket"`
+	OpsPerSecond   float64 `koanf:"opsPerSecond"`
+	BytesPerSecond float64 `koanf:"bytesPerSecond"`
+}
+
+// QuotaConfig is the storage quota of a bucket. A zero value disables that quota.
+type QuotaConfig struct {
+	Bucket     string `koanf:"bucket"`
+	MaxBytes   int64  `koanf:"maxBytes"`
+	MaxObjects int64  `koanf:"maxObjects"`
+}
+
+// AuthConfig holds the authorization settings for shard operations.
+// Authorization is disabled if no grants are configured (anyone who can publish to the shard subjects has full access).
+type AuthConfig struct {
+	// TokenSecret is the shared secret used to verify the 'authToken' header. Tokens are rejected if empty.
+	TokenSecret string `koanf:"tokenSecret" env:"AUTH_TOKEN_SECRET"`
+	// Grants are only configurable via YAML. Anything not granted is denied.
+	Grants []AuthGrantConfig `koanf:"grants"`
+}
+
+// AuthGrantConfig grants actions on a bucket and key prefix to a set of principals.
+type AuthGrantConfig struct {
+	// Principals are NATS users or token subjects. "*" matches any authenticated principal.
+	Principals []string `koanf:"principals"`
+	// Bucket is the bucket the grant applies to. "*" matches any bucket.
+	Bucket string `koanf:"bucket"`
+	// Prefix limits the grant to keys starting with it. Empty means the whole bucket.
+	Prefix string `koanf:"prefix"`
+	// Actions are any of "read", "write", "delete" and "admin".
+	Actions []string `koanf:"actions"`
+}
+
+// TenantConfig maps a tenant to the namespace of buckets it may access.
+// A bucket belongs to the tenant if it is listed in Buckets or starts with BucketPrefix.
+type TenantConfig struct {
+	Name         string   `koanf:"name"`
+	Buckets      []string `koanf:"buckets"`
+	BucketPrefix string   `koanf:"bucketPrefix"`
+	// NatsUsers are the NATS users whose requests belong to this tenant, as reported by the NATS server
+	// in the Nats-Request-Info header. Requests from these users do not need a 'tenant' header.
+	// Only used when NATSConfig.TrustRequestInfo is set, the tenant is then only reachable by these users.
+	NatsUsers []string `koanf:"natsUsers"`
+	// WatchSubjectPrefix lets the watches of this tenant deliver to the subjects under this prefix,
+	// besides the inbox of the requester.
+	WatchSubjectPrefix string `koanf:"watchSubjectPrefix"`
 }
 
 // NATSConfig holds the configuration for NATS.
 type NATSConfig struct {
-	URL                    string        `koanf:"url" env:"NATS_URL"`
-	Creds                  string        `koanf:"creds" env:"NATS_CREDS"`
-	SubjectPrefix          string        `koanf:"subjectPrefix" env:"NATS_SUBJECT_PREFIX"`
-	NatsDrainTimeout       time.Duration `koanf:"natsDrainTimeout" env:"NATS_DRAIN_TIMEOUT"`             // timeout for NATS drain operation, default 30s
-	ShutdownGracePeriod    time.Duration `koanf:"shutdownGracePeriod" env:"NATS_SHUTDOWN_GRACE_PERIOD"` // grace period to wait for in-flight messages during shutdown, default 100ms
+	URL                 string        `koanf:"url" env:"NATS_URL"`
+	Creds               string        `koanf:"creds" env:"NATS_CREDS"`
+	SubjectPrefix       string        `koanf:"subjectPrefix" env:"NATS_SUBJECT_PREFIX"`
+	NatsDrainTimeout    time.Duration `koanf:"natsDrainTimeout" env:"NATS_DRAIN_TIMEOUT"`            // timeout for NATS drain operation, default 30s
+	ShutdownGracePeriod time.Duration `koanf:"shutdownGracePeriod" env:"NATS_SHUTDOWN_GRACE_PERIOD"` // grace period to wait for in-flight messages during shutdown, default 100ms
+	// TrustRequestInfo identifies requesters by the Nats-Request-Info header. Only enable it when every client reaches
+	// the Nimbus subjects through a service import: the NATS server only sets the header on messages crossing an import,
+	// on any other message it is whatever the client sent.
+	TrustRequestInfo bool `koanf:"trustRequestInfo" env:"NATS_TRUST_REQUEST_INFO"`
 }
 
-// BlobConfig holds the configuration for MinIO blob storage.
+// BlobConfig holds the configuration for blob storage.
 type BlobConfig struct {
+	// Provider is the storage backend: BlobProviderMinIO (S3 compatible storage, default), BlobProviderFilesystem, BlobProviderAzure or BlobProviderGCS.
+	// Endpoint and credentials are only used by BlobProviderMinIO.
+	Provider                          string        `koanf:"provider" env:"BLOB_PROVIDER"`
 	Endpoint                          string        `koanf:"endpoint" env:"BLOB_ENDPOINT"`
 	AccessKeyID                       string        `koanf:"accessKeyID" env:"BLOB_ACCESS_KEY_ID"`
 	SecretAccessKey                   string        `koanf:"secretAccessKey" env:"BLOB_SECRET_ACCESS_KEY"`
@@ -35,10 +181,132 @@ type BlobConfig struct {
 	DeleteMarkerCleanupDelayDays      int           `koanf:"deleteMarkerCleanupDelayDays" env:"BLOB_DELETE_MARKER_CLEANUP_DELAY_DAYS"`            // in days, default 1
 	NonCurrentVersionCleanupDelayDays int           `koanf:"nonCurrentVersionCleanupDelayDays" env:"BLOB_NON_CURRENT_VERSION_CLEANUP_DELAY_DAYS"` // in days, default 1
 	BlobOperationTimeout              time.Duration `koanf:"blobOperationTimeout" env:"BLOB_OPERATION_TIMEOUT"`                                   // timeout for blob operations, default 30s
+	// ObjectLockMaxRetentionDays caps the default retention of object lock buckets created through the admin API, default 3650.
+	// A COMPLIANCE retention cannot be shortened or removed by anyone, so a mistyped value would lock data for that long.
+	ObjectLockMaxRetentionDays int `koanf:"objectLockMaxRetentionDays" env:"BLOB_OBJECT_LOCK_MAX_RETENTION_DAYS"`
+	// Replica is the second blob endpoint writes are replicated to. Replication is disabled if its endpoint is empty.
+	Replica BlobReplicaConfig `koanf:"replica"`
+	// Retention are the retention policies, applied as lifecycle rules when their bucket is provisioned. Only configurable via YAML.
+	Retention []RetentionConfig `koanf:"retention"`
+	// Filesystem holds the settings of the BlobProviderFilesystem provider.
+	Filesystem BlobFilesystemConfig `koanf:"filesystem"`
+	// Azure holds the settings of the BlobProviderAzure provider.
+	Azure BlobAzureConfig `koanf:"azure"`
+	// GCS holds the settings of the BlobProviderGCS provider.
+	GCS BlobGCSConfig `koanf:"gcs"`
+}
+
+const (
+	// BlobProviderMinIO stores objects in S3 compatible storage (MinIO, AWS S3...).
+	BlobProviderMinIO = "minio"
+	// BlobProviderFilesystem stores objects in a local directory tree, for development, CI and small edge deployments.
+	BlobProviderFilesystem = "filesystem"
+	// BlobProviderAzure stores objects in Azure Blob Storage, buckets being containers.
+	BlobProviderAzure = "azure"
+	// BlobProviderGCS stores objects in Google Cloud Storage.
+	BlobProviderGCS = "gcs"
+)
+
+// BlobFilesystemConfig holds the settings of the local filesystem storage provider.
+type BlobFilesystemConfig struct {
+	// Root is the directory holding one directory per bucket, default data/blob
+	Root string `koanf:"root" env:"BLOB_FILESYSTEM_ROOT"`
+	// KeepVersions keeps the previous versions and delete markers of objects in a sidecar directory per bucket,
+	// deleted after nonCurrentVersionCleanupDelayDays (deleteMarkerCleanupDelayDays for delete markers) like the lifecycle rules do.
+	// If false, only the current version of an object is kept.
+	KeepVersions bool `koanf:"keepVersions" env:"BLOB_FILESYSTEM_KEEP_VERSIONS"`
+}
+
+// BlobAzureConfig holds the settings of the Azure Blob Storage provider.
+// Either ConnectionString, or AccountName and AccountKey, are required.
+type BlobAzureConfig struct {
+	// ConnectionString is the connection string of the storage account, e.g. the one of the Azurite emulator.
+	// It takes precedence over the account name and key.
+	ConnectionString string `koanf:"connectionString" env:"BLOB_AZURE_CONNECTION_STRING"`
+	AccountName      string `koanf:"accountName" env:"BLOB_AZURE_ACCOUNT_NAME"`
+	AccountKey       string `koanf:"accountKey" env:"BLOB_AZURE_ACCOUNT_KEY"`
+	// Endpoint is the blob service URL used with the account name and key, default https://{accountName}.blob.core.windows.net/
+	Endpoint string `koanf:"endpoint" env:"BLOB_AZURE_ENDPOINT"`
+	// SoftDeleteDays is the number of days permanently deleted blobs and versions can be undeleted, set on the storage account
+	// when buckets are provisioned, default 7
+	SoftDeleteDays int `koanf:"softDeleteDays" env:"BLOB_AZURE_SOFT_DELETE_DAYS"`
+	// Unversioned runs without blob versioning, for the Azurite emulator which has none: buckets are created without checking
+	// versioning, and only the current version of a blob is kept, like blob.filesystem.keepVersions=false.
+	Unversioned bool `koanf:"unversioned" env:"BLOB_AZURE_UNVERSIONED"`
+	// SubscriptionID and ResourceGroup locate the storage account (AccountName) in Azure Resource Manager, where the lifecycle
+	// management policy applying the version cleanup rules and retention policies is set. Azure Resource Manager is
+	// authenticated with the default Azure credential chain (AZURE_CLIENT_ID and AZURE_CLIENT_SECRET, managed identity...).
+	SubscriptionID string `koanf:"subscriptionID" env:"BLOB_AZURE_SUBSCRIPTION_ID"`
+	ResourceGroup  string `koanf:"resourceGroup" env:"BLOB_AZURE_RESOURCE_GROUP"`
+	// ClientVersionCleanup applies the version cleanup rules from Nimbus instead of a lifecycle management policy,
+	// for storage accounts Nimbus cannot manage in Azure Resource Manager.
+	ClientVersionCleanup bool `koanf:"clientVersionCleanup" env:"BLOB_AZURE_CLIENT_VERSION_CLEANUP"`
+	// VersionCleanupInterval is the time between two runs of the version cleanup rules on the buckets owned by this node,
+	// with ClientVersionCleanup, default 1h
+	VersionCleanupInterval time.Duration `koanf:"versionCleanupInterval" env:"BLOB_AZURE_VERSION_CLEANUP_INTERVAL"`
+}
+
+// ManagementPolicies reports whether the lifecycle management policy of the storage account is managed by Nimbus.
+func (c BlobAzureConfig) ManagementPolicies() bool {
+	return c.SubscriptionID != ""
+}
+
+// BlobGCSConfig holds the settings of the Google Cloud Storage provider.
+type BlobGCSConfig struct {
+	// ProjectID is the Google Cloud project buckets are created and listed in. Required.
+	ProjectID string `koanf:"projectID" env:"BLOB_GCS_PROJECT_ID"`
+	// CredentialsFile is the service account key file. If empty, the application default credentials are used.
+	CredentialsFile string `koanf:"credentialsFile" env:"BLOB_GCS_CREDENTIALS_FILE"`
+	// Endpoint is the URL of a GCS compatible server (e.g. fake-gcs-server), requests to it are not authenticated.
+	// Empty uses Google Cloud Storage.
+	Endpoint string `koanf:"endpoint" env:"BLOB_GCS_ENDPOINT"`
+}
+
+// RetentionConfig is a retention policy: objects of a bucket (or of a key prefix) expire once older than Days.
+// Expired objects get a delete marker, and are removed once non-current by the version cleanup rule.
+type RetentionConfig struct {
+	Bucket string `koanf:"bucket"`
+	// Prefix limits the policy to keys starting with it. Empty applies it to the whole bucket.
+	Prefix string `koanf:"prefix"`
+	Days   int    `koanf:"days"`
+}
+
+// BlobReplicaConfig holds the settings of the blob endpoint writes are asynchronously replicated to.
+// The replica can be another provider or account, it only needs the S3 API.
+type BlobReplicaConfig struct {
+	Endpoint        string `koanf:"endpoint" env:"BLOB_REPLICA_ENDPOINT"`
+	AccessKeyID     string `koanf:"accessKeyID" env:"BLOB_REPLICA_ACCESS_KEY_ID"`
+	SecretAccessKey string `koanf:"secretAccessKey" env:"BLOB_REPLICA_SECRET_ACCESS_KEY"`
+	UseSSL          bool   `koanf:"useSSL" env:"BLOB_REPLICA_USE_SSL"`
+	// QueueSize is the number of writes each shard queues for replication. Writes that do not fit are caught up by the next resync. Default 1024.
+	QueueSize int `koanf:"queueSize" env:"BLOB_REPLICA_QUEUE_SIZE"`
+	// RetryDelay is how long a replication worker waits before retrying a write that failed to reach the replica, default 1s
+	RetryDelay time.Duration `koanf:"retryDelay" env:"BLOB_REPLICA_RETRY_DELAY"`
+	// ResyncInterval is how often every bucket is compared with the replica, and missing or different objects are copied. Default 1h.
+	ResyncInterval time.Duration `koanf:"resyncInterval" env:"BLOB_REPLICA_RESYNC_INTERVAL"`
+}
+
+// Enabled reports whether replication is configured.
+func (c BlobReplicaConfig) Enabled() bool {
+	return c.Endpoint != ""
 }
 
 type DbConfig struct {
-	ChannelBufferSize int `koanf:"channelBufferSize" env:"DB_CHANNEL_BUFFER_SIZE"`
+	ChannelBufferSize  int           `koanf:"channelBufferSize" env:"DB_CHANNEL_BUFFER_SIZE"`
+	MaxQueueWait       time.Duration `koanf:"maxQueueWait" env:"DB_MAX_QUEUE_WAIT"`             // max time requests may wait in a shard queue before the shard sheds load, 0 disables, default 0
+	OverloadRetryAfter time.Duration `koanf:"overloadRetryAfter" env:"DB_OVERLOAD_RETRY_AFTER"` // retry-after hint sent with overload responses, default 100ms
+	// InteractiveLaneWeight is the number of interactive (read) requests a shard serves for every bulk (write)
+	// request when both lanes have work. Default 4.
+	InteractiveLaneWeight int `koanf:"interactiveLaneWeight" env:"DB_INTERACTIVE_LANE_WEIGHT"`
+	// AsyncQueueSize is the number of async writes a shard can hold before rejecting more. Default 1024.
+	AsyncQueueSize int `koanf:"asyncQueueSize" env:"DB_ASYNC_QUEUE_SIZE"`
+	// AsyncRetryDelay is the delay before retrying an async write that failed to reach blob storage. Default 1s.
+	AsyncRetryDelay time.Duration `koanf:"asyncRetryDelay" env:"DB_ASYNC_RETRY_DELAY"`
+	// AsyncMaxAttempts is the number of attempts of an async write before it is dead-lettered. Default 5.
+	AsyncMaxAttempts int `koanf:"asyncMaxAttempts" env:"DB_ASYNC_MAX_ATTEMPTS"`
+	// DeadLetterSubject is the subject acknowledged writes that could not be applied to blob storage are published to.
+	// Default {nats.subjectPrefix}.deadletter.
+	DeadLetterSubject string `koanf:"deadLetterSubject" env:"DB_DEAD_LETTER_SUBJECT"`
 }
 
 const (
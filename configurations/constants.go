@@ -21,6 +21,9 @@ const (
 	// DefaultNonCurrentVersionCleanupDelayDays is the default delay in days before non-current versions are cleaned up
 	DefaultNonCurrentVersionCleanupDelayDays int = 1
 
+	// DefaultObjectLockMaxRetentionDays is the default maximum default retention in days of object lock buckets (10 years)
+	DefaultObjectLockMaxRetentionDays int = 3650
+
 	// DefaultNATSSubjectPrefix is the default subject prefix for NATS
 	DefaultNATSSubjectPrefix string = "nimbus"
 
@@ -30,6 +33,72 @@ const (
 	// DefaultDbChannelBufferSize is the default channel buffer size for the database operations
 	DefaultDbChannelBufferSize int = 256
 
+	// DefaultDbOverloadRetryAfter is the default retry-after hint sent to clients when a shard is overloaded
+	DefaultDbOverloadRetryAfter = 100 * time.Millisecond
+
+	// DefaultDbInteractiveLaneWeight is the default number of interactive requests served per bulk request
+	DefaultDbInteractiveLaneWeight int = 4
+
+	// DefaultDbAsyncQueueSize is the default number of async writes a shard can hold
+	DefaultDbAsyncQueueSize int = 1024
+
+	// DefaultDbAsyncRetryDelay is the default delay before retrying an async write that failed to reach blob storage
+	DefaultDbAsyncRetryDelay = time.Second
+
+	// DefaultDbAsyncMaxAttempts is the default number of attempts of an async write before it is dead-lettered
+	DefaultDbAsyncMaxAttempts int = 5
+
+	// DefaultLimitsQuotaRefreshInterval is the default interval at which bucket usage is recounted for quotas
+	DefaultLimitsQuotaRefreshInterval = 5 * time.Minute
+
+	// DefaultCDCStream is the default name of the JetStream stream capturing change events
+	DefaultCDCStream string = "NIMBUS_CDC"
+
+	// DefaultCDCMaxAge is the default retention of the change event stream
+	DefaultCDCMaxAge = 24 * time.Hour
+
+	// DefaultCDCWatchLease is the default time a watch lives without being renewed
+	DefaultCDCWatchLease = 5 * time.Minute
+
+	// DefaultWALStream is the default name of the JetStream stream holding pending writes
+	DefaultWALStream string = "NIMBUS_WAL"
+
+	// DefaultWALRetryDelay is the default delay before retrying a logged write that failed to reach blob storage
+	DefaultWALRetryDelay = time.Second
+
+	// DefaultWALCatchUpTimeout is the default bound of the startup wait for writes left pending by a previous run
+	DefaultWALCatchUpTimeout = 10 * time.Minute
+
+	// DefaultBlobProvider is the default storage backend
+	DefaultBlobProvider string = BlobProviderMinIO
+
+	// DefaultBlobFilesystemRoot is the default directory of the filesystem storage provider
+	DefaultBlobFilesystemRoot string = "data/blob"
+
+	// DefaultBlobAzureSoftDeleteDays is the default number of days deleted Azure blobs can be undeleted
+	DefaultBlobAzureSoftDeleteDays int = 7
+
+	// DefaultBlobAzureVersionCleanupInterval is the default time between two runs of the Azure version cleanup
+	DefaultBlobAzureVersionCleanupInterval = time.Hour
+
+	// DefaultWriteBufferDir is the default directory of the write-behind buffer segment logs
+	DefaultWriteBufferDir string = "data/write-buffer"
+
+	// DefaultWriteBufferSegmentSize is the default size after which a new write-behind buffer segment is started
+	DefaultWriteBufferSegmentSize int64 = 64 << 20
+
+	// DefaultWriteBufferMaxPendingBytes is the default limit of the data size of buffered writes not yet uploaded
+	DefaultWriteBufferMaxPendingBytes int64 = 1 << 30
+
+	// DefaultWriteBufferRetryDelay is the default delay before retrying a buffered write that failed to reach blob storage
+	DefaultWriteBufferRetryDelay = time.Second
+
+	// DefaultScrubInterval is the default time between the start of two integrity scrubs
+	DefaultScrubInterval = 24 * time.Hour
+
+	// DefaultScrubBytesPerSecond is the default rate at which the integrity scrubber re-reads objects
+	DefaultScrubBytesPerSecond int64 = 10 << 20
+
 	// DefaultLogLevel is the default logging level
 	DefaultLogLevel string = LogLevelInfo
 
@@ -42,7 +111,20 @@ const (
 	// DefaultBlobOperationTimeout is the default timeout for blob operations
 	DefaultBlobOperationTimeout = 30 * time.Second
 
+	// DefaultBlobReplicaQueueSize is the default number of writes each shard queues for replication
+	DefaultBlobReplicaQueueSize = 1024
+
+	// DefaultBlobReplicaRetryDelay is the default delay before retrying a write that failed to reach the replica
+	DefaultBlobReplicaRetryDelay = time.Second
+
+	// DefaultBlobReplicaResyncInterval is the default interval between two comparisons of the buckets with the replica
+	DefaultBlobReplicaResyncInterval = time.Hour
+
 	AppName = "NimbusDb"
 
 	SystemHandlersQueueGroup = "common_config_qg"
+
+	AdminHandlersQueueGroup = "admin_qg"
+
+	WatchHandlersQueueGroup = "watch_qg"
 )
@@ -101,12 +101,27 @@ func Load(path string) (*Config, error) {
 	if cfg.HealthPort == 0 {
 		cfg.HealthPort = DefaultHealthPort
 	}
+	if cfg.Blob.Provider == "" {
+		cfg.Blob.Provider = DefaultBlobProvider
+	}
+	if cfg.Blob.Filesystem.Root == "" {
+		cfg.Blob.Filesystem.Root = DefaultBlobFilesystemRoot
+	}
+	if cfg.Blob.Azure.SoftDeleteDays == 0 {
+		cfg.Blob.Azure.SoftDeleteDays = DefaultBlobAzureSoftDeleteDays
+	}
+	if cfg.Blob.Azure.VersionCleanupInterval == 0 {
+		cfg.Blob.Azure.VersionCleanupInterval = DefaultBlobAzureVersionCleanupInterval
+	}
 	if cfg.Blob.DeleteMarkerCleanupDelayDays == 0 {
 		cfg.Blob.DeleteMarkerCleanupDelayDays = DefaultDeleteMarkerCleanupDelayDays
 	}
 	if cfg.Blob.NonCurrentVersionCleanupDelayDays == 0 {
 		cfg.Blob.NonCurrentVersionCleanupDelayDays = DefaultNonCurrentVersionCleanupDelayDays
 	}
+	if cfg.Blob.ObjectLockMaxRetentionDays == 0 {
+		cfg.Blob.ObjectLockMaxRetentionDays = DefaultObjectLockMaxRetentionDays
+	}
 	if cfg.NATS.URL == "" {
 		cfg.NATS.URL = DefaultNATSURL
 	}
@@ -122,12 +137,88 @@ func Load(path string) (*Config, error) {
 	if cfg.Blob.BlobOperationTimeout == 0 {
 		cfg.Blob.BlobOperationTimeout = DefaultBlobOperationTimeout
 	}
+	if cfg.Blob.Replica.QueueSize == 0 {
+		cfg.Blob.Replica.QueueSize = DefaultBlobReplicaQueueSize
+	}
+	if cfg.Blob.Replica.RetryDelay == 0 {
+		cfg.Blob.Replica.RetryDelay = DefaultBlobReplicaRetryDelay
+	}
+	if cfg.Blob.Replica.ResyncInterval == 0 {
+		cfg.Blob.Replica.ResyncInterval = DefaultBlobReplicaResyncInterval
+	}
 	if cfg.Db.ChannelBufferSize == 0 {
 		cfg.Db.ChannelBufferSize = DefaultDbChannelBufferSize
 	}
+	if cfg.Db.OverloadRetryAfter == 0 {
+		cfg.Db.OverloadRetryAfter = DefaultDbOverloadRetryAfter
+	}
+	if cfg.Db.InteractiveLaneWeight == 0 {
+		cfg.Db.InteractiveLaneWeight = DefaultDbInteractiveLaneWeight
+	}
+	if cfg.Db.AsyncQueueSize == 0 {
+		cfg.Db.AsyncQueueSize = DefaultDbAsyncQueueSize
+	}
+	if cfg.Db.AsyncRetryDelay == 0 {
+		cfg.Db.AsyncRetryDelay = DefaultDbAsyncRetryDelay
+	}
+	if cfg.Db.AsyncMaxAttempts == 0 {
+		cfg.Db.AsyncMaxAttempts = DefaultDbAsyncMaxAttempts
+	}
+	if cfg.Db.DeadLetterSubject == "" {
+		cfg.Db.DeadLetterSubject = cfg.NATS.SubjectPrefix + ".deadletter"
+	}
+	if cfg.Limits.QuotaRefreshInterval == 0 {
+		cfg.Limits.QuotaRefreshInterval = DefaultLimitsQuotaRefreshInterval
+	}
+	if cfg.CDC.SubjectPrefix == "" {
+		cfg.CDC.SubjectPrefix = cfg.NATS.SubjectPrefix + ".cdc"
+	}
+	if cfg.CDC.Stream == "" {
+		cfg.CDC.Stream = DefaultCDCStream
+	}
+	if cfg.CDC.MaxAge == 0 {
+		cfg.CDC.MaxAge = DefaultCDCMaxAge
+	}
+	if cfg.CDC.WatchLease == 0 {
+		cfg.CDC.WatchLease = DefaultCDCWatchLease
+	}
+	if cfg.WAL.SubjectPrefix == "" {
+		cfg.WAL.SubjectPrefix = cfg.NATS.SubjectPrefix + ".wal"
+	}
+	if cfg.WAL.Stream == "" {
+		cfg.WAL.Stream = DefaultWALStream
+	}
+	if cfg.WAL.RetryDelay == 0 {
+		cfg.WAL.RetryDelay = DefaultWALRetryDelay
+	}
+	if cfg.WAL.SigningKey == "" {
+		cfg.WAL.SigningKey = cfg.Auth.TokenSecret
+	}
+	if cfg.WAL.CatchUpTimeout == 0 {
+		cfg.WAL.CatchUpTimeout = DefaultWALCatchUpTimeout
+	}
+	if cfg.WriteBuffer.Dir == "" {
+		cfg.WriteBuffer.Dir = DefaultWriteBufferDir
+	}
+	if cfg.WriteBuffer.SegmentSize == 0 {
+		cfg.WriteBuffer.SegmentSize = DefaultWriteBufferSegmentSize
+	}
+	if cfg.WriteBuffer.RetryDelay == 0 {
+		cfg.WriteBuffer.RetryDelay = DefaultWriteBufferRetryDelay
+	}
+	if cfg.WriteBuffer.MaxPendingBytes == 0 {
+		cfg.WriteBuffer.MaxPendingBytes = DefaultWriteBufferMaxPendingBytes
+	}
+	if cfg.Scrub.Interval == 0 {
+		cfg.Scrub.Interval = DefaultScrubInterval
+	}
+	if cfg.Scrub.BytesPerSecond == 0 {
+		cfg.Scrub.BytesPerSecond = DefaultScrubBytesPerSecond
+	}
 	if cfg.LogLevel == "" {
 		cfg.LogLevel = DefaultLogLevel
 	}
+	cfg.Buckets = normalizeBuckets(cfg.Buckets)
 	// 5. Validate configuration
 	if err := validateConfig(cfg); err != nil {
 		return nil, err
@@ -137,18 +228,94 @@ func Load(path string) (*Config, error) {
 	log.Info().Msg("Configuration loaded:")
 	log.Info().Msgf("shardCount: %d", cfg.ShardCount)
 	log.Info().Msgf("healthPort: %d", cfg.HealthPort)
+	log.Info().Msgf("blobProvider: %s", cfg.Blob.Provider)
 	log.Info().Msgf("blobEndpoint: %s", cfg.Blob.Endpoint)
+	log.Info().Msgf("blobFilesystemRoot: %s", cfg.Blob.Filesystem.Root)
+	log.Info().Msgf("blobFilesystemKeepVersions: %t", cfg.Blob.Filesystem.KeepVersions)
+	log.Info().Msgf("blobAzureAccountName: %s", cfg.Blob.Azure.AccountName)
+	log.Info().Msgf("blobAzureEndpoint: %s", cfg.Blob.Azure.Endpoint)
+	log.Info().Msgf("blobAzureSoftDeleteDays: %d", cfg.Blob.Azure.SoftDeleteDays)
+	log.Info().Msgf("blobAzureUnversioned: %t", cfg.Blob.Azure.Unversioned)
+	log.Info().Msgf("blobAzureSubscriptionID: %s", cfg.Blob.Azure.SubscriptionID)
+	log.Info().Msgf("blobAzureResourceGroup: %s", cfg.Blob.Azure.ResourceGroup)
+	log.Info().Msgf("blobAzureClientVersionCleanup: %t", cfg.Blob.Azure.ClientVersionCleanup)
+	log.Info().Msgf("blobAzureVersionCleanupInterval: %s", cfg.Blob.Azure.VersionCleanupInterval)
+	log.Info().Msgf("blobGCSProjectID: %s", cfg.Blob.GCS.ProjectID)
+	log.Info().Msgf("blobGCSEndpoint: %s", cfg.Blob.GCS.Endpoint)
 	log.Info().Msgf("blobUseSSL: %t", cfg.Blob.UseSSL)
 	log.Info().Msgf("blobDeleteMarkerCleanupDelayDays: %d", cfg.Blob.DeleteMarkerCleanupDelayDays)
 	log.Info().Msgf("blobNonCurrentVersionCleanupDelayDays: %d", cfg.Blob.NonCurrentVersionCleanupDelayDays)
+	log.Info().Msgf("blobObjectLockMaxRetentionDays: %d", cfg.Blob.ObjectLockMaxRetentionDays)
+	log.Info().Msgf("blobReplicaEndpoint: %s", cfg.Blob.Replica.Endpoint)
+	log.Info().Msgf("blobReplicaResyncInterval: %s", cfg.Blob.Replica.ResyncInterval)
+	log.Info().Msgf("blobRetention: %d", len(cfg.Blob.Retention))
 	log.Info().Msgf("natsURL: %s", cfg.NATS.URL)
 	log.Info().Msgf("natsSubjectPrefix: %s", cfg.NATS.SubjectPrefix)
+	log.Info().Msgf("natsTrustRequestInfo: %t", cfg.NATS.TrustRequestInfo)
 	log.Info().Msgf("dbChannelBufferSize: %d", cfg.Db.ChannelBufferSize)
+	log.Info().Msgf("dbMaxQueueWait: %s", cfg.Db.MaxQueueWait)
+	log.Info().Msgf("dbOverloadRetryAfter: %s", cfg.Db.OverloadRetryAfter)
+	log.Info().Msgf("dbInteractiveLaneWeight: %d", cfg.Db.InteractiveLaneWeight)
+	log.Info().Msgf("dbAsyncQueueSize: %d", cfg.Db.AsyncQueueSize)
+	log.Info().Msgf("dbAsyncRetryDelay: %s", cfg.Db.AsyncRetryDelay)
+	log.Info().Msgf("dbAsyncMaxAttempts: %d", cfg.Db.AsyncMaxAttempts)
+	log.Info().Msgf("dbDeadLetterSubject: %s", cfg.Db.DeadLetterSubject)
 	log.Info().Msgf("logLevel: %s", cfg.LogLevel)
+	log.Info().Msgf("buckets: %v", cfg.Buckets)
+	log.Info().Msgf("tenants: %d", len(cfg.Tenants))
+	log.Info().Msgf("authGrants: %d", len(cfg.Auth.Grants))
+	log.Info().Msgf("limitsShard: %.0f ops/s, %.0f bytes/s", cfg.Limits.Shard.OpsPerSecond, cfg.Limits.Shard.BytesPerSecond)
+	log.Info().Msgf("limitsRates: %d", len(cfg.Limits.Rates))
+	log.Info().Msgf("limitsQuotas: %d", len(cfg.Limits.Quotas))
+	log.Info().Msgf("limitsQuotaRefreshInterval: %s", cfg.Limits.QuotaRefreshInterval)
+	log.Info().Msgf("cdcEnabled: %t", cfg.CDC.Enabled)
+	log.Info().Msgf("cdcSubjectPrefix: %s", cfg.CDC.SubjectPrefix)
+	log.Info().Msgf("cdcJetStream: %t", cfg.CDC.JetStream)
+	log.Info().Msgf("cdcStream: %s", cfg.CDC.Stream)
+	log.Info().Msgf("cdcMaxAge: %s", cfg.CDC.MaxAge)
+	log.Info().Msgf("cdcWatchLease: %s", cfg.CDC.WatchLease)
+	log.Info().Msgf("walEnabled: %t", cfg.WAL.Enabled)
+	log.Info().Msgf("walSubjectPrefix: %s", cfg.WAL.SubjectPrefix)
+	log.Info().Msgf("walStream: %s", cfg.WAL.Stream)
+	log.Info().Msgf("walRetryDelay: %s", cfg.WAL.RetryDelay)
+	log.Info().Msgf("walSigningKeySet: %t", cfg.WAL.SigningKey != "")
+	log.Info().Msgf("walCatchUpTimeout: %s", cfg.WAL.CatchUpTimeout)
+	log.Info().Msgf("writeBufferEnabled: %t", cfg.WriteBuffer.Enabled)
+	log.Info().Msgf("writeBufferDir: %s", cfg.WriteBuffer.Dir)
+	log.Info().Msgf("writeBufferSegmentSize: %d", cfg.WriteBuffer.SegmentSize)
+	log.Info().Msgf("writeBufferRetryDelay: %s", cfg.WriteBuffer.RetryDelay)
+	log.Info().Msgf("writeBufferMaxPendingBytes: %d", cfg.WriteBuffer.MaxPendingBytes)
+	log.Info().Msgf("scrubEnabled: %t", cfg.Scrub.Enabled)
+	log.Info().Msgf("scrubInterval: %s", cfg.Scrub.Interval)
+	log.Info().Msgf("scrubBytesPerSecond: %d", cfg.Scrub.BytesPerSecond)
 
 	return cfg, nil
 }
 
+// subjectsOverlap returns true if one subject prefix is equal to, or nested in, the other.
+// JetStream streams cannot capture overlapping subjects.
+func subjectsOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// normalizeBuckets splits comma separated entries (the env var arrives as a single string),
+// trims the bucket names and drops empty and duplicate entries, so that "a, b," behaves like [a b].
+func normalizeBuckets(buckets []string) []string {
+	result := make([]string, 0, len(buckets))
+	seen := make(map[string]bool, len(buckets))
+	for _, entry := range buckets {
+		for _, b := range strings.Split(entry, ",") {
+			b = strings.TrimSpace(b)
+			if b == "" || seen[b] {
+				continue
+			}
+			seen[b] = true
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
 // validateConfig validates the configuration values.
 func validateConfig(cfg *Config) error {
 	// Validate health port range (1-65535)
@@ -164,6 +331,151 @@ func validateConfig(cfg *Config) error {
 	if cfg.Blob.NonCurrentVersionCleanupDelayDays < 1 || cfg.Blob.NonCurrentVersionCleanupDelayDays > maxLifecycleDays {
 		return fmt.Errorf("non-current version cleanup delay days must be between 1 and %d, got %d", maxLifecycleDays, cfg.Blob.NonCurrentVersionCleanupDelayDays)
 	}
+	if cfg.Blob.ObjectLockMaxRetentionDays < 1 {
+		return fmt.Errorf("object lock max retention days must be at least 1, got %d", cfg.Blob.ObjectLockMaxRetentionDays)
+	}
+
+	// Validate blob provider
+	switch cfg.Blob.Provider {
+	case BlobProviderMinIO, BlobProviderGCS:
+	case BlobProviderFilesystem, BlobProviderAzure:
+		// Retention policies are applied by the lifecycle rules of the storage provider, Azure ones by its management policy
+		if len(cfg.Blob.Retention) > 0 && !cfg.Blob.Azure.ManagementPolicies() {
+			return fmt.Errorf("blob retention policies are not supported by the %s blob provider", cfg.Blob.Provider)
+		}
+	default:
+		return fmt.Errorf("blob provider must be %s, %s, %s or %s, got %s", BlobProviderMinIO, BlobProviderFilesystem, BlobProviderAzure, BlobProviderGCS, cfg.Blob.Provider)
+	}
+	if cfg.Blob.Provider == BlobProviderAzure {
+		if err := validateAzure(cfg.Blob.Azure); err != nil {
+			return err
+		}
+	}
+	if cfg.Blob.Provider == BlobProviderGCS && cfg.Blob.GCS.ProjectID == "" {
+		return fmt.Errorf("blob gcs project ID is required")
+	}
+	if cfg.Blob.Azure.SoftDeleteDays < 1 || cfg.Blob.Azure.SoftDeleteDays > maxLifecycleDays {
+		return fmt.Errorf("blob azure soft delete days must be between 1 and %d, got %d", maxLifecycleDays, cfg.Blob.Azure.SoftDeleteDays)
+	}
+	if cfg.Blob.Azure.VersionCleanupInterval < 0 {
+		return fmt.Errorf("blob azure version cleanup interval cannot be negative, got %s", cfg.Blob.Azure.VersionCleanupInterval)
+	}
+
+	// Validate blob replication settings
+	if cfg.Blob.Replica.Enabled() {
+		if cfg.Blob.Replica.AccessKeyID == "" || cfg.Blob.Replica.SecretAccessKey == "" {
+			return fmt.Errorf("blob replica access key ID and secret access key are required")
+		}
+		// The same endpoint is fine with another account, but not with the same one (buckets would be replicated onto themselves)
+		if cfg.Blob.Replica.Endpoint == cfg.Blob.Endpoint && cfg.Blob.Replica.AccessKeyID == cfg.Blob.AccessKeyID {
+			return fmt.Errorf("blob replica must be another endpoint or account than the blob endpoint: %s", cfg.Blob.Replica.Endpoint)
+		}
+	}
+	if cfg.Blob.Replica.QueueSize < 1 {
+		return fmt.Errorf("blob replica queue size must be at least 1, got %d", cfg.Blob.Replica.QueueSize)
+	}
+	if cfg.Blob.Replica.RetryDelay < 0 {
+		return fmt.Errorf("blob replica retry delay cannot be negative, got %s", cfg.Blob.Replica.RetryDelay)
+	}
+	if cfg.Blob.Replica.ResyncInterval < 0 {
+		return fmt.Errorf("blob replica resync interval cannot be negative, got %s", cfg.Blob.Replica.ResyncInterval)
+	}
+
+	// Validate retention policies
+	if err := validateRetention(cfg.Blob.Retention, cfg.Buckets); err != nil {
+		return err
+	}
+
+	// Validate shard queue settings
+	if cfg.Db.MaxQueueWait < 0 {
+		return fmt.Errorf("db max queue wait cannot be negative, got %s", cfg.Db.MaxQueueWait)
+	}
+	if cfg.Db.OverloadRetryAfter < 0 {
+		return fmt.Errorf("db overload retry after cannot be negative, got %s", cfg.Db.OverloadRetryAfter)
+	}
+	if cfg.Db.InteractiveLaneWeight < 1 {
+		return fmt.Errorf("db interactive lane weight must be at least 1, got %d", cfg.Db.InteractiveLaneWeight)
+	}
+	if cfg.Db.AsyncQueueSize < 1 {
+		return fmt.Errorf("db async queue size must be at least 1, got %d", cfg.Db.AsyncQueueSize)
+	}
+	if cfg.Db.AsyncRetryDelay < 0 {
+		return fmt.Errorf("db async retry delay cannot be negative, got %s", cfg.Db.AsyncRetryDelay)
+	}
+	if cfg.Db.AsyncMaxAttempts < 1 {
+		return fmt.Errorf("db async max attempts must be at least 1, got %d", cfg.Db.AsyncMaxAttempts)
+	}
+	if strings.ContainsAny(cfg.Db.DeadLetterSubject, " *>") {
+		return fmt.Errorf("db dead letter subject cannot contain spaces or wildcards: %s", cfg.Db.DeadLetterSubject)
+	}
+
+	// Validate tenants
+	if err := validateTenants(cfg.Tenants); err != nil {
+		return err
+	}
+
+	// Validate authorization grants
+	if err := validateAuthConfig(&cfg.Auth); err != nil {
+		return err
+	}
+
+	// Validate rate limits and quotas
+	if err := validateLimitsConfig(&cfg.Limits); err != nil {
+		return err
+	}
+
+	// Validate change data capture
+	if cfg.CDC.MaxAge < 0 {
+		return fmt.Errorf("cdc max age cannot be negative, got %s", cfg.CDC.MaxAge)
+	}
+	if cfg.CDC.WatchLease < 0 {
+		return fmt.Errorf("cdc watch lease cannot be negative, got %s", cfg.CDC.WatchLease)
+	}
+	if strings.ContainsAny(cfg.CDC.SubjectPrefix, " *>") {
+		return fmt.Errorf("cdc subject prefix cannot contain spaces or wildcards: %s", cfg.CDC.SubjectPrefix)
+	}
+
+	// Validate write-ahead log
+	if cfg.WAL.RetryDelay < 0 {
+		return fmt.Errorf("wal retry delay cannot be negative, got %s", cfg.WAL.RetryDelay)
+	}
+	if cfg.WAL.CatchUpTimeout < 0 {
+		return fmt.Errorf("wal catch up timeout cannot be negative, got %s", cfg.WAL.CatchUpTimeout)
+	}
+	if cfg.WAL.Enabled && cfg.WAL.SigningKey == "" {
+		return fmt.Errorf("wal requires a signing key (wal.signingKey or auth.tokenSecret), logged writes are not applied without a valid signature")
+	}
+	if strings.ContainsAny(cfg.WAL.SubjectPrefix, " *>") {
+		return fmt.Errorf("wal subject prefix cannot contain spaces or wildcards: %s", cfg.WAL.SubjectPrefix)
+	}
+	if cfg.WAL.Enabled && cfg.CDC.Enabled && cfg.CDC.JetStream && subjectsOverlap(cfg.WAL.SubjectPrefix, cfg.CDC.SubjectPrefix) {
+		return fmt.Errorf("wal subject prefix %s and cdc subject prefix %s cannot overlap", cfg.WAL.SubjectPrefix, cfg.CDC.SubjectPrefix)
+	}
+	if cfg.WAL.Enabled && cfg.CDC.Enabled && cfg.CDC.JetStream && cfg.WAL.Stream == cfg.CDC.Stream {
+		return fmt.Errorf("wal stream and cdc stream cannot have the same name: %s", cfg.WAL.Stream)
+	}
+
+	// Validate write-behind buffer
+	if cfg.WriteBuffer.SegmentSize < 0 {
+		return fmt.Errorf("write buffer segment size cannot be negative, got %d", cfg.WriteBuffer.SegmentSize)
+	}
+	if cfg.WriteBuffer.RetryDelay < 0 {
+		return fmt.Errorf("write buffer retry delay cannot be negative, got %s", cfg.WriteBuffer.RetryDelay)
+	}
+	if cfg.WriteBuffer.MaxPendingBytes < 0 {
+		return fmt.Errorf("write buffer max pending bytes cannot be negative, got %d", cfg.WriteBuffer.MaxPendingBytes)
+	}
+	if cfg.WriteBuffer.Enabled && cfg.WAL.Enabled {
+		return fmt.Errorf("write buffer and wal cannot both be enabled")
+	}
+
+	// Validate integrity scrubber
+	if cfg.Scrub.Interval < 0 {
+		return fmt.Errorf("scrub interval cannot be negative, got %s", cfg.Scrub.Interval)
+	}
+	if cfg.Scrub.BytesPerSecond < 0 {
+		return fmt.Errorf("scrub bytes per second cannot be negative, got %d", cfg.Scrub.BytesPerSecond)
+	}
 
 	// Validate log level
 	if err := validateLogLevel(cfg.LogLevel); err != nil {
@@ -216,6 +528,160 @@ func validateLogLevel(level string) error {
 	return fmt.Errorf("invalid log level '%s': must be one of %s", level, strings.Join(validLevels, ", "))
 }
 
+// validateTenants validates the tenant namespaces.
+// Tenant names and NATS users must be unique, and every tenant needs at least one bucket or a bucket prefix.
+func validateTenants(tenants []TenantConfig) error {
+	names := make(map[string]bool, len(tenants))
+	natsUsers := make(map[string]string)
+	for _, t := range tenants {
+		if t.Name == "" {
+			return fmt.Errorf("tenant name cannot be empty")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate tenant name: %s", t.Name)
+		}
+		names[t.Name] = true
+
+		if len(t.Buckets) == 0 && t.BucketPrefix == "" {
+			return fmt.Errorf("tenant %s must have buckets or a bucket prefix", t.Name)
+		}
+		if strings.ContainsAny(t.WatchSubjectPrefix, " \t*>") {
+			return fmt.Errorf("tenant %s watch subject prefix cannot contain spaces or wildcards: %s", t.Name, t.WatchSubjectPrefix)
+		}
+		for _, user := range t.NatsUsers {
+			if other, ok := natsUsers[user]; ok {
+				return fmt.Errorf("NATS user %s is mapped to both tenant %s and %s", user, other, t.Name)
+			}
+			natsUsers[user] = t.Name
+		}
+	}
+	return nil
+}
+
+// validateAuthConfig validates the authorization grants.
+// Every grant needs principals, a bucket (or "*") and known actions.
+func validateAuthConfig(cfg *AuthConfig) error {
+	for i, g := range cfg.Grants {
+		if len(g.Principals) == 0 {
+			return fmt.Errorf("auth grant %d must have at least one principal", i)
+		}
+		if g.Bucket == "" {
+			return fmt.Errorf("auth grant %d must have a bucket (use \"*\" for any bucket)", i)
+		}
+		if len(g.Actions) == 0 {
+			return fmt.Errorf("auth grant %d must have at least one action", i)
+		}
+		for _, action := range g.Actions {
+			switch strings.ToLower(action) {
+			case "read", "write", "delete", "admin":
+			default:
+				return fmt.Errorf("auth grant %d has unknown action %q, must be one of: read, write, delete, admin", i, action)
+			}
+		}
+	}
+	return nil
+}
+
+// validateLimitsConfig validates the rate limits and quotas.
+// Rates and quotas cannot be negative, every rate targets exactly one tenant or bucket,
+// and a tenant or bucket can only have one rate limit and one quota.
+func validateLimitsConfig(cfg *LimitsConfig) error {
+	if cfg.Shard.OpsPerSecond < 0 || cfg.Shard.BytesPerSecond < 0 {
+		return fmt.Errorf("shard rate limit cannot be negative")
+	}
+
+	seen := make(map[string]bool, len(cfg.Rates))
+	for i, r := range cfg.Rates {
+		if (r.Tenant == "") == (r.Bucket == "") {
+			return fmt.Errorf("rate limit %d must have exactly one of tenant or bucket", i)
+		}
+		if r.OpsPerSecond < 0 || r.BytesPerSecond < 0 {
+			return fmt.Errorf("rate limit %d cannot be negative", i)
+		}
+		key := "tenant:" + r.Tenant
+		if r.Bucket != "" {
+			key = "bucket:" + r.Bucket
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate rate limit for %s", key)
+		}
+		seen[key] = true
+	}
+
+	if cfg.QuotaRefreshInterval < 0 {
+		return fmt.Errorf("quota refresh interval cannot be negative, got %s", cfg.QuotaRefreshInterval)
+	}
+	buckets := make(map[string]bool, len(cfg.Quotas))
+	for i, q := range cfg.Quotas {
+		if q.Bucket == "" {
+			return fmt.Errorf("quota %d must have a bucket", i)
+		}
+		if q.MaxBytes < 0 || q.MaxObjects < 0 {
+			return fmt.Errorf("quota of bucket %s cannot be negative", q.Bucket)
+		}
+		if buckets[q.Bucket] {
+			return fmt.Errorf("duplicate quota for bucket %s", q.Bucket)
+		}
+		buckets[q.Bucket] = true
+	}
+	return nil
+}
+
+// validateAzure validates the settings of the Azure provider.
+// The version cleanup rules must be applied by exactly one of a lifecycle management policy and Nimbus, unless blobs are unversioned.
+func validateAzure(azure BlobAzureConfig) error {
+	if azure.ConnectionString == "" && (azure.AccountName == "" || azure.AccountKey == "") {
+		return fmt.Errorf("blob azure connection string, or account name and account key, are required")
+	}
+	if azure.ManagementPolicies() && (azure.ResourceGroup == "" || azure.AccountName == "") {
+		return fmt.Errorf("blob azure resource group and account name are required with a subscription ID")
+	}
+	if azure.ResourceGroup != "" && !azure.ManagementPolicies() {
+		return fmt.Errorf("blob azure subscription ID is required with a resource group")
+	}
+	if azure.ManagementPolicies() && azure.ClientVersionCleanup {
+		return fmt.Errorf("blob azure client version cleanup cannot be enabled with a subscription ID, versions are cleaned by the lifecycle management policy")
+	}
+	if azure.Unversioned && azure.ClientVersionCleanup {
+		return fmt.Errorf("blob azure client version cleanup cannot be enabled on unversioned blobs")
+	}
+	if !azure.Unversioned && !azure.ManagementPolicies() && !azure.ClientVersionCleanup {
+		return fmt.Errorf("blob azure subscription ID and resource group are required to set the lifecycle management policy, " +
+			"or enable clientVersionCleanup to clean versions from Nimbus, or unversioned for Azurite")
+	}
+	return nil
+}
+
+// validateRetention validates the retention policies.
+// Every policy targets a provisioned bucket, so its lifecycle rule is applied on startup, and keeps objects at least a day.
+// Prefixes of a bucket cannot overlap: lifecycle rules all apply, so the shortest retention would win.
+func validateRetention(policies []RetentionConfig, buckets []string) error {
+	provisioned := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		provisioned[b] = true
+	}
+
+	prefixes := make(map[string][]string)
+	for i, p := range policies {
+		if p.Bucket == "" {
+			return fmt.Errorf("retention policy %d must have a bucket", i)
+		}
+		if !provisioned[p.Bucket] {
+			return fmt.Errorf("retention bucket %s must be listed in buckets, so its lifecycle rule is applied on startup", p.Bucket)
+		}
+		if p.Days < 1 {
+			return fmt.Errorf("retention days of bucket %s must be at least 1, got %d", p.Bucket, p.Days)
+		}
+		for _, other := range prefixes[p.Bucket] {
+			if strings.HasPrefix(p.Prefix, other) || strings.HasPrefix(other, p.Prefix) {
+				return fmt.Errorf("retention prefixes %q and %q of bucket %s overlap", other, p.Prefix, p.Bucket)
+			}
+		}
+		prefixes[p.Bucket] = append(prefixes[p.Bucket], p.Prefix)
+	}
+	return nil
+}
+
 // validateNATSConfig validates the NATS configuration values.
 func validateNATSConfig(cfg *NATSConfig) error {
 	// SubjectPrefix must be a valid NATS subject prefix
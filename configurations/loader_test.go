@@ -3,7 +3,9 @@ package configurations
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad_FromYAML(t *testing.T) {
@@ -497,3 +499,448 @@ func TestLoad_LogLevelDefault(t *testing.T) {
 		t.Errorf("Expected LogLevel to default to %s, got %s", DefaultLogLevel, cfg.LogLevel)
 	}
 }
+
+func TestLoad_DbShardQueueSettings(t *testing.T) {
+	// Test that overload settings default and can be set via YAML file
+	tmpDir := t.TempDir()
+	yamlFile := filepath.Join(tmpDir, "test_config.yml")
+	yamlContent := `shardCount: 5
+db:
+  maxQueueWait: 250ms`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	// Load config
+	cfg, err := Load(yamlFile)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Db.MaxQueueWait != 250*time.Millisecond {
+		t.Errorf("Expected MaxQueueWait to be 250ms, got %s", cfg.Db.MaxQueueWait)
+	}
+	if cfg.Db.OverloadRetryAfter != DefaultDbOverloadRetryAfter {
+		t.Errorf("Expected OverloadRetryAfter to default to %s, got %s", DefaultDbOverloadRetryAfter, cfg.Db.OverloadRetryAfter)
+	}
+}
+
+func TestLoad_DbNegativeMaxQueueWait(t *testing.T) {
+	// Test that a negative max queue wait fails validation
+	tmpDir := t.TempDir()
+	yamlFile := filepath.Join(tmpDir, "test_config.yml")
+	yamlContent := `shardCount: 5
+db:
+  maxQueueWait: -1s`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	cfg, err := Load(yamlFile)
+	if err == nil {
+		t.Error("Load() should have failed with negative maxQueueWait, but didn't")
+	}
+	if cfg != nil {
+		t.Error("Load() should return nil config on error")
+	}
+}
+
+func TestLoad_BucketsFromEnv(t *testing.T) {
+	// Test that buckets can be given as a comma separated env var
+	os.Setenv("BUCKETS", "orders, users,,orders")
+	defer os.Unsetenv("BUCKETS")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(cfg.Buckets) != 2 || cfg.Buckets[0] != "orders" || cfg.Buckets[1] != "users" {
+		t.Errorf("Expected buckets [orders users], got %v", cfg.Buckets)
+	}
+}
+
+func TestLoad_Tenants(t *testing.T) {
+	tmpDir := t.TempDir()
+	yamlFile := filepath.Join(tmpDir, "test_config.yml")
+	yamlContent := `tenants:
+  - name: acme
+    buckets: [acme-orders]
+    natsUsers: [acme-svc]
+  - name: globex
+    bucketPrefix: globex-`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	cfg, err := Load(yamlFile)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(cfg.Tenants) != 2 {
+		t.Fatalf("Expected 2 tenants, got %d", len(cfg.Tenants))
+	}
+	if cfg.Tenants[0].NatsUsers[0] != "acme-svc" || cfg.Tenants[1].BucketPrefix != "globex-" {
+		t.Errorf("Expected tenants to be loaded from YAML, got %+v", cfg.Tenants)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []TenantConfig
+		wantErr bool
+	}{
+		{"valid", []TenantConfig{{Name: "a", Buckets: []string{"a-1"}}, {Name: "b", BucketPrefix: "b-"}}, false},
+		{"empty name", []TenantConfig{{Buckets: []string{"a-1"}}}, true},
+		{"duplicate name", []TenantConfig{{Name: "a", BucketPrefix: "a-"}, {Name: "a", BucketPrefix: "b-"}}, true},
+		{"no namespace", []TenantConfig{{Name: "a"}}, true},
+		{"shared NATS user", []TenantConfig{{Name: "a", BucketPrefix: "a-", NatsUsers: []string{"svc"}}, {Name: "b", BucketPrefix: "b-", NatsUsers: []string{"svc"}}}, true},
+		{"watch subject prefix", []TenantConfig{{Name: "a", BucketPrefix: "a-", WatchSubjectPrefix: "a.watches"}}, false},
+		{"wildcard watch subject prefix", []TenantConfig{{Name: "a", BucketPrefix: "a-", WatchSubjectPrefix: "a.*"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTenants(tt.tenants)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_AuthGrants(t *testing.T) {
+	tmpDir := t.TempDir()
+	yamlFile := filepath.Join(tmpDir, "test_config.yml")
+	yamlContent := `auth:
+  grants:
+    - principals: [orders-svc]
+      bucket: orders
+      actions: [read, write]
+    - principals: [ops]
+      bucket: "*"
+      actions: [purge]`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	_, err := Load(yamlFile)
+	if err == nil {
+		t.Fatal("Expected Load() to fail on unknown action")
+	}
+	if !strings.Contains(err.Error(), "purge") {
+		t.Errorf("Expected error to mention the unknown action, got %v", err)
+	}
+}
+
+func TestValidateLimitsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     LimitsConfig
+		wantErr bool
+	}{
+		{"empty", LimitsConfig{}, false},
+		{"valid", LimitsConfig{
+			Shard:  RateLimitConfig{OpsPerSecond: 100},
+			Rates:  []RateLimitConfig{{Tenant: "acme", OpsPerSecond: 10}, {Bucket: "orders", BytesPerSecond: 1 << 20}},
+			Quotas: []QuotaConfig{{Bucket: "orders", MaxBytes: 1 << 30}},
+		}, false},
+		{"negative shard rate", LimitsConfig{Shard: RateLimitConfig{OpsPerSecond: -1}}, true},
+		{"rate without target", LimitsConfig{Rates: []RateLimitConfig{{OpsPerSecond: 1}}}, true},
+		{"rate with both targets", LimitsConfig{Rates: []RateLimitConfig{{Tenant: "acme", Bucket: "orders", OpsPerSecond: 1}}}, true},
+		{"duplicate rate", LimitsConfig{Rates: []RateLimitConfig{{Bucket: "orders", OpsPerSecond: 1}, {Bucket: "orders", OpsPerSecond: 2}}}, true},
+		{"quota without bucket", LimitsConfig{Quotas: []QuotaConfig{{MaxBytes: 1}}}, true},
+		{"negative quota", LimitsConfig{Quotas: []QuotaConfig{{Bucket: "orders", MaxObjects: -1}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLimitsConfig(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSubjectsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"nimbus.wal", "nimbus.cdc", false},
+		{"nimbus.wal", "nimbus.wal", true},
+		{"nimbus.wal", "nimbus.wal.orders", true},
+		{"nimbus.cdc.x", "nimbus.cdc", true},
+		{"nimbus.wal", "nimbus.walx", false},
+	}
+
+	for _, tt := range tests {
+		if overlap := subjectsOverlap(tt.a, tt.b); overlap != tt.expected {
+			t.Errorf("Expected subjectsOverlap(%s, %s) to be %t, got %t", tt.a, tt.b, tt.expected, overlap)
+		}
+	}
+}
+
+func TestLoad_WriteBufferAndWALExclusive(t *testing.T) {
+	tmpDir := t.TempDir()
+	yamlFile := filepath.Join(tmpDir, "test_config.yml")
+	yamlContent := `shardCount: 5
+wal:
+  enabled: true
+  signingKey: secret
+writeBuffer:
+  enabled: true`
+	if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	if _, err := Load(yamlFile); err == nil {
+		t.Error("Load() should have failed with both the write buffer and the wal enabled, but didn't")
+	}
+}
+
+func TestLoad_WALSigningKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		expectError bool
+		expectedKey string
+	}{
+		{"no key", "shardCount: 5\nwal:\n  enabled: true", true, ""},
+		{"signing key", "shardCount: 5\nwal:\n  enabled: true\n  signingKey: wal-secret", false, "wal-secret"},
+		{"auth token secret", "shardCount: 5\nauth:\n  tokenSecret: token-secret\nwal:\n  enabled: true", false, "token-secret"},
+		{"disabled", "shardCount: 5", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+			if err := os.WriteFile(yamlFile, []byte(tt.yaml), 0644); err != nil {
+				t.Fatalf("Failed to create test YAML file: %v", err)
+			}
+
+			cfg, err := Load(yamlFile)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Load() should have failed, but didn't")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			if cfg.WAL.SigningKey != tt.expectedKey {
+				t.Errorf("Expected signing key %q, got %q", tt.expectedKey, cfg.WAL.SigningKey)
+			}
+			if cfg.WAL.CatchUpTimeout != DefaultWALCatchUpTimeout {
+				t.Errorf("Expected catch up timeout %s, got %s", DefaultWALCatchUpTimeout, cfg.WAL.CatchUpTimeout)
+			}
+		})
+	}
+}
+
+func TestLoad_WriteBufferDefaults(t *testing.T) {
+	tmpDir := t.TempDir()
+	yamlFile := filepath.Join(tmpDir, "test_config.yml")
+	if err := os.WriteFile(yamlFile, []byte("shardCount: 5"), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	cfg, err := Load(yamlFile)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.WriteBuffer.Dir != DefaultWriteBufferDir {
+		t.Errorf("Expected write buffer dir to be %s, got %s", DefaultWriteBufferDir, cfg.WriteBuffer.Dir)
+	}
+	if cfg.WriteBuffer.SegmentSize != DefaultWriteBufferSegmentSize {
+		t.Errorf("Expected write buffer segment size to be %d, got %d", DefaultWriteBufferSegmentSize, cfg.WriteBuffer.SegmentSize)
+	}
+}
+
+func TestLoad_Retention(t *testing.T) {
+	tests := []struct {
+		name        string
+		yamlContent string
+		expectError bool
+	}{
+		{"valid", "shardCount: 5\nbuckets: [audit]\nblob:\n  retention:\n    - bucket: audit\n      prefix: logs/\n      days: 2555\n    - bucket: audit\n      prefix: feeds/\n      days: 90", false},
+		{"bucket not provisioned", "shardCount: 5\nblob:\n  retention:\n    - bucket: audit\n      days: 90", true},
+		{"missing bucket", "shardCount: 5\nblob:\n  retention:\n    - days: 90", true},
+		{"missing days", "shardCount: 5\nbuckets: [audit]\nblob:\n  retention:\n    - bucket: audit", true},
+		{"overlapping prefixes", "shardCount: 5\nbuckets: [audit]\nblob:\n  retention:\n    - bucket: audit\n      days: 90\n    - bucket: audit\n      prefix: logs/\n      days: 2555", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+			if err := os.WriteFile(yamlFile, []byte(tt.yamlContent), 0644); err != nil {
+				t.Fatalf("Failed to create test YAML file: %v", err)
+			}
+
+			_, err := Load(yamlFile)
+			if tt.expectError && err == nil {
+				t.Error("Load() should have failed, but didn't")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Load() failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_CDCDefaults(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+	if err := os.WriteFile(yamlFile, []byte("shardCount: 5\ncdc:\n  enabled: true"), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	cfg, err := Load(yamlFile)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.CDC.MaxAge != DefaultCDCMaxAge {
+		t.Errorf("Expected cdc max age to be %s, got %s", DefaultCDCMaxAge, cfg.CDC.MaxAge)
+	}
+	if cfg.CDC.WatchLease != DefaultCDCWatchLease {
+		t.Errorf("Expected cdc watch lease to be %s, got %s", DefaultCDCWatchLease, cfg.CDC.WatchLease)
+	}
+}
+
+func TestLoad_ScrubDefaults(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+	if err := os.WriteFile(yamlFile, []byte("shardCount: 5\nscrub:\n  enabled: true"), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	cfg, err := Load(yamlFile)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Scrub.Interval != DefaultScrubInterval {
+		t.Errorf("Expected scrub interval to be %s, got %s", DefaultScrubInterval, cfg.Scrub.Interval)
+	}
+	if cfg.Scrub.BytesPerSecond != DefaultScrubBytesPerSecond {
+		t.Errorf("Expected scrub bytes per second to be %d, got %d", DefaultScrubBytesPerSecond, cfg.Scrub.BytesPerSecond)
+	}
+}
+
+func TestLoad_ScrubNegativeInterval(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+	if err := os.WriteFile(yamlFile, []byte("shardCount: 5\nscrub:\n  interval: -1h"), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	if _, err := Load(yamlFile); err == nil {
+		t.Error("Load() should have failed with a negative scrub interval, but didn't")
+	}
+}
+
+func TestLoad_BlobReplica(t *testing.T) {
+	tests := []struct {
+		name        string
+		yamlContent string
+		expectError bool
+	}{
+		{"disabled", "shardCount: 5", false},
+		{"other endpoint", "shardCount: 5\nblob:\n  endpoint: minio:9000\n  accessKeyID: a\n  replica:\n    endpoint: backup:9000\n    accessKeyID: b\n    secretAccessKey: s", false},
+		{"same endpoint other account", "shardCount: 5\nblob:\n  endpoint: minio:9000\n  accessKeyID: a\n  replica:\n    endpoint: minio:9000\n    accessKeyID: b\n    secretAccessKey: s", false},
+		{"same endpoint and account", "shardCount: 5\nblob:\n  endpoint: minio:9000\n  accessKeyID: a\n  replica:\n    endpoint: minio:9000\n    accessKeyID: a\n    secretAccessKey: s", true},
+		{"missing credentials", "shardCount: 5\nblob:\n  replica:\n    endpoint: backup:9000", true},
+		{"negative resync interval", "shardCount: 5\nblob:\n  replica:\n    resyncInterval: -1m", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+			if err := os.WriteFile(yamlFile, []byte(tt.yamlContent), 0644); err != nil {
+				t.Fatalf("Failed to create test YAML file: %v", err)
+			}
+
+			cfg, err := Load(yamlFile)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Load() should have failed, but didn't")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			if cfg.Blob.Replica.ResyncInterval != DefaultBlobReplicaResyncInterval {
+				t.Errorf("Expected resync interval %s, got %s", DefaultBlobReplicaResyncInterval, cfg.Blob.Replica.ResyncInterval)
+			}
+		})
+	}
+}
+
+func TestLoad_BlobProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		yamlContent string
+		expectError bool
+	}{
+		{"default", "shardCount: 5", false},
+		{"filesystem", "shardCount: 5\nblob:\n  provider: filesystem\n  filesystem:\n    root: /var/lib/nimbus\n    keepVersions: true", false},
+		{"unknown provider", "shardCount: 5\nblob:\n  provider: ftp", true},
+		{"filesystem with retention", "shardCount: 5\nbuckets: [audit]\nblob:\n  provider: filesystem\n  retention:\n    - bucket: audit\n      days: 90", true},
+		{"azure with connection string", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    connectionString: UseDevelopmentStorage=true\n    unversioned: true", false},
+		{"azure with management policies", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    accountKey: c2VjcmV0\n    subscriptionID: sub\n    resourceGroup: rg", false},
+		{"azure with client version cleanup", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    accountKey: c2VjcmV0\n    clientVersionCleanup: true", false},
+		{"azure without version cleanup", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    accountKey: c2VjcmV0", true},
+		{"azure with both version cleanups", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    accountKey: c2VjcmV0\n    subscriptionID: sub\n    resourceGroup: rg\n    clientVersionCleanup: true", true},
+		{"azure subscription without resource group", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    accountKey: c2VjcmV0\n    subscriptionID: sub", true},
+		{"azure unversioned with client version cleanup", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    connectionString: UseDevelopmentStorage=true\n    unversioned: true\n    clientVersionCleanup: true", true},
+		{"azure without credentials", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    unversioned: true", true},
+		{"azure with invalid soft delete days", "shardCount: 5\nblob:\n  provider: azure\n  azure:\n    connectionString: UseDevelopmentStorage=true\n    unversioned: true\n    softDeleteDays: 400", true},
+		{"azure with retention", "shardCount: 5\nbuckets: [audit]\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    accountKey: c2VjcmV0\n    clientVersionCleanup: true\n  retention:\n    - bucket: audit\n      days: 90", true},
+		{"azure with retention and management policies", "shardCount: 5\nbuckets: [audit]\nblob:\n  provider: azure\n  azure:\n    accountName: nimbus\n    accountKey: c2VjcmV0\n    subscriptionID: sub\n    resourceGroup: rg\n  retention:\n    - bucket: audit\n      days: 90", false},
+		{"gcs with project ID", "shardCount: 5\nblob:\n  provider: gcs\n  gcs:\n    projectID: nimbus\n    endpoint: http://localhost:4443/storage/v1/", false},
+		{"gcs without project ID", "shardCount: 5\nblob:\n  provider: gcs", true},
+		{"gcs with retention", "shardCount: 5\nbuckets: [audit]\nblob:\n  provider: gcs\n  gcs:\n    projectID: nimbus\n  retention:\n    - bucket: audit\n      days: 90", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+			if err := os.WriteFile(yamlFile, []byte(tt.yamlContent), 0644); err != nil {
+				t.Fatalf("Failed to create test YAML file: %v", err)
+			}
+
+			_, err := Load(yamlFile)
+			if tt.expectError && err == nil {
+				t.Error("Load() should have failed, but didn't")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Load() failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_BlobProviderDefaults(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "test_config.yml")
+	if err := os.WriteFile(yamlFile, []byte("shardCount: 5"), 0644); err != nil {
+		t.Fatalf("Failed to create test YAML file: %v", err)
+	}
+
+	cfg, err := Load(yamlFile)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Blob.Provider != DefaultBlobProvider {
+		t.Errorf("Expected blob provider to be %s, got %s", DefaultBlobProvider, cfg.Blob.Provider)
+	}
+	if cfg.Blob.Filesystem.Root != DefaultBlobFilesystemRoot {
+		t.Errorf("Expected filesystem root to be %s, got %s", DefaultBlobFilesystemRoot, cfg.Blob.Filesystem.Root)
+	}
+	if cfg.Blob.Filesystem.KeepVersions {
+		t.Error("Expected filesystem versions not to be kept by default")
+	}
+	if cfg.Blob.Azure.SoftDeleteDays != DefaultBlobAzureSoftDeleteDays {
+		t.Errorf("Expected azure soft delete days to be %d, got %d", DefaultBlobAzureSoftDeleteDays, cfg.Blob.Azure.SoftDeleteDays)
+	}
+	if cfg.Blob.Azure.VersionCleanupInterval != DefaultBlobAzureVersionCleanupInterval {
+		t.Errorf("Expected azure version cleanup interval to be %s, got %s", DefaultBlobAzureVersionCleanupInterval, cfg.Blob.Azure.VersionCleanupInterval)
+	}
+}
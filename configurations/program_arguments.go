@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // ProgramArguments holds all command-line arguments for the application.
@@ -135,3 +136,121 @@ func isValidValue(value string, validValues []string) bool {
 func (pa *ProgramArguments) GetMode() string {
 	return strings.ToLower(strings.TrimSpace(pa.Mode))
 }
+
+const (
+	// CommandBackup exports a bucket to a backup archive instead of starting the server
+	CommandBackup = "backup"
+
+	// CommandRestore imports a backup archive into a bucket instead of starting the server
+	CommandRestore = "restore"
+
+	// CommandPointInTimeRestore brings a bucket back to its state at a point in time instead of starting the server
+	CommandPointInTimeRestore = "pitr"
+)
+
+// BackupArguments holds the command-line arguments of the backup, restore and point-in-time restore commands.
+type BackupArguments struct {
+	// Command is CommandBackup, CommandRestore or CommandPointInTimeRestore.
+	Command string
+
+	// ConfigPath specifies the path to the configuration YAML file, for the blob storage settings.
+	ConfigPath string
+
+	// Bucket is the bucket to export, or to restore to.
+	Bucket string
+
+	// Prefix limits the export or the point-in-time restore to keys starting with it. Not used by restore.
+	Prefix string
+
+	// File is the path of the archive to write, or to read. Not used by point-in-time restore.
+	File string
+
+	// At is the point in time to restore to. Only used by point-in-time restore.
+	At time.Time
+
+	// DryRun only reports the changes of a point-in-time restore, without applying them.
+	DryRun bool
+}
+
+// IsBackupCommand reports whether the first command-line argument selects the backup, restore or point-in-time restore command.
+//
+// params:
+//   - arg: The first command-line argument
+//
+// return:
+//   - bool: True for CommandBackup, CommandRestore and CommandPointInTimeRestore
+func IsBackupCommand(arg string) bool {
+	return arg == CommandBackup || arg == CommandRestore || arg == CommandPointInTimeRestore
+}
+
+// ParseBackupArguments parses the arguments of the backup, restore and point-in-time restore commands.
+//
+// params:
+//   - command: CommandBackup, CommandRestore or CommandPointInTimeRestore
+//   - args: The arguments following the command
+//
+// return:
+//   - *BackupArguments: The parsed and validated arguments.
+//   - error: An error if parsing fails, or a required argument is missing.
+func ParseBackupArguments(command string, args []string) (*BackupArguments, error) {
+	if !IsBackupCommand(command) {
+		return nil, fmt.Errorf("invalid command '%s': must be one of %s, %s, %s", command, CommandBackup, CommandRestore, CommandPointInTimeRestore)
+	}
+	parsedArgs := &BackupArguments{Command: command}
+	var at string
+
+	fs := flag.NewFlagSet("nimbusdb "+command, flag.ContinueOnError)
+	fs.StringVar(&parsedArgs.ConfigPath, "config", DefaultConfigPath, fmt.Sprintf("Path to configuration YAML file (default: %s)", DefaultConfigPath))
+	fs.StringVar(&parsedArgs.ConfigPath, "c", DefaultConfigPath, "Shorthand for -config")
+	switch command {
+	case CommandBackup:
+		fs.StringVar(&parsedArgs.Bucket, "bucket", "", "Bucket to export")
+		fs.StringVar(&parsedArgs.Prefix, "prefix", "", "Only export keys starting with this prefix")
+	case CommandRestore:
+		fs.StringVar(&parsedArgs.Bucket, "bucket", "", "Bucket to restore to, must exist")
+	case CommandPointInTimeRestore:
+		fs.StringVar(&parsedArgs.Bucket, "bucket", "", "Bucket to restore")
+		fs.StringVar(&parsedArgs.Prefix, "prefix", "", "Only restore keys starting with this prefix")
+		fs.StringVar(&at, "at", "", "Point in time to restore to (RFC 3339, e.g. 2024-05-01T12:00:00Z)")
+		fs.BoolVar(&parsedArgs.DryRun, "dry-run", false, "Only report the changes, without applying them")
+	}
+	if command != CommandPointInTimeRestore {
+		fs.StringVar(&parsedArgs.File, "file", "", "Path of the tar archive")
+		fs.StringVar(&parsedArgs.File, "f", "", "Shorthand for -file")
+	}
+
+	fs.Usage = func() {
+		if command == CommandPointInTimeRestore {
+			fmt.Fprintf(fs.Output(), "Usage: %s %s -bucket <bucket> -at <time> [options]\n\n", AppName, command)
+		} else {
+			fmt.Fprintf(fs.Output(), "Usage: %s %s -bucket <bucket> -file <archive.tar> [options]\n\n", AppName, command)
+		}
+		fmt.Fprintf(fs.Output(), "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("failed to parse arguments: %w", err)
+	}
+
+	var validationErrors []string
+	if parsedArgs.Bucket == "" {
+		validationErrors = append(validationErrors, "bucket is required")
+	}
+	if command == CommandPointInTimeRestore {
+		parsed, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid at '%s': must be an RFC 3339 time", at))
+		} else if parsed.After(time.Now()) {
+			validationErrors = append(validationErrors, fmt.Sprintf("invalid at '%s': must not be in the future", at))
+		}
+		parsedArgs.At = parsed
+	} else if parsedArgs.File == "" {
+		validationErrors = append(validationErrors, "file is required")
+	}
+	if len(validationErrors) > 0 {
+		return nil, errors.New(strings.Join(validationErrors, "; "))
+	}
+
+	return parsedArgs, nil
+}
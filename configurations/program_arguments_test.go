@@ -3,6 +3,7 @@ package configurations
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseArguments_DefaultValues(t *testing.T) {
@@ -236,3 +237,54 @@ func TestIsValidValue(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBackupArguments(t *testing.T) {
+	args, err := ParseBackupArguments(CommandBackup, []string{"-bucket", "orders", "-prefix", "2024/", "-f", "orders.tar"})
+	if err != nil {
+		t.Fatalf("ParseBackupArguments() failed: %v", err)
+	}
+	if args.Bucket != "orders" || args.Prefix != "2024/" || args.File != "orders.tar" {
+		t.Errorf("Expected bucket orders, prefix 2024/ and file orders.tar, got %+v", args)
+	}
+	if args.ConfigPath != DefaultConfigPath {
+		t.Errorf("Expected config path %s, got %s", DefaultConfigPath, args.ConfigPath)
+	}
+}
+
+func TestParseBackupArguments_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+	}{
+		{"unknown command", "export", []string{"-bucket", "orders", "-file", "orders.tar"}},
+		{"missing bucket", CommandBackup, []string{"-file", "orders.tar"}},
+		{"missing file", CommandRestore, []string{"-bucket", "orders"}},
+		{"prefix on restore", CommandRestore, []string{"-bucket", "orders", "-file", "orders.tar", "-prefix", "a/"}},
+		{"missing at", CommandPointInTimeRestore, []string{"-bucket", "orders"}},
+		{"invalid at", CommandPointInTimeRestore, []string{"-bucket", "orders", "-at", "yesterday"}},
+		{"future at", CommandPointInTimeRestore, []string{"-bucket", "orders", "-at", "2999-01-01T00:00:00Z"}},
+		{"file on pitr", CommandPointInTimeRestore, []string{"-bucket", "orders", "-at", "2024-05-01T12:00:00Z", "-file", "orders.tar"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseBackupArguments(tt.command, tt.args); err == nil {
+				t.Error("ParseBackupArguments() should have failed")
+			}
+		})
+	}
+}
+
+func TestParseBackupArguments_PointInTimeRestore(t *testing.T) {
+	args, err := ParseBackupArguments(CommandPointInTimeRestore, []string{"-bucket", "orders", "-prefix", "2024/", "-at", "2024-05-01T12:00:00Z", "-dry-run"})
+	if err != nil {
+		t.Fatalf("ParseBackupArguments() failed: %v", err)
+	}
+	if !args.At.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected at 2024-05-01T12:00:00Z, got %s", args.At)
+	}
+	if !args.DryRun {
+		t.Error("Expected dry run to be true")
+	}
+}
@@ -0,0 +1,330 @@
+package db
+
+import (
+	"NimbusDb/auth"
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// startAdminHandlers subscribes to the admin subjects used by operators to manage buckets and check their integrity.
+// All data nodes listen through the admin queue group, so each request is served by exactly one node.
+//
+// return:
+//   - []*nats.Subscription: All subscriptions created for admin handlers
+func startAdminHandlers() []*nats.Subscription {
+	handlers := []struct {
+		suffix  string
+		handler nats.MsgHandler
+	}{
+		{".admin.bucket.create", createBucket},
+		{".admin.bucket.delete", deleteBucket},
+		{".admin.bucket.list", listBuckets},
+		{".admin.bucket.describe", describeBucket},
+		{".admin.legalhold.apply", applyLegalHold},
+		{".admin.legalhold.remove", removeLegalHold},
+		{".admin.retention.report", retentionReport},
+		{".admin.scrub.report", scrubReport},
+	}
+
+	subscriptions := make([]*nats.Subscription, 0, len(handlers))
+	for _, h := range handlers {
+		subject := globalConfig.NATS.SubjectPrefix + h.suffix
+		sub, err := globalNATSConn.QueueSubscribe(subject, configurations.AdminHandlersQueueGroup, h.handler)
+		if err != nil {
+			log.Fatal().Err(err).Str("subject", subject).Msg("Failed to start NATS admin handler")
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	return subscriptions
+}
+
+// createBucket handles requests to create a bucket.
+// The bucket is created with versioning and lifecycle rules, and its settings are returned.
+// Creating an existing bucket re-applies versioning and lifecycle rules.
+// The optional 'objectLock', 'retentionMode' and 'retentionDays' headers create the bucket with object lock,
+// or change the default retention of an existing locked bucket.
+// If authorization is configured, the requester needs the write or admin action on the whole bucket,
+// and the admin action when object lock headers are set, as a COMPLIANCE retention cannot be undone.
+func createBucket(msg *nats.Msg) {
+	bucketName, ok := requireBucketName(msg)
+	if !ok {
+		return
+	}
+	if !requireGrant(msg, bucketName, "bucket create", auth.ActionWrite, auth.ActionAdmin) {
+		return
+	}
+	lock, err := parseObjectLockHeaders(msg.Header, globalConfig.Blob.ObjectLockMaxRetentionDays)
+	if err != nil {
+		RespondWithNatsError(msg, ErrorCodeBadRequest, err.Error())
+		return
+	}
+	if lock != (blob.ObjectLockConfig{}) && !requireGrant(msg, bucketName, "object lock bucket create", auth.ActionAdmin) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+
+	if err := globalBlobClient.CreateBucket(ctx, bucketName, lock); err != nil {
+		log.Error().Err(err).Str("bucketName", bucketName).Msg("Failed to create bucket")
+		RespondWithNatsError(msg, blobErrorStatus(err), err.Error())
+		return
+	}
+	log.Info().Str("bucketName", bucketName).Bool("objectLock", lock.Enabled).Str("retentionMode", lock.Mode).Int("retentionDays", lock.RetentionDays).Msg("Bucket created")
+
+	description, err := globalBlobClient.DescribeBucket(ctx, bucketName)
+	if err != nil {
+		RespondWithNatsError(msg, blobErrorStatus(err), err.Error())
+		return
+	}
+	respondWithJSON(msg, description)
+}
+
+// deleteBucket handles requests to delete an empty bucket.
+// If authorization is configured, the requester needs the delete action on the whole bucket.
+func deleteBucket(msg *nats.Msg) {
+	bucketName, ok := requireBucketName(msg)
+	if !ok {
+		return
+	}
+
+	if !requireGrant(msg, bucketName, "bucket delete", auth.ActionDelete) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+
+	if err := globalBlobClient.DeleteBucket(ctx, bucketName); err != nil {
+		log.Error().Err(err).Str("bucketName", bucketName).Msg("Failed to delete bucket")
+		RespondWithNatsError(msg, blobErrorStatus(err), err.Error())
+		return
+	}
+	log.Info().Str("bucketName", bucketName).Msg("Bucket deleted")
+	RespondWithNatsSuccess(msg)
+}
+
+// listBuckets handles requests to list all buckets.
+// If tenants are configured, only the buckets in the namespace of the requester's tenant are listed.
+// If authorization is configured, only the buckets the requester has the read, write or admin action on are listed.
+func listBuckets(msg *nats.Msg) {
+	visible, err := bucketListFilter(msg)
+	if err != nil {
+		log.Warn().Err(err).Msg("Rejected forbidden bucket list")
+		RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+
+	buckets, err := globalBlobClient.ListBuckets(ctx)
+	if err != nil {
+		RespondWithNatsError(msg, blobErrorStatus(err), err.Error())
+		return
+	}
+	listed := make([]blob.BucketInfo, 0, len(buckets))
+	for _, b := range buckets {
+		if visible(b.Name) {
+			listed = append(listed, b)
+		}
+	}
+	respondWithJSON(msg, BucketListResponse{Buckets: listed})
+}
+
+// bucketListFilter returns the filter of the buckets listed to the requester of a bucket list:
+// the buckets in the namespace of its tenant if tenants are configured, and the buckets it has the read,
+// write or admin action on if authorization is configured. Without either, every bucket is listed.
+//
+// params:
+//   - msg: The bucket list request
+//
+// return:
+//   - func(string) bool: Reports whether a bucket is listed to the requester
+//   - error: An error wrapping ErrForbidden if the tenant of the requester is missing or unknown, or the requester is not authenticated
+func bucketListFilter(msg *nats.Msg) (func(bucketName string) bool, error) {
+	var namespace *tenant
+	if globalTenants != nil {
+		t, err := globalTenants.resolve(msg)
+		if err != nil {
+			return nil, err
+		}
+		namespace = t
+	}
+	principal, err := globalAuthorizer.authenticate(msg, "list")
+	if err != nil {
+		return nil, err
+	}
+	return func(bucketName string) bool {
+		if namespace != nil && !namespace.allows(bucketName) {
+			return false
+		}
+		return globalAuthorizer.allows(principal, bucketName, "", auth.ActionRead, auth.ActionWrite, auth.ActionAdmin)
+	}, nil
+}
+
+// describeBucket handles requests for the versioning and lifecycle settings of a bucket.
+// If authorization is configured, the requester needs the read or admin action on the whole bucket.
+func describeBucket(msg *nats.Msg) {
+	bucketName, ok := requireBucketName(msg)
+	if !ok {
+		return
+	}
+	if !requireGrant(msg, bucketName, "bucket describe", auth.ActionRead, auth.ActionAdmin) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+
+	description, err := globalBlobClient.DescribeBucket(ctx, bucketName)
+	if err != nil {
+		RespondWithNatsError(msg, blobErrorStatus(err), err.Error())
+		return
+	}
+	respondWithJSON(msg, description)
+}
+
+// retentionReport handles requests for the objects of a bucket its retention policies expire, without deleting them.
+// If authorization is configured, the requester needs the read or admin action on the whole bucket.
+func retentionReport(msg *nats.Msg) {
+	bucketName, ok := requireBucketName(msg)
+	if !ok {
+		return
+	}
+	if !requireGrant(msg, bucketName, "retention report", auth.ActionRead, auth.ActionAdmin) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+
+	report, err := globalBlobClient.PlanRetention(ctx, bucketName, time.Now())
+	if err != nil {
+		RespondWithNatsError(msg, blobErrorStatus(err), err.Error())
+		return
+	}
+	respondWithJSON(msg, report)
+}
+
+// applyLegalHold handles requests to put an object version under legal hold, so it cannot be deleted until the hold is removed.
+// If authorization is configured, the requester needs the admin action on the key.
+func applyLegalHold(msg *nats.Msg) {
+	setLegalHold(msg, true)
+}
+
+// removeLegalHold handles requests to remove the legal hold of an object version.
+// If authorization is configured, the requester needs the admin action on the key, a write or delete grant is not enough
+// since removing a hold is what lets the protected version be deleted.
+func removeLegalHold(msg *nats.Msg) {
+	setLegalHold(msg, false)
+}
+
+// setLegalHold applies or removes the legal hold of the object version in the 'bucketName', 'fileName' and optional 'versionId' headers.
+// Without 'versionId', the current version is held.
+func setLegalHold(msg *nats.Msg, on bool) {
+	bucketName, ok := requireBucketName(msg)
+	if !ok {
+		return
+	}
+	fileName := msg.Header.Get("fileName")
+	if fileName == "" {
+		RespondWithNatsError(msg, ErrorCodeBadRequest, "missing 'fileName' header")
+		return
+	}
+	versionID := msg.Header.Get("versionId")
+
+	if _, err := globalAuthorizer.authorize(msg, bucketName, fileName, auth.ActionAdmin); err != nil {
+		log.Warn().Err(err).Str("bucketName", bucketName).Str("fileName", fileName).Msg("Rejected forbidden legal hold change")
+		RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+
+	if err := globalBlobClient.SetLegalHold(ctx, bucketName, fileName, versionID, on); err != nil {
+		log.Error().Err(err).Str("bucketName", bucketName).Str("fileName", fileName).Str("versionID", versionID).Bool("legalHold", on).Msg("Failed to set legal hold")
+		RespondWithNatsError(msg, blobErrorStatus(err), err.Error())
+		return
+	}
+	log.Info().Str("bucketName", bucketName).Str("fileName", fileName).Str("versionID", versionID).Bool("legalHold", on).Msg("Legal hold set")
+	respondWithJSON(msg, LegalHoldResponse{Bucket: bucketName, Key: fileName, VersionID: versionID, LegalHold: on})
+}
+
+// parseObjectLockHeaders parses the object lock configuration of a bucket creation request.
+// 'objectLock' enables object lock, 'retentionMode' and 'retentionDays' set the default retention of new object versions.
+//
+// params:
+//   - h: The request headers
+//   - maxRetentionDays: The maximum 'retentionDays'
+//
+// return:
+//   - blob.ObjectLockConfig: The object lock configuration, the zero value if no header is set
+//   - error: An error if a header is malformed, the retention is longer than maxRetentionDays or the configuration is invalid
+func parseObjectLockHeaders(h nats.Header, maxRetentionDays int) (blob.ObjectLockConfig, error) {
+	var lock blob.ObjectLockConfig
+	var err error
+	if s := h.Get("objectLock"); s != "" {
+		lock.Enabled, err = strconv.ParseBool(s)
+		if err != nil {
+			return lock, fmt.Errorf("invalid 'objectLock' header: %s", s)
+		}
+	}
+	lock.Mode = h.Get("retentionMode")
+	if s := h.Get("retentionDays"); s != "" {
+		lock.RetentionDays, err = strconv.Atoi(s)
+		if err != nil {
+			return lock, fmt.Errorf("invalid 'retentionDays' header: %s", s)
+		}
+		if lock.RetentionDays > maxRetentionDays {
+			return lock, fmt.Errorf("'retentionDays' header must be at most %d, got %d", maxRetentionDays, lock.RetentionDays)
+		}
+	}
+	return lock, lock.Validate()
+}
+
+// requireGrant checks that the requester of an admin operation on a whole bucket is granted any of the actions,
+// or responds with a 403. Use auth.Wildcard as bucket for operations spanning every bucket.
+func requireGrant(msg *nats.Msg, bucketName, operation string, actions ...auth.Action) bool {
+	if _, err := globalAuthorizer.authorizeAny(msg, bucketName, "", actions...); err != nil {
+		log.Warn().Err(err).Str("bucketName", bucketName).Msgf("Rejected forbidden %s", operation)
+		RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+// requireBucketName returns the 'bucketName' header, or responds with a 400 if it is missing,
+// and with a 403 if tenants are configured and the bucket is outside the namespace of the requester's tenant.
+func requireBucketName(msg *nats.Msg) (string, bool) {
+	bucketName := msg.Header.Get("bucketName")
+	if bucketName == "" {
+		RespondWithNatsError(msg, ErrorCodeBadRequest, "missing 'bucketName' header")
+		return "", false
+	}
+	if _, err := globalTenants.authorize(msg, bucketName); err != nil {
+		log.Warn().Err(err).Str("bucketName", bucketName).Str("subject", msg.Subject).Msg("Rejected admin request outside the tenant namespace")
+		RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+		return "", false
+	}
+	return bucketName, true
+}
+
+// respondWithJSON responds with a success status and v encoded as JSON.
+func respondWithJSON(msg *nats.Msg, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		RespondWithNatsError(msg, ErrorCodeInternalServerError, err.Error())
+		return
+	}
+	RespondWithNatsData(msg, b)
+}
@@ -0,0 +1,296 @@
+package db
+
+import (
+	"NimbusDb/auth"
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// setupAdminTest serves the admin handlers on an embedded NATS server, with a filesystem blob storage holding the orders and users buckets.
+// orders-svc may write the orders bucket, orders-cleaner may write and delete it, ops-admin has the admin action on every bucket.
+func setupAdminTest(t *testing.T) *nats.Conn {
+	t.Helper()
+	nc, _ := runJetStreamServer(t)
+
+	cfg := &configurations.Config{}
+	cfg.NATS.SubjectPrefix = "nimbus"
+	cfg.Blob.BlobOperationTimeout = 5 * time.Second
+	cfg.Blob.ObjectLockMaxRetentionDays = configurations.DefaultObjectLockMaxRetentionDays
+	cfg.Blob.Filesystem.Root = t.TempDir()
+	storage, err := blob.NewFilesystem(cfg)
+	if err != nil {
+		t.Fatalf("NewFilesystem() failed: %v", err)
+	}
+
+	previousConfig, previousConn, previousStorage := globalConfig, globalNATSConn, globalBlobClient
+	globalConfig, globalNATSConn, globalBlobClient = cfg, nc, storage
+	globalAuthorizer = newAuthorizer(configurations.AuthConfig{
+		TokenSecret: "test-secret",
+		Grants: []configurations.AuthGrantConfig{
+			{Principals: []string{"orders-svc"}, Bucket: "orders", Actions: []string{"read", "write"}},
+			{Principals: []string{"orders-cleaner"}, Bucket: "orders", Actions: []string{"write", "delete"}},
+			{Principals: []string{"ops-admin"}, Bucket: "*", Actions: []string{"admin"}},
+		},
+	}, false)
+	subscriptions := startAdminHandlers()
+	t.Cleanup(func() {
+		for _, sub := range subscriptions {
+			sub.Unsubscribe()
+		}
+		globalConfig, globalNATSConn, globalBlobClient, globalAuthorizer = previousConfig, previousConn, previousStorage, nil
+	})
+
+	for _, bucketName := range []string{"orders", "users"} {
+		if resp := adminRequest(t, nc, "bucket.create", "ops-admin", map[string]string{"bucketName": bucketName}); resp.Header.Get(StatusHeader) != strconv.Itoa(SuccessCode) {
+			t.Fatalf("Failed to create bucket %s: %s", bucketName, resp.Header.Get(ErrorHeader))
+		}
+	}
+	return nc
+}
+
+// adminRequest sends a request to an admin subject, with a token of the principal if it is not empty.
+func adminRequest(t *testing.T, nc *nats.Conn, subject, principal string, headers map[string]string) *nats.Msg {
+	t.Helper()
+	msg := nats.NewMsg("nimbus.admin." + subject)
+	for k, v := range headers {
+		msg.Header.Set(k, v)
+	}
+	if principal != "" {
+		token, err := auth.SignToken([]byte("test-secret"), auth.Claims{Subject: principal})
+		if err != nil {
+			t.Fatalf("SignToken() failed: %v", err)
+		}
+		msg.Header.Set(AuthTokenHeader, token)
+	}
+	resp, err := nc.RequestMsg(msg, 5*time.Second)
+	if err != nil {
+		t.Fatalf("RequestMsg() failed: %v", err)
+	}
+	return resp
+}
+
+func TestAdminHandlers_Authorization(t *testing.T) {
+	nc := setupAdminTest(t)
+
+	tests := []struct {
+		name           string
+		subject        string
+		principal      string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{"anonymous create", "bucket.create", "", map[string]string{"bucketName": "orders"}, ErrorCodeForbidden},
+		{"create with write", "bucket.create", "orders-svc", map[string]string{"bucketName": "orders"}, SuccessCode},
+		{"create without grant", "bucket.create", "orders-svc", map[string]string{"bucketName": "invoices"}, ErrorCodeForbidden},
+		{"describe with read", "bucket.describe", "orders-svc", map[string]string{"bucketName": "orders"}, SuccessCode},
+		{"describe without grant", "bucket.describe", "orders-svc", map[string]string{"bucketName": "users"}, ErrorCodeForbidden},
+		{"anonymous list", "bucket.list", "", nil, ErrorCodeForbidden},
+		{"retention report without grant", "retention.report", "orders-svc", map[string]string{"bucketName": "users"}, ErrorCodeForbidden},
+		{"retention report with admin", "retention.report", "ops-admin", map[string]string{"bucketName": "users"}, SuccessCode},
+		{"scrub report without admin", "scrub.report", "orders-svc", nil, ErrorCodeForbidden},
+		{"scrub report with admin", "scrub.report", "ops-admin", nil, ErrorCodeNotFound},
+		{"object lock with write", "bucket.create", "orders-svc", map[string]string{"bucketName": "orders", "objectLock": "true"}, ErrorCodeForbidden},
+		{"retention change with write", "bucket.create", "orders-svc", map[string]string{"bucketName": "orders", "objectLock": "true", "retentionMode": "GOVERNANCE", "retentionDays": "7"}, ErrorCodeForbidden},
+		{"legal hold with write", "legalhold.apply", "orders-svc", map[string]string{"bucketName": "orders", "fileName": "order-1"}, ErrorCodeForbidden},
+		{"legal hold removal with write and delete", "legalhold.remove", "orders-cleaner", map[string]string{"bucketName": "orders", "fileName": "order-1"}, ErrorCodeForbidden},
+		// Reaches blob storage, which has no object lock on the filesystem provider
+		{"legal hold with admin", "legalhold.apply", "ops-admin", map[string]string{"bucketName": "orders", "fileName": "order-1"}, ErrorCodeConflict},
+		{"retention over the cap", "bucket.create", "ops-admin", map[string]string{"bucketName": "audit", "objectLock": "true", "retentionMode": "COMPLIANCE", "retentionDays": "36500"}, ErrorCodeBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := adminRequest(t, nc, tt.subject, tt.principal, tt.headers)
+			if got := resp.Header.Get(StatusHeader); got != strconv.Itoa(tt.expectedStatus) {
+				t.Errorf("Expected status %d, got %s (%s)", tt.expectedStatus, got, resp.Header.Get(ErrorHeader))
+			}
+		})
+	}
+}
+
+func TestAdminHandlers_ListBucketsFiltered(t *testing.T) {
+	nc := setupAdminTest(t)
+
+	tests := []struct {
+		principal string
+		expected  []string
+	}{
+		{"orders-svc", []string{"orders"}},
+		{"ops-admin", []string{"orders", "users"}},
+		{"nobody", []string{}},
+	}
+	for _, tt := range tests {
+		resp := adminRequest(t, nc, "bucket.list", tt.principal, nil)
+		var list BucketListResponse
+		if err := json.Unmarshal(resp.Data, &list); err != nil {
+			t.Fatalf("Failed to decode bucket list: %v (%s)", err, resp.Header.Get(ErrorHeader))
+		}
+		names := make([]string, 0, len(list.Buckets))
+		for _, b := range list.Buckets {
+			names = append(names, b.Name)
+		}
+		if len(names) != len(tt.expected) {
+			t.Errorf("Expected %s to list %v, got %v", tt.principal, tt.expected, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.expected[i] {
+				t.Errorf("Expected %s to list %v, got %v", tt.principal, tt.expected, names)
+				break
+			}
+		}
+	}
+}
+
+func TestAdminHandlers_TenantNamespace(t *testing.T) {
+	nc := setupAdminTest(t)
+	// Without authorization, only the tenant namespace limits the requests
+	globalAuthorizer = nil
+	globalTenants = newTenantRegistry([]configurations.TenantConfig{{Name: "shop", Buckets: []string{"orders"}}}, false)
+	defer func() { globalTenants = nil }()
+
+	tests := []struct {
+		name           string
+		subject        string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{"describe in namespace", "bucket.describe", map[string]string{"tenant": "shop", "bucketName": "orders"}, SuccessCode},
+		{"describe outside namespace", "bucket.describe", map[string]string{"tenant": "shop", "bucketName": "users"}, ErrorCodeForbidden},
+		{"describe without tenant", "bucket.describe", map[string]string{"bucketName": "orders"}, ErrorCodeForbidden},
+		{"create outside namespace", "bucket.create", map[string]string{"tenant": "shop", "bucketName": "invoices"}, ErrorCodeForbidden},
+		{"delete outside namespace", "bucket.delete", map[string]string{"tenant": "shop", "bucketName": "users"}, ErrorCodeForbidden},
+		{"retention report outside namespace", "retention.report", map[string]string{"tenant": "shop", "bucketName": "users"}, ErrorCodeForbidden},
+		// Reaches blob storage, which has no object lock on the filesystem provider
+		{"legal hold in namespace", "legalhold.apply", map[string]string{"tenant": "shop", "bucketName": "orders", "fileName": "order-1"}, ErrorCodeConflict},
+		{"legal hold outside namespace", "legalhold.apply", map[string]string{"tenant": "shop", "bucketName": "users", "fileName": "user-1"}, ErrorCodeForbidden},
+		{"list without tenant", "bucket.list", nil, ErrorCodeForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := adminRequest(t, nc, tt.subject, "", tt.headers)
+			if got := resp.Header.Get(StatusHeader); got != strconv.Itoa(tt.expectedStatus) {
+				t.Errorf("Expected status %d, got %s (%s)", tt.expectedStatus, got, resp.Header.Get(ErrorHeader))
+			}
+		})
+	}
+
+	resp := adminRequest(t, nc, "bucket.list", "", map[string]string{"tenant": "shop"})
+	var list BucketListResponse
+	if err := json.Unmarshal(resp.Data, &list); err != nil {
+		t.Fatalf("Failed to decode bucket list: %v (%s)", err, resp.Header.Get(ErrorHeader))
+	}
+	if len(list.Buckets) != 1 || list.Buckets[0].Name != "orders" {
+		t.Errorf("Expected tenant shop to list [orders], got %+v", list.Buckets)
+	}
+}
+
+func TestBucketListFilter_Tenants(t *testing.T) {
+	buckets := []string{"acme-orders", "shared-reports", "globex-users", "initech-logs"}
+
+	tests := []struct {
+		name     string
+		tenants  *tenantRegistry
+		headers  map[string]string
+		expected []string
+	}{
+		{"tenancy disabled", nil, nil, buckets},
+		{"bucket list tenant", newTestTenantRegistry(), map[string]string{"tenant": "globex"}, []string{"globex-users"}},
+		{"trusted NATS user", newTestTenantRegistry(), map[string]string{natsRequestInfoHeader: `{"user":"acme-svc"}`}, []string{"acme-orders", "shared-reports"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := globalTenants
+			globalTenants = tt.tenants
+			defer func() { globalTenants = previous }()
+
+			visible, err := bucketListFilter(newShardOperationMsg(tt.headers))
+			if err != nil {
+				t.Fatalf("Expected the bucket list to be allowed, got %v", err)
+			}
+			var listed []string
+			for _, b := range buckets {
+				if visible(b) {
+					listed = append(listed, b)
+				}
+			}
+			if len(listed) != len(tt.expected) {
+				t.Fatalf("Expected %v to be listed, got %v", tt.expected, listed)
+			}
+			for i := range listed {
+				if listed[i] != tt.expected[i] {
+					t.Fatalf("Expected %v to be listed, got %v", tt.expected, listed)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketListFilter_RejectsUnknownTenant(t *testing.T) {
+	previous := globalTenants
+	globalTenants = newTenantRegistry([]configurations.TenantConfig{{Name: "shop", Buckets: []string{"orders"}}}, false)
+	defer func() { globalTenants = previous }()
+
+	for _, headers := range []map[string]string{nil, {"tenant": "unknown"}} {
+		if _, err := bucketListFilter(newShardOperationMsg(headers)); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Expected ErrForbidden for headers %v, got %v", headers, err)
+		}
+	}
+}
+
+func TestBucketListFilter_Grants(t *testing.T) {
+	previousTenants, previousAuthorizer := globalTenants, globalAuthorizer
+	globalAuthorizer = newAuthorizer(configurations.AuthConfig{
+		TokenSecret: "test-secret",
+		Grants: []configurations.AuthGrantConfig{
+			{Principals: []string{"orders-svc"}, Bucket: "orders", Actions: []string{"read"}},
+			{Principals: []string{"orders-cleaner"}, Bucket: "orders", Actions: []string{"delete"}},
+			{Principals: []string{"ops-admin"}, Bucket: "*", Actions: []string{"admin"}},
+		},
+	}, true)
+	defer func() { globalTenants, globalAuthorizer = previousTenants, previousAuthorizer }()
+	buckets := []string{"orders", "users"}
+
+	tests := []struct {
+		name     string
+		user     string
+		tenants  *tenantRegistry
+		expected []string
+	}{
+		{"read grant", "orders-svc", nil, []string{"orders"}},
+		{"delete grant only", "orders-cleaner", nil, nil},
+		{"admin on every bucket", "ops-admin", nil, []string{"orders", "users"}},
+		{"admin within a tenant", "ops-admin", newTenantRegistry([]configurations.TenantConfig{{Name: "shop", Buckets: []string{"users"}, NatsUsers: []string{"ops-admin"}}}, true), []string{"users"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globalTenants = tt.tenants
+			visible, err := bucketListFilter(newShardOperationMsg(map[string]string{natsRequestInfoHeader: `{"user":"` + tt.user + `"}`}))
+			if err != nil {
+				t.Fatalf("Expected the bucket list to be allowed, got %v", err)
+			}
+			var listed []string
+			for _, b := range buckets {
+				if visible(b) {
+					listed = append(listed, b)
+				}
+			}
+			if fmt.Sprint(listed) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected %v to be listed, got %v", tt.expected, listed)
+			}
+		})
+	}
+
+	globalTenants = nil
+	if _, err := bucketListFilter(newShardOperationMsg(nil)); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for an anonymous bucket list, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package db
+
+import (
+	"NimbusDb/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// globalAsyncWrites queues the async writes of the shards owned by this node.
+	// It is set once when the shard handlers start and never modified.
+	globalAsyncWrites *asyncWriter
+)
+
+// errAsyncQueueFull is returned when a write cannot be queued because the shard queue is full.
+var errAsyncQueueFull = errors.New("async write queue full")
+
+// asyncTask is an async write, or a flush marker, queued on a shard.
+type asyncTask struct {
+	id         string
+	bucketName string
+	fileName   string
+	data       []byte
+	// flushed is called once every task queued before it is done. Only set for flush markers.
+	flushed func()
+	// result receives the outcome of the write instead of publishing it. Only set for sync writes queued by writeBehind,
+	// which are not in the overlay as they are not acknowledged yet.
+	result chan asyncResult
+	// usage is the usage change of the write for the quota of the bucket. Async writes record it once queued,
+	// and revert it if dropped. Sync writes record it once applied.
+	usage quotaWrite
+}
+
+// asyncResult is the outcome of a sync write applied by the async worker.
+type asyncResult struct {
+	versionID string
+	err       error
+}
+
+// asyncWriter applies async writes to blob storage in the background, with one bounded queue and worker per shard.
+// Writes of a shard are applied in queue order. Failed writes are retried, and published to the dead-letter subject
+// once they fail permanently or run out of attempts.
+// The queue map is read-only after creation, the queues are thread-safe.
+type asyncWriter struct {
+	queues    map[uint16]chan *asyncTask
+	overlay   *writeOverlay
+	write     blobWriteFunc
+	opTimeout time.Duration
+	// retryDelay is the delay between two attempts of a failed write, maxAttempts the number of attempts of a write.
+	retryDelay  time.Duration
+	maxAttempts int
+	// queued is the number of tasks in all queues.
+	queued atomic.Int64
+	// workers tracks the running workers, so stop can wait for them.
+	workers sync.WaitGroup
+	applied *metrics.Counter
+	retried *metrics.Counter
+	failed  *metrics.Counter
+}
+
+// newAsyncWriter creates the async write queues and starts their workers.
+//
+// params:
+//   - shardIDs: The shards owned by this node, each gets its own queue and worker
+//   - queueSize: The number of tasks a shard queue can hold
+//   - opTimeout: The timeout of a single blob write
+//   - retryDelay: The delay before retrying a failed async write
+//   - maxAttempts: The number of attempts of an async write before it is dead-lettered
+//   - write: The function applying writes to blob storage
+//
+// return:
+//   - *asyncWriter: The async writer
+func newAsyncWriter(shardIDs []uint16, queueSize int, opTimeout, retryDelay time.Duration, maxAttempts int, write blobWriteFunc) *asyncWriter {
+	a := &asyncWriter{
+		queues:      make(map[uint16]chan *asyncTask, len(shardIDs)),
+		overlay:     newWriteOverlay(),
+		write:       write,
+		opTimeout:   opTimeout,
+		retryDelay:  retryDelay,
+		maxAttempts: maxAttempts,
+		applied:     metrics.GetCounter("nimbus_async_writes_applied_total", nil),
+		retried:     metrics.GetCounter("nimbus_async_writes_retried_total", nil),
+		failed:      metrics.GetCounter("nimbus_async_writes_failed_total", nil),
+	}
+	for _, shardID := range shardIDs {
+		queue := make(chan *asyncTask, queueSize)
+		a.queues[shardID] = queue
+		a.workers.Add(1)
+		go a.run(shardID, queue)
+	}
+	metrics.RegisterGaugeFunc("nimbus_async_writes_queued", nil, func() float64 {
+		return float64(a.queued.Load())
+	})
+	return a
+}
+
+// enqueueWrite queues a write without blocking. The write is visible to reads through the overlay until applied.
+// Its usage is recorded for the bucket quota once queued, and reverted if the write is dropped to the dead-letter subject.
+//
+// return:
+//   - bool: False if the shard queue is full, the write is then not queued
+func (a *asyncWriter) enqueueWrite(shardID uint16, bucketName, fileName string, data []byte, usage quotaWrite) bool {
+	task := &asyncTask{id: nuid.Next(), bucketName: bucketName, fileName: fileName, data: data, usage: usage}
+	a.overlay.add(bucketName, fileName, task.id, data)
+	// Recorded before the worker can drop the write and revert it
+	globalLimits.recordWrite(bucketName, usage)
+	if !a.enqueue(shardID, task) {
+		globalLimits.revertWrite(bucketName, usage)
+		a.overlay.remove(bucketName, fileName, task.id)
+		return false
+	}
+	return true
+}
+
+// enqueueFlush queues a flush marker without blocking. flushed is called once every write queued before it is done.
+//
+// return:
+//   - bool: False if the shard queue is full, flushed is then never called
+func (a *asyncWriter) enqueueFlush(shardID uint16, flushed func()) bool {
+	return a.enqueue(shardID, &asyncTask{flushed: flushed})
+}
+
+// writeBehind applies a sync write after the writes already queued on the shard, and waits until it is applied.
+// Queued writes are older than the sync write: written directly, it would be overwritten once they are applied.
+// Once applied, the worker records the write for quotas and publishes the change, also if ctx is done first,
+// as the write still lands in blob storage. Unlike queued writes, it is not retried nor dead-lettered, the caller gets the error.
+//
+// params:
+//   - ctx: The operation context, bounding the wait
+//   - shardID: The shard of the object
+//   - bucketName: The bucket of the object
+//   - fileName: The object key
+//   - data: The content to write
+//   - usage: The usage change recorded for the bucket quota once applied
+//
+// return:
+//   - string: The version ID of the written object
+//   - error: errAsyncQueueFull if the shard queue is full, the context error if it is done first, or the blob write error
+func (a *asyncWriter) writeBehind(ctx context.Context, shardID uint16, bucketName, fileName string, data []byte, usage quotaWrite) (string, error) {
+	task := &asyncTask{bucketName: bucketName, fileName: fileName, data: data, result: make(chan asyncResult, 1), usage: usage}
+	if !a.enqueue(shardID, task) {
+		return "", errAsyncQueueFull
+	}
+	select {
+	case result := <-task.result:
+		return result.versionID, result.err
+	case <-ctx.Done():
+		// The write is still applied, but may be too late for the caller
+		return "", fmt.Errorf("failed waiting for queued writes of %s: %w", fileName, ctx.Err())
+	}
+}
+
+// enqueue adds a task to the queue of a shard if it has room.
+func (a *asyncWriter) enqueue(shardID uint16, task *asyncTask) bool {
+	queue, ok := a.queues[shardID]
+	if !ok {
+		return false
+	}
+	a.queued.Add(1)
+	select {
+	case queue <- task:
+		return true
+	default:
+		a.queued.Add(-1)
+		return false
+	}
+}
+
+// lookup returns the content of the latest queued write of an object.
+// A nil async writer has no queued writes.
+func (a *asyncWriter) lookup(bucketName, fileName string) ([]byte, bool, error) {
+	if a == nil {
+		return nil, false, nil
+	}
+	return a.overlay.get(bucketName, fileName)
+}
+
+// has reports whether an object has queued writes. A nil async writer has no queued writes.
+func (a *asyncWriter) has(bucketName, fileName string) bool {
+	return a != nil && a.overlay.has(bucketName, fileName)
+}
+
+// stop closes the shard queues and waits until the workers have applied the tasks queued before and returned.
+// No task may be queued once stop is called.
+func (a *asyncWriter) stop() {
+	for _, queue := range a.queues {
+		close(queue)
+	}
+	a.workers.Wait()
+}
+
+// run applies the tasks of a shard queue one at a time, until the queue is closed.
+func (a *asyncWriter) run(shardID uint16, queue chan *asyncTask) {
+	defer a.workers.Done()
+	for task := range queue {
+		if task.flushed != nil {
+			task.flushed()
+		} else {
+			a.apply(shardID, task)
+		}
+		a.queued.Add(-1)
+	}
+}
+
+// apply applies a queued write to blob storage, and publishes it as a change event.
+// Failed writes are retried, and published to the dead-letter subject once they fail permanently or run out of attempts,
+// their usage is then reverted.
+// A sync write is applied once, recorded and published if it succeeded, and its outcome sent to its caller.
+func (a *asyncWriter) apply(shardID uint16, task *asyncTask) {
+	if task.result != nil {
+		versionID, err := a.writeOnce(task)
+		if err == nil {
+			globalLimits.recordWrite(task.bucketName, task.usage)
+			writeApplied(shardID, task.bucketName, task.fileName, versionID, task.data)
+		}
+		task.result <- asyncResult{versionID: versionID, err: err}
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		versionID, err := a.writeOnce(task)
+		if err == nil {
+			a.overlay.remove(task.bucketName, task.fileName, task.id)
+			a.applied.Inc()
+			writeApplied(shardID, task.bucketName, task.fileName, versionID, task.data)
+			return
+		}
+
+		if isPermanentWriteError(err) || attempt >= a.maxAttempts {
+			a.overlay.remove(task.bucketName, task.fileName, task.id)
+			a.failed.Inc()
+			globalLimits.revertWrite(task.bucketName, task.usage)
+			globalDeadLetters.publish(shardID, task.bucketName, task.fileName, task.data, err)
+			return
+		}
+
+		a.retried.Inc()
+		log.Warn().Err(err).Uint16("shardID", shardID).Str("bucketName", task.bucketName).Str("fileName", task.fileName).Int("attempt", attempt).Dur("retryDelay", a.retryDelay).Msg("Failed to apply async write, retrying")
+		time.Sleep(a.retryDelay)
+	}
+}
+
+// writeOnce makes a single attempt to write a queued task to blob storage, bounded by the blob operation timeout.
+func (a *asyncWriter) writeOnce(task *asyncTask) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.opTimeout)
+	defer cancel()
+	return a.write(ctx, task.bucketName, task.fileName, task.data)
+}
+
+// lookupPending returns the content of the latest acknowledged write of an object
+// that is not yet applied to blob storage, from the write-ahead log, the write buffer or the async write queues.
+//
+// return:
+//   - []byte: The content of the latest pending write
+//   - bool: False if the object has no pending write
+//   - error: An error if the pending write could not be read back from the write buffer or the write-ahead log
+func lookupPending(bucketName, fileName string) ([]byte, bool, error) {
+	if data, ok, err := globalWAL.lookup(bucketName, fileName); ok || err != nil {
+		return data, ok, err
+	}
+	if data, ok, err := globalWriteBuffer.lookup(bucketName, fileName); ok || err != nil {
+		return data, ok, err
+	}
+	return globalAsyncWrites.lookup(bucketName, fileName)
+}
+
+// hasPending reports whether an object has an acknowledged write not yet applied to blob storage,
+// without reading its content.
+func hasPending(bucketName, fileName string) bool {
+	return globalWAL.has(bucketName, fileName) || globalWriteBuffer.has(bucketName, fileName) || globalAsyncWrites.has(bucketName, fileName)
+}
+
+// DrainAsyncWrites waits until the queued async writes are applied, or until timeout.
+// Must be called after the shard handlers have exited, so no write is queued anymore, and before draining the NATS connection
+// (failed writes are published to the dead-letter subject).
+//
+// params:
+//   - timeout: The maximum time to wait
+func DrainAsyncWrites(timeout time.Duration) {
+	if globalAsyncWrites == nil {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	for globalAsyncWrites.queued.Load() > 0 {
+		if time.Now().After(deadline) {
+			log.Warn().Int64("queued", globalAsyncWrites.queued.Load()).Msg("Timed out applying queued async writes, they are lost")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Info().Msg("Queued async writes applied")
+}
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// waitAsyncFlushed queues a flush marker and waits until it is reached.
+func waitAsyncFlushed(t *testing.T, a *asyncWriter, shardID uint16) {
+	t.Helper()
+	done := make(chan struct{})
+	if !a.enqueueFlush(shardID, func() { close(done) }) {
+		t.Fatal("Expected flush to be queued")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Flush did not complete")
+	}
+}
+
+func TestAsyncWriter_AppliesInOrder(t *testing.T) {
+	store := &fakeBlobWrites{}
+	a := newAsyncWriter([]uint16{0, 1}, 16, time.Second, time.Millisecond, 1, store.write)
+	t.Cleanup(a.stop)
+
+	for i := 0; i < 5; i++ {
+		if !a.enqueueWrite(0, "orders", "order-1", []byte(fmt.Sprint(i)), quotaWrite{}) {
+			t.Fatal("Expected write to be queued")
+		}
+	}
+	waitAsyncFlushed(t, a, 0)
+
+	expected := []string{"orders/order-1=0", "orders/order-1=1", "orders/order-1=2", "orders/order-1=3", "orders/order-1=4"}
+	if fmt.Sprint(store.applied()) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, store.applied())
+	}
+	if _, ok, _ := a.lookup("orders", "order-1"); ok {
+		t.Error("Expected no queued write once flushed")
+	}
+}
+
+func TestAsyncWriter_ReadsSeeQueuedWrites(t *testing.T) {
+	blocked := make(chan struct{})
+	store := &fakeBlobWrites{}
+	a := newAsyncWriter([]uint16{0}, 16, time.Second, time.Millisecond, 1, store.write)
+	t.Cleanup(a.stop)
+
+	// Hold the worker so the writes stay queued
+	a.enqueueFlush(0, func() { <-blocked })
+	a.enqueueWrite(0, "orders", "order-1", []byte("first"), quotaWrite{})
+	a.enqueueWrite(0, "orders", "order-1", []byte("second"), quotaWrite{})
+
+	data, ok, _ := a.lookup("orders", "order-1")
+	if !ok || string(data) != "second" {
+		t.Errorf("Expected queued write 'second', got '%s'", data)
+	}
+
+	close(blocked)
+	waitAsyncFlushed(t, a, 0)
+	if len(store.applied()) != 2 {
+		t.Errorf("Expected 2 writes applied, got %v", store.applied())
+	}
+}
+
+func TestAsyncWriter_FailedWrites(t *testing.T) {
+	store := &fakeBlobWrites{failures: 1}
+	a := newAsyncWriter([]uint16{0}, 16, time.Second, time.Millisecond, 1, store.write)
+	t.Cleanup(a.stop)
+	failedBefore := a.failed.Value()
+
+	a.enqueueWrite(0, "orders", "order-1", []byte("lost"), quotaWrite{})
+	a.enqueueWrite(0, "orders", "order-2", []byte("kept"), quotaWrite{})
+	waitAsyncFlushed(t, a, 0)
+
+	if got := a.failed.Value() - failedBefore; got != 1 {
+		t.Errorf("Expected 1 failed write, got %d", got)
+	}
+	expected := []string{"orders/order-2=kept"}
+	if fmt.Sprint(store.applied()) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, store.applied())
+	}
+	if _, ok, _ := a.lookup("orders", "order-1"); ok {
+		t.Error("Expected failed write to leave the overlay")
+	}
+}
+
+func TestAsyncWriter_RevertsUsageOfFailedWrites(t *testing.T) {
+	q := setTestQuota(t)
+	store := &fakeBlobWrites{failures: 1}
+	a := newAsyncWriter([]uint16{0}, 16, time.Second, time.Millisecond, 1, store.write)
+	t.Cleanup(a.stop)
+
+	a.enqueueWrite(0, "orders", "order-1", []byte("lost"), quotaWrite{bytes: 4, objects: 1})
+	a.enqueueWrite(0, "orders", "order-2", []byte("kept"), quotaWrite{bytes: 4, objects: 1})
+	waitAsyncFlushed(t, a, 0)
+
+	if got, objects := q.bytes.Load(), q.objects.Load(); got != 4 || objects != 1 {
+		t.Errorf("Expected usage of the applied write only (4 bytes, 1 object), got %d bytes and %d objects", got, objects)
+	}
+}
+
+func TestAsyncWriter_RetriesFailedWrites(t *testing.T) {
+	store := &fakeBlobWrites{failures: 2}
+	a := newAsyncWriter([]uint16{0}, 16, time.Second, time.Millisecond, 3, store.write)
+	t.Cleanup(a.stop)
+	retriedBefore, failedBefore := a.retried.Value(), a.failed.Value()
+
+	a.enqueueWrite(0, "orders", "order-1", []byte("kept"), quotaWrite{})
+	waitAsyncFlushed(t, a, 0)
+
+	expected := []string{"orders/order-1=kept"}
+	if fmt.Sprint(store.applied()) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, store.applied())
+	}
+	if got := a.retried.Value() - retriedBefore; got != 2 {
+		t.Errorf("Expected 2 retries, got %d", got)
+	}
+	if got := a.failed.Value() - failedBefore; got != 0 {
+		t.Errorf("Expected no failed write, got %d", got)
+	}
+}
+
+func TestAsyncWriter_QueueFull(t *testing.T) {
+	blocked := make(chan struct{})
+	a := newAsyncWriter([]uint16{0}, 1, time.Second, time.Millisecond, 1, (&fakeBlobWrites{}).write)
+	t.Cleanup(func() {
+		close(blocked)
+		a.stop()
+	})
+
+	// The worker holds the first task, the queue holds the second
+	a.enqueueFlush(0, func() { <-blocked })
+	for !a.enqueueWrite(0, "orders", "order-1", []byte("queued"), quotaWrite{}) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if a.enqueueWrite(0, "orders", "order-2", []byte("rejected"), quotaWrite{}) {
+		t.Error("Expected write to be rejected when the queue is full")
+	}
+	if _, ok, _ := a.lookup("orders", "order-2"); ok {
+		t.Error("Expected rejected write not to be visible")
+	}
+	if a.enqueueWrite(1, "orders", "order-3", []byte("unknown shard"), quotaWrite{}) {
+		t.Error("Expected write to a shard not owned by the node to be rejected")
+	}
+}
+
+func TestAsyncWriter_WriteBehind(t *testing.T) {
+	blocked := make(chan struct{})
+	store := &fakeBlobWrites{}
+	a := newAsyncWriter([]uint16{0}, 16, time.Second, time.Millisecond, 1, store.write)
+	t.Cleanup(a.stop)
+
+	a.enqueueFlush(0, func() { <-blocked })
+	a.enqueueWrite(0, "orders", "order-1", []byte("async"), quotaWrite{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.writeBehind(context.Background(), 0, "orders", "order-1", []byte("sync"), quotaWrite{})
+		done <- err
+	}()
+	for a.queued.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(blocked)
+
+	if err := <-done; err != nil {
+		t.Fatalf("writeBehind() failed: %v", err)
+	}
+	expected := []string{"orders/order-1=async", "orders/order-1=sync"}
+	if fmt.Sprint(store.applied()) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, store.applied())
+	}
+	if _, err := a.writeBehind(context.Background(), 1, "orders", "order-1", []byte("unknown shard"), quotaWrite{}); err != errAsyncQueueFull {
+		t.Errorf("Expected errAsyncQueueFull for a shard not owned by the node, got %v", err)
+	}
+}
+
+func TestAsyncWriter_WriteBehindRecordedAfterTimeout(t *testing.T) {
+	previousLimits := globalLimits
+	globalLimits = newLimits(configurations.LimitsConfig{Quotas: []configurations.QuotaConfig{{Bucket: "orders", MaxBytes: 1 << 20}}}, 1)
+	t.Cleanup(func() { globalLimits = previousLimits })
+	blocked := make(chan struct{})
+	store := &fakeBlobWrites{}
+	a := newAsyncWriter([]uint16{0}, 16, time.Second, time.Millisecond, 1, store.write)
+	t.Cleanup(a.stop)
+
+	// The caller gives up while the write waits behind the queued ones
+	a.enqueueFlush(0, func() { <-blocked })
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.writeBehind(ctx, 0, "orders", "order-1", []byte("late"), quotaWrite{bytes: 4, objects: 1}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	close(blocked)
+	waitAsyncFlushed(t, a, 0)
+
+	expected := []string{"orders/order-1=late"}
+	if fmt.Sprint(store.applied()) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, store.applied())
+	}
+	if got := globalLimits.quotas.buckets["orders"].bytes.Load(); got != 4 {
+		t.Errorf("Expected the applied write to be recorded as 4 bytes, got %d", got)
+	}
+}
+
+func TestHandleWriteOperation_SyncAfterAsync(t *testing.T) {
+	nc, _ := runJetStreamServer(t)
+	cfg := &configurations.Config{}
+	cfg.Blob.BlobOperationTimeout = 5 * time.Second
+	cfg.Blob.Filesystem.Root = t.TempDir()
+	storage, err := blob.NewFilesystem(cfg)
+	if err != nil {
+		t.Fatalf("NewFilesystem() failed: %v", err)
+	}
+	if err := storage.CreateBucket(context.Background(), "orders", blob.ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	previousConfig, previousStorage := globalConfig, globalBlobClient
+	globalConfig, globalBlobClient = cfg, storage
+	t.Cleanup(func() { globalConfig, globalBlobClient, globalAsyncWrites = previousConfig, previousStorage, nil })
+	globalAsyncWrites = newAsyncWriter([]uint16{0}, 16, cfg.Blob.BlobOperationTimeout, time.Millisecond, 1, storage.WriteFile)
+	t.Cleanup(globalAsyncWrites.stop)
+
+	queue := newShardQueue(0, 10, 3, 0, time.Second)
+	defer queue.close()
+	go handleShardOperation(0, queue)
+	sub, err := nc.Subscribe("nimbus.shards.0.op", queue.enqueue)
+	if err != nil {
+		t.Fatalf("Subscribe() failed: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	request := func(headers map[string]string, data string) *nats.Msg {
+		msg := newShardOperationMsg(headers)
+		msg.Data = []byte(data)
+		resp, err := nc.RequestMsg(msg, 5*time.Second)
+		if err != nil {
+			t.Fatalf("RequestMsg() failed: %v", err)
+		}
+		if got := resp.Header.Get(StatusHeader); got != strconv.Itoa(SuccessCode) {
+			t.Fatalf("Expected %s header to be %d, got %q (%s)", StatusHeader, SuccessCode, got, resp.Header.Get(ErrorHeader))
+		}
+		return resp
+	}
+
+	// Hold the worker so the async write stays queued while the sync write of the same key arrives
+	blocked := make(chan struct{})
+	globalAsyncWrites.enqueueFlush(0, func() { <-blocked })
+	request(map[string]string{"type": "0", "fileName": "order-1", "bucketName": "orders", "async": "true"}, "older")
+	go func() {
+		for globalAsyncWrites.queued.Load() < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		close(blocked)
+	}()
+	request(map[string]string{"type": "0", "fileName": "order-1", "bucketName": "orders"}, "newer")
+
+	if resp := request(map[string]string{"type": "1", "fileName": "order-1", "bucketName": "orders"}, ""); string(resp.Data) != "newer" {
+		t.Errorf("Expected read to return 'newer', got '%s'", resp.Data)
+	}
+	if data, err := storage.ReadFile(context.Background(), "orders", "order-1", ""); err != nil || string(data) != "newer" {
+		t.Errorf("Expected blob storage to hold 'newer', got '%s' (%v)", data, err)
+	}
+}
+
+func TestHandleWriteOperation_CreateOnly(t *testing.T) {
+	nc, _ := runJetStreamServer(t)
+	cfg := &configurations.Config{}
+	cfg.Blob.BlobOperationTimeout = 5 * time.Second
+	cfg.Blob.Filesystem.Root = t.TempDir()
+	storage, err := blob.NewFilesystem(cfg)
+	if err != nil {
+		t.Fatalf("NewFilesystem() failed: %v", err)
+	}
+	if err := storage.CreateBucket(context.Background(), "orders", blob.ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	previousConfig, previousStorage := globalConfig, globalBlobClient
+	globalConfig, globalBlobClient = cfg, storage
+	t.Cleanup(func() { globalConfig, globalBlobClient, globalAsyncWrites = previousConfig, previousStorage, nil })
+	globalAsyncWrites = newAsyncWriter([]uint16{0}, 16, cfg.Blob.BlobOperationTimeout, time.Millisecond, 1, storage.WriteFile)
+	t.Cleanup(globalAsyncWrites.stop)
+
+	queue := newShardQueue(0, 10, 3, 0, time.Second)
+	defer queue.close()
+	go handleShardOperation(0, queue)
+	sub, err := nc.Subscribe("nimbus.shards.0.op", queue.enqueue)
+	if err != nil {
+		t.Fatalf("Subscribe() failed: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	// Concurrent create-only writes of the same file, async ones included, create it once
+	var wg sync.WaitGroup
+	statuses := make(chan string, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := newShardOperationMsg(map[string]string{"type": "0", "fileName": "order-1", "bucketName": "orders", "overwrite": "false", "async": strconv.FormatBool(i%2 == 0)})
+			msg.Data = []byte("order")
+			resp, err := nc.RequestMsg(msg, 5*time.Second)
+			if err != nil {
+				t.Errorf("RequestMsg() failed: %v", err)
+				return
+			}
+			statuses <- resp.Header.Get(StatusHeader)
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	created, rejected := 0, 0
+	for status := range statuses {
+		switch status {
+		case strconv.Itoa(SuccessCode):
+			created++
+		case strconv.Itoa(ErrorCodeBadRequest):
+			rejected++
+		default:
+			t.Errorf("Expected %d or %d, got %s", SuccessCode, ErrorCodeBadRequest, status)
+		}
+	}
+	if created != 1 || rejected != 7 {
+		t.Errorf("Expected 1 write to create the file and 7 to be rejected, got %d and %d", created, rejected)
+	}
+	versions, err := storage.ListObjectVersions(context.Background(), "orders", "")
+	if err != nil {
+		t.Fatalf("ListObjectVersions() failed: %v", err)
+	}
+	if len(versions) != 1 {
+		t.Errorf("Expected a single version of order-1, got %+v", versions)
+	}
+}
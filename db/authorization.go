@@ -0,0 +1,176 @@
+package db
+
+import (
+	"NimbusDb/auth"
+	"NimbusDb/configurations"
+	"NimbusDb/metrics"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// AuthTokenHeader is the request header carrying a signed token identifying the requester.
+	AuthTokenHeader = "authToken"
+)
+
+var (
+	// globalAuthorizer authorizes shard operations. Nil if authorization is disabled.
+	// It is set once during initialization and never modified.
+	globalAuthorizer *authorizer
+)
+
+// authorizer identifies the principal of a request and checks it against the policy.
+// This type is read-only after creation and thread-safe.
+type authorizer struct {
+	secret []byte
+	policy *auth.Policy
+	// trustRequestInfo identifies requesters by the Nats-Request-Info header, see NATSConfig.TrustRequestInfo.
+	trustRequestInfo bool
+}
+
+// newAuthorizer builds the authorizer from configuration.
+// Assumes the configuration has been validated.
+//
+// params:
+//   - cfg: The authorization configuration
+//   - trustRequestInfo: Whether the NATS user of the Nats-Request-Info header identifies the requester
+//
+// return:
+//   - *authorizer: The authorizer, or nil if no grants are configured (authorization disabled)
+func newAuthorizer(cfg configurations.AuthConfig, trustRequestInfo bool) *authorizer {
+	if len(cfg.Grants) == 0 {
+		return nil
+	}
+
+	policy, err := auth.NewPolicy(cfg.Grants)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to build authorization policy")
+	}
+	return &authorizer{
+		secret:           []byte(cfg.TokenSecret),
+		policy:           policy,
+		trustRequestInfo: trustRequestInfo,
+	}
+}
+
+// authorize identifies the principal of a request and checks that it may perform the action on the bucket and key.
+// The principal is the NATS user of the Nats-Request-Info header when the header is trusted (nats.trustRequestInfo),
+// otherwise the subject of the 'authToken' header. A nil authorizer (authorization disabled) allows every request.
+// Denials are counted in the nimbus_auth_denied_total metric.
+//
+// params:
+//   - msg: The request message
+//   - bucketName: The bucket the request targets
+//   - key: The object key the request targets. Empty for bucket level operations.
+//   - action: The action the request performs
+//
+// return:
+//   - string: The principal, empty if authorization is disabled
+//   - error: An error wrapping ErrForbidden if the requester is not identified or not allowed
+func (a *authorizer) authorize(msg *nats.Msg, bucketName, key string, action auth.Action) (string, error) {
+	return a.authorizeAny(msg, bucketName, key, action)
+}
+
+// authorizeAny is authorize for requests allowed by any of several actions, e.g. bucket creation with write or admin.
+//
+// params:
+//   - msg: The request message
+//   - bucketName: The bucket the request targets, auth.Wildcard for requests spanning every bucket
+//   - key: The object key the request targets. Empty for bucket level operations.
+//   - actions: The actions, any of which allows the request
+//
+// return:
+//   - string: The principal, empty if authorization is disabled
+//   - error: An error wrapping ErrForbidden if the requester is not identified or not allowed
+func (a *authorizer) authorizeAny(msg *nats.Msg, bucketName, key string, actions ...auth.Action) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+
+	label := actionsLabel(actions)
+	principal, err := a.authenticate(msg, label)
+	if err != nil {
+		return "", err
+	}
+	if !a.allows(principal, bucketName, key, actions...) {
+		metrics.GetCounter("nimbus_auth_denied_total", metrics.Labels{"action": label, "reason": "not_granted"}).Inc()
+		return "", fmt.Errorf("%w: %s is not allowed to %s %s/%s", ErrForbidden, principal, label, bucketName, key)
+	}
+	return principal, nil
+}
+
+// authenticate identifies the principal of a request, counting failures for the action label in nimbus_auth_denied_total.
+// A nil authorizer (authorization disabled) returns an empty principal.
+func (a *authorizer) authenticate(msg *nats.Msg, label string) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	principal, err := a.principal(msg)
+	if err != nil {
+		metrics.GetCounter("nimbus_auth_denied_total", metrics.Labels{"action": label, "reason": "unauthenticated"}).Inc()
+		return "", err
+	}
+	return principal, nil
+}
+
+// allows reports whether the principal is granted any of the actions on the bucket and key.
+// A nil authorizer (authorization disabled) allows everything.
+func (a *authorizer) allows(principal, bucketName, key string, actions ...auth.Action) bool {
+	if a == nil {
+		return true
+	}
+	for _, action := range actions {
+		if a.policy.Allows(principal, bucketName, key, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// actionsLabel joins actions for metric labels and error messages, e.g. "write|admin".
+func actionsLabel(actions []auth.Action) string {
+	names := make([]string, len(actions))
+	for i, action := range actions {
+		names[i] = string(action)
+	}
+	return strings.Join(names, "|")
+}
+
+// principal returns the identity of the requester.
+// The Nats-Request-Info header is only set by the NATS server on messages crossing a service import, any client can
+// send it on other messages, so it is ignored unless trusted.
+func (a *authorizer) principal(msg *nats.Msg) (string, error) {
+	if a.trustRequestInfo {
+		if user := natsUser(msg); user != "" {
+			return user, nil
+		}
+	}
+
+	token := msg.Header.Get(AuthTokenHeader)
+	if token == "" {
+		return "", fmt.Errorf("%w: missing '%s' header", ErrForbidden, AuthTokenHeader)
+	}
+	if len(a.secret) == 0 {
+		return "", fmt.Errorf("%w: token authentication is not configured", ErrForbidden)
+	}
+	claims, err := auth.VerifyToken(a.secret, token, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrForbidden, err)
+	}
+	return claims.Subject, nil
+}
+
+// actionFor returns the action performed by a shard operation type.
+// Reads need the read action, everything else changes data and needs the write action.
+func actionFor(operationType int) auth.Action {
+	switch operationType {
+	case PointRead, CollectionRead:
+		return auth.ActionRead
+	default:
+		return auth.ActionWrite
+	}
+}
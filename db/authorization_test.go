@@ -0,0 +1,153 @@
+package db
+
+import (
+	"NimbusDb/auth"
+	"NimbusDb/configurations"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestAuthorizer creates an authorizer granting orders-svc read and write on the orders bucket.
+// The Nats-Request-Info header is trusted, as behind a service import.
+func newTestAuthorizer() *authorizer {
+	return newAuthorizer(configurations.AuthConfig{
+		TokenSecret: "test-secret",
+		Grants: []configurations.AuthGrantConfig{
+			{Principals: []string{"orders-svc"}, Bucket: "orders", Actions: []string{"read", "write"}},
+		},
+	}, true)
+}
+
+func TestNewAuthorizer_Disabled(t *testing.T) {
+	a := newAuthorizer(configurations.AuthConfig{}, false)
+	if a != nil {
+		t.Fatal("Expected nil authorizer when no grants are configured")
+	}
+
+	principal, err := a.authorize(newShardOperationMsg(nil), "orders", "a/b", auth.ActionWrite)
+	if err != nil || principal != "" {
+		t.Errorf("Expected every request to be allowed when authorization is disabled, got principal %q and error %v", principal, err)
+	}
+}
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	a := newTestAuthorizer()
+	validToken, err := auth.SignToken([]byte("test-secret"), auth.Claims{Subject: "orders-svc", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("SignToken() failed: %v", err)
+	}
+	forgedToken, _ := auth.SignToken([]byte("other-secret"), auth.Claims{Subject: "orders-svc"})
+
+	tests := []struct {
+		name              string
+		headers           map[string]string
+		action            auth.Action
+		expectedPrincipal string
+		expectForbid      bool
+	}{
+		{"valid token", map[string]string{AuthTokenHeader: validToken}, auth.ActionWrite, "orders-svc", false},
+		{"NATS user", map[string]string{natsRequestInfoHeader: `{"user":"orders-svc"}`}, auth.ActionRead, "orders-svc", false},
+		{"action not granted", map[string]string{AuthTokenHeader: validToken}, auth.ActionDelete, "", true},
+		{"forged token", map[string]string{AuthTokenHeader: forgedToken}, auth.ActionRead, "", true},
+		{"anonymous", nil, auth.ActionRead, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := a.authorize(newShardOperationMsg(tt.headers), "orders", "a/b", tt.action)
+			if tt.expectForbid {
+				if !errors.Is(err, ErrForbidden) {
+					t.Errorf("Expected ErrForbidden, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected request to be allowed, got %v", err)
+			}
+			if principal != tt.expectedPrincipal {
+				t.Errorf("Expected principal %s, got %s", tt.expectedPrincipal, principal)
+			}
+		})
+	}
+}
+
+func TestAuthorizer_UntrustedRequestInfo(t *testing.T) {
+	a := newTestAuthorizer()
+	a.trustRequestInfo = false
+	validToken, err := auth.SignToken([]byte("test-secret"), auth.Claims{Subject: "orders-svc"})
+	if err != nil {
+		t.Fatalf("SignToken() failed: %v", err)
+	}
+
+	// Without a service import, the header is whatever the client sent
+	forged := newShardOperationMsg(map[string]string{natsRequestInfoHeader: `{"user":"orders-svc"}`})
+	if _, err := a.authorize(forged, "orders", "a/b", auth.ActionRead); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a forged Nats-Request-Info header without token, got %v", err)
+	}
+
+	withToken := newShardOperationMsg(map[string]string{natsRequestInfoHeader: `{"user":"admin"}`, AuthTokenHeader: validToken})
+	principal, err := a.authorize(withToken, "orders", "a/b", auth.ActionRead)
+	if err != nil {
+		t.Fatalf("Expected request to be allowed, got %v", err)
+	}
+	if principal != "orders-svc" {
+		t.Errorf("Expected the principal of the token, got %s", principal)
+	}
+}
+
+func TestHandleShardOperation_ForgedRequestInfo(t *testing.T) {
+	nc, _ := runJetStreamServer(t)
+	globalAuthorizer = newTestAuthorizer()
+	globalAuthorizer.trustRequestInfo = false
+	defer func() { globalAuthorizer = nil }()
+
+	queue := newShardQueue(0, 10, 3, 0, time.Second)
+	defer queue.close()
+	go handleShardOperation(0, queue)
+	sub, err := nc.Subscribe("nimbus.shards.0.op", queue.enqueue)
+	if err != nil {
+		t.Fatalf("Subscribe() failed: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	// A plain publish, not crossing a service import, carrying a Nats-Request-Info header of a granted user
+	msg := newShardOperationMsg(map[string]string{
+		"type":                "1",
+		"fileName":            "/a/b",
+		"bucketName":          "orders",
+		natsRequestInfoHeader: `{"user":"orders-svc"}`,
+	})
+	resp, err := nc.RequestMsg(msg, 5*time.Second)
+	if err != nil {
+		t.Fatalf("RequestMsg() failed: %v", err)
+	}
+	if got := resp.Header.Get(StatusHeader); got != strconv.Itoa(ErrorCodeForbidden) {
+		t.Errorf("Expected %s header to be %d, got %q (%s)", StatusHeader, ErrorCodeForbidden, got, resp.Header.Get(ErrorHeader))
+	}
+}
+
+func TestExtractShardOperationHeaders_Authorization(t *testing.T) {
+	globalAuthorizer = newTestAuthorizer()
+	defer func() { globalAuthorizer = nil }()
+
+	msg := newShardOperationMsg(map[string]string{
+		"type":                "0",
+		"fileName":            "/a/b",
+		"bucketName":          "users",
+		natsRequestInfoHeader: `{"user":"orders-svc"}`,
+	})
+	if _, err := ExtractShardOperationHeaders(msg); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a bucket not granted, got %v", err)
+	}
+
+	msg.Header.Set("bucketName", "orders")
+	headers, err := ExtractShardOperationHeaders(msg)
+	if err != nil {
+		t.Fatalf("ExtractShardOperationHeaders() failed: %v", err)
+	}
+	if headers.Principal != "orders-svc" {
+		t.Errorf("Expected principal orders-svc, got %s", headers.Principal)
+	}
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"NimbusDb/metrics"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ChangeOperationWrite is the operation of change events published for writes.
+	ChangeOperationWrite = "write"
+	// ChangeOperationDelete is the operation of change events published for deletes.
+	ChangeOperationDelete = "delete"
+)
+
+var (
+	// globalCDC publishes change events. Nil if change data capture is disabled.
+	// It is set once during initialization and never modified.
+	globalCDC *cdcPublisher
+)
+
+// cdcPublisher publishes change events to NATS, or to a JetStream stream for durability.
+// This type is read-only after creation and thread-safe.
+type cdcPublisher struct {
+	subjectPrefix string
+	// js is nil when events are published with core NATS.
+	js        jetstream.JetStream
+	published *metrics.Counter
+	failed    *metrics.Counter
+}
+
+// InitializeCDC sets up change data capture if it is enabled.
+// Watches are only available with change data capture.
+// With JetStream enabled, the stream capturing the events is created (or updated) so events are durable from the first write.
+// Must be called after InitializeGlobals and before starting the shard handlers.
+func InitializeCDC() {
+	cfg := globalConfig.CDC
+	if !cfg.Enabled {
+		return
+	}
+
+	p := &cdcPublisher{
+		subjectPrefix: cfg.SubjectPrefix,
+		published:     metrics.GetCounter("nimbus_cdc_events_published_total", nil),
+		failed:        metrics.GetCounter("nimbus_cdc_publish_errors_total", nil),
+	}
+
+	if cfg.JetStream {
+		js, err := jetstream.New(globalNATSConn)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create JetStream context for CDC")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+		defer cancel()
+		_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:     cfg.Stream,
+			Subjects: []string{cfg.SubjectPrefix + ".>"},
+			MaxAge:   cfg.MaxAge,
+			Storage:  jetstream.FileStorage,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Str("stream", cfg.Stream).Msg("Failed to create CDC stream")
+		}
+		p.js = js
+	}
+
+	globalCDC = p
+	globalWatches = newWatchRegistry(cfg.WatchLease)
+	log.Info().Str("subjectPrefix", cfg.SubjectPrefix).Bool("jetStream", cfg.JetStream).Msg("Change data capture enabled")
+}
+
+// cdcSubject returns the subject change events of a bucket are published to.
+// Dots in bucket names would split the subject into several tokens, so they are replaced by underscores.
+//
+// params:
+//   - subjectPrefix: The CDC subject prefix
+//   - bucketName: The bucket of the changed object
+//
+// return:
+//   - string: The subject, {subjectPrefix}.{bucketName with dots replaced by underscores}
+func cdcSubject(subjectPrefix, bucketName string) string {
+	return subjectPrefix + "." + strings.ReplaceAll(bucketName, ".", "_")
+}
+
+// publish publishes a change event. A nil publisher (CDC disabled) does nothing.
+// Failures are logged and counted but never fail the change itself, which is already stored.
+// With JetStream, the publish waits for the stream ack. Publishes are not retried, so no message ID is set:
+// changes of an unversioned key share their version ID and would be dropped as duplicates.
+//
+// params:
+//   - event: The change event
+func (p *cdcPublisher) publish(event ChangeEvent) {
+	if p == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		p.failed.Inc()
+		log.Error().Err(err).Msg("Failed to encode change event")
+		return
+	}
+	msg := nats.NewMsg(cdcSubject(p.subjectPrefix, event.Bucket))
+	msg.Data = data
+
+	if p.js != nil {
+		// Not bound to the client deadline, the change is stored and its event should not be lost
+		ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+		defer cancel()
+		_, err = p.js.PublishMsg(ctx, msg)
+	} else {
+		err = globalNATSConn.PublishMsg(msg)
+	}
+	if err != nil {
+		p.failed.Inc()
+		log.Error().Err(err).Str("bucketName", event.Bucket).Str("fileName", event.Key).Msg("Failed to publish change event")
+		return
+	}
+	p.published.Inc()
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestCDCSubject(t *testing.T) {
+	tests := []struct {
+		bucketName string
+		expected   string
+	}{
+		{"orders", "nimbus.cdc.orders"},
+		{"orders.eu", "nimbus.cdc.orders_eu"},
+		{"a.b.c", "nimbus.cdc.a_b_c"},
+	}
+
+	for _, tt := range tests {
+		if subject := cdcSubject("nimbus.cdc", tt.bucketName); subject != tt.expected {
+			t.Errorf("Expected subject '%s', got '%s'", tt.expected, subject)
+		}
+	}
+}
+
+func TestChangeEvent_JSON(t *testing.T) {
+	event := ChangeEvent{
+		Bucket:    "orders",
+		Key:       "order-1",
+		VersionID: "v1",
+		Size:      42,
+		Operation: ChangeOperationWrite,
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"bucket":"orders","key":"order-1","versionId":"v1","size":42,"operation":"write","timestamp":"2024-01-02T03:04:05Z"}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, string(b))
+	}
+}
+
+func TestCDCPublisher_NilIsNoOp(t *testing.T) {
+	var p *cdcPublisher
+	// Must not panic when change data capture is disabled
+	p.publish(ChangeEvent{Bucket: "orders", Key: "order-1", Operation: ChangeOperationWrite})
+}
+
+func TestCDCPublisher_Publish(t *testing.T) {
+	for _, jetStream := range []bool{false, true} {
+		name := "core"
+		if jetStream {
+			name = "jetstream"
+		}
+		t.Run(name, func(t *testing.T) {
+			nc, js := runJetStreamServer(t)
+			cfg := &configurations.Config{}
+			cfg.Blob.BlobOperationTimeout = 5 * time.Second
+			cfg.CDC = configurations.CDCConfig{Enabled: true, SubjectPrefix: "nimbus.cdc", JetStream: jetStream, Stream: "NIMBUS_CDC", MaxAge: time.Hour}
+			previousConfig, previousConn := globalConfig, globalNATSConn
+			globalConfig, globalNATSConn = cfg, nc
+			defer func() {
+				globalConfig, globalNATSConn, globalCDC, globalWatches = previousConfig, previousConn, nil, nil
+			}()
+
+			sub, err := nc.SubscribeSync("nimbus.cdc.orders_eu")
+			if err != nil {
+				t.Fatalf("SubscribeSync() failed: %v", err)
+			}
+			if err := nc.Flush(); err != nil {
+				t.Fatalf("Flush() failed: %v", err)
+			}
+
+			InitializeCDC()
+			publishedBefore := globalCDC.published.Value()
+			event := ChangeEvent{
+				Bucket:    "orders.eu",
+				Key:       "order-1",
+				VersionID: "v1",
+				Size:      42,
+				Operation: ChangeOperationWrite,
+				Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			}
+			globalCDC.publish(event)
+
+			msg, err := sub.NextMsg(5 * time.Second)
+			if err != nil {
+				t.Fatalf("Expected change event on 'nimbus.cdc.orders_eu', got %v", err)
+			}
+			var got ChangeEvent
+			if err := json.Unmarshal(msg.Data, &got); err != nil {
+				t.Fatalf("Expected JSON change event, got %v (%s)", err, msg.Data)
+			}
+			if got != event {
+				t.Errorf("Expected %+v, got %+v", event, got)
+			}
+			if published := globalCDC.published.Value() - publishedBefore; published != 1 {
+				t.Errorf("Expected 1 published event, got %d", published)
+			}
+
+			if !jetStream {
+				if _, err := js.Stream(context.Background(), "NIMBUS_CDC"); err != jetstream.ErrStreamNotFound {
+					t.Errorf("Expected no stream with core NATS, got %v", err)
+				}
+				return
+			}
+
+			// Changes of an unversioned key share their version ID and are all stored
+			overwrite := event
+			overwrite.VersionID = "null"
+			overwrite.Size = 43
+			event.VersionID = "null"
+			globalCDC.publish(event)
+			globalCDC.publish(overwrite)
+			stream, err := js.Stream(context.Background(), "NIMBUS_CDC")
+			if err != nil {
+				t.Fatalf("Stream() failed: %v", err)
+			}
+			stored, err := stream.GetLastMsgForSubject(context.Background(), "nimbus.cdc.orders_eu")
+			if err != nil {
+				t.Fatalf("GetLastMsgForSubject() failed: %v", err)
+			}
+			var last ChangeEvent
+			if err := json.Unmarshal(stored.Data, &last); err != nil {
+				t.Fatalf("Expected JSON change event, got %v (%s)", err, stored.Data)
+			}
+			if last != overwrite {
+				t.Errorf("Expected stored event %+v, got %+v", overwrite, last)
+			}
+			info, err := stream.Info(context.Background())
+			if err != nil {
+				t.Fatalf("Info() failed: %v", err)
+			}
+			if info.State.Msgs != 3 {
+				t.Errorf("Expected 3 events in the stream, got %d", info.State.Msgs)
+			}
+		})
+	}
+}
@@ -3,25 +3,60 @@ package db
 import (
 	"NimbusDb/blob"
 	"NimbusDb/configurations"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
 )
 
 const (
 	// ErrorCodeBadRequest represents a client error (400)
 	ErrorCodeBadRequest = 400
+	// ErrorCodeForbidden represents a request outside of the caller's tenant namespace (403)
+	ErrorCodeForbidden = 403
+	// ErrorCodeNotFound represents a missing resource, e.g. a bucket (404)
+	ErrorCodeNotFound = 404
+	// ErrorCodeConflict represents a request conflicting with the current state, e.g. deleting a non-empty bucket (409)
+	ErrorCodeConflict = 409
+	// ErrorCodeGone represents a resource that expired, e.g. a watch whose lease was not renewed (410)
+	ErrorCodeGone = 410
+	// ErrorCodeTooManyRequests represents a request throttled by a rate limit (429), retry after the hinted delay
+	ErrorCodeTooManyRequests = 429
 	// ErrorCodeInternalServerError represents a server error (500)
 	ErrorCodeInternalServerError = 500
+	// ErrorCodeServiceUnavailable represents an overloaded or unavailable shard (503), retry after the hinted delay
+	ErrorCodeServiceUnavailable = 503
+	// ErrorCodeGatewayTimeout represents a request whose deadline passed before it completed (504)
+	ErrorCodeGatewayTimeout = 504
+	// ErrorCodeInsufficientStorage represents a write rejected by a bucket quota (507)
+	ErrorCodeInsufficientStorage = 507
 
 	SuccessCode = 200
 )
 
+const (
+	// StatusHeader is the response header carrying the status code of the operation.
+	// It is set on every response, success or error.
+	StatusHeader = "Nimbus-Status"
+	// ErrorHeader is the response header carrying the error description.
+	// It is only set on error responses.
+	ErrorHeader = "Nimbus-Error"
+	// RetryAfterHeader is the response header carrying how long (in ms) the client should wait before retrying.
+	// It is only set on retryable error responses.
+	RetryAfterHeader = "Nimbus-Retry-After"
+)
+
+// headerValueReplacer flattens multi-line values so they can be sent as a single header line.
+var headerValueReplacer = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
 var (
 	// globalConfig holds the configuration for system handlers.
 	// It is set once during initialization and never modified.
@@ -29,9 +64,9 @@ var (
 	// globalNATSConn holds the NATS connection for system handlers.
 	// It is set once during initialization and never modified.
 	globalNATSConn *nats.Conn
-	// globalBlobClient holds the blob client for system handlers.
+	// globalBlobClient holds the blob storage reads and writes are applied to.
 	// It is set once during initialization and never modified.
-	globalBlobClient *blob.Client
+	globalBlobClient blob.Storage
 	// initOnce ensures InitializeGlobals can only be called once.
 	initOnce sync.Once
 )
@@ -48,8 +83,41 @@ type ShardOperationHeaders struct {
 	FileName      string
 	BucketName    string
 	Overwrite     bool
+	// Async acknowledges a write once queued, before it reaches blob storage. From the 'async' header.
+	Async bool
+	// Tenant is the tenant the request belongs to. Empty if tenancy is disabled.
+	Tenant string
+	// Principal is the authenticated requester. Empty if authorization is disabled.
+	Principal string
+	// Deadline is the absolute client deadline from the 'deadline' header. Zero if not supplied.
+	Deadline time.Time
+	// Timeout is the client timeout from the 'timeoutMs' header, relative to when the node received the request.
+	// Zero if not supplied.
+	Timeout time.Duration
+}
+
+// EffectiveDeadline returns the earliest of the client supplied deadlines.
+// The relative timeout is anchored at the time the node received the request,
+// so time spent waiting in the shard channel counts against it.
+//
+// params:
+//   - receivedAt: The time the request was received from NATS
+//
+// return:
+//   - time.Time: The deadline for the request, or the zero time if the client did not supply one
+func (h *ShardOperationHeaders) EffectiveDeadline(receivedAt time.Time) time.Time {
+	deadline := h.Deadline
+	if h.Timeout > 0 {
+		timeoutDeadline := receivedAt.Add(h.Timeout)
+		if deadline.IsZero() || timeoutDeadline.Before(deadline) {
+			deadline = timeoutDeadline
+		}
+	}
+	return deadline
 }
 
+// DbResponse is the JSON body of write and error responses.
+// Kept for backward compatibility, clients should rely on the Nimbus-Status and Nimbus-Error headers instead.
 type DbResponse struct {
 	Error  string `json:"error"`
 	Status int    `json:"status"`
@@ -62,12 +130,16 @@ type DbResponse struct {
 // params:
 //   - cfg: The application configuration containing NATS and shard settings
 //   - nc: The NATS connection to use for subscriptions
-//   - blobClient: The blob client to use for storage operations
-func InitializeGlobals(cfg *configurations.Config, nc *nats.Conn, blobClient *blob.Client) {
+//   - blobClient: The blob storage to use for storage operations, of any provider
+func InitializeGlobals(cfg *configurations.Config, nc *nats.Conn, blobClient blob.Storage) {
 	initOnce.Do(func() {
 		globalConfig = cfg
 		globalNATSConn = nc
 		globalBlobClient = blobClient
+		globalTenants = newTenantRegistry(cfg.Tenants, cfg.NATS.TrustRequestInfo)
+		globalAuthorizer = newAuthorizer(cfg.Auth, cfg.NATS.TrustRequestInfo)
+		globalLimits = newLimits(cfg.Limits, cfg.ShardCount)
+		globalDeadLetters = newDeadLetterPublisher(cfg.Db.DeadLetterSubject)
 	})
 }
 
@@ -109,44 +181,133 @@ func SetGlobalState(state *configurations.State) {
 
 // RespondWithNatsError responds with a NATS native error using headers.
 // This provides a standardized, reusable error response format across all handlers.
-// Uses NATS native error headers as per NATS best practices.
+// The status and description are carried in the Nimbus-Status and Nimbus-Error headers,
+// so clients can tell errors apart from data without parsing the body.
+// The JSON DbResponse body is kept for backward compatibility with older clients.
 // Retryability can be determined by the error code: 4xx errors are typically not retriable,
 // while 5xx errors may be retriable depending on the specific error.
 // params:
 //   - msg: The NATS message to respond to
-//   - errorCode: The error code (e.g., "400" for bad request, "500" for server error)
-//   - errorDescription: The error description/message
-//
-// return:
-//   - nil
+//   - status: The error code (e.g., 400 for bad request, 500 for server error)
+//   - description: The error description/message
 func RespondWithNatsError(msg *nats.Msg, status int, description string) {
 	resp := DbResponse{
 		Error:  description,
 		Status: status,
 	}
 	b, _ := json.Marshal(resp)
-	msg.Respond(b)
+	respond(msg, newResponseMsg(status, description, b))
+}
+
+// RespondWithNatsRetryableError responds with an error like RespondWithNatsError,
+// and additionally tells the client how long to back off before retrying via the Nimbus-Retry-After header.
+// params:
+//   - msg: The NATS message to respond to
+//   - status: The error code (e.g., 503 for an overloaded shard)
+//   - description: The error description/message
+//   - retryAfter: How long the client should wait before retrying
+func RespondWithNatsRetryableError(msg *nats.Msg, status int, description string, retryAfter time.Duration) {
+	resp := DbResponse{
+		Error:  description,
+		Status: status,
+	}
+	b, _ := json.Marshal(resp)
+	respMsg := newResponseMsg(status, description, b)
+	respMsg.Header.Set(RetryAfterHeader, strconv.FormatInt(retryAfter.Milliseconds(), 10))
+	respond(msg, respMsg)
 }
 
+// RespondWithNatsSuccess responds with a success status (200) and no payload.
+// The status is carried in the Nimbus-Status header, with the JSON DbResponse body
+// kept for backward compatibility with older clients.
+// params:
+//   - msg: The NATS message to respond to
 func RespondWithNatsSuccess(msg *nats.Msg) {
 	resp := DbResponse{
 		Error:  "",
 		Status: SuccessCode,
 	}
 	b, _ := json.Marshal(resp)
-	msg.Respond(b)
+	respond(msg, newResponseMsg(SuccessCode, "", b))
+}
 
+// RespondWithNatsData responds with a success status (200) and the given raw payload.
+// The payload is sent as is (never parsed or wrapped), the status lives only in the headers.
+// params:
+//   - msg: The NATS message to respond to
+//   - data: The raw payload to return to the requester
+func RespondWithNatsData(msg *nats.Msg, data []byte) {
+	respond(msg, newResponseMsg(SuccessCode, "", data))
 }
 
-// extractShardOperationHeaders extracts and validates required headers from a NATS message.
-// It extracts operation type, fileName, and bucketName from the message headers.
+// newResponseMsg builds a response message carrying the status headers and the given body.
+// params:
+//   - status: The status code to put in the Nimbus-Status header
+//   - description: The error description to put in the Nimbus-Error header. Omitted if empty.
+//   - data: The response body
+//
+// return:
+//   - *nats.Msg: The response message (subject is filled in by msg.RespondMsg)
+func newResponseMsg(status int, description string, data []byte) *nats.Msg {
+	resp := nats.NewMsg("")
+	resp.Header.Set(StatusHeader, strconv.Itoa(status))
+	if description != "" {
+		// header values cannot span multiple lines
+		resp.Header.Set(ErrorHeader, headerValueReplacer.Replace(description))
+	}
+	resp.Data = data
+	return resp
+}
+
+// respond sends the response message and logs if it could not be sent.
+func respond(msg *nats.Msg, resp *nats.Msg) {
+	if err := msg.RespondMsg(resp); err != nil {
+		log.Error().Err(err).Str("subject", msg.Subject).Msg("Failed to send NATS response")
+	}
+}
+
+// blobErrorStatus returns the response status for a failed blob operation.
+// Client mistakes map to 4xx, operations that ran out of time report 504 so clients
+// can tell them apart from storage failures, and everything else is a 500.
+// params:
+//   - err: The error returned by the blob client
+//
+// return:
+//   - int: The response status
+func blobErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, blob.ErrInvalidBucketName), errors.Is(err, blob.ErrInvalidObjectLock), errors.Is(err, blob.ErrPreconditionFailed):
+		return ErrorCodeBadRequest
+	case errors.Is(err, blob.ErrBucketNotFound), errors.Is(err, blob.ErrObjectNotFound):
+		return ErrorCodeNotFound
+	case errors.Is(err, blob.ErrBucketNotEmpty), errors.Is(err, blob.ErrObjectLocked), errors.Is(err, blob.ErrObjectLockNotEnabled):
+		return ErrorCodeConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorCodeGatewayTimeout
+	default:
+		return ErrorCodeInternalServerError
+	}
+}
+
+// isPermanentWriteError reports whether a deferred write failed for a reason retrying cannot fix,
+// so it is dead-lettered instead of retried: the bucket is invalid or missing, or the object is locked.
+func isPermanentWriteError(err error) bool {
+	return errors.Is(err, blob.ErrInvalidBucketName) || errors.Is(err, blob.ErrBucketNotFound) || errors.Is(err, blob.ErrObjectLocked)
+}
+
+// ExtractShardOperationHeaders extracts and validates required headers from a NATS message.
+// It extracts operation type, fileName, and bucketName from the message headers,
+// along with the optional overwrite, async, deadline and timeoutMs headers.
+// Flush operations apply to the whole shard and need neither fileName nor bucketName.
+// If tenants are configured, it also rejects requests targeting a bucket outside the tenant namespace,
+// and if authorization is configured, requests whose requester is not granted the operation on the bucket and key.
 // Optimized for performance by using direct map access and explicit base parsing.
 // params:
 //   - msg: The NATS message containing the operation request
 //
 // return:
 //   - *ShardOperationHeaders: The extracted headers
-//   - error: An error if any required header is missing or invalid
+//   - error: An error if any required header is missing or invalid, wrapping ErrForbidden if the request is outside the tenant namespace or not authorized
 func ExtractShardOperationHeaders(msg *nats.Msg) (*ShardOperationHeaders, error) {
 	h := msg.Header
 
@@ -160,15 +321,18 @@ func ExtractShardOperationHeaders(msg *nats.Msg) (*ShardOperationHeaders, error)
 		return nil, fmt.Errorf("invalid 'type' header: %s", opStr)
 	}
 
+	// A flush applies to the whole shard, it has no bucket or file
+	shardWide := op == Flush
+
 	// --- fileName ---
 	fn := h.Get("fileName")
-	if fn == "" {
+	if fn == "" && !shardWide {
 		return nil, errors.New("missing 'fileName' header")
 	}
 
 	// --- bucketName ---
 	bn := h.Get("bucketName")
-	if bn == "" {
+	if bn == "" && !shardWide {
 		return nil, errors.New("missing 'bucketName' header")
 	}
 
@@ -182,11 +346,61 @@ func ExtractShardOperationHeaders(msg *nats.Msg) (*ShardOperationHeaders, error)
 		}
 	}
 
+	// --- async (default false) ---
+	var async bool
+	if asStr := h.Get("async"); asStr != "" {
+		async, err = strconv.ParseBool(asStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'async' header: %s", asStr)
+		}
+	}
+
+	// --- deadline (optional, unix epoch milliseconds) ---
+	var deadline time.Time
+	if dlStr := h.Get("deadline"); dlStr != "" {
+		dl, err := strconv.ParseInt(dlStr, 10, 64)
+		if err != nil || dl <= 0 {
+			return nil, fmt.Errorf("invalid 'deadline' header: %s", dlStr)
+		}
+		deadline = time.UnixMilli(dl)
+	}
+
+	// --- timeoutMs (optional) ---
+	var timeout time.Duration
+	if toStr := h.Get("timeoutMs"); toStr != "" {
+		to, err := strconv.ParseInt(toStr, 10, 64)
+		if err != nil || to <= 0 {
+			return nil, fmt.Errorf("invalid 'timeoutMs' header: %s", toStr)
+		}
+		timeout = time.Duration(to) * time.Millisecond
+	}
+
+	// --- tenant and authToken, not checked for shard wide operations which access no data ---
+	var tenant, principal string
+	if !shardWide {
+		// required if tenants are configured
+		tenant, err = globalTenants.authorize(msg, bn)
+		if err != nil {
+			return nil, err
+		}
+
+		// required if authorization is configured
+		principal, err = globalAuthorizer.authorize(msg, bn, fn, actionFor(op))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// return the struct pointer (single heap alloc)
 	return &ShardOperationHeaders{
 		OperationType: op,
 		FileName:      fn,
 		BucketName:    bn,
 		Overwrite:     ow,
+		Async:         async,
+		Deadline:      deadline,
+		Timeout:       timeout,
+		Tenant:        tenant,
+		Principal:     principal,
 	}, nil
 }
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// newShardOperationMsg creates a shard operation message with the given headers.
+func newShardOperationMsg(headers map[string]string) *nats.Msg {
+	msg := nats.NewMsg("nimbus.shards.0.op")
+	for k, v := range headers {
+		msg.Header.Set(k, v)
+	}
+	return msg
+}
+
+func TestNewResponseMsg_Success(t *testing.T) {
+	resp := newResponseMsg(SuccessCode, "", []byte(`{"error":"looks like an error"}`))
+
+	if got := resp.Header.Get(StatusHeader); got != strconv.Itoa(SuccessCode) {
+		t.Errorf("Expected %s header to be %d, got %q", StatusHeader, SuccessCode, got)
+	}
+	if _, ok := resp.Header[ErrorHeader]; ok {
+		t.Errorf("Expected no %s header on success response", ErrorHeader)
+	}
+	if string(resp.Data) != `{"error":"looks like an error"}` {
+		t.Errorf("Expected payload to be returned untouched, got %s", string(resp.Data))
+	}
+}
+
+func TestNewResponseMsg_Error(t *testing.T) {
+	resp := newResponseMsg(ErrorCodeInternalServerError, "failed to read file:\nconnection reset", nil)
+
+	if got := resp.Header.Get(StatusHeader); got != strconv.Itoa(ErrorCodeInternalServerError) {
+		t.Errorf("Expected %s header to be %d, got %q", StatusHeader, ErrorCodeInternalServerError, got)
+	}
+	if got := resp.Header.Get(ErrorHeader); got != "failed to read file: connection reset" {
+		t.Errorf("Expected %s header to be a single line, got %q", ErrorHeader, got)
+	}
+}
+
+func TestExtractShardOperationHeaders_Success(t *testing.T) {
+	msg := newShardOperationMsg(map[string]string{
+		"type":       "0",
+		"fileName":   "/ts-id-2/p",
+		"bucketName": "gk-test",
+		"overwrite":  "false",
+	})
+
+	headers, err := ExtractShardOperationHeaders(msg)
+	if err != nil {
+		t.Fatalf("ExtractShardOperationHeaders() failed: %v", err)
+	}
+	if headers.OperationType != PointWrite {
+		t.Errorf("Expected OperationType to be %d, got %d", PointWrite, headers.OperationType)
+	}
+	if headers.FileName != "/ts-id-2/p" {
+		t.Errorf("Expected FileName to be '/ts-id-2/p', got %s", headers.FileName)
+	}
+	if headers.BucketName != "gk-test" {
+		t.Errorf("Expected BucketName to be 'gk-test', got %s", headers.BucketName)
+	}
+	if headers.Overwrite {
+		t.Error("Expected Overwrite to be false")
+	}
+}
+
+func TestExtractShardOperationHeaders_DefaultOverwrite(t *testing.T) {
+	msg := newShardOperationMsg(map[string]string{
+		"type":       "1",
+		"fileName":   "/ts-id-2/p",
+		"bucketName": "gk-test",
+	})
+
+	headers, err := ExtractShardOperationHeaders(msg)
+	if err != nil {
+		t.Fatalf("ExtractShardOperationHeaders() failed: %v", err)
+	}
+	if !headers.Overwrite {
+		t.Error("Expected Overwrite to default to true")
+	}
+}
+
+func TestExtractShardOperationHeaders_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"missing type", map[string]string{"fileName": "f", "bucketName": "b"}},
+		{"invalid type", map[string]string{"type": "write", "fileName": "f", "bucketName": "b"}},
+		{"missing fileName", map[string]string{"type": "0", "bucketName": "b"}},
+		{"missing bucketName", map[string]string{"type": "0", "fileName": "f"}},
+		{"invalid overwrite", map[string]string{"type": "0", "fileName": "f", "bucketName": "b", "overwrite": "maybe"}},
+		{"invalid async", map[string]string{"type": "0", "fileName": "f", "bucketName": "b", "async": "later"}},
+		{"invalid deadline", map[string]string{"type": "0", "fileName": "f", "bucketName": "b", "deadline": "tomorrow"}},
+		{"negative timeoutMs", map[string]string{"type": "0", "fileName": "f", "bucketName": "b", "timeoutMs": "-5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ExtractShardOperationHeaders(newShardOperationMsg(tt.headers)); err == nil {
+				t.Error("ExtractShardOperationHeaders() should have failed")
+			}
+		})
+	}
+}
+
+func TestExtractShardOperationHeaders_Async(t *testing.T) {
+	headers, err := ExtractShardOperationHeaders(newShardOperationMsg(map[string]string{
+		"type":       "0",
+		"fileName":   "/ts-id-2/p",
+		"bucketName": "gk-test",
+		"async":      "true",
+	}))
+	if err != nil {
+		t.Fatalf("ExtractShardOperationHeaders() failed: %v", err)
+	}
+	if !headers.Async {
+		t.Error("Expected Async to be true")
+	}
+}
+
+func TestExtractShardOperationHeaders_Flush(t *testing.T) {
+	headers, err := ExtractShardOperationHeaders(newShardOperationMsg(map[string]string{
+		"type":      "4",
+		"timeoutMs": "5000",
+	}))
+	if err != nil {
+		t.Fatalf("ExtractShardOperationHeaders() failed: %v", err)
+	}
+	if headers.OperationType != Flush {
+		t.Errorf("Expected OperationType to be %d, got %d", Flush, headers.OperationType)
+	}
+	if headers.Timeout != 5*time.Second {
+		t.Errorf("Expected Timeout to be 5s, got %s", headers.Timeout)
+	}
+}
+
+func TestExtractShardOperationHeaders_Deadlines(t *testing.T) {
+	msg := newShardOperationMsg(map[string]string{
+		"type":       "1",
+		"fileName":   "f",
+		"bucketName": "b",
+		"deadline":   "1700000000000",
+		"timeoutMs":  "250",
+	})
+
+	headers, err := ExtractShardOperationHeaders(msg)
+	if err != nil {
+		t.Fatalf("ExtractShardOperationHeaders() failed: %v", err)
+	}
+	if !headers.Deadline.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("Expected Deadline to be %v, got %v", time.UnixMilli(1700000000000), headers.Deadline)
+	}
+	if headers.Timeout != 250*time.Millisecond {
+		t.Errorf("Expected Timeout to be 250ms, got %v", headers.Timeout)
+	}
+}
+
+func TestShardOperationHeaders_EffectiveDeadline(t *testing.T) {
+	receivedAt := time.Now()
+
+	tests := []struct {
+		name     string
+		headers  ShardOperationHeaders
+		expected time.Time
+	}{
+		{"none", ShardOperationHeaders{}, time.Time{}},
+		{"deadline only", ShardOperationHeaders{Deadline: receivedAt.Add(time.Second)}, receivedAt.Add(time.Second)},
+		{"timeout only", ShardOperationHeaders{Timeout: time.Second}, receivedAt.Add(time.Second)},
+		{"timeout earlier", ShardOperationHeaders{Deadline: receivedAt.Add(time.Minute), Timeout: time.Second}, receivedAt.Add(time.Second)},
+		{"deadline earlier", ShardOperationHeaders{Deadline: receivedAt.Add(time.Second), Timeout: time.Minute}, receivedAt.Add(time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.headers.EffectiveDeadline(receivedAt); !got.Equal(tt.expected) {
+				t.Errorf("Expected deadline %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseObjectLockHeaders(t *testing.T) {
+	lock, err := parseObjectLockHeaders(newShardOperationMsg(map[string]string{
+		"objectLock":    "true",
+		"retentionMode": blob.ObjectLockModeCompliance,
+		"retentionDays": "2555",
+	}).Header, 3650)
+	if err != nil {
+		t.Fatalf("parseObjectLockHeaders() failed: %v", err)
+	}
+	expected := blob.ObjectLockConfig{Enabled: true, Mode: blob.ObjectLockModeCompliance, RetentionDays: 2555}
+	if lock != expected {
+		t.Errorf("Expected %+v, got %+v", expected, lock)
+	}
+
+	lock, err = parseObjectLockHeaders(newShardOperationMsg(nil).Header, 3650)
+	if err != nil || lock.Enabled {
+		t.Errorf("Expected no object lock without headers, got %+v (%v)", lock, err)
+	}
+
+	for _, headers := range []map[string]string{
+		{"objectLock": "yes please"},
+		{"objectLock": "true", "retentionMode": blob.ObjectLockModeGovernance, "retentionDays": "a week"},
+		{"retentionMode": blob.ObjectLockModeGovernance, "retentionDays": "7"},
+		{"objectLock": "true", "retentionMode": blob.ObjectLockModeCompliance, "retentionDays": "36500"},
+	} {
+		if _, err := parseObjectLockHeaders(newShardOperationMsg(headers).Header, 3650); err == nil {
+			t.Errorf("Expected headers %v to be rejected", headers)
+		}
+	}
+}
+
+func TestIsPermanentWriteError(t *testing.T) {
+	if !isPermanentWriteError(fmt.Errorf("%w: trail", blob.ErrObjectLocked)) {
+		t.Error("Expected a locked object to be a permanent write error")
+	}
+	if isPermanentWriteError(errors.New("connection reset")) {
+		t.Error("Expected a connection error not to be a permanent write error")
+	}
+	if status := blobErrorStatus(fmt.Errorf("%w: trail", blob.ErrObjectLocked)); status != ErrorCodeConflict {
+		t.Errorf("Expected status %d, got %d", ErrorCodeConflict, status)
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"NimbusDb/metrics"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// globalDeadLetters publishes acknowledged writes that could not be applied to blob storage.
+	// It is set once during initialization and never modified.
+	globalDeadLetters *deadLetterPublisher
+)
+
+// deadLetterPublisher publishes acknowledged writes that could not be applied to blob storage to the dead-letter subject,
+// so that they are not lost silently.
+// This type is read-only after creation and thread-safe.
+type deadLetterPublisher struct {
+	subject   string
+	published *metrics.Counter
+}
+
+// newDeadLetterPublisher creates the dead-letter publisher.
+func newDeadLetterPublisher(subject string) *deadLetterPublisher {
+	return &deadLetterPublisher{
+		subject:   subject,
+		published: metrics.GetCounter("nimbus_dead_letters_total", nil),
+	}
+}
+
+// publish publishes a write that could not be applied, with its content and the cause of the failure.
+// The write is logged in any case. A nil publisher only logs.
+//
+// params:
+//   - shardID: The shard of the write
+//   - bucketName: The bucket of the write
+//   - fileName: The file of the write
+//   - data: The content of the write
+//   - cause: Why the write could not be applied
+func (p *deadLetterPublisher) publish(shardID uint16, bucketName, fileName string, data []byte, cause error) {
+	log.Error().Err(cause).Uint16("shardID", shardID).Str("bucketName", bucketName).Str("fileName", fileName).Int("size", len(data)).Msg("Acknowledged write could not be applied to blob storage")
+	if p == nil {
+		return
+	}
+
+	msg := nats.NewMsg(p.subject)
+	msg.Header.Set("bucketName", bucketName)
+	msg.Header.Set("fileName", fileName)
+	msg.Header.Set("shardID", strconv.FormatUint(uint64(shardID), 10))
+	msg.Header.Set(ErrorHeader, headerValueReplacer.Replace(cause.Error()))
+	msg.Data = data
+	if err := globalNATSConn.PublishMsg(msg); err != nil {
+		log.Error().Err(err).Uint16("shardID", shardID).Str("bucketName", bucketName).Str("fileName", fileName).Msg("Failed to publish dead letter, the write is lost")
+		return
+	}
+	p.published.Inc()
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"NimbusDb/metrics"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// limitScopeShard is the scope of the rate limit applied to each shard.
+	limitScopeShard = "shard"
+	// limitScopeTenant is the scope of the rate limits applied per tenant.
+	limitScopeTenant = "tenant"
+	// limitScopeBucket is the scope of the rate limits applied per bucket.
+	limitScopeBucket = "bucket"
+)
+
+var (
+	// globalLimits holds the rate limiters and quotas. Nil if no limits are configured.
+	// It is set once during initialization and never modified.
+	globalLimits *limits
+)
+
+// limits holds the rate limiters per shard, tenant and bucket, and the bucket quotas.
+// The maps are read-only after creation, the limiters themselves are thread-safe.
+type limits struct {
+	shards  map[uint16]*rateLimiter
+	tenants map[string]*rateLimiter
+	buckets map[string]*rateLimiter
+	// quotas is nil if no quotas are configured.
+	quotas *quotaTracker
+	// throttled counts the throttled requests per scope.
+	throttled map[string]*metrics.Counter
+}
+
+// newLimits builds the rate limiters and quotas from configuration.
+// Assumes the configuration has been validated.
+//
+// params:
+//   - cfg: The limits configuration
+//   - shardCount: The number of shards, each gets its own shard rate limiter
+//
+// return:
+//   - *limits: The limits, or nil if no rate limit or quota is configured
+func newLimits(cfg configurations.LimitsConfig, shardCount uint16) *limits {
+	now := time.Now()
+	l := &limits{
+		shards:    make(map[uint16]*rateLimiter),
+		tenants:   make(map[string]*rateLimiter),
+		buckets:   make(map[string]*rateLimiter),
+		quotas:    newQuotaTracker(cfg.Quotas, cfg.QuotaRefreshInterval),
+		throttled: make(map[string]*metrics.Counter),
+	}
+
+	configured := l.quotas != nil
+	if newRateLimiter(cfg.Shard, now) != nil {
+		configured = true
+		for shardID := uint16(0); shardID < shardCount; shardID++ {
+			l.shards[shardID] = newRateLimiter(cfg.Shard, now)
+		}
+	}
+	for _, r := range cfg.Rates {
+		limiter := newRateLimiter(r, now)
+		if limiter == nil {
+			continue
+		}
+		configured = true
+		if r.Tenant != "" {
+			l.tenants[r.Tenant] = limiter
+		} else {
+			l.buckets[r.Bucket] = limiter
+		}
+	}
+	if !configured {
+		return nil
+	}
+
+	for _, scope := range []string{limitScopeShard, limitScopeTenant, limitScopeBucket} {
+		l.throttled[scope] = metrics.GetCounter("nimbus_requests_throttled_total", metrics.Labels{"scope": scope})
+	}
+	return l
+}
+
+// admit checks a request against the shard, tenant and bucket rate limits.
+// A request is only charged if every limit allows it.
+// A nil limits (no limits configured) admits every request.
+//
+// params:
+//   - shardID: The shard the request was sent to
+//   - headers: The request headers (Tenant and BucketName are used)
+//   - bytes: The request size, 0 if not known upfront (reads)
+//
+// return:
+//   - string: The scope of the limit that throttled the request, empty if admitted
+//   - time.Duration: How long the client should wait before retrying, 0 if admitted
+func (l *limits) admit(shardID uint16, headers *ShardOperationHeaders, bytes int64) (string, time.Duration) {
+	if l == nil {
+		return "", 0
+	}
+
+	now := time.Now()
+	checks := []struct {
+		scope   string
+		limiter *rateLimiter
+	}{
+		{limitScopeShard, l.shards[shardID]},
+		{limitScopeTenant, l.tenants[headers.Tenant]},
+		{limitScopeBucket, l.buckets[headers.BucketName]},
+	}
+	for i, c := range checks {
+		if wait := c.limiter.allow(bytes, now); wait > 0 {
+			// Give back what the previous limiters took, the request is not processed
+			for _, prev := range checks[:i] {
+				prev.limiter.undo(bytes)
+			}
+			l.throttled[c.scope].Inc()
+			return c.scope, wait
+		}
+	}
+	return "", 0
+}
+
+// charge charges bytes that were not known when the request was admitted (e.g. the size of a read)
+// against the shard, tenant and bucket byte rate limits.
+//
+// params:
+//   - shardID: The shard the request was sent to
+//   - headers: The request headers (Tenant and BucketName are used)
+//   - bytes: The number of bytes to charge
+func (l *limits) charge(shardID uint16, headers *ShardOperationHeaders, bytes int64) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	l.shards[shardID].charge(bytes, now)
+	l.tenants[headers.Tenant].charge(bytes, now)
+	l.buckets[headers.BucketName].charge(bytes, now)
+}
+
+// planWrite returns the change a write makes to the usage of its bucket.
+// For buckets with a quota, the object is looked up in the pending writes, then in blob storage, to tell
+// an overwrite from a new object. Writes to other buckets are not tracked and counted as new objects.
+//
+// params:
+//   - ctx: The operation context
+//   - bucketName: The bucket of the write
+//   - fileName: The file of the write
+//   - size: The size of the write
+//
+// return:
+//   - quotaWrite: The usage change of the write
+//   - error: An error if the overwritten object could not be looked up
+func (l *limits) planWrite(ctx context.Context, bucketName, fileName string, size int64) (quotaWrite, error) {
+	if l == nil || !l.quotas.tracks(bucketName) {
+		return quotaWrite{bytes: size, objects: 1}, nil
+	}
+
+	if data, ok, err := lookupPending(bucketName, fileName); err != nil {
+		return quotaWrite{}, err
+	} else if ok {
+		return quotaWrite{bytes: size - int64(len(data))}, nil
+	}
+	info, err := globalBlobClient.StatObject(ctx, bucketName, fileName, "")
+	if errors.Is(err, blob.ErrObjectNotFound) {
+		return quotaWrite{bytes: size, objects: 1}, nil
+	}
+	if err != nil {
+		return quotaWrite{}, err
+	}
+	return quotaWrite{bytes: size - info.Size}, nil
+}
+
+// checkQuota returns an error wrapping ErrQuotaExceeded if the write would take the bucket over its quota.
+func (l *limits) checkQuota(bucketName string, w quotaWrite) error {
+	if l == nil {
+		return nil
+	}
+	return l.quotas.check(bucketName, w)
+}
+
+// recordWrite adds the usage change of a successful write to the tracked usage of the bucket.
+func (l *limits) recordWrite(bucketName string, w quotaWrite) {
+	if l == nil {
+		return
+	}
+	l.quotas.record(bucketName, w)
+}
+
+// revertWrite subtracts the usage change of an acknowledged write that was dropped before reaching blob storage.
+func (l *limits) revertWrite(bucketName string, w quotaWrite) {
+	l.recordWrite(bucketName, quotaWrite{bytes: -w.bytes, objects: -w.objects})
+}
+
+// pendingUsage holds the usage changes recorded for the quotas of acknowledged writes not yet applied to blob storage,
+// by write ID, so the usage of a write dropped to the dead-letter subject can be reverted.
+// Writes left pending by a previous run are not in it, their usage was never recorded by this run.
+// This type is thread-safe.
+type pendingUsage struct {
+	writes sync.Map // write ID -> *pendingUsageEntry
+}
+
+// pendingUsageEntry is the usage change recorded for a pending write.
+type pendingUsageEntry struct {
+	bucketName string
+	usage      quotaWrite
+}
+
+// record records the usage change of a write once acknowledged. Must be called before the write can be applied or dropped.
+func (p *pendingUsage) record(id, bucketName string, usage quotaWrite) {
+	globalLimits.recordWrite(bucketName, usage)
+	p.writes.Store(id, &pendingUsageEntry{bucketName: bucketName, usage: usage})
+}
+
+// applied forgets the usage change of a write applied to blob storage, it stays recorded.
+func (p *pendingUsage) applied(id string) {
+	p.writes.Delete(id)
+}
+
+// revert reverts the usage change of a write that was not acknowledged or was dropped. Does nothing for unknown writes.
+func (p *pendingUsage) revert(id string) {
+	if e, ok := p.writes.LoadAndDelete(id); ok {
+		entry := e.(*pendingUsageEntry)
+		globalLimits.revertWrite(entry.bucketName, entry.usage)
+	}
+}
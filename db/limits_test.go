@@ -0,0 +1,201 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewLimits_Disabled(t *testing.T) {
+	l := newLimits(configurations.LimitsConfig{}, 4)
+	if l != nil {
+		t.Fatal("Expected nil limits when nothing is configured")
+	}
+	if scope, wait := l.admit(0, &ShardOperationHeaders{BucketName: "orders"}, 100); scope != "" || wait != 0 {
+		t.Errorf("Expected nil limits to admit every request, got scope %q and wait %s", scope, wait)
+	}
+	if err := l.checkQuota("orders", quotaWrite{bytes: 100, objects: 1}); err != nil {
+		t.Errorf("Expected nil limits to allow every write, got %v", err)
+	}
+	if w, err := l.planWrite(context.Background(), "orders", "order-1", 100); err != nil || w != (quotaWrite{bytes: 100, objects: 1}) {
+		t.Errorf("Expected nil limits to count every write as a new object, got %+v and %v", w, err)
+	}
+}
+
+func TestLimits_Admit(t *testing.T) {
+	l := newLimits(configurations.LimitsConfig{
+		Shard: configurations.RateLimitConfig{OpsPerSecond: 100},
+		Rates: []configurations.RateLimitConfig{
+			{Tenant: "acme", OpsPerSecond: 2},
+			{Bucket: "orders", BytesPerSecond: 1000},
+		},
+	}, 2)
+
+	acme := &ShardOperationHeaders{Tenant: "acme", BucketName: "acme-data"}
+	for i := 0; i < 2; i++ {
+		if scope, _ := l.admit(0, acme, 0); scope != "" {
+			t.Fatalf("Expected request %d to be admitted, got throttled by %s", i, scope)
+		}
+	}
+	scope, wait := l.admit(0, acme, 0)
+	if scope != limitScopeTenant || wait <= 0 {
+		t.Errorf("Expected tenant throttle with a retry hint, got scope %q and wait %s", scope, wait)
+	}
+
+	// The shard limiter is not charged for the throttled request
+	// (allowing for the refill while the test runs)
+	if got := l.shards[0].ops.tokens; got < 97.5 || got > 98.5 {
+		t.Errorf("Expected about 98 shard tokens left, got %v", got)
+	}
+
+	// Other tenants are not affected
+	if scope, _ := l.admit(0, &ShardOperationHeaders{Tenant: "globex", BucketName: "globex-data"}, 0); scope != "" {
+		t.Errorf("Expected other tenant to be admitted, got throttled by %s", scope)
+	}
+
+	// Bucket byte limits apply to the request size
+	if scope, _ := l.admit(1, &ShardOperationHeaders{BucketName: "orders"}, 800); scope != "" {
+		t.Fatalf("Expected write within the byte limit to be admitted, got throttled by %s", scope)
+	}
+	if scope, _ := l.admit(1, &ShardOperationHeaders{BucketName: "orders"}, 800); scope != limitScopeBucket {
+		t.Errorf("Expected bucket throttle, got scope %q", scope)
+	}
+}
+
+func TestQuotaTracker_Check(t *testing.T) {
+	tracker := newQuotaTracker([]configurations.QuotaConfig{
+		{Bucket: "quota-bytes", MaxBytes: 100},
+		{Bucket: "quota-objects", MaxObjects: 2},
+	}, 0)
+
+	// Usage is unknown until counted once, writes are not limited
+	if err := tracker.check("quota-bytes", quotaWrite{bytes: 1000, objects: 1}); err != nil {
+		t.Errorf("Expected writes to be allowed before usage is known, got %v", err)
+	}
+
+	tracker.buckets["quota-bytes"].known.Store(true)
+	tracker.buckets["quota-objects"].known.Store(true)
+
+	tracker.record("quota-bytes", quotaWrite{bytes: 60, objects: 1})
+	if err := tracker.check("quota-bytes", quotaWrite{bytes: 40, objects: 1}); err != nil {
+		t.Errorf("Expected write up to the quota to be allowed, got %v", err)
+	}
+	if err := tracker.check("quota-bytes", quotaWrite{bytes: 41, objects: 1}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+
+	tracker.record("quota-objects", quotaWrite{bytes: 1, objects: 1})
+	tracker.record("quota-objects", quotaWrite{bytes: 1, objects: 1})
+	if err := tracker.check("quota-objects", quotaWrite{bytes: 1, objects: 1}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded on object count, got %v", err)
+	}
+
+	// Overwrites do not add objects, and only count their size change
+	if err := tracker.check("quota-objects", quotaWrite{bytes: 1}); err != nil {
+		t.Errorf("Expected overwrite at the object quota to be allowed, got %v", err)
+	}
+	tracker.record("quota-objects", quotaWrite{bytes: -1})
+	if got := tracker.buckets["quota-objects"].objects.Load(); got != 2 {
+		t.Errorf("Expected 2 objects after an overwrite, got %d", got)
+	}
+	if got := tracker.buckets["quota-objects"].bytes.Load(); got != 1 {
+		t.Errorf("Expected 1 byte after an overwrite shrinking an object, got %d", got)
+	}
+	tracker.record("quota-bytes", quotaWrite{bytes: 40, objects: 1})
+	if err := tracker.check("quota-bytes", quotaWrite{bytes: -10}); err != nil {
+		t.Errorf("Expected overwrite shrinking the usage of a full bucket to be allowed, got %v", err)
+	}
+	if err := tracker.check("quota-bytes", quotaWrite{bytes: 1}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for an overwrite growing a full bucket, got %v", err)
+	}
+
+	if err := tracker.check("no-quota", quotaWrite{bytes: 1 << 30, objects: 1}); err != nil {
+		t.Errorf("Expected buckets without quota to be unlimited, got %v", err)
+	}
+}
+
+func TestLimits_PlanWrite(t *testing.T) {
+	cfg := &configurations.Config{}
+	cfg.Blob.BlobOperationTimeout = 5 * time.Second
+	cfg.Blob.Filesystem.Root = t.TempDir()
+	storage, err := blob.NewFilesystem(cfg)
+	if err != nil {
+		t.Fatalf("NewFilesystem() failed: %v", err)
+	}
+	ctx := context.Background()
+	if err := storage.CreateBucket(ctx, "orders", blob.ObjectLockConfig{}); err != nil {
+		t.Fatalf("CreateBucket() failed: %v", err)
+	}
+	if _, err := storage.WriteFile(ctx, "orders", "order-1", []byte("12345")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	previousConfig, previousStorage := globalConfig, globalBlobClient
+	globalConfig, globalBlobClient = cfg, storage
+	defer func() { globalConfig, globalBlobClient = previousConfig, previousStorage }()
+
+	l := newLimits(configurations.LimitsConfig{Quotas: []configurations.QuotaConfig{{Bucket: "orders", MaxObjects: 1}}}, 1)
+	l.quotas.buckets["orders"].known.Store(true)
+
+	tests := []struct {
+		name     string
+		bucket   string
+		fileName string
+		expected quotaWrite
+	}{
+		{"overwrite", "orders", "order-1", quotaWrite{bytes: -2}},
+		{"new object", "orders", "order-2", quotaWrite{bytes: 3, objects: 1}},
+		{"bucket without quota", "users", "user-1", quotaWrite{bytes: 3, objects: 1}},
+	}
+	for _, tt := range tests {
+		w, err := l.planWrite(ctx, tt.bucket, tt.fileName, 3)
+		if err != nil {
+			t.Fatalf("planWrite() failed for %s: %v", tt.name, err)
+		}
+		if w != tt.expected {
+			t.Errorf("Expected %+v for %s, got %+v", tt.expected, tt.name, w)
+		}
+	}
+
+	// The bucket holds as many objects as its quota, but overwrites are still allowed
+	l.quotas.record("orders", quotaWrite{bytes: 5, objects: 1})
+	overwrite, _ := l.planWrite(ctx, "orders", "order-1", 10)
+	if err := l.checkQuota("orders", overwrite); err != nil {
+		t.Errorf("Expected overwrite at the object quota to be allowed, got %v", err)
+	}
+	created, _ := l.planWrite(ctx, "orders", "order-2", 10)
+	if err := l.checkQuota("orders", created); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for a new object, got %v", err)
+	}
+}
+
+// setTestQuota installs limits with a quota on the orders bucket for the duration of the test.
+func setTestQuota(t *testing.T) *bucketQuota {
+	t.Helper()
+	previousLimits := globalLimits
+	globalLimits = newLimits(configurations.LimitsConfig{Quotas: []configurations.QuotaConfig{{Bucket: "orders", MaxBytes: 1 << 20}}}, 1)
+	t.Cleanup(func() { globalLimits = previousLimits })
+	q := globalLimits.quotas.buckets["orders"]
+	q.known.Store(true)
+	return q
+}
+
+func TestPendingUsage(t *testing.T) {
+	q := setTestQuota(t)
+	var p pendingUsage
+
+	p.record("applied", "orders", quotaWrite{bytes: 5, objects: 1})
+	p.record("dropped", "orders", quotaWrite{bytes: 3, objects: 1})
+	p.applied("applied")
+	p.revert("dropped")
+	// Reverting twice, or a write of a previous run, changes nothing
+	p.revert("dropped")
+	p.revert("applied")
+	p.revert("unknown")
+
+	if got, objects := q.bytes.Load(), q.objects.Load(); got != 5 || objects != 1 {
+		t.Errorf("Expected usage of the applied write only (5 bytes, 1 object), got %d bytes and %d objects", got, objects)
+	}
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"NimbusDb/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrQuotaExceeded is returned when a write would take a bucket over its storage quota.
+var ErrQuotaExceeded = errors.New("bucket quota exceeded")
+
+// bucketQuota is the quota and the tracked usage of a bucket.
+type bucketQuota struct {
+	maxBytes   int64
+	maxObjects int64
+	// known is false until the usage has been counted once, writes are not limited until then.
+	known   atomic.Bool
+	bytes   atomic.Int64
+	objects atomic.Int64
+	// rejected counts the writes rejected by the quota.
+	rejected *metrics.Counter
+}
+
+// quotaWrite is the change a write makes to the usage of its bucket.
+type quotaWrite struct {
+	// bytes is the size of a new object, or the size difference with the overwritten object.
+	bytes int64
+	// objects is 1 for a new object, 0 for an overwrite.
+	objects int64
+}
+
+// quotaTracker tracks bucket usage for quota enforcement.
+// Usage is recounted from blob storage periodically, and updated on every write in between:
+// a new object adds its size and one object, an overwrite only its size difference with the overwritten object.
+// The bucket map is read-only after creation, the usage counters are atomic.
+type quotaTracker struct {
+	buckets         map[string]*bucketQuota
+	refreshInterval time.Duration
+}
+
+// newQuotaTracker creates the quota tracker and registers the usage metrics.
+//
+// params:
+//   - quotas: The configured quotas
+//   - refreshInterval: How often usage is recounted from blob storage
+//
+// return:
+//   - *quotaTracker: The tracker, or nil if no quotas are configured
+func newQuotaTracker(quotas []configurations.QuotaConfig, refreshInterval time.Duration) *quotaTracker {
+	if len(quotas) == 0 {
+		return nil
+	}
+
+	t := &quotaTracker{
+		buckets:         make(map[string]*bucketQuota, len(quotas)),
+		refreshInterval: refreshInterval,
+	}
+	for _, q := range quotas {
+		bq := &bucketQuota{
+			maxBytes:   q.MaxBytes,
+			maxObjects: q.MaxObjects,
+			rejected:   metrics.GetCounter("nimbus_quota_rejected_writes_total", metrics.Labels{"bucket": q.Bucket}),
+		}
+		t.buckets[q.Bucket] = bq
+		metrics.RegisterGaugeFunc("nimbus_bucket_usage_bytes", metrics.Labels{"bucket": q.Bucket}, func() float64 {
+			return float64(bq.bytes.Load())
+		})
+		metrics.RegisterGaugeFunc("nimbus_bucket_usage_objects", metrics.Labels{"bucket": q.Bucket}, func() float64 {
+			return float64(bq.objects.Load())
+		})
+	}
+	return t
+}
+
+// tracks reports whether writes to the bucket are limited by a quota, so their usage change has to be known.
+func (t *quotaTracker) tracks(bucketName string) bool {
+	if t == nil {
+		return false
+	}
+	q, ok := t.buckets[bucketName]
+	return ok && q.known.Load()
+}
+
+// check returns an error wrapping ErrQuotaExceeded if the write would take the bucket over its quota.
+// Overwrites are not limited by the object count, and writes shrinking the usage are always allowed.
+// A nil tracker, or a bucket without quota, allows every write.
+func (t *quotaTracker) check(bucketName string, w quotaWrite) error {
+	if t == nil {
+		return nil
+	}
+	q, ok := t.buckets[bucketName]
+	if !ok || !q.known.Load() {
+		return nil
+	}
+
+	if q.maxBytes > 0 && w.bytes > 0 && q.bytes.Load()+w.bytes > q.maxBytes {
+		q.rejected.Inc()
+		return fmt.Errorf("%w: bucket %s uses %d of %d bytes", ErrQuotaExceeded, bucketName, q.bytes.Load(), q.maxBytes)
+	}
+	if q.maxObjects > 0 && w.objects > 0 && q.objects.Load()+w.objects > q.maxObjects {
+		q.rejected.Inc()
+		return fmt.Errorf("%w: bucket %s holds %d of %d objects", ErrQuotaExceeded, bucketName, q.objects.Load(), q.maxObjects)
+	}
+	return nil
+}
+
+// record adds the usage change of a successful write to the tracked usage of the bucket.
+func (t *quotaTracker) record(bucketName string, w quotaWrite) {
+	if t == nil {
+		return
+	}
+	if q, ok := t.buckets[bucketName]; ok {
+		q.bytes.Add(w.bytes)
+		q.objects.Add(w.objects)
+	}
+}
+
+// refresh recounts the usage of every bucket with a quota from blob storage.
+// Buckets whose usage cannot be counted keep their previous usage.
+func (t *quotaTracker) refresh(ctx context.Context) {
+	for bucketName, q := range t.buckets {
+		opCtx, cancel := context.WithTimeout(ctx, globalConfig.Blob.BlobOperationTimeout)
+		usage, err := globalBlobClient.BucketUsage(opCtx, bucketName)
+		cancel()
+		if err != nil {
+			log.Error().Err(err).Str("bucketName", bucketName).Msg("Failed to count bucket usage for quota")
+			continue
+		}
+		q.bytes.Store(usage.Bytes)
+		q.objects.Store(usage.Objects)
+		q.known.Store(true)
+		log.Debug().Str("bucketName", bucketName).Int64("bytes", usage.Bytes).Int64("objects", usage.Objects).Msg("Bucket usage counted")
+	}
+}
+
+// StartQuotaRefresher counts the usage of every bucket with a quota, then keeps recounting it
+// every Limits.QuotaRefreshInterval in the background until ctx is cancelled.
+// Does nothing if no quotas are configured. Must be called after InitializeGlobals.
+//
+// params:
+//   - ctx: The context that stops the background refresh when cancelled
+func StartQuotaRefresher(ctx context.Context) {
+	if globalLimits == nil || globalLimits.quotas == nil {
+		return
+	}
+	tracker := globalLimits.quotas
+
+	// Count once before serving requests, so quotas are enforced from the first write
+	tracker.refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(tracker.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tracker.refresh(ctx)
+			}
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket refilled at a constant rate, holding up to one second worth of tokens.
+// This type is thread-safe.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full token bucket.
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := math.Max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// refill adds the tokens accumulated since the last call. Must be called with mu held.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// take removes n tokens if available.
+// Requests larger than the burst are allowed once the bucket is full, leaving it in debt,
+// so that a single large write is delayed rather than rejected forever.
+//
+// params:
+//   - n: The number of tokens to take. 0 only checks that the bucket is not in debt.
+//   - now: The current time
+//
+// return:
+//   - time.Duration: 0 if the tokens were taken, otherwise how long until they are available
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	needed := math.Min(n, b.burst)
+	if b.tokens >= needed {
+		b.tokens -= n
+		return 0
+	}
+	// Round up to whole milliseconds, the unit of the retry-after hint
+	wait := time.Duration((needed - b.tokens) / b.rate * float64(time.Second))
+	return wait.Truncate(time.Millisecond) + time.Millisecond
+}
+
+// give returns n tokens, e.g. taken for a request that was rejected by another limit afterwards.
+func (b *tokenBucket) give(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// charge removes n tokens unconditionally, e.g. for bytes read, which are only known once the read is done.
+func (b *tokenBucket) charge(n float64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= n
+}
+
+// rateLimiter limits operations and bytes per second. Either limit can be nil (unlimited).
+type rateLimiter struct {
+	ops   *tokenBucket
+	bytes *tokenBucket
+}
+
+// newRateLimiter creates a rate limiter from configuration.
+//
+// params:
+//   - cfg: The rate limit configuration
+//   - now: The current time
+//
+// return:
+//   - *rateLimiter: The rate limiter, or nil if both rates are zero
+func newRateLimiter(cfg configurations.RateLimitConfig, now time.Time) *rateLimiter {
+	if cfg.OpsPerSecond <= 0 && cfg.BytesPerSecond <= 0 {
+		return nil
+	}
+	l := &rateLimiter{}
+	if cfg.OpsPerSecond > 0 {
+		l.ops = newTokenBucket(cfg.OpsPerSecond, now)
+	}
+	if cfg.BytesPerSecond > 0 {
+		l.bytes = newTokenBucket(cfg.BytesPerSecond, now)
+	}
+	return l
+}
+
+// allow takes one operation and the given bytes from the limiter.
+// Nothing is taken if the request is throttled.
+//
+// params:
+//   - bytes: The request size, 0 if not known upfront (the bytes limit then only checks that it is not in debt)
+//   - now: The current time
+//
+// return:
+//   - time.Duration: 0 if the request is allowed, otherwise how long the client should wait before retrying
+func (l *rateLimiter) allow(bytes int64, now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	if l.ops != nil {
+		if wait := l.ops.take(1, now); wait > 0 {
+			return wait
+		}
+	}
+	if l.bytes != nil {
+		if wait := l.bytes.take(float64(bytes), now); wait > 0 {
+			if l.ops != nil {
+				l.ops.give(1)
+			}
+			return wait
+		}
+	}
+	return 0
+}
+
+// undo returns the operation and bytes taken by allow, for a request that was throttled by another limiter.
+func (l *rateLimiter) undo(bytes int64) {
+	if l == nil {
+		return
+	}
+	if l.ops != nil {
+		l.ops.give(1)
+	}
+	if l.bytes != nil {
+		l.bytes.give(float64(bytes))
+	}
+}
+
+// charge removes bytes from the limiter after the fact.
+func (l *rateLimiter) charge(bytes int64, now time.Time) {
+	if l == nil || l.bytes == nil {
+		return
+	}
+	l.bytes.charge(float64(bytes), now)
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, now)
+
+	for i := 0; i < 10; i++ {
+		if wait := b.take(1, now); wait != 0 {
+			t.Fatalf("Expected burst of 10 to be allowed, request %d waited %s", i, wait)
+		}
+	}
+	wait := b.take(1, now)
+	if wait <= 0 || wait > 101*time.Millisecond {
+		t.Errorf("Expected a wait of about 100ms once the burst is used, got %s", wait)
+	}
+
+	// Tokens are refilled at the configured rate
+	if wait := b.take(1, now.Add(100*time.Millisecond)); wait != 0 {
+		t.Errorf("Expected a token to be available after 100ms, got wait %s", wait)
+	}
+}
+
+func TestTokenBucket_LargerThanBurst(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(100, now)
+
+	// A request larger than the burst is allowed once the bucket is full, leaving it in debt
+	if wait := b.take(300, now); wait != 0 {
+		t.Fatalf("Expected oversized request to be allowed on a full bucket, got wait %s", wait)
+	}
+	if wait := b.take(0, now.Add(time.Second)); wait == 0 {
+		t.Error("Expected the bucket to still be in debt after 1s")
+	}
+	if wait := b.take(0, now.Add(2*time.Second)); wait != 0 {
+		t.Errorf("Expected the debt to be paid off after 2s, got wait %s", wait)
+	}
+}
+
+func TestRateLimiter_BytesThrottleGivesBackOp(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(configurations.RateLimitConfig{OpsPerSecond: 1, BytesPerSecond: 10}, now)
+
+	l.bytes.charge(20, now)
+	if wait := l.allow(5, now); wait == 0 {
+		t.Fatal("Expected request to be throttled by the bytes limit")
+	}
+	// The op taken before the bytes check is given back
+	l.bytes.give(20)
+	if wait := l.allow(5, now); wait != 0 {
+		t.Errorf("Expected op token to be given back, got wait %s", wait)
+	}
+}
+
+func TestNewRateLimiter_Disabled(t *testing.T) {
+	l := newRateLimiter(configurations.RateLimitConfig{}, time.Now())
+	if l != nil {
+		t.Fatal("Expected nil rate limiter when no rate is configured")
+	}
+	if wait := l.allow(1<<20, time.Now()); wait != 0 {
+		t.Errorf("Expected nil rate limiter to allow everything, got wait %s", wait)
+	}
+}
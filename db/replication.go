@@ -0,0 +1,512 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"NimbusDb/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// replicationLockStripes is the number of locks the copies of keys to the replica are serialized with.
+	replicationLockStripes = 64
+)
+
+var (
+	// globalReplication replicates applied writes to the replica endpoint. Nil if replication is disabled.
+	// It is set once during startup and never modified.
+	globalReplication *replicator
+)
+
+// replicationStore is the part of the blob client used by replication, on both endpoints.
+type replicationStore interface {
+	ListBuckets(ctx context.Context) ([]blob.BucketInfo, error)
+	ListObjects(ctx context.Context, bucketName, prefix string) ([]blob.ObjectInfo, error)
+	ReadFile(ctx context.Context, bucketName, fileName, versionID string) ([]byte, error)
+	StatObject(ctx context.Context, bucketName, fileName, versionID string) (*blob.ObjectInfo, error)
+	WriteFile(ctx context.Context, bucketName, fileName string, data []byte) (string, error)
+	DeleteObject(ctx context.Context, bucketName, fileName, versionID string) error
+	ProvisionBucket(ctx context.Context, bucketName string) (*blob.BucketProvisionReport, error)
+}
+
+// replicationTask is an applied write waiting to be replicated.
+type replicationTask struct {
+	bucketName string
+	fileName   string
+	data       []byte
+	queuedAt   time.Time
+}
+
+// replicator copies the writes applied to blob storage to the replica endpoint in the background,
+// with one bounded queue and worker per shard, so writes of an object are replicated in order.
+// Writes that fail are retried until they succeed. Writes that do not fit in a queue, or are lost on shutdown,
+// are copied by the periodic resync, which compares the buckets owned by this node with the replica.
+// The resync also deletes from the replica the objects deleted from blob storage outside of shard operations.
+// A bucket is owned by the node owning the shard its name hashes to (see bucketShard),
+// so each bucket is resynced by a single node once shards are spread over several nodes.
+// The queue maps are read-only after creation, the queues are thread-safe.
+type replicator struct {
+	source         replicationStore
+	target         replicationStore
+	retryDelay     time.Duration
+	opTimeout      time.Duration
+	resyncInterval time.Duration
+	shardCount     uint16
+	// ownedShards returns the shards owned by this node.
+	ownedShards func() []uint16
+	queues      map[uint16]chan *replicationTask
+	// inFlight is the time (unix nanoseconds) the task being replicated by a shard worker was queued, 0 when idle.
+	inFlight map[uint16]*atomic.Int64
+	// locks serialize the copies of a key by the shard workers and the resync.
+	locks [replicationLockStripes]sync.Mutex
+	// provisioned holds the buckets known to exist on the replica.
+	provisioned sync.Map
+	queued      atomic.Int64
+	replicated  *metrics.Counter
+	failed      *metrics.Counter
+	dropped     *metrics.Counter
+	resynced    *metrics.Counter
+	pruned      *metrics.Counter
+	// resyncFailed counts the objects the resync failed to compare, copy or delete, left to the next resync.
+	resyncFailed *metrics.Counter
+	lastResync   *metrics.Gauge
+}
+
+// StartReplication starts replicating writes to the replica endpoint, and the periodic resync
+// (the first one right away, to catch up with writes missed while the node or the replica was down).
+// Does nothing if target is nil (replication disabled). Must be called after InitializeGlobals and after the shard state
+// is initialized, the resync only covers the buckets of the shards owned by this node.
+//
+// params:
+//   - ctx: The context that stops the workers and the resync when cancelled
+//   - target: The blob storage of the replica endpoint, nil if replication is disabled
+func StartReplication(ctx context.Context, target blob.Storage) {
+	if target == nil {
+		return
+	}
+	cfg := globalConfig.Blob.Replica
+	r := newReplicator(globalBlobClient, target, cfg, globalConfig.Blob.BlobOperationTimeout, globalConfig.ShardCount, func() []uint16 {
+		state := GetGlobalState()
+		if state == nil {
+			return nil
+		}
+		return state.GetShardIDs()
+	})
+	r.start(ctx)
+	globalReplication = r
+	log.Info().Str("endpoint", cfg.Endpoint).Dur("resyncInterval", cfg.ResyncInterval).Msg("Blob replication enabled")
+}
+
+// newReplicator creates the replication queues. Workers are started with start.
+//
+// params:
+//   - source: The blob storage writes are applied to
+//   - target: The replica
+//   - cfg: The replica configuration
+//   - opTimeout: The timeout of a single blob operation
+//   - shardCount: The number of shards, each gets its own queue and worker
+//   - ownedShards: Returns the shards owned by this node, whose buckets are resynced
+//
+// return:
+//   - *replicator: The replicator
+func newReplicator(source, target replicationStore, cfg configurations.BlobReplicaConfig, opTimeout time.Duration, shardCount uint16, ownedShards func() []uint16) *replicator {
+	r := &replicator{
+		source:         source,
+		target:         target,
+		retryDelay:     cfg.RetryDelay,
+		opTimeout:      opTimeout,
+		resyncInterval: cfg.ResyncInterval,
+		shardCount:     shardCount,
+		ownedShards:    ownedShards,
+		queues:         make(map[uint16]chan *replicationTask, shardCount),
+		inFlight:       make(map[uint16]*atomic.Int64, shardCount),
+		replicated:     metrics.GetCounter("nimbus_replication_replicated_writes_total", nil),
+		failed:         metrics.GetCounter("nimbus_replication_errors_total", nil),
+		dropped:        metrics.GetCounter("nimbus_replication_dropped_writes_total", nil),
+		resynced:       metrics.GetCounter("nimbus_replication_resync_copied_objects_total", nil),
+		pruned:         metrics.GetCounter("nimbus_replication_resync_deleted_objects_total", nil),
+		resyncFailed:   metrics.GetCounter("nimbus_replication_resync_errors_total", nil),
+		lastResync:     metrics.GetGauge("nimbus_replication_last_resync_timestamp_seconds", nil),
+	}
+	for shardID := uint16(0); shardID < shardCount; shardID++ {
+		r.queues[shardID] = make(chan *replicationTask, cfg.QueueSize)
+		r.inFlight[shardID] = &atomic.Int64{}
+	}
+
+	metrics.RegisterGaugeFunc("nimbus_replication_queued_writes", nil, func() float64 {
+		return float64(r.queued.Load())
+	})
+	metrics.RegisterGaugeFunc("nimbus_replication_lag_seconds", nil, r.lag)
+	return r
+}
+
+// start starts the shard workers and the resync loop.
+func (r *replicator) start(ctx context.Context) {
+	for shardID, queue := range r.queues {
+		go r.run(ctx, shardID, queue)
+	}
+	go r.runResync(ctx)
+}
+
+// lag returns how long the oldest write not yet replicated has been waiting, in seconds.
+// The task a worker is replicating is the oldest of its queue.
+func (r *replicator) lag() float64 {
+	var oldest int64
+	for _, queuedAt := range r.inFlight {
+		if t := queuedAt.Load(); t != 0 && (oldest == 0 || t < oldest) {
+			oldest = t
+		}
+	}
+	if oldest == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, oldest)).Seconds()
+}
+
+// enqueue queues an applied write for replication without blocking.
+// A nil replicator (disabled) ignores the write. A write that does not fit in the shard queue is left to the resync.
+func (r *replicator) enqueue(shardID uint16, bucketName, fileName string, data []byte) {
+	if r == nil {
+		return
+	}
+	queue, ok := r.queues[shardID]
+	if !ok {
+		return
+	}
+	r.queued.Add(1)
+	select {
+	case queue <- &replicationTask{bucketName: bucketName, fileName: fileName, data: data, queuedAt: time.Now()}:
+	default:
+		r.queued.Add(-1)
+		r.dropped.Inc()
+		log.Debug().Uint16("shardID", shardID).Str("bucketName", bucketName).Str("fileName", fileName).Msg("Replication queue full, write left to the next resync")
+	}
+}
+
+// run replicates the writes of a shard queue one at a time, until ctx is cancelled.
+func (r *replicator) run(ctx context.Context, shardID uint16, queue chan *replicationTask) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task := <-queue:
+			r.inFlight[shardID].Store(task.queuedAt.UnixNano())
+			r.replicate(ctx, shardID, task)
+			r.inFlight[shardID].Store(0)
+			r.queued.Add(-1)
+		}
+	}
+}
+
+// replicate writes a task to the replica, retrying until it succeeds or ctx is cancelled.
+func (r *replicator) replicate(ctx context.Context, shardID uint16, task *replicationTask) {
+	for {
+		err := r.copyToTarget(task.bucketName, task.fileName, task.data)
+		if err == nil {
+			r.replicated.Inc()
+			return
+		}
+
+		r.failed.Inc()
+		log.Warn().Err(err).Uint16("shardID", shardID).Str("bucketName", task.bucketName).Str("fileName", task.fileName).Dur("retryDelay", r.retryDelay).Msg("Failed to replicate write, retrying")
+		if !sleepCtx(ctx, r.retryDelay) {
+			// Copied by the resync on the next start
+			return
+		}
+	}
+}
+
+// copyToTarget writes an object to the replica, provisioning its bucket first if needed.
+func (r *replicator) copyToTarget(bucketName, fileName string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opTimeout)
+	defer cancel()
+	if err := r.provision(ctx, bucketName); err != nil {
+		return err
+	}
+	mu := r.lockKey(bucketName, fileName)
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := r.target.WriteFile(ctx, bucketName, fileName, data)
+	return err
+}
+
+// lockKey returns the lock serializing the copies of a key to the replica.
+func (r *replicator) lockKey(bucketName, fileName string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(bucketName + "/" + fileName))
+	return &r.locks[h.Sum32()%replicationLockStripes]
+}
+
+// bucketShard returns the shard owning a bucket for background jobs, from the FNV-1a hash of its name.
+func bucketShard(bucketName string, shardCount uint16) uint16 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(bucketName))
+	return uint16(h.Sum32() % uint32(shardCount))
+}
+
+// provision makes sure a bucket exists on the replica with the expected settings, once per bucket.
+func (r *replicator) provision(ctx context.Context, bucketName string) error {
+	if _, ok := r.provisioned.Load(bucketName); ok {
+		return nil
+	}
+	if _, err := r.target.ProvisionBucket(ctx, bucketName); err != nil {
+		return fmt.Errorf("failed to provision replica bucket %s: %w", bucketName, err)
+	}
+	r.provisioned.Store(bucketName, struct{}{})
+	return nil
+}
+
+// runResync resyncs the owned buckets right away, then every resync interval, until ctx is cancelled.
+func (r *replicator) runResync(ctx context.Context) {
+	ticker := time.NewTicker(r.resyncInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.resync(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to resync buckets with the replica")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resync compares every bucket owned by this node with the replica, copies the objects missing on the replica
+// or differing from it (by size, and ETag or stored checksum), and deletes the objects of the replica missing from the bucket.
+// A bucket that fails is logged and skipped until the next resync.
+//
+// params:
+//   - ctx: The context bounding the resync
+//
+// return:
+//   - error: An error if the buckets could not be listed
+func (r *replicator) resync(ctx context.Context) error {
+	buckets, err := r.source.ListBuckets(ctx)
+	if err != nil {
+		return err
+	}
+
+	owned := make(map[uint16]bool)
+	for _, shardID := range r.ownedShards() {
+		owned[shardID] = true
+	}
+	var resynced, copied, deleted int
+	for _, bucket := range buckets {
+		if !owned[bucketShard(bucket.Name, r.shardCount)] {
+			continue
+		}
+		resynced++
+		c, d, err := r.resyncBucket(ctx, bucket.Name)
+		copied += c
+		deleted += d
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Error().Err(err).Str("bucketName", bucket.Name).Int("copied", c).Int("deleted", d).Msg("Failed to resync bucket with the replica")
+		}
+	}
+	r.lastResync.Set(float64(time.Now().Unix()))
+	log.Info().Int("buckets", resynced).Int("copied", copied).Int("deleted", deleted).Msg("Resynced buckets with the replica")
+	return nil
+}
+
+// resyncBucket copies the objects of a bucket missing on the replica or differing from it,
+// and deletes the objects of the replica missing from the bucket.
+// The replica is listed first, so an object written and replicated during the resync is never taken for a deleted one.
+// An object is copied at its current version, read and written under the key lock of the shard workers:
+// a write applied during the resync is replicated after the copy, so the copy never overwrites it with an older version.
+// An object that cannot be compared, copied or deleted is logged, counted and left to the next resync, the rest of the bucket is still resynced.
+//
+// return:
+//   - int: The number of objects copied
+//   - int: The number of objects deleted from the replica
+//   - error: An error if the bucket could not be listed, or some objects could not be resynced
+func (r *replicator) resyncBucket(ctx context.Context, bucketName string) (int, int, error) {
+	if err := r.provision(ctx, bucketName); err != nil {
+		return 0, 0, err
+	}
+	targetObjects, err := r.target.ListObjects(ctx, bucketName, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	sourceObjects, err := r.source.ListObjects(ctx, bucketName, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	replicated := make(map[string]blob.ObjectInfo, len(targetObjects))
+	for _, obj := range targetObjects {
+		replicated[obj.Key] = obj
+	}
+
+	copied, failed := 0, 0
+	for _, obj := range sourceObjects {
+		if ctx.Err() != nil {
+			return copied, 0, ctx.Err()
+		}
+		target, ok := replicated[obj.Key]
+		delete(replicated, obj.Key)
+		if ok && target.Size == obj.Size {
+			same, err := r.sameContent(ctx, bucketName, obj, target)
+			if err != nil {
+				failed++
+				r.resyncFailed.Inc()
+				log.Warn().Err(err).Str("bucketName", bucketName).Str("fileName", obj.Key).Msg("Failed to compare object with the replica, left to the next resync")
+				continue
+			}
+			if same {
+				continue
+			}
+		}
+
+		ok, err = r.copyCurrent(ctx, bucketName, obj.Key)
+		if err != nil {
+			failed++
+			r.resyncFailed.Inc()
+			log.Warn().Err(err).Str("bucketName", bucketName).Str("fileName", obj.Key).Msg("Failed to copy object to the replica, left to the next resync")
+			continue
+		}
+		if ok {
+			copied++
+			r.resynced.Inc()
+		}
+	}
+
+	// The objects left were deleted from the bucket
+	deleted := 0
+	for key := range replicated {
+		if ctx.Err() != nil {
+			return copied, deleted, ctx.Err()
+		}
+		removed, err := r.deleteFromTarget(ctx, bucketName, key)
+		if err != nil {
+			failed++
+			r.resyncFailed.Inc()
+			log.Warn().Err(err).Str("bucketName", bucketName).Str("fileName", key).Msg("Failed to delete object from the replica, left to the next resync")
+			continue
+		}
+		if removed {
+			deleted++
+			r.pruned.Inc()
+		}
+	}
+	if failed > 0 {
+		return copied, deleted, fmt.Errorf("failed to resync %d objects", failed)
+	}
+	return copied, deleted, nil
+}
+
+// sameContent reports whether an object of the bucket and its replica, listed with the same size, have the same content.
+// They are compared by ETag, or by the checksum stored with the current versions when the provider has no ETags.
+// Objects without a stored checksum on either side are only compared by size.
+func (r *replicator) sameContent(ctx context.Context, bucketName string, source, target blob.ObjectInfo) (bool, error) {
+	if source.ETag != "" {
+		return target.ETag == source.ETag, nil
+	}
+	opCtx, cancel := context.WithTimeout(ctx, r.opTimeout)
+	defer cancel()
+	sourceInfo, err := r.source.StatObject(opCtx, bucketName, source.Key, "")
+	if errors.Is(err, blob.ErrObjectNotFound) {
+		// Deleted since it was listed, the replica copy is deleted by the next resync
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	targetInfo, err := r.target.StatObject(opCtx, bucketName, target.Key, "")
+	if errors.Is(err, blob.ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if sourceInfo.Checksum == "" || targetInfo.Checksum == "" {
+		return true, nil
+	}
+	return strings.EqualFold(sourceInfo.Checksum, targetInfo.Checksum), nil
+}
+
+// copyCurrent copies the current version of an object to the replica, under its key lock.
+//
+// return:
+//   - bool: True if the object was copied, false if it was deleted from the bucket since it was listed
+//   - error: An error if the object could not be read or written
+func (r *replicator) copyCurrent(ctx context.Context, bucketName, fileName string) (bool, error) {
+	opCtx, cancel := context.WithTimeout(ctx, r.opTimeout)
+	defer cancel()
+	mu := r.lockKey(bucketName, fileName)
+	mu.Lock()
+	defer mu.Unlock()
+	data, err := r.source.ReadFile(opCtx, bucketName, fileName, "")
+	if errors.Is(err, blob.ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := r.target.WriteFile(opCtx, bucketName, fileName, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteFromTarget deletes an object from the replica, under its key lock, unless it was written to the bucket
+// since it was listed or has a write not yet applied to blob storage. Its versions are kept on the replica, like on the bucket.
+//
+// return:
+//   - bool: True if the object was deleted
+//   - error: An error if the bucket could not be listed, or the object could not be deleted
+func (r *replicator) deleteFromTarget(ctx context.Context, bucketName, fileName string) (bool, error) {
+	if hasPending(bucketName, fileName) {
+		return false, nil
+	}
+	opCtx, cancel := context.WithTimeout(ctx, r.opTimeout)
+	defer cancel()
+	mu := r.lockKey(bucketName, fileName)
+	mu.Lock()
+	defer mu.Unlock()
+	objects, err := r.source.ListObjects(opCtx, bucketName, fileName)
+	if err != nil {
+		return false, err
+	}
+	for _, obj := range objects {
+		if obj.Key == fileName {
+			return false, nil
+		}
+	}
+	if err := r.target.DeleteObject(opCtx, bucketName, fileName, ""); err != nil && !errors.Is(err, blob.ErrObjectNotFound) {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeApplied records a write applied to blob storage: the change is published
+// if change data capture is enabled, and the write is queued for replication if replication is enabled.
+//
+// params:
+//   - shardID: The shard of the write
+//   - bucketName: The bucket written to
+//   - fileName: The file written
+//   - versionID: The version created in blob storage
+//   - data: The file content
+func writeApplied(shardID uint16, bucketName, fileName, versionID string, data []byte) {
+	globalCDC.publish(ChangeEvent{
+		Bucket:    bucketName,
+		Key:       fileName,
+		VersionID: versionID,
+		Size:      int64(len(data)),
+		Operation: ChangeOperationWrite,
+		Timestamp: time.Now().UTC(),
+	})
+	globalReplication.enqueue(shardID, bucketName, fileName, data)
+}
@@ -0,0 +1,373 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is an in-memory replication store, keeping the current content of each object.
+type memoryStore struct {
+	mu          sync.Mutex
+	buckets     map[string]map[string][]byte
+	writes      int
+	failures    int
+	provisioned []string
+	// unreadable lists the keys ReadFile fails to read.
+	unreadable map[string]bool
+	// onRead is called once by the next ReadFile, after the object is read, with mu held.
+	onRead func()
+	// noETag lists objects without ETag, like the filesystem provider.
+	noETag bool
+}
+
+func newMemoryStore(buckets ...string) *memoryStore {
+	s := &memoryStore{buckets: make(map[string]map[string][]byte)}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string][]byte)
+	}
+	return s
+}
+
+func (s *memoryStore) ListBuckets(ctx context.Context) ([]blob.BucketInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buckets []blob.BucketInfo
+	for name := range s.buckets {
+		buckets = append(buckets, blob.BucketInfo{Name: name})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets, nil
+}
+
+func (s *memoryStore) ListObjects(ctx context.Context, bucketName, prefix string) ([]blob.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, blob.ErrBucketNotFound
+	}
+	var infos []blob.ObjectInfo
+	for key, data := range objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		info := blob.ObjectInfo{Key: key, Size: int64(len(data))}
+		if !s.noETag {
+			info.ETag = fmt.Sprintf("%x", data)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *memoryStore) StatObject(ctx context.Context, bucketName, fileName, versionID string) (*blob.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.buckets[bucketName][fileName]
+	if !ok {
+		return nil, blob.ErrObjectNotFound
+	}
+	sum := sha256.Sum256(data)
+	return &blob.ObjectInfo{Key: fileName, Size: int64(len(data)), Checksum: hex.EncodeToString(sum[:])}, nil
+}
+
+func (s *memoryStore) ReadFile(ctx context.Context, bucketName, fileName, versionID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.buckets[bucketName][fileName]
+	if !ok {
+		return nil, blob.ErrObjectNotFound
+	}
+	if s.unreadable[fileName] {
+		return nil, errors.New("read failed")
+	}
+	if s.onRead != nil {
+		onRead := s.onRead
+		s.onRead = nil
+		onRead()
+	}
+	return data, nil
+}
+
+func (s *memoryStore) WriteFile(ctx context.Context, bucketName, fileName string, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return "", errors.New("replica unavailable")
+	}
+	objects, ok := s.buckets[bucketName]
+	if !ok {
+		return "", blob.ErrBucketNotFound
+	}
+	objects[fileName] = data
+	s.writes++
+	return fmt.Sprintf("v%d", s.writes), nil
+}
+
+func (s *memoryStore) DeleteObject(ctx context.Context, bucketName, fileName, versionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucketName][fileName]; !ok {
+		return blob.ErrObjectNotFound
+	}
+	delete(s.buckets[bucketName], fileName)
+	return nil
+}
+
+func (s *memoryStore) ProvisionBucket(ctx context.Context, bucketName string) (*blob.BucketProvisionReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.buckets[bucketName]
+	if !exists {
+		s.buckets[bucketName] = make(map[string][]byte)
+	}
+	s.provisioned = append(s.provisioned, bucketName)
+	return &blob.BucketProvisionReport{Name: bucketName, Created: !exists}, nil
+}
+
+func (s *memoryStore) get(bucketName, fileName string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.buckets[bucketName][fileName]
+	return string(data), ok
+}
+
+// allShards returns an ownedShards function owning every shard.
+func allShards(shardCount uint16) func() []uint16 {
+	return func() []uint16 {
+		shards := make([]uint16, 0, shardCount)
+		for shardID := uint16(0); shardID < shardCount; shardID++ {
+			shards = append(shards, shardID)
+		}
+		return shards
+	}
+}
+
+var testReplicaConfig = configurations.BlobReplicaConfig{
+	Endpoint:       "replica:9000",
+	QueueSize:      2,
+	RetryDelay:     10 * time.Millisecond,
+	ResyncInterval: time.Hour,
+}
+
+func TestReplicator_ReplicatesWritesWithRetries(t *testing.T) {
+	source, target := newMemoryStore("orders"), newMemoryStore()
+	target.failures = 2
+	r := newReplicator(source, target, testReplicaConfig, 5*time.Second, 2, allShards(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for shardID, queue := range r.queues {
+		go r.run(ctx, shardID, queue)
+	}
+
+	r.enqueue(1, "orders", "o", []byte("1"))
+	r.enqueue(1, "orders", "o", []byte("2"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.queued.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if data, ok := target.get("orders", "o"); !ok || data != "2" {
+		t.Errorf("Expected the latest write to be replicated, got %q (found %t)", data, ok)
+	}
+	if len(target.provisioned) != 1 {
+		t.Errorf("Expected the replica bucket to be provisioned once, got %v", target.provisioned)
+	}
+}
+
+func TestReplicator_DropsWritesWhenQueueFull(t *testing.T) {
+	r := newReplicator(newMemoryStore(), newMemoryStore(), testReplicaConfig, 5*time.Second, 1, allShards(1))
+	dropped := r.dropped.Value()
+
+	// No worker is running, the queue holds 2 writes
+	for i := 0; i < 3; i++ {
+		r.enqueue(0, "orders", fmt.Sprintf("o%d", i), []byte("x"))
+	}
+	if got := r.dropped.Value() - dropped; got != 1 {
+		t.Errorf("Expected 1 dropped write, got %d", got)
+	}
+	if got := r.queued.Load(); got != 2 {
+		t.Errorf("Expected 2 queued writes, got %d", got)
+	}
+}
+
+func TestReplicator_ResyncCopiesMissingAndDifferentObjects(t *testing.T) {
+	source, target := newMemoryStore("orders", "users"), newMemoryStore("orders")
+	source.buckets["orders"]["same"] = []byte("1")
+	source.buckets["orders"]["changed"] = []byte("new")
+	source.buckets["orders"]["missing"] = []byte("3")
+	source.buckets["users"]["u"] = []byte("4")
+	target.buckets["orders"]["same"] = []byte("1")
+	target.buckets["orders"]["changed"] = []byte("old")
+	r := newReplicator(source, target, testReplicaConfig, 5*time.Second, 1, allShards(1))
+
+	if err := r.resync(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if target.writes != 3 {
+		t.Errorf("Expected 3 objects copied, got %d", target.writes)
+	}
+	for bucket, key := range map[string]string{"orders": "changed", "users": "u"} {
+		want, _ := source.get(bucket, key)
+		if got, _ := target.get(bucket, key); got != want {
+			t.Errorf("Expected %s/%s to be %q on the replica, got %q", bucket, key, want, got)
+		}
+	}
+}
+
+func TestReplicator_ResyncComparesChecksumsWithoutETag(t *testing.T) {
+	source, target := newMemoryStore("orders"), newMemoryStore("orders")
+	source.noETag, target.noETag = true, true
+	source.buckets["orders"]["same"] = []byte("abc")
+	source.buckets["orders"]["changed"] = []byte("new")
+	target.buckets["orders"]["same"] = []byte("abc")
+	// Same size, so only the stored checksum tells them apart
+	target.buckets["orders"]["changed"] = []byte("old")
+	r := newReplicator(source, target, testReplicaConfig, 5*time.Second, 1, allShards(1))
+
+	if err := r.resync(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if target.writes != 1 {
+		t.Errorf("Expected 1 object copied, got %d", target.writes)
+	}
+	if data, _ := target.get("orders", "changed"); data != "new" {
+		t.Errorf("Expected orders/changed to be %q on the replica, got %q", "new", data)
+	}
+}
+
+func TestReplicator_NilEnqueue(t *testing.T) {
+	var r *replicator
+	// Must not panic when replication is disabled
+	r.enqueue(0, "orders", "o", []byte("1"))
+}
+
+func TestReplicator_ResyncDoesNotOverwriteNewerWrite(t *testing.T) {
+	source, target := newMemoryStore("orders"), newMemoryStore("orders")
+	source.buckets["orders"]["o"] = []byte("old")
+	r := newReplicator(source, target, testReplicaConfig, 5*time.Second, 1, allShards(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.run(ctx, 0, r.queues[0])
+
+	// A newer write is applied and queued after the resync read the object
+	source.onRead = func() {
+		source.buckets["orders"]["o"] = []byte("new")
+		r.enqueue(0, "orders", "o", []byte("new"))
+	}
+	if err := r.resync(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.queued.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if data, _ := target.get("orders", "o"); data != "new" {
+		t.Errorf("Expected the newer write on the replica, got %q", data)
+	}
+}
+
+func TestReplicator_ResyncOnlyOwnedBuckets(t *testing.T) {
+	source, target := newMemoryStore(), newMemoryStore()
+	var owned, other string
+	for i := 0; owned == "" || other == ""; i++ {
+		name := fmt.Sprintf("bucket-%d", i)
+		if bucketShard(name, 2) == 0 {
+			owned = name
+		} else {
+			other = name
+		}
+	}
+	for _, name := range []string{owned, other} {
+		source.buckets[name] = map[string][]byte{"o": []byte("1")}
+		target.buckets[name] = map[string][]byte{"extra": []byte("2")}
+	}
+	r := newReplicator(source, target, testReplicaConfig, 5*time.Second, 2, func() []uint16 { return []uint16{0} })
+
+	if err := r.resync(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := target.get(owned, "o"); !ok {
+		t.Errorf("Expected %s/o to be copied to the replica", owned)
+	}
+	if _, ok := target.get(owned, "extra"); ok {
+		t.Errorf("Expected %s/extra to be deleted from the replica", owned)
+	}
+	if _, ok := target.get(other, "o"); ok {
+		t.Errorf("Expected %s of another node to be left alone", other)
+	}
+	if _, ok := target.get(other, "extra"); !ok {
+		t.Errorf("Expected %s/extra of another node to be kept", other)
+	}
+}
+
+func TestReplicator_ResyncContinuesAfterObjectFailure(t *testing.T) {
+	source, target := newMemoryStore("orders"), newMemoryStore("orders")
+	source.buckets["orders"]["broken"] = []byte("1")
+	source.buckets["orders"]["missing"] = []byte("2")
+	source.unreadable = map[string]bool{"broken": true}
+	target.buckets["orders"]["expired"] = []byte("3")
+	r := newReplicator(source, target, testReplicaConfig, 5*time.Second, 1, allShards(1))
+	failed := r.resyncFailed.Value()
+
+	copied, deleted, err := r.resyncBucket(context.Background(), "orders")
+	if err == nil {
+		t.Error("Expected the failed object to be reported")
+	}
+	if copied != 1 || deleted != 1 {
+		t.Errorf("Expected 1 object copied and 1 deleted, got %d and %d", copied, deleted)
+	}
+	if _, ok := target.get("orders", "missing"); !ok {
+		t.Error("Expected orders/missing to be copied after the failed object")
+	}
+	if _, ok := target.get("orders", "expired"); ok {
+		t.Error("Expected orders/expired to be deleted after the failed object")
+	}
+	if got := r.resyncFailed.Value() - failed; got != 1 {
+		t.Errorf("Expected 1 failed object, got %d", got)
+	}
+}
+
+func TestReplicator_ResyncDeletesObjectsMissingFromSource(t *testing.T) {
+	source, target := newMemoryStore("orders"), newMemoryStore("orders")
+	source.buckets["orders"]["kept"] = []byte("1")
+	target.buckets["orders"]["kept"] = []byte("1")
+	target.buckets["orders"]["deleted"] = []byte("2")
+	target.buckets["orders"]["pending"] = []byte("3")
+	r := newReplicator(source, target, testReplicaConfig, 5*time.Second, 1, allShards(1))
+	pruned := r.pruned.Value()
+
+	previous := globalAsyncWrites
+	t.Cleanup(func() { globalAsyncWrites = previous })
+	a := newAsyncWriter(nil, 1, time.Second, time.Millisecond, 1, nil)
+	t.Cleanup(a.stop)
+	globalAsyncWrites = a
+	a.overlay.add("orders", "pending", "1", []byte("4"))
+
+	if err := r.resync(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := target.get("orders", "deleted"); ok {
+		t.Errorf("Expected orders/deleted to be deleted from the replica")
+	}
+	for _, key := range []string{"kept", "pending"} {
+		if _, ok := target.get("orders", key); !ok {
+			t.Errorf("Expected orders/%s to be kept on the replica", key)
+		}
+	}
+	if got := r.pruned.Value() - pruned; got != 1 {
+		t.Errorf("Expected 1 deleted object, got %d", got)
+	}
+}
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"NimbusDb/auth"
+	"NimbusDb/blob"
+	"NimbusDb/metrics"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxScrubFindings is the number of corrupt and unreadable objects kept in a scrub report, each.
+	maxScrubFindings = 1000
+)
+
+var (
+	// globalScrubber verifies the integrity of the buckets owned by this node. Nil if the scrubber is disabled.
+	// It is set once during startup and never modified.
+	globalScrubber *scrubber
+)
+
+// scrubStore is the part of the blob client used by the scrubber.
+type scrubStore interface {
+	ListBuckets(ctx context.Context) ([]blob.BucketInfo, error)
+	ListObjects(ctx context.Context, bucketName, prefix string) ([]blob.ObjectInfo, error)
+	VerifyObject(ctx context.Context, bucketName string, obj blob.ObjectInfo) (bool, error)
+	CheckBucketSettings(ctx context.Context, bucketName string) (*blob.BucketSettingsDrift, error)
+}
+
+// scrubber periodically re-reads every current object of the buckets owned by this node,
+// verifies them against their stored checksum, and checks the versioning and lifecycle settings of the buckets.
+// Reads are throttled to a byte rate. Findings are logged, counted in metrics, and kept in the last report.
+// A bucket is owned by the node owning the shard its name hashes to (see bucketShard),
+// so each bucket is scrubbed by a single node once shards are spread over several nodes.
+// This type is thread-safe.
+type scrubber struct {
+	store          scrubStore
+	interval       time.Duration
+	bytesPerSecond int64
+	opTimeout      time.Duration
+	shardCount     uint16
+	// ownedShards returns the shards owned by this node.
+	ownedShards func() []uint16
+	mu          sync.Mutex
+	last        *ScrubReport
+	objects     *metrics.Counter
+	bytes       *metrics.Counter
+	corrupt     *metrics.Counter
+	unreadable  *metrics.Counter
+	drifted     *metrics.Gauge
+	lastScrub   *metrics.Gauge
+}
+
+// StartScrubber starts the background integrity scrubber if it is enabled, the first scrub right away.
+// Must be called after the shard state is initialized, the scrubber only covers the buckets of the shards owned by this node.
+//
+// params:
+//   - ctx: The context that stops the scrubber when cancelled
+func StartScrubber(ctx context.Context) {
+	cfg := globalConfig.Scrub
+	if !cfg.Enabled {
+		return
+	}
+
+	s := newScrubber(globalBlobClient, cfg.Interval, cfg.BytesPerSecond, globalConfig.Blob.BlobOperationTimeout, globalConfig.ShardCount, func() []uint16 {
+		state := GetGlobalState()
+		if state == nil {
+			return nil
+		}
+		return state.GetShardIDs()
+	})
+	globalScrubber = s
+	go s.run(ctx)
+	log.Info().Dur("interval", cfg.Interval).Int64("bytesPerSecond", cfg.BytesPerSecond).Msg("Integrity scrubber enabled")
+}
+
+// newScrubber creates a scrubber. It is started with run.
+//
+// params:
+//   - store: The blob storage to scrub
+//   - interval: The time between the start of two scrubs
+//   - bytesPerSecond: The rate at which objects are re-read
+//   - opTimeout: The timeout of a single blob operation
+//   - shardCount: The number of shards buckets are spread over
+//   - ownedShards: Returns the shards owned by this node
+//
+// return:
+//   - *scrubber: The scrubber
+func newScrubber(store scrubStore, interval time.Duration, bytesPerSecond int64, opTimeout time.Duration, shardCount uint16, ownedShards func() []uint16) *scrubber {
+	return &scrubber{
+		store:          store,
+		interval:       interval,
+		bytesPerSecond: bytesPerSecond,
+		opTimeout:      opTimeout,
+		shardCount:     shardCount,
+		ownedShards:    ownedShards,
+		objects:        metrics.GetCounter("nimbus_scrub_objects_total", nil),
+		bytes:          metrics.GetCounter("nimbus_scrub_bytes_total", nil),
+		corrupt:        metrics.GetCounter("nimbus_scrub_corrupt_objects_total", nil),
+		unreadable:     metrics.GetCounter("nimbus_scrub_unreadable_objects_total", nil),
+		drifted:        metrics.GetGauge("nimbus_scrub_drifted_buckets", nil),
+		lastScrub:      metrics.GetGauge("nimbus_scrub_last_completed_timestamp_seconds", nil),
+	}
+}
+
+// run scrubs the owned buckets right away, then every interval, until ctx is cancelled.
+func (s *scrubber) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.scrub(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// report returns the report of the last scrub, nil if none has finished yet.
+// A nil scrubber (disabled) has no report.
+func (s *scrubber) report() *ScrubReport {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// scrub verifies every current object and the settings of the buckets owned by this node, and records the report.
+//
+// params:
+//   - ctx: The context bounding the scrub
+//
+// return:
+//   - *ScrubReport: The report, not completed if ctx was cancelled or the buckets could not be listed
+func (s *scrubber) scrub(ctx context.Context) *ScrubReport {
+	report := &ScrubReport{
+		StartedAt:      time.Now().UTC(),
+		Corrupt:        []ScrubFinding{},
+		Unreadable:     []ScrubFinding{},
+		DriftedBuckets: []blob.BucketSettingsDrift{},
+	}
+	defer func() {
+		report.FinishedAt = time.Now().UTC()
+		s.mu.Lock()
+		s.last = report
+		s.mu.Unlock()
+	}()
+
+	listCtx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	buckets, err := s.store.ListBuckets(listCtx)
+	cancel()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list buckets to scrub")
+		return report
+	}
+
+	owned := make(map[uint16]bool)
+	for _, shardID := range s.ownedShards() {
+		owned[shardID] = true
+	}
+	for _, bucket := range buckets {
+		if !owned[bucketShard(bucket.Name, s.shardCount)] {
+			continue
+		}
+		report.Buckets++
+		if !s.scrubBucket(ctx, bucket.Name, report) {
+			log.Info().Int("buckets", report.Buckets).Int64("objects", report.Objects).Msg("Integrity scrub interrupted")
+			return report
+		}
+	}
+
+	report.Completed = true
+	s.drifted.Set(float64(len(report.DriftedBuckets)))
+	s.lastScrub.Set(float64(time.Now().Unix()))
+	log.Info().Int("buckets", report.Buckets).Int64("objects", report.Objects).Int64("bytes", report.Bytes).
+		Int("corrupt", len(report.Corrupt)).Int("unreadable", len(report.Unreadable)).Int("driftedBuckets", len(report.DriftedBuckets)).
+		Msg("Integrity scrub completed")
+	return report
+}
+
+// scrubBucket checks the settings of a bucket, then verifies its current objects one at a time, throttled.
+//
+// return:
+//   - bool: False if ctx was cancelled before the bucket was scrubbed
+func (s *scrubber) scrubBucket(ctx context.Context, bucketName string, report *ScrubReport) bool {
+	opCtx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	drift, err := s.store.CheckBucketSettings(opCtx, bucketName)
+	cancel()
+	switch {
+	case err != nil:
+		log.Error().Err(err).Str("bucketName", bucketName).Msg("Failed to check bucket settings")
+	case drift.Drifted():
+		log.Warn().Str("bucketName", bucketName).Str("versioning", drift.Versioning).Strs("driftedLifecycleRules", drift.DriftedLifecycleRules).Msg("Bucket settings drifted")
+		report.DriftedBuckets = append(report.DriftedBuckets, *drift)
+	}
+
+	// Listing a large bucket can take longer than a single operation, it is only bounded by ctx
+	objects, err := s.store.ListObjects(ctx, bucketName, "")
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		log.Error().Err(err).Str("bucketName", bucketName).Msg("Failed to list objects to scrub")
+		s.unreadable.Inc()
+		s.addFinding(report, &report.Unreadable, ScrubFinding{Bucket: bucketName, Error: err.Error()})
+		return true
+	}
+
+	for _, obj := range objects {
+		opCtx, cancel := context.WithTimeout(ctx, s.opTimeout)
+		verified, err := s.store.VerifyObject(opCtx, bucketName, obj)
+		cancel()
+		if ctx.Err() != nil {
+			return false
+		}
+
+		report.Objects++
+		report.Bytes += obj.Size
+		s.objects.Inc()
+		s.bytes.Add(uint64(obj.Size))
+		if verified {
+			report.Verified++
+		}
+		if err != nil {
+			finding := ScrubFinding{Bucket: bucketName, Key: obj.Key, VersionID: obj.VersionID, Error: err.Error()}
+			if errors.Is(err, blob.ErrObjectCorrupt) {
+				log.Error().Err(err).Str("bucketName", bucketName).Str("fileName", obj.Key).Str("versionID", obj.VersionID).Msg("Corrupt object detected")
+				s.corrupt.Inc()
+				s.addFinding(report, &report.Corrupt, finding)
+			} else {
+				log.Error().Err(err).Str("bucketName", bucketName).Str("fileName", obj.Key).Str("versionID", obj.VersionID).Msg("Unreadable object detected")
+				s.unreadable.Inc()
+				s.addFinding(report, &report.Unreadable, finding)
+			}
+		}
+
+		if !s.throttle(ctx, report) {
+			return false
+		}
+	}
+	return true
+}
+
+// addFinding appends a finding to a list of the report, unless the list is full.
+func (s *scrubber) addFinding(report *ScrubReport, findings *[]ScrubFinding, finding ScrubFinding) {
+	if len(*findings) >= maxScrubFindings {
+		report.Truncated = true
+		return
+	}
+	*findings = append(*findings, finding)
+}
+
+// throttle waits until the bytes read since the start of the scrub are within the configured rate.
+//
+// return:
+//   - bool: False if ctx was cancelled while waiting
+func (s *scrubber) throttle(ctx context.Context, report *ScrubReport) bool {
+	if s.bytesPerSecond <= 0 {
+		return ctx.Err() == nil
+	}
+	due := report.StartedAt.Add(time.Duration(float64(report.Bytes) / float64(s.bytesPerSecond) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		return sleepCtx(ctx, wait)
+	}
+	return ctx.Err() == nil
+}
+
+// scrubReport handles requests for the report of the last integrity scrub of this node.
+// The report spans every bucket, so if authorization is configured the requester needs the admin action on any bucket ("*").
+func scrubReport(msg *nats.Msg) {
+	if !requireGrant(msg, auth.Wildcard, "scrub report", auth.ActionAdmin) {
+		return
+	}
+	if globalScrubber == nil {
+		RespondWithNatsError(msg, ErrorCodeNotFound, "integrity scrubber is disabled")
+		return
+	}
+	report := globalScrubber.report()
+	if report == nil {
+		RespondWithNatsError(msg, ErrorCodeNotFound, "no integrity scrub has finished yet")
+		return
+	}
+	respondWithJSON(msg, report)
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// scrubTestStore is an in-memory scrub store, with objects and buckets marked as failing their checks.
+type scrubTestStore struct {
+	*memoryStore
+	corrupt    map[string]bool
+	unreadable map[string]bool
+	drifted    map[string]bool
+}
+
+func newScrubTestStore(buckets ...string) *scrubTestStore {
+	return &scrubTestStore{
+		memoryStore: newMemoryStore(buckets...),
+		corrupt:     make(map[string]bool),
+		unreadable:  make(map[string]bool),
+		drifted:     make(map[string]bool),
+	}
+}
+
+func (s *scrubTestStore) VerifyObject(ctx context.Context, bucketName string, obj blob.ObjectInfo) (bool, error) {
+	switch {
+	case s.unreadable[obj.Key]:
+		return false, fmt.Errorf("failed to read object %s", obj.Key)
+	case s.corrupt[obj.Key]:
+		return true, fmt.Errorf("%w: %s", blob.ErrObjectCorrupt, obj.Key)
+	}
+	return true, nil
+}
+
+func (s *scrubTestStore) CheckBucketSettings(ctx context.Context, bucketName string) (*blob.BucketSettingsDrift, error) {
+	drift := &blob.BucketSettingsDrift{Name: bucketName, Versioning: blob.VersioningEnabled}
+	if s.drifted[bucketName] {
+		drift.DriftedLifecycleRules = []string{"CleanOldVersions"}
+	}
+	return drift, nil
+}
+
+func TestScrubber_ReportsFindings(t *testing.T) {
+	store := newScrubTestStore("orders", "users")
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := store.WriteFile(ctx, "orders", key, []byte("data")); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	store.corrupt["b"] = true
+	store.unreadable["c"] = true
+	store.drifted["users"] = true
+
+	s := newScrubber(store, time.Hour, 0, time.Second, 4, allShards(4))
+	corruptBefore := s.corrupt.Value()
+	report := s.scrub(ctx)
+
+	if !report.Completed {
+		t.Error("Expected the scrub to complete")
+	}
+	if report.Buckets != 2 || report.Objects != 3 || report.Bytes != 12 {
+		t.Errorf("Expected 2 buckets, 3 objects and 12 bytes, got %d, %d and %d", report.Buckets, report.Objects, report.Bytes)
+	}
+	if len(report.Corrupt) != 1 || report.Corrupt[0].Key != "b" {
+		t.Errorf("Expected b to be reported corrupt, got %+v", report.Corrupt)
+	}
+	if len(report.Unreadable) != 1 || report.Unreadable[0].Key != "c" {
+		t.Errorf("Expected c to be reported unreadable, got %+v", report.Unreadable)
+	}
+	if len(report.DriftedBuckets) != 1 || report.DriftedBuckets[0].Name != "users" {
+		t.Errorf("Expected users to be reported drifted, got %+v", report.DriftedBuckets)
+	}
+	if got := s.corrupt.Value() - corruptBefore; got != 1 {
+		t.Errorf("Expected the corrupt objects counter to increase by 1, got %d", got)
+	}
+	if s.report() != report {
+		t.Error("Expected the report to be kept as the last report")
+	}
+}
+
+func TestScrubber_OwnedBucketsOnly(t *testing.T) {
+	const shardCount = 8
+	store := newScrubTestStore("orders")
+	owned := bucketShard("orders", shardCount)
+	// Find a bucket owned by another shard
+	other := ""
+	for i := 0; other == ""; i++ {
+		if name := fmt.Sprintf("bucket-%d", i); bucketShard(name, shardCount) != owned {
+			other = name
+		}
+	}
+	store.buckets[other] = map[string][]byte{"x": []byte("data")}
+
+	s := newScrubber(store, time.Hour, 0, time.Second, shardCount, func() []uint16 { return []uint16{owned} })
+	report := s.scrub(context.Background())
+	if report.Buckets != 1 || report.Objects != 0 {
+		t.Errorf("Expected only the owned empty bucket to be scrubbed, got %d buckets and %d objects", report.Buckets, report.Objects)
+	}
+}
+
+func TestScrubber_Throttle(t *testing.T) {
+	store := newScrubTestStore("orders")
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if _, err := store.WriteFile(ctx, "orders", fmt.Sprintf("key-%d", i), make([]byte, 20)); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+
+	// 100 bytes at 1000 bytes/s
+	s := newScrubber(store, time.Hour, 1000, time.Second, 1, allShards(1))
+	start := time.Now()
+	s.scrub(ctx)
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected the scrub to be throttled to at least 90ms, got %s", elapsed)
+	}
+}
+
+func TestScrubber_Cancelled(t *testing.T) {
+	store := newScrubTestStore("orders")
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := store.WriteFile(ctx, "orders", "a", make([]byte, 1000)); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	// 1000 bytes at 1 byte/s, interrupted while throttled
+	s := newScrubber(store, time.Hour, 1, time.Second, 1, allShards(1))
+	time.AfterFunc(20*time.Millisecond, cancel)
+	report := s.scrub(ctx)
+	if report.Completed {
+		t.Error("Expected the cancelled scrub not to complete")
+	}
+}
+
+func TestScrubber_NilReport(t *testing.T) {
+	var s *scrubber
+	if s.report() != nil {
+		t.Error("Expected a disabled scrubber to have no report")
+	}
+}
@@ -0,0 +1,458 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// segmentFileSuffix is the suffix of segment files. The name is the sequence of the first record, zero padded.
+	segmentFileSuffix = ".seg"
+	// checkpointFileName is the file holding the sequence of the last record applied to blob storage.
+	checkpointFileName = "checkpoint"
+	// recordHeaderSize is the size of the record header: body length and CRC-32 of the body.
+	recordHeaderSize = 8
+	// maxRecordDataSize is the largest data of a record, the maximum NATS payload.
+	maxRecordDataSize = 64 << 20
+	// maxRecordBodySize is the largest record body: sequence, bucket and file names with their lengths, and data.
+	maxRecordBodySize = 8 + 2 + 0xFFFF + 2 + 0xFFFF + maxRecordDataSize
+)
+
+// logRecord is a write stored in a segment log.
+type logRecord struct {
+	seq        uint64
+	bucketName string
+	fileName   string
+	data       []byte
+}
+
+// recordRef locates a record in a segment file. Only the position and names of pending writes are kept in memory,
+// their data is read back from the segment when needed.
+type recordRef struct {
+	seq        uint64
+	bucketName string
+	fileName   string
+	path       string
+	// offset is the position of the record header in the segment file.
+	offset int64
+	// size is the length of the data of the record.
+	size int
+}
+
+// segmentFile is a segment of the log and the range of sequences it holds.
+type segmentFile struct {
+	path     string
+	firstSeq uint64
+	// lastSeq is the sequence of the last record, 0 if the segment is empty.
+	lastSeq uint64
+}
+
+// segmentLog is an append-only log of writes on local disk, split in segment files.
+// Every append is fsynced before it returns. Records up to the checkpoint have been applied,
+// segments holding only applied records are deleted.
+// A record torn by a crash (incomplete or failing its checksum) ends its segment, it was never acknowledged.
+// A record that failed to be written or synced is discarded before the next append, so it cannot hide later records.
+// This type is thread-safe.
+type segmentLog struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	// segments are ordered by sequence, the last one is the active segment.
+	segments   []*segmentFile
+	active     *os.File
+	activeSize int64
+	nextSeq    uint64
+	// torn is true if the active segment may hold bytes of a failed append after activeSize.
+	torn bool
+}
+
+// openSegmentLog opens the segment log in dir, creating the directory if needed, and returns the references
+// of the records that were appended but not yet applied. Appends go to a new segment.
+//
+// params:
+//   - dir: The directory of the log
+//   - segmentSize: The size in bytes after which a new segment is started
+//
+// return:
+//   - *segmentLog: The log
+//   - []recordRef: The records after the checkpoint, in append order
+//   - error: An error if the log could not be read or the new segment could not be created
+func openSegmentLog(dir string, segmentSize int64) (*segmentLog, []recordRef, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	l := &segmentLog{dir: dir, segmentSize: segmentSize}
+
+	checkpoint := l.readCheckpoint()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentFileSuffix) {
+			continue
+		}
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, &segmentFile{path: filepath.Join(dir, name), firstSeq: firstSeq})
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i].firstSeq < l.segments[j].firstSeq })
+
+	var pending []recordRef
+	l.nextSeq = checkpoint + 1
+	for _, seg := range l.segments {
+		records, err := scanSegment(seg.path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read segment %s: %w", seg.path, err)
+		}
+		for _, r := range records {
+			seg.lastSeq = r.seq
+			if r.seq >= l.nextSeq {
+				l.nextSeq = r.seq + 1
+			}
+			if r.seq > checkpoint {
+				pending = append(pending, r)
+			}
+		}
+	}
+
+	// Segments holding only applied records are not needed anymore
+	if err := l.deleteApplied(checkpoint); err != nil {
+		return nil, nil, err
+	}
+	if err := l.rotate(); err != nil {
+		return nil, nil, err
+	}
+	return l, pending, nil
+}
+
+// scanSegment returns the references of the records of a segment file, up to the first torn record.
+// Records are checked one at a time, their data is not kept.
+func scanSegment(path string) ([]recordRef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []recordRef
+	var offset int64
+	var body []byte
+	r := bufio.NewReader(f)
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			// io.EOF is the clean end, io.ErrUnexpectedEOF a torn header
+			return refs, nil
+		}
+		n := int(binary.BigEndian.Uint32(header[0:4]))
+		// A length larger than any record or than the rest of the file is a torn or corrupt header
+		if n > maxRecordBodySize || int64(n) > info.Size()-offset-recordHeaderSize {
+			return refs, nil
+		}
+		if cap(body) < n {
+			body = make([]byte, n)
+		}
+		body = body[:n]
+		if _, err := io.ReadFull(r, body); err != nil {
+			return refs, nil
+		}
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+			return refs, nil
+		}
+		rec, err := decodeRecord(body)
+		if err != nil {
+			return refs, nil
+		}
+		refs = append(refs, recordRef{seq: rec.seq, bucketName: rec.bucketName, fileName: rec.fileName, path: path, offset: offset, size: len(rec.data)})
+		offset += int64(recordHeaderSize + n)
+	}
+}
+
+// readRecord reads a record back from its segment file.
+//
+// return:
+//   - logRecord: The record with its data
+//   - error: os.ErrNotExist if the segment was deleted because the record was applied, or an error if it could not be read
+func readRecord(ref recordRef) (logRecord, error) {
+	f, err := os.Open(ref.path)
+	if err != nil {
+		return logRecord{}, err
+	}
+	defer f.Close()
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, ref.offset); err != nil {
+		return logRecord{}, fmt.Errorf("failed to read record %d: %w", ref.seq, err)
+	}
+	n := binary.BigEndian.Uint32(header[0:4])
+	if n > maxRecordBodySize {
+		return logRecord{}, fmt.Errorf("record %d of %s has an invalid length %d", ref.seq, ref.path, n)
+	}
+	body := make([]byte, n)
+	if _, err := f.ReadAt(body, ref.offset+recordHeaderSize); err != nil {
+		return logRecord{}, fmt.Errorf("failed to read record %d: %w", ref.seq, err)
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return logRecord{}, fmt.Errorf("record %d of %s fails its checksum", ref.seq, ref.path)
+	}
+	rec, err := decodeRecord(body)
+	if err != nil {
+		return logRecord{}, err
+	}
+	if rec.seq != ref.seq {
+		return logRecord{}, fmt.Errorf("expected record %d in %s, found %d", ref.seq, ref.path, rec.seq)
+	}
+	return rec, nil
+}
+
+// encodeRecord encodes a record: header (body length, CRC-32 of the body) followed by the body
+// (sequence, bucket name, file name, each name prefixed by its length, and the data).
+func encodeRecord(rec logRecord) []byte {
+	bodyLen := 8 + 2 + len(rec.bucketName) + 2 + len(rec.fileName) + len(rec.data)
+	b := make([]byte, recordHeaderSize+bodyLen)
+	body := b[recordHeaderSize:]
+
+	binary.BigEndian.PutUint64(body[0:8], rec.seq)
+	i := 8
+	binary.BigEndian.PutUint16(body[i:], uint16(len(rec.bucketName)))
+	i += 2 + copy(body[i+2:], rec.bucketName)
+	binary.BigEndian.PutUint16(body[i:], uint16(len(rec.fileName)))
+	i += 2 + copy(body[i+2:], rec.fileName)
+	copy(body[i:], rec.data)
+
+	binary.BigEndian.PutUint32(b[0:4], uint32(bodyLen))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(body))
+	return b
+}
+
+// decodeRecord decodes a record body written by encodeRecord.
+func decodeRecord(body []byte) (logRecord, error) {
+	errCorrupt := errors.New("corrupt record")
+	if len(body) < 12 {
+		return logRecord{}, errCorrupt
+	}
+	rec := logRecord{seq: binary.BigEndian.Uint64(body[0:8])}
+	i := 8
+	n := int(binary.BigEndian.Uint16(body[i:]))
+	if len(body) < i+2+n+2 {
+		return logRecord{}, errCorrupt
+	}
+	rec.bucketName = string(body[i+2 : i+2+n])
+	i += 2 + n
+	n = int(binary.BigEndian.Uint16(body[i:]))
+	if len(body) < i+2+n {
+		return logRecord{}, errCorrupt
+	}
+	rec.fileName = string(body[i+2 : i+2+n])
+	i += 2 + n
+	rec.data = body[i:]
+	return rec, nil
+}
+
+// append appends a write to the active segment and fsyncs it.
+// A new segment is started once the active one reaches the segment size.
+//
+// params:
+//   - bucketName: The bucket of the write
+//   - fileName: The file of the write
+//   - data: The content of the write
+//
+// return:
+//   - recordRef: The reference of the record, to read it back
+//   - error: An error if the record could not be written and synced, it must then not be acknowledged
+func (l *segmentLog) append(bucketName, fileName string, data []byte) (recordRef, error) {
+	if len(bucketName) > 0xFFFF || len(fileName) > 0xFFFF {
+		return recordRef{}, errors.New("bucket or file name too long")
+	}
+	if len(data) > maxRecordDataSize {
+		return recordRef{}, errors.New("data too large")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.torn {
+		if err := l.discardTail(); err != nil {
+			return recordRef{}, fmt.Errorf("failed to discard a failed append: %w", err)
+		}
+	}
+
+	rec := logRecord{seq: l.nextSeq, bucketName: bucketName, fileName: fileName, data: data}
+	ref := recordRef{seq: rec.seq, bucketName: bucketName, fileName: fileName, path: l.active.Name(), offset: l.activeSize, size: len(data)}
+	b := encodeRecord(rec)
+	_, err := l.active.Write(b)
+	if err == nil {
+		err = l.active.Sync()
+	}
+	if err != nil {
+		// Part of the record may be in the segment, it must not stay in front of the next records
+		l.torn = true
+		if discardErr := l.discardTail(); discardErr != nil {
+			log.Error().Err(discardErr).Str("dir", l.dir).Msg("Failed to discard a failed append, retrying on the next append")
+		}
+		return recordRef{}, err
+	}
+	l.nextSeq++
+	l.activeSize += int64(len(b))
+	l.segments[len(l.segments)-1].lastSeq = rec.seq
+
+	if l.activeSize >= l.segmentSize {
+		// The record is durable, a failed rotation only delays the new segment to the next append
+		if err := l.rotate(); err != nil {
+			log.Warn().Err(err).Str("segment", l.active.Name()).Msg("Failed to start a new segment, appending to the current one")
+		}
+	}
+	return ref, nil
+}
+
+// discardTail removes the bytes of a failed append from the active segment, by truncating it back to its last record.
+// If the segment cannot be truncated, appends continue in a new segment: the torn record then ends the old one.
+// Must be called with mu held.
+//
+// return:
+//   - error: An error if the segment could neither be truncated nor replaced, appends must then fail
+func (l *segmentLog) discardTail() error {
+	// A failed replacement of an empty segment leaves no active segment
+	err := os.ErrClosed
+	if l.active != nil {
+		err = l.active.Truncate(l.activeSize)
+		if err == nil {
+			err = l.active.Sync()
+		}
+	}
+	if err != nil {
+		if rotateErr := l.rotate(); rotateErr != nil {
+			return errors.Join(err, rotateErr)
+		}
+	}
+	l.torn = false
+	return nil
+}
+
+// rotate starts a new segment and closes the active one. A segment holding no record is replaced, as it would
+// have the name of the new one. The directory is fsynced so the new segment survives a power loss.
+// On error, the active segment is left unchanged, unless it was being replaced. Must be called with mu held (or before the log is shared).
+func (l *segmentLog) rotate() error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.nextSeq, segmentFileSuffix))
+	last := len(l.segments) - 1
+	replaced := l.active != nil && l.segments[last].lastSeq == 0
+	if replaced {
+		// Closed first: the file is removed and created again under the same name
+		_ = l.active.Close()
+		l.active = nil
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		l.segments = l.segments[:last]
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+
+	if l.active != nil {
+		// Every record of the old segment is already synced
+		if err := l.active.Close(); err != nil {
+			log.Warn().Err(err).Str("segment", l.active.Name()).Msg("Failed to close segment")
+		}
+	}
+	l.active = f
+	l.activeSize = 0
+	l.segments = append(l.segments, &segmentFile{path: path, firstSeq: l.nextSeq})
+	return nil
+}
+
+// syncDir fsyncs a directory, so the files created or renamed in it survive a power loss.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// commit records that every record up to seq has been applied, and deletes the segments that are not needed anymore.
+// The checkpoint is not fsynced: after a crash, records may be applied again, but never skipped.
+func (l *segmentLog) commit(seq uint64) error {
+	tmp := filepath.Join(l.dir, checkpointFileName+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(seq, 10)), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(l.dir, checkpointFileName)); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.deleteApplied(seq)
+}
+
+// deleteApplied deletes the segments, other than the active one, whose records are all applied. Must be called with mu held.
+func (l *segmentLog) deleteApplied(checkpoint uint64) error {
+	kept := l.segments[:0]
+	for i, seg := range l.segments {
+		isActive := l.active != nil && i == len(l.segments)-1
+		if !isActive && seg.lastSeq <= checkpoint {
+			if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	l.segments = kept
+	return nil
+}
+
+// readCheckpoint returns the sequence of the last applied record, 0 if unknown (every record is then replayed).
+func (l *segmentLog) readCheckpoint() uint64 {
+	b, err := os.ReadFile(filepath.Join(l.dir, checkpointFileName))
+	if err != nil {
+		return 0
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// close closes the active segment.
+func (l *segmentLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active == nil {
+		return nil
+	}
+	return l.active.Close()
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestSegmentLog(t *testing.T, dir string, segmentSize int64) (*segmentLog, []recordRef) {
+	t.Helper()
+	l, records, err := openSegmentLog(dir, segmentSize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { _ = l.close() })
+	return l, records
+}
+
+func appendTestRecord(t *testing.T, l *segmentLog, fileName, data string) uint64 {
+	t.Helper()
+	ref, err := l.append("orders", fileName, []byte(data))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return ref.seq
+}
+
+func TestSegmentLog_ReopenReturnsUncommittedRecords(t *testing.T) {
+	dir := t.TempDir()
+	l, records := openTestSegmentLog(t, dir, 1<<20)
+	if len(records) != 0 {
+		t.Fatalf("Expected no records in a new log, got %d", len(records))
+	}
+	appendTestRecord(t, l, "a", "1")
+	seq := appendTestRecord(t, l, "b", "2")
+	appendTestRecord(t, l, "c", "3")
+	if err := l.commit(seq); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_ = l.close()
+
+	l, records = openTestSegmentLog(t, dir, 1<<20)
+	if len(records) != 1 || records[0].fileName != "c" || records[0].size != 1 || records[0].bucketName != "orders" {
+		t.Fatalf("Expected only record c to be replayed, got %+v", records)
+	}
+	if rec, err := readRecord(records[0]); err != nil || string(rec.data) != "3" {
+		t.Errorf("Expected record c to be read back as 3, got %q (%v)", rec.data, err)
+	}
+	if next := appendTestRecord(t, l, "d", "4"); next != records[0].seq+1 {
+		t.Errorf("Expected sequence %d after reopen, got %d", records[0].seq+1, next)
+	}
+}
+
+func TestSegmentLog_TornTailIsIgnored(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := openTestSegmentLog(t, dir, 1<<20)
+	appendTestRecord(t, l, "a", "1")
+	appendTestRecord(t, l, "b", "2")
+	path := l.active.Name()
+	_ = l.close()
+
+	// Simulate a crash in the middle of the last append
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	_, records := openTestSegmentLog(t, dir, 1<<20)
+	if len(records) != 1 || records[0].fileName != "a" {
+		t.Fatalf("Expected only record a to be replayed, got %+v", records)
+	}
+}
+
+func TestSegmentLog_CorruptRecordEndsSegment(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := openTestSegmentLog(t, dir, 1<<20)
+	appendTestRecord(t, l, "a", "1")
+	appendTestRecord(t, l, "b", "2")
+	path := l.active.Name()
+	_ = l.close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	b[len(b)-1] ^= 0xFF
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	_, records := openTestSegmentLog(t, dir, 1<<20)
+	if len(records) != 1 || records[0].fileName != "a" {
+		t.Fatalf("Expected only record a to be replayed, got %+v", records)
+	}
+}
+
+func TestSegmentLog_InvalidRecordLengthEndsSegment(t *testing.T) {
+	tests := []struct {
+		name   string
+		length uint32
+	}{
+		{"larger than any record", maxRecordBodySize + 1},
+		{"larger than the rest of the file", 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l, _ := openTestSegmentLog(t, dir, 1<<20)
+			appendTestRecord(t, l, "a", "1")
+			ref, err := l.append("orders", "b", []byte("2"))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			_ = l.close()
+
+			// Simulate a corrupt length in the header of the last record
+			b, err := os.ReadFile(ref.path)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			binary.BigEndian.PutUint32(b[ref.offset:], tt.length)
+			if err := os.WriteFile(ref.path, b, 0o644); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			_, records := openTestSegmentLog(t, dir, 1<<20)
+			if len(records) != 1 || records[0].fileName != "a" {
+				t.Fatalf("Expected only record a to be replayed, got %+v", records)
+			}
+		})
+	}
+}
+
+func TestSegmentLog_RotatesAndDeletesAppliedSegments(t *testing.T) {
+	dir := t.TempDir()
+	// Every record fills a segment
+	l, _ := openTestSegmentLog(t, dir, 1)
+	appendTestRecord(t, l, "a", "1")
+	seq := appendTestRecord(t, l, "b", "2")
+	appendTestRecord(t, l, "c", "3")
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentFileSuffix))
+	if len(segments) != 4 {
+		t.Fatalf("Expected 4 segments (3 full and the active one), got %d", len(segments))
+	}
+
+	if err := l.commit(seq); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentFileSuffix))
+	if len(segments) != 2 {
+		t.Errorf("Expected 2 segments after commit, got %d", len(segments))
+	}
+}
+
+func TestSegmentLog_FailedAppendIsTruncated(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := openTestSegmentLog(t, dir, 1<<20)
+	appendTestRecord(t, l, "a", "1")
+
+	// Simulate a short write left in the segment by a failed append
+	f, err := os.OpenFile(l.active.Name(), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	f.Close()
+	l.torn = true
+
+	ref, err := l.append("orders", "b", []byte("2"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rec, err := readRecord(ref); err != nil || string(rec.data) != "2" {
+		t.Errorf("Expected record b to be read back as 2, got %q (%v)", rec.data, err)
+	}
+	_ = l.close()
+
+	_, records := openTestSegmentLog(t, dir, 1<<20)
+	if len(records) != 2 || records[0].fileName != "a" || records[1].fileName != "b" {
+		t.Fatalf("Expected records a and b to be replayed, got %+v", records)
+	}
+}
+
+func TestSegmentLog_FailedWriteContinuesInNewSegment(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := openTestSegmentLog(t, dir, 1<<20)
+
+	// A closed segment can neither be written nor truncated, appends continue in a new one
+	_ = l.active.Close()
+	if _, err := l.append("orders", "lost", []byte("0")); err == nil {
+		t.Fatal("Expected append to a closed segment to fail")
+	}
+	appendTestRecord(t, l, "a", "1")
+	_ = l.active.Close()
+	if _, err := l.append("orders", "lost", []byte("0")); err == nil {
+		t.Fatal("Expected append to a closed segment to fail")
+	}
+	appendTestRecord(t, l, "b", "2")
+	if len(l.segments) != 2 {
+		t.Errorf("Expected 2 segments, got %d", len(l.segments))
+	}
+	_ = l.close()
+
+	_, records := openTestSegmentLog(t, dir, 1<<20)
+	if len(records) != 2 || records[0].fileName != "a" || records[1].fileName != "b" {
+		t.Fatalf("Expected records a and b to be replayed, got %+v", records)
+	}
+}
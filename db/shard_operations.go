@@ -1,8 +1,11 @@
 package db
 
 import (
+	"NimbusDb/blob"
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
@@ -21,12 +24,28 @@ const (
 	// CollectionRead represents a read operation.
 	// See devdocs/api.md (Operation Types) for details.
 	CollectionRead = 3
+	// Flush waits until every write acknowledged by the shard before it has reached blob storage.
+	// See devdocs/api.md (Operation Types) for details.
+	Flush = 4
 )
 
-// ShardHandlerInfo holds subscription and channel information for a shard handler.
+// ShardHandlerInfo holds subscription and queue information for a shard handler.
 type ShardHandlerInfo struct {
 	Subscription *nats.Subscription
-	Channel      chan *nats.Msg
+	queue        *shardQueue
+	// done is closed once the handler goroutine exits.
+	done chan struct{}
+}
+
+// CloseQueue closes the shard queue, letting the handler goroutine drain the queued requests and exit.
+// Must only be called after the subscription is unsubscribed and in-flight messages are delivered.
+func (h *ShardHandlerInfo) CloseQueue() {
+	h.queue.close()
+}
+
+// Done returns a channel closed once the handler goroutine has handled the queued requests and exited.
+func (h *ShardHandlerInfo) Done() <-chan struct{} {
+	return h.done
 }
 
 // StartShardHandlers initializes and starts all NATS shard operation handlers.
@@ -55,21 +74,32 @@ func StartShardHandlers() []*ShardHandlerInfo {
 
 	handlers := make([]*ShardHandlerInfo, 0, len(shardIDs))
 
+	// Async writes are applied by one worker per shard, separate from the shard handler
+	globalAsyncWrites = newAsyncWriter(shardIDs, globalConfig.Db.AsyncQueueSize, globalConfig.Blob.BlobOperationTimeout,
+		globalConfig.Db.AsyncRetryDelay, globalConfig.Db.AsyncMaxAttempts, globalBlobClient.WriteFile)
+
 	// Subscribe to each shard operation subject
 	for _, shardID := range shardIDs {
 		subject := fmt.Sprintf("%s.shards.%d.op", globalConfig.NATS.SubjectPrefix, shardID)
-		ch := make(chan *nats.Msg, globalConfig.Db.ChannelBufferSize)
-		sub, err := globalNATSConn.ChanSubscribe(subject, ch)
+		queue := newShardQueue(shardID, globalConfig.Db.ChannelBufferSize, globalConfig.Db.InteractiveLaneWeight, globalConfig.Db.MaxQueueWait, globalConfig.Db.OverloadRetryAfter)
+		// The queue stamps the receive time so that client timeouts account for the time spent in the channel,
+		// and rejects requests with an explicit overload response instead of blocking when the shard falls behind
+		sub, err := globalNATSConn.Subscribe(subject, queue.enqueue)
 		if err != nil {
 			log.Fatal().Err(err).Uint16("shardID", shardID).Msg("Failed to subscribe to shard operation subject")
 		}
 
 		// Start handler goroutine for this shard's channel to handle the messages
-		go handleShardOperation(shardID, ch)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handleShardOperation(shardID, queue)
+		}()
 
 		handlers = append(handlers, &ShardHandlerInfo{
 			Subscription: sub,
-			Channel:      ch,
+			queue:        queue,
+			done:         done,
 		})
 
 		log.Info().Uint16("shardID", shardID).Str("subject", subject).Msg("Subscribed to shard operation subject")
@@ -79,24 +109,56 @@ func StartShardHandlers() []*ShardHandlerInfo {
 
 // handleShardOperation handles requests for shard operations (write/read).
 // It processes the operation based on the type header and responds accordingly.
+// Requests are taken from the interactive and bulk lanes of the queue with weighted scheduling.
+// Requests whose client deadline has already passed, or that are over a rate limit, are rejected without touching blob storage.
 // params:
 //   - shardID: The shard ID for this operation
-//   - ch: The channel to receive the messages from
-func handleShardOperation(shardID uint16, ch chan *nats.Msg) {
-	for msg := range ch {
+//   - queue: The shard queue to receive the requests from
+func handleShardOperation(shardID uint16, queue *shardQueue) {
+	for {
+		req, ok := queue.next()
+		if !ok {
+			return
+		}
+		msg := req.msg
+
 		// Extract headers
 		headers, err := ExtractShardOperationHeaders(msg)
 		if err != nil {
+			if errors.Is(err, ErrForbidden) {
+				log.Warn().Err(err).Uint16("shardID", shardID).Str("bucketName", msg.Header.Get("bucketName")).Str("fileName", msg.Header.Get("fileName")).Msg("Rejected forbidden request")
+				RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+				continue
+			}
 			RespondWithNatsError(msg, ErrorCodeBadRequest, err.Error())
 			continue
 		}
 
+		// Shed requests the client has already given up on
+		deadline := headers.EffectiveDeadline(req.receivedAt)
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			log.Debug().Uint16("shardID", shardID).Str("fileName", headers.FileName).Dur("queued", time.Since(req.receivedAt)).Msg("Dropping expired shard operation")
+			RespondWithNatsError(msg, ErrorCodeGatewayTimeout, "request deadline exceeded before processing")
+			continue
+		}
+
+		// Throttle requests over the shard, tenant or bucket rate limits before touching blob storage
+		if scope, retryAfter := globalLimits.admit(shardID, headers, int64(len(msg.Data))); retryAfter > 0 {
+			log.Debug().Uint16("shardID", shardID).Str("scope", scope).Str("tenant", headers.Tenant).Str("bucketName", headers.BucketName).Msg("Throttled shard operation")
+			RespondWithNatsRetryableError(msg, ErrorCodeTooManyRequests, fmt.Sprintf("%s rate limit exceeded, retry after %d ms", scope, retryAfter.Milliseconds()), retryAfter)
+			continue
+		}
+
+		ctx, cancel := newOperationContext(deadline)
+
 		// Route to appropriate handler based on operation type
 		switch headers.OperationType {
 		case PointWrite:
-			handleWriteOperation(msg, shardID, headers.FileName, headers.BucketName, headers.Overwrite)
+			handleWriteOperation(ctx, msg, shardID, headers)
 		case PointRead:
-			handleReadOperation(msg, shardID, headers.FileName, headers.BucketName)
+			handleReadOperation(ctx, msg, shardID, headers)
+		case Flush:
+			handleFlushOperation(msg, shardID, deadline)
 		case CollectionWrite:
 			RespondWithNatsError(msg, ErrorCodeBadRequest, "collection write operation not yet implemented")
 		case CollectionRead:
@@ -105,44 +167,186 @@ func handleShardOperation(shardID uint16, ch chan *nats.Msg) {
 			RespondWithNatsError(msg, ErrorCodeBadRequest, fmt.Sprintf("unknown operation type: %d", headers.OperationType))
 		}
 
+		cancel()
+	}
+}
+
+// handleFlushOperation handles flush requests for shard operations.
+// It responds once every write acknowledged by the shard before the flush has reached blob storage:
+// the writes logged in the write-ahead log or the write buffer if one is enabled, the queued async writes otherwise.
+// The wait happens off the shard handler goroutine, so the shard keeps serving requests meanwhile.
+// params:
+//   - msg: The NATS message to respond to
+//   - shardID: The shard ID for this operation
+//   - deadline: The client deadline for the request. Zero if the client did not supply one.
+func handleFlushOperation(msg *nats.Msg, shardID uint16, deadline time.Time) {
+	if globalWAL != nil {
+		go func() {
+			ctx, cancel := newOperationContext(deadline)
+			defer cancel()
+			if err := globalWAL.waitFlushed(ctx, shardID); err != nil {
+				RespondWithNatsError(msg, walErrorStatus(err), fmt.Sprintf("failed to flush the write-ahead log: %v", err))
+				return
+			}
+			RespondWithNatsSuccess(msg)
+		}()
+		return
+	}
+	if globalWriteBuffer != nil {
+		go func() {
+			ctx, cancel := newOperationContext(deadline)
+			defer cancel()
+			if err := globalWriteBuffer.waitFlushed(ctx, shardID); err != nil {
+				RespondWithNatsError(msg, ErrorCodeGatewayTimeout, fmt.Sprintf("failed to flush the write buffer: %v", err))
+				return
+			}
+			RespondWithNatsSuccess(msg)
+		}()
+		return
+	}
+
+	if !globalAsyncWrites.enqueueFlush(shardID, func() { RespondWithNatsSuccess(msg) }) {
+		respondAsyncQueueFull(msg)
+	}
+}
+
+// respondAsyncQueueFull responds with a retryable 503 to a request that could not be queued on the full async write queue of its shard.
+func respondAsyncQueueFull(msg *nats.Msg) {
+	retryAfter := globalConfig.Db.OverloadRetryAfter
+	RespondWithNatsRetryableError(msg, ErrorCodeServiceUnavailable, fmt.Sprintf("async write queue full, retry after %d ms", retryAfter.Milliseconds()), retryAfter)
+}
+
+// walErrorStatus returns the response status for a write that could not be appended to the write-ahead log.
+func walErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCodeGatewayTimeout
+	}
+	return ErrorCodeServiceUnavailable
+}
+
+// newOperationContext creates the context for a single blob operation.
+// The context expires at the configured blob operation timeout, or at the client deadline if that is earlier.
+// params:
+//   - deadline: The client deadline for the request. Zero if the client did not supply one.
+//
+// return:
+//   - context.Context: The operation context
+//   - context.CancelFunc: The function to release the context resources
+func newOperationContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	timeoutDeadline := time.Now().Add(globalConfig.Blob.BlobOperationTimeout)
+	if deadline.IsZero() || deadline.After(timeoutDeadline) {
+		deadline = timeoutDeadline
 	}
+	return context.WithDeadline(context.Background(), deadline)
 }
 
 // handleWriteOperation handles write requests for shard operations.
 // It writes the message data directly to blob storage without parsing.
-// If overwrite is false and the file already exists, it returns an error.
+// If overwrite is false and the file already exists, it returns an error: the write is applied right away,
+// bypassing the write-ahead log, the write buffer and the async queue, with a condition checked by blob storage
+// so concurrent writes of the same file cannot both create it.
+// Writes that would take the bucket over its storage quota are rejected with a 507.
+// Successful writes are published as change events if change data capture is enabled, and replicated if replication is enabled.
+// If the write-ahead log is enabled, the write is acknowledged once logged and applied to blob storage in the background.
+// If the write buffer is enabled, the write is acknowledged once fsynced to local disk and uploaded in the background.
+// Otherwise async writes are acknowledged once queued, and applied by the async worker of the shard.
+// A sync write of an object with queued async writes is applied behind them by the worker, so they cannot overwrite it.
 // params:
+//   - ctx: The operation context, bounded by the blob operation timeout and the client deadline
 //   - msg: The NATS message which contains pure byte[] data to be written to blob storage
 //   - shardID: The shard ID for this operation
-//   - fileName: The file path where the data should be stored
-//   - bucketName: The bucket name where the data should be stored
-//   - overwrite: If false, returns an error if the file already exists
-func handleWriteOperation(msg *nats.Msg, shardID uint16, fileName string, bucketName string, overwrite bool) {
+//   - headers: The operation headers (fileName, bucketName and overwrite are used)
+func handleWriteOperation(ctx context.Context, msg *nats.Msg, shardID uint16, headers *ShardOperationHeaders) {
 	// todo: metrics for write latency and count
-	// Create context with timeout for blob operation using config value
-	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
-	defer cancel()
+	fileName, bucketName := headers.FileName, headers.BucketName
 
-	// Check if file exists when overwrite is false
-	if !overwrite {
-		exists, err := globalBlobClient.FileExists(ctx, bucketName, fileName)
-		if err != nil {
-			RespondWithNatsError(msg, ErrorCodeInternalServerError, fmt.Sprintf("failed to check if file exists: %v", err))
+	// Pending writes of the file are not in blob storage yet, blob storage checks the others when writing
+	if !headers.Overwrite && hasPending(bucketName, fileName) {
+		RespondWithNatsError(msg, ErrorCodeBadRequest, fmt.Sprintf("file already exists: %s", fileName))
+		return
+	}
+
+	// Reject writes that would take the bucket over its storage quota, overwrites only count their size change
+	usage, err := globalLimits.planWrite(ctx, bucketName, fileName, int64(len(msg.Data)))
+	if err != nil {
+		log.Error().Err(err).Str("fileName", fileName).Str("bucketName", bucketName).Uint16("shardID", shardID).Msg("Failed to look up the overwritten file for the bucket quota")
+		RespondWithNatsError(msg, blobErrorStatus(err), fmt.Sprintf("failed to check bucket quota: %v", err))
+		return
+	}
+	if err := globalLimits.checkQuota(bucketName, usage); err != nil {
+		RespondWithNatsError(msg, ErrorCodeInsufficientStorage, err.Error())
+		return
+	}
+
+	// With the write-ahead log, acknowledge once logged, the flusher applies the write and publishes the change
+	if globalWAL != nil && headers.Overwrite {
+		if err := globalWAL.append(ctx, shardID, bucketName, fileName, msg.Data, usage); err != nil {
+			log.Error().Err(err).Str("fileName", fileName).Str("bucketName", bucketName).Uint16("shardID", shardID).Msg("Failed to append write to the write-ahead log")
+			RespondWithNatsError(msg, walErrorStatus(err), fmt.Sprintf("failed to log write: %v", err))
 			return
 		}
-		if exists {
-			RespondWithNatsError(msg, ErrorCodeBadRequest, fmt.Sprintf("file already exists: %s", fileName))
+		RespondWithNatsSuccess(msg)
+		return
+	}
+
+	// With the write buffer, acknowledge once on local disk, the uploader applies the write and publishes the change
+	if globalWriteBuffer != nil && headers.Overwrite {
+		if err := globalWriteBuffer.append(shardID, bucketName, fileName, msg.Data, usage); err != nil {
+			if errors.Is(err, errWriteBufferFull) {
+				retryAfter := globalConfig.Db.OverloadRetryAfter
+				RespondWithNatsRetryableError(msg, ErrorCodeServiceUnavailable, fmt.Sprintf("%v, retry after %d ms", err, retryAfter.Milliseconds()), retryAfter)
+				return
+			}
+			log.Error().Err(err).Str("fileName", fileName).Str("bucketName", bucketName).Uint16("shardID", shardID).Msg("Failed to append write to the write buffer")
+			RespondWithNatsError(msg, ErrorCodeInternalServerError, fmt.Sprintf("failed to buffer write: %v", err))
 			return
 		}
+		RespondWithNatsSuccess(msg)
+		return
 	}
 
-	// Write data directly to blob without parsing (as per API spec)
-	_, err := globalBlobClient.WriteFile(ctx, bucketName, fileName, msg.Data)
+	// Async writes are acknowledged once queued
+	if headers.Async && headers.Overwrite {
+		if !globalAsyncWrites.enqueueWrite(shardID, bucketName, fileName, msg.Data, usage) {
+			respondAsyncQueueFull(msg)
+			return
+		}
+		RespondWithNatsSuccess(msg)
+		return
+	}
+
+	// Write data directly to blob without parsing (as per API spec),
+	// or behind the queued async writes of the object, which would overwrite it once applied
+	var versionID string
+	// recorded is true if the async worker records and publishes the write, also when ctx is done before it is applied
+	recorded := false
+	if !headers.Overwrite {
+		versionID, err = globalBlobClient.WriteFileWithOptions(ctx, bucketName, fileName, msg.Data, blob.WriteOptions{IfNotExists: true})
+		if errors.Is(err, blob.ErrPreconditionFailed) {
+			RespondWithNatsError(msg, ErrorCodeBadRequest, fmt.Sprintf("file already exists: %s", fileName))
+			return
+		}
+	} else if globalAsyncWrites.has(bucketName, fileName) {
+		_, err = globalAsyncWrites.writeBehind(ctx, shardID, bucketName, fileName, msg.Data, usage)
+		if errors.Is(err, errAsyncQueueFull) {
+			respondAsyncQueueFull(msg)
+			return
+		}
+		recorded = true
+	} else {
+		versionID, err = globalBlobClient.WriteFile(ctx, bucketName, fileName, msg.Data)
+	}
 	if err != nil {
 		log.Error().Err(err).Str("fileName", fileName).Str("bucketName", bucketName).Uint16("shardID", shardID).Msg("Failed to write file to blob storage")
-		RespondWithNatsError(msg, ErrorCodeInternalServerError, fmt.Sprintf("failed to write file: %v", err))
+		RespondWithNatsError(msg, blobErrorStatus(err), fmt.Sprintf("failed to write file: %v", err))
 		return
 	}
+	if !recorded {
+		globalLimits.recordWrite(bucketName, usage)
+
+		// Publish the change before responding, so readers notified by the client never miss it
+		writeApplied(shardID, bucketName, fileName, versionID, msg.Data)
+	}
 
 	// Respond with success
 	RespondWithNatsSuccess(msg)
@@ -150,28 +354,41 @@ func handleWriteOperation(msg *nats.Msg, shardID uint16, fileName string, bucket
 
 // handleReadOperation handles read requests for shard operations.
 // It reads the file data directly from blob storage and returns it as byte[].
+// Writes still pending in the write-ahead log, the write buffer or the async queue are returned instead.
 // The data is returned directly without parsing, as per API specification.
 // params:
+//   - ctx: The operation context, bounded by the blob operation timeout and the client deadline
 //   - msg: The NATS message to respond to
 //   - shardID: The shard ID for this operation
-//   - fileName: The file path to read from
-//   - bucketName: The bucket name where the file is stored
-func handleReadOperation(msg *nats.Msg, shardID uint16, fileName string, bucketName string) {
+//   - headers: The operation headers (fileName and bucketName are used)
+func handleReadOperation(ctx context.Context, msg *nats.Msg, shardID uint16, headers *ShardOperationHeaders) {
 	// todo: metrics for read latency and count
-	// Create context with timeout for blob operation using config value
-	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
-	defer cancel()
+	fileName, bucketName := headers.FileName, headers.BucketName
+
+	// Writes still in the write-ahead log, the write buffer or the async queue are newer than blob storage
+	data, pending, err := lookupPending(bucketName, fileName)
+	if err != nil {
+		log.Error().Err(err).Str("fileName", fileName).Str("bucketName", bucketName).Uint16("shardID", shardID).Msg("Failed to read pending write")
+		RespondWithNatsError(msg, ErrorCodeInternalServerError, fmt.Sprintf("failed to read pending write: %v", err))
+		return
+	}
+	if pending {
+		globalLimits.charge(shardID, headers, int64(len(data)))
+		RespondWithNatsData(msg, data)
+		return
+	}
 
 	// Read data directly from blob without parsing (as per API spec)
-	data, err := globalBlobClient.ReadFile(ctx, bucketName, fileName, "")
+	data, err = globalBlobClient.ReadFile(ctx, bucketName, fileName, "")
 	if err != nil {
 		log.Error().Err(err).Str("fileName", fileName).Str("bucketName", bucketName).Uint16("shardID", shardID).Msg("Failed to read file from blob storage")
-		RespondWithNatsError(msg, ErrorCodeInternalServerError, fmt.Sprintf("failed to read file: %v", err))
+		RespondWithNatsError(msg, blobErrorStatus(err), fmt.Sprintf("failed to read file: %v", err))
 		return
 	}
 
+	// The read size is only known now, charge it against the byte rate limits
+	globalLimits.charge(shardID, headers, int64(len(data)))
+
 	// Respond with raw byte[] data directly (as per API spec: shard owner never parses data)
-	if err := msg.Respond(data); err != nil {
-		log.Error().Err(err).Str("fileName", fileName).Str("bucketName", bucketName).Uint16("shardID", shardID).Msg("Failed to send NATS response in handleReadOperation")
-	}
+	RespondWithNatsData(msg, data)
 }
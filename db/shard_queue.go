@@ -0,0 +1,245 @@
+package db
+
+import (
+	"NimbusDb/metrics"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// shedReasonQueueFull is the shed reason used when the shard channel has no free slot.
+	shedReasonQueueFull = "queue_full"
+	// shedReasonQueueWait is the shed reason used when queued requests wait longer than Db.MaxQueueWait.
+	shedReasonQueueWait = "queue_wait"
+)
+
+const (
+	// laneInteractive is the lane for latency sensitive requests (point reads by default).
+	laneInteractive = "interactive"
+	// laneBulk is the lane for throughput oriented requests (writes by default).
+	laneBulk = "bulk"
+)
+
+const (
+	// PriorityHigh routes a request to the interactive lane regardless of its operation type.
+	PriorityHigh = "high"
+	// PriorityLow routes a request to the bulk lane regardless of its operation type.
+	PriorityLow = "low"
+)
+
+// shardRequest is a shard operation message queued for processing along with the time it was received.
+type shardRequest struct {
+	msg        *nats.Msg
+	receivedAt time.Time
+	lane       *shardLane
+}
+
+// shardLane is one of the bounded channels of a shard queue.
+type shardLane struct {
+	name string
+	ch   chan *shardRequest
+	// lastWait is the time (in ns) the most recently dequeued request of this lane spent in the queue.
+	lastWait atomic.Int64
+	// shedFull and shedWait count the requests of this lane rejected per shed reason.
+	shedFull *metrics.Counter
+	shedWait *metrics.Counter
+}
+
+// shardQueue sits between a shard subscription and its handler goroutine.
+// Requests are split into an interactive lane and a bulk lane, so that a backlog of
+// bulk writes cannot starve point reads. The handler drains the lanes with weighted scheduling.
+// Instead of blocking (and letting NATS drop messages as a slow consumer), it rejects requests
+// with an explicit overload response when a lane is full or its requests wait too long.
+type shardQueue struct {
+	shardID     uint16
+	interactive *shardLane
+	bulk        *shardLane
+	// weight is the number of interactive requests served for every bulk request when both lanes have work.
+	weight int
+	// maxWait is the maximum queue wait tolerated before shedding, 0 disables the check.
+	maxWait time.Duration
+	// retryAfter is the retry hint sent with overload responses.
+	retryAfter time.Duration
+	// shedLog is a sampled logger so a burst of rejections does not flood the logs.
+	shedLog zerolog.Logger
+
+	// The fields below are only used by the handler goroutine.
+	// interactiveRecv and bulkRecv are set to nil once the lane is closed and drained.
+	interactiveRecv chan *shardRequest
+	bulkRecv        chan *shardRequest
+	// streak is the number of interactive requests served since the last bulk request.
+	streak int
+}
+
+// newShardQueue creates the queue for a shard and registers its metrics.
+//
+// params:
+//   - shardID: The shard ID the queue belongs to
+//   - size: The channel buffer size of each lane
+//   - weight: The number of interactive requests served for every bulk request when both lanes have work
+//   - maxWait: The maximum queue wait tolerated before shedding, 0 disables the check
+//   - retryAfter: The retry hint sent with overload responses
+//
+// return:
+//   - *shardQueue: The new queue
+func newShardQueue(shardID uint16, size int, weight int, maxWait time.Duration, retryAfter time.Duration) *shardQueue {
+	q := &shardQueue{
+		shardID:     shardID,
+		interactive: newShardLane(shardID, laneInteractive, size),
+		bulk:        newShardLane(shardID, laneBulk, size),
+		weight:      weight,
+		maxWait:     maxWait,
+		retryAfter:  retryAfter,
+		shedLog:     log.Sample(&zerolog.BurstSampler{Burst: 1, Period: time.Second}),
+	}
+	q.interactiveRecv = q.interactive.ch
+	q.bulkRecv = q.bulk.ch
+	return q
+}
+
+// newShardLane creates a lane of a shard queue and registers its metrics.
+func newShardLane(shardID uint16, name string, size int) *shardLane {
+	shard := strconv.Itoa(int(shardID))
+	lane := &shardLane{
+		name:     name,
+		ch:       make(chan *shardRequest, size),
+		shedFull: metrics.GetCounter("nimbus_shard_requests_shed_total", metrics.Labels{"shard": shard, "lane": name, "reason": shedReasonQueueFull}),
+		shedWait: metrics.GetCounter("nimbus_shard_requests_shed_total", metrics.Labels{"shard": shard, "lane": name, "reason": shedReasonQueueWait}),
+	}
+	metrics.RegisterGaugeFunc("nimbus_shard_queue_depth", metrics.Labels{"shard": shard, "lane": name}, func() float64 {
+		return float64(len(lane.ch))
+	})
+	return lane
+}
+
+// enqueue queues a request received from NATS on its lane, or rejects it with a 503 if the lane is overloaded.
+// It never blocks, so it is safe to call from the NATS subscription callback.
+//
+// params:
+//   - msg: The shard operation message
+func (q *shardQueue) enqueue(msg *nats.Msg) {
+	lane := q.laneFor(msg)
+	req := &shardRequest{msg: msg, receivedAt: time.Now(), lane: lane}
+
+	if q.maxWait > 0 && len(lane.ch) > 0 && time.Duration(lane.lastWait.Load()) > q.maxWait {
+		q.shed(msg, lane, shedReasonQueueWait, lane.shedWait)
+		return
+	}
+
+	select {
+	case lane.ch <- req:
+	default:
+		q.shed(msg, lane, shedReasonQueueFull, lane.shedFull)
+	}
+}
+
+// laneFor picks the lane of a request.
+// The 'priority' header wins if present, otherwise reads go to the interactive lane and everything else to the bulk lane.
+// Headers are not validated here, invalid requests are rejected by the handler goroutine.
+func (q *shardQueue) laneFor(msg *nats.Msg) *shardLane {
+	switch strings.ToLower(msg.Header.Get("priority")) {
+	case PriorityHigh:
+		return q.interactive
+	case PriorityLow:
+		return q.bulk
+	}
+
+	switch msg.Header.Get("type") {
+	case strconv.Itoa(PointRead), strconv.Itoa(CollectionRead):
+		return q.interactive
+	default:
+		return q.bulk
+	}
+}
+
+// next returns the next request to process, blocking until one is available.
+// When both lanes have work, up to weight interactive requests are served for every bulk request.
+// Must only be called from the shard handler goroutine.
+//
+// return:
+//   - *shardRequest: The next request
+//   - bool: False once both lanes are closed and drained
+func (q *shardQueue) next() (*shardRequest, bool) {
+	for q.interactiveRecv != nil || q.bulkRecv != nil {
+		if q.streak < q.weight {
+			if req, ok := tryReceive(&q.interactiveRecv); ok {
+				return q.served(req), true
+			}
+		}
+		if req, ok := tryReceive(&q.bulkRecv); ok {
+			return q.served(req), true
+		}
+		if req, ok := tryReceive(&q.interactiveRecv); ok {
+			return q.served(req), true
+		}
+
+		if q.interactiveRecv == nil && q.bulkRecv == nil {
+			break
+		}
+
+		// Both lanes are empty, wait for whichever gets work first
+		select {
+		case req, ok := <-q.interactiveRecv:
+			if ok {
+				return q.served(req), true
+			}
+			q.interactiveRecv = nil
+		case req, ok := <-q.bulkRecv:
+			if ok {
+				return q.served(req), true
+			}
+			q.bulkRecv = nil
+		}
+	}
+	return nil, false
+}
+
+// served updates the scheduling state and lane wait time for a request taken off the queue.
+func (q *shardQueue) served(req *shardRequest) *shardRequest {
+	if req.lane == q.interactive {
+		q.streak++
+	} else {
+		q.streak = 0
+	}
+	req.lane.lastWait.Store(int64(time.Since(req.receivedAt)))
+	return req
+}
+
+// tryReceive receives from the channel without blocking.
+// If the channel is closed and drained, it is set to nil.
+func tryReceive(ch *chan *shardRequest) (*shardRequest, bool) {
+	if *ch == nil {
+		return nil, false
+	}
+	select {
+	case req, ok := <-*ch:
+		if !ok {
+			*ch = nil
+			return nil, false
+		}
+		return req, true
+	default:
+		return nil, false
+	}
+}
+
+// close closes all lanes. The handler goroutine drains the remaining requests and exits.
+// Must only be called once no more requests can be enqueued.
+func (q *shardQueue) close() {
+	close(q.interactive.ch)
+	close(q.bulk.ch)
+}
+
+// shed rejects a request with an overload response.
+func (q *shardQueue) shed(msg *nats.Msg, lane *shardLane, reason string, counter *metrics.Counter) {
+	counter.Inc()
+	q.shedLog.Warn().Uint16("shardID", q.shardID).Str("lane", lane.name).Str("reason", reason).Int("queueDepth", len(lane.ch)).Msg("Shard overloaded, shedding requests")
+	RespondWithNatsRetryableError(msg, ErrorCodeServiceUnavailable, fmt.Sprintf("shard overloaded, retry after %d ms", q.retryAfter.Milliseconds()), q.retryAfter)
+}
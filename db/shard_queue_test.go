@@ -0,0 +1,140 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// newQueuedMsg creates a shard operation message with the given operation type and priority.
+func newQueuedMsg(opType int, priority string) *nats.Msg {
+	msg := nats.NewMsg("nimbus.shards.0.op")
+	msg.Header.Set("type", strconv.Itoa(opType))
+	if priority != "" {
+		msg.Header.Set("priority", priority)
+	}
+	return msg
+}
+
+func TestShardQueue_ShedsWhenFull(t *testing.T) {
+	q := newShardQueue(1000, 1, 4, 0, 50*time.Millisecond)
+
+	q.enqueue(newQueuedMsg(PointWrite, ""))
+	q.enqueue(newQueuedMsg(PointWrite, ""))
+
+	if len(q.bulk.ch) != 1 {
+		t.Errorf("Expected 1 queued request, got %d", len(q.bulk.ch))
+	}
+	if q.bulk.shedFull.Value() != 1 {
+		t.Errorf("Expected 1 request shed as queue full, got %d", q.bulk.shedFull.Value())
+	}
+
+	// A full bulk lane does not affect the interactive lane
+	q.enqueue(newQueuedMsg(PointRead, ""))
+	if len(q.interactive.ch) != 1 {
+		t.Errorf("Expected read to be queued on the interactive lane, got %d queued", len(q.interactive.ch))
+	}
+}
+
+func TestShardQueue_ShedsOnQueueWait(t *testing.T) {
+	q := newShardQueue(1001, 10, 4, 10*time.Millisecond, 50*time.Millisecond)
+
+	// First request is accepted, the recorded wait is still zero
+	q.enqueue(newQueuedMsg(PointWrite, ""))
+
+	// Simulate the handler serving a request that waited too long while the lane is still backed up
+	q.served(&shardRequest{receivedAt: time.Now().Add(-time.Second), lane: q.bulk})
+	q.enqueue(newQueuedMsg(PointWrite, ""))
+
+	if len(q.bulk.ch) != 1 {
+		t.Errorf("Expected 1 queued request, got %d", len(q.bulk.ch))
+	}
+	if q.bulk.shedWait.Value() != 1 {
+		t.Errorf("Expected 1 request shed on queue wait, got %d", q.bulk.shedWait.Value())
+	}
+
+	// Once the lane is drained, requests are accepted again
+	<-q.bulk.ch
+	q.enqueue(newQueuedMsg(PointWrite, ""))
+	if len(q.bulk.ch) != 1 {
+		t.Errorf("Expected request to be accepted once lane drained, got %d queued", len(q.bulk.ch))
+	}
+}
+
+func TestShardQueue_LaneSelection(t *testing.T) {
+	q := newShardQueue(1002, 10, 4, 0, 50*time.Millisecond)
+
+	tests := []struct {
+		name     string
+		msg      *nats.Msg
+		expected *shardLane
+	}{
+		{"point read", newQueuedMsg(PointRead, ""), q.interactive},
+		{"collection read", newQueuedMsg(CollectionRead, ""), q.interactive},
+		{"point write", newQueuedMsg(PointWrite, ""), q.bulk},
+		{"low priority read", newQueuedMsg(PointRead, PriorityLow), q.bulk},
+		{"high priority write", newQueuedMsg(PointWrite, "HIGH"), q.interactive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := q.laneFor(tt.msg); got != tt.expected {
+				t.Errorf("Expected lane %s, got %s", tt.expected.name, got.name)
+			}
+		})
+	}
+}
+
+func TestShardQueue_WeightedScheduling(t *testing.T) {
+	q := newShardQueue(1003, 10, 2, 0, 50*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		q.enqueue(newQueuedMsg(PointRead, ""))
+	}
+	for i := 0; i < 2; i++ {
+		q.enqueue(newQueuedMsg(PointWrite, ""))
+	}
+	q.close()
+
+	var order string
+	for {
+		req, ok := q.next()
+		if !ok {
+			break
+		}
+		order += req.lane.name[:1]
+	}
+
+	// 2 interactive requests per bulk request while both lanes have work, then the remaining interactive ones
+	if order != "iibiibi" {
+		t.Errorf("Expected serving order 'iibiibi', got '%s'", order)
+	}
+}
+
+func TestShardQueue_NextBlocksUntilWork(t *testing.T) {
+	q := newShardQueue(1004, 10, 4, 0, 50*time.Millisecond)
+
+	done := make(chan *shardRequest)
+	go func() {
+		req, _ := q.next()
+		done <- req
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("next() should block while both lanes are empty")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.enqueue(newQueuedMsg(PointWrite, ""))
+	select {
+	case req := <-done:
+		if req == nil || req.lane != q.bulk {
+			t.Error("Expected next() to return the bulk request")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("next() did not return after a request was enqueued")
+	}
+}
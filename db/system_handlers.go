@@ -2,14 +2,13 @@ package db
 
 import (
 	"NimbusDb/configurations"
-	"encoding/json"
 
 	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog/log"
 )
 
 // StartSystemHandlers initializes and starts all NATS system handlers.
-// It subscribes to system subjects using the globally configured connection.
+// It subscribes to system subjects (configuration, admin and watch) using the globally configured connection.
 // Panics if InitializeGlobals has not been called first.
 //
 // return:
@@ -32,21 +31,19 @@ func StartSystemHandlers() []*nats.Subscription {
 	}
 	subscriptions = append(subscriptions, sub)
 
+	// Subscribe to bucket management requests
+	subscriptions = append(subscriptions, startAdminHandlers()...)
+
+	// Subscribe to watch requests
+	subscriptions = append(subscriptions, startWatchHandlers()...)
+
 	return subscriptions
 }
 
 // getShardCount handles requests for the current shard count.
 // It responds with the shard count from the global configuration.
 func getShardCount(msg *nats.Msg) {
-	resp := ShardsResponse{
+	respondWithJSON(msg, ShardsResponse{
 		ShardCount: globalConfig.ShardCount,
-	}
-	b, err := json.Marshal(resp)
-	if err != nil {
-		RespondWithNatsError(msg, ErrorCodeInternalServerError, err.Error())
-		return
-	}
-	if err := msg.Respond(b); err != nil {
-		log.Error().Err(err).Msg("Failed to respond to getShardCount")
-	}
+	})
 }
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// TenantHeader is the request header naming the tenant a request belongs to.
+	TenantHeader = "tenant"
+	// natsRequestInfoHeader is set by the NATS server on requests crossing accounts through a shared service import.
+	// It carries the identity of the requesting client. On other requests it is whatever the client sent.
+	natsRequestInfoHeader = "Nats-Request-Info"
+)
+
+// ErrForbidden is returned when a request is not allowed to access the bucket it targets.
+var ErrForbidden = errors.New("forbidden")
+
+var (
+	// globalTenants holds the tenant namespaces. Nil if tenancy is disabled.
+	// It is set once during initialization and never modified.
+	globalTenants *tenantRegistry
+)
+
+// tenant is the namespace of buckets a tenant may access.
+type tenant struct {
+	name         string
+	buckets      map[string]bool
+	bucketPrefix string
+	// userBound is true if NATS users are mapped to the tenant, it is then never selected by the 'tenant' header
+	// when the Nats-Request-Info header is trusted.
+	userBound bool
+	// watchSubjectPrefix is the subject prefix the watches of the tenant may deliver to, besides the requester's inbox.
+	watchSubjectPrefix string
+}
+
+// allows reports whether the bucket belongs to the tenant namespace.
+func (t *tenant) allows(bucketName string) bool {
+	if t.buckets[bucketName] {
+		return true
+	}
+	return t.bucketPrefix != "" && strings.HasPrefix(bucketName, t.bucketPrefix)
+}
+
+// tenantRegistry resolves the tenant of a request and checks the bucket it targets.
+// This type is read-only after creation and thread-safe.
+type tenantRegistry struct {
+	byName     map[string]*tenant
+	byNatsUser map[string]*tenant
+	// trustRequestInfo maps requests to tenants by the Nats-Request-Info header, see NATSConfig.TrustRequestInfo.
+	trustRequestInfo bool
+}
+
+// natsRequestInfo is the subset of the Nats-Request-Info header used to identify the requester.
+type natsRequestInfo struct {
+	User string `json:"user"`
+}
+
+// newTenantRegistry builds the tenant registry from configuration.
+// Assumes the configuration has been validated.
+//
+// params:
+//   - tenants: The configured tenants
+//   - trustRequestInfo: Whether the NATS user of the Nats-Request-Info header identifies the tenant
+//
+// return:
+//   - *tenantRegistry: The registry, or nil if no tenants are configured (tenancy disabled)
+func newTenantRegistry(tenants []configurations.TenantConfig, trustRequestInfo bool) *tenantRegistry {
+	if len(tenants) == 0 {
+		return nil
+	}
+
+	r := &tenantRegistry{
+		byName:           make(map[string]*tenant, len(tenants)),
+		byNatsUser:       make(map[string]*tenant),
+		trustRequestInfo: trustRequestInfo,
+	}
+	for _, cfg := range tenants {
+		t := &tenant{
+			name:               cfg.Name,
+			buckets:            make(map[string]bool, len(cfg.Buckets)),
+			bucketPrefix:       cfg.BucketPrefix,
+			userBound:          len(cfg.NatsUsers) > 0,
+			watchSubjectPrefix: cfg.WatchSubjectPrefix,
+		}
+		for _, b := range cfg.Buckets {
+			t.buckets[b] = true
+		}
+		r.byName[cfg.Name] = t
+		for _, user := range cfg.NatsUsers {
+			r.byNatsUser[user] = t
+		}
+	}
+	return r
+}
+
+// authorize resolves the tenant of a request and checks that the bucket belongs to its namespace.
+// The tenant is derived from the NATS user when the Nats-Request-Info header is trusted (nats.trustRequestInfo),
+// otherwise it is taken from the 'tenant' header. Trusted NATS users not mapped to a tenant are rejected.
+// A nil registry (tenancy disabled) allows every request.
+//
+// params:
+//   - msg: The request message
+//   - bucketName: The bucket the request targets
+//
+// return:
+//   - string: The name of the tenant, empty if tenancy is disabled
+//   - error: An error wrapping ErrForbidden if the tenant is missing, unknown or not allowed to access the bucket
+func (r *tenantRegistry) authorize(msg *nats.Msg, bucketName string) (string, error) {
+	if r == nil {
+		return "", nil
+	}
+
+	t, err := r.resolve(msg)
+	if err != nil {
+		return "", err
+	}
+	if !t.allows(bucketName) {
+		return "", fmt.Errorf("%w: bucket %s is outside the namespace of tenant %s", ErrForbidden, bucketName, t.name)
+	}
+	return t.name, nil
+}
+
+// resolve returns the tenant of a request.
+func (r *tenantRegistry) resolve(msg *nats.Msg) (*tenant, error) {
+	headerTenant := msg.Header.Get(TenantHeader)
+
+	// Behind a service import, the NATS user is set by the server and wins over the header.
+	// Otherwise the Nats-Request-Info header can be forged by any client, so it is ignored.
+	if user := r.natsUser(msg); user != "" {
+		t, ok := r.byNatsUser[user]
+		if !ok {
+			// The header could name any tenant, including one the user must not reach
+			return nil, fmt.Errorf("%w: NATS user %s is not mapped to a tenant", ErrForbidden, user)
+		}
+		if headerTenant != "" && headerTenant != t.name {
+			return nil, fmt.Errorf("%w: 'tenant' header %s does not match the tenant of NATS user %s", ErrForbidden, headerTenant, user)
+		}
+		return t, nil
+	}
+
+	if headerTenant == "" {
+		return nil, fmt.Errorf("%w: missing 'tenant' header", ErrForbidden)
+	}
+	t, ok := r.byName[headerTenant]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown tenant %s", ErrForbidden, headerTenant)
+	}
+	// Only its NATS users reach a tenant they are mapped to
+	if r.trustRequestInfo && t.userBound {
+		return nil, fmt.Errorf("%w: tenant %s is only reachable by its NATS users", ErrForbidden, headerTenant)
+	}
+	return t, nil
+}
+
+// watchSubjectPrefix returns the subject prefix the watches of a tenant may deliver to,
+// empty if tenancy is disabled or the tenant has none.
+func (r *tenantRegistry) watchSubjectPrefix(name string) string {
+	if r == nil {
+		return ""
+	}
+	if t, ok := r.byName[name]; ok {
+		return t.watchSubjectPrefix
+	}
+	return ""
+}
+
+// natsUser returns the requesting NATS user if the Nats-Request-Info header is trusted, or empty.
+func (r *tenantRegistry) natsUser(msg *nats.Msg) string {
+	if !r.trustRequestInfo {
+		return ""
+	}
+	return natsUser(msg)
+}
+
+// natsUser returns the requesting NATS user from the Nats-Request-Info header, or empty if not present.
+// Callers must only use it when the header is trusted, see NATSConfig.TrustRequestInfo.
+func natsUser(msg *nats.Msg) string {
+	raw := msg.Header.Get(natsRequestInfoHeader)
+	if raw == "" {
+		return ""
+	}
+	var info natsRequestInfo
+	if err := json.Unmarshal([]byte(raw), &info); err != nil {
+		return ""
+	}
+	return info.User
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"errors"
+	"testing"
+)
+
+// newTestTenantRegistry creates a registry with a bucket list tenant and a bucket prefix tenant.
+// The Nats-Request-Info header is trusted, as behind a service import.
+func newTestTenantRegistry() *tenantRegistry {
+	return newTenantRegistry([]configurations.TenantConfig{
+		{Name: "acme", Buckets: []string{"acme-orders", "shared-reports"}, NatsUsers: []string{"acme-svc"}},
+		{Name: "globex", BucketPrefix: "globex-"},
+	}, true)
+}
+
+func TestNewTenantRegistry_Disabled(t *testing.T) {
+	r := newTenantRegistry(nil, false)
+	if r != nil {
+		t.Fatal("Expected nil registry when no tenants are configured")
+	}
+
+	tenant, err := r.authorize(newShardOperationMsg(nil), "any-bucket")
+	if err != nil || tenant != "" {
+		t.Errorf("Expected every request to be allowed when tenancy is disabled, got tenant %q and error %v", tenant, err)
+	}
+}
+
+func TestTenantRegistry_Authorize(t *testing.T) {
+	r := newTestTenantRegistry()
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		bucketName     string
+		expectedTenant string
+		expectForbid   bool
+	}{
+		{"listed bucket", map[string]string{natsRequestInfoHeader: `{"user":"acme-svc"}`, TenantHeader: "acme"}, "acme-orders", "acme", false},
+		{"prefixed bucket", map[string]string{TenantHeader: "globex"}, "globex-users", "globex", false},
+		{"bucket of another tenant", map[string]string{TenantHeader: "globex"}, "acme-orders", "", true},
+		{"bucket outside prefix", map[string]string{TenantHeader: "globex"}, "globe-users", "", true},
+		{"missing tenant", nil, "acme-orders", "", true},
+		{"unknown tenant", map[string]string{TenantHeader: "initech"}, "acme-orders", "", true},
+		{"tenant from NATS user", map[string]string{natsRequestInfoHeader: `{"acc":"A","user":"acme-svc"}`}, "shared-reports", "acme", false},
+		{"NATS user wins over header", map[string]string{natsRequestInfoHeader: `{"user":"acme-svc"}`, TenantHeader: "globex"}, "globex-users", "", true},
+		{"unmapped NATS user", map[string]string{natsRequestInfoHeader: `{"user":"other"}`, TenantHeader: "globex"}, "globex-users", "", true},
+		{"unmapped NATS user without header", map[string]string{natsRequestInfoHeader: `{"user":"other"}`}, "globex-users", "", true},
+		{"header naming a tenant with NATS users", map[string]string{TenantHeader: "acme"}, "acme-orders", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant, err := r.authorize(newShardOperationMsg(tt.headers), tt.bucketName)
+			if tt.expectForbid {
+				if !errors.Is(err, ErrForbidden) {
+					t.Errorf("Expected ErrForbidden, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected request to be allowed, got %v", err)
+			}
+			if tenant != tt.expectedTenant {
+				t.Errorf("Expected tenant %s, got %s", tt.expectedTenant, tenant)
+			}
+		})
+	}
+}
+
+func TestTenantRegistry_UntrustedRequestInfo(t *testing.T) {
+	r := newTestTenantRegistry()
+	r.trustRequestInfo = false
+
+	// Without a service import, the header is whatever the client sent
+	forged := newShardOperationMsg(map[string]string{natsRequestInfoHeader: `{"user":"acme-svc"}`})
+	if _, err := r.authorize(forged, "acme-orders"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a forged Nats-Request-Info header, got %v", err)
+	}
+	forged.Header.Set(TenantHeader, "globex")
+	if _, err := r.authorize(forged, "acme-orders"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected the tenant header to apply, got %v", err)
+	}
+	tenant, err := r.authorize(forged, "globex-users")
+	if err != nil || tenant != "globex" {
+		t.Errorf("Expected tenant globex, got %q (%v)", tenant, err)
+	}
+}
+
+func TestExtractShardOperationHeaders_Tenant(t *testing.T) {
+	globalTenants = newTestTenantRegistry()
+	defer func() { globalTenants = nil }()
+
+	msg := newShardOperationMsg(map[string]string{
+		"type":       "1",
+		"fileName":   "/a/b",
+		"bucketName": "acme-orders",
+		TenantHeader: "globex",
+	})
+	if _, err := ExtractShardOperationHeaders(msg); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+
+	msg.Header.Set(TenantHeader, "acme")
+	if _, err := ExtractShardOperationHeaders(msg); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden without the NATS user of acme, got %v", err)
+	}
+
+	msg.Header.Set(natsRequestInfoHeader, `{"user":"acme-svc"}`)
+	headers, err := ExtractShardOperationHeaders(msg)
+	if err != nil {
+		t.Fatalf("ExtractShardOperationHeaders() failed: %v", err)
+	}
+	if headers.Tenant != "acme" {
+		t.Errorf("Expected tenant acme, got %s", headers.Tenant)
+	}
+}
@@ -1,6 +1,87 @@
 package db
 
+import (
+	"NimbusDb/blob"
+	"time"
+)
+
 // ShardsResponse represents the response for shard count queries.
 type ShardsResponse struct {
 	ShardCount uint16 `json:"shardCount"`
 }
+
+// BucketListResponse represents the response for bucket list requests.
+type BucketListResponse struct {
+	Buckets []blob.BucketInfo `json:"buckets"`
+}
+
+// LegalHoldResponse represents the response for legal hold apply and remove requests.
+type LegalHoldResponse struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// VersionID is the held version, empty for the current version.
+	VersionID string `json:"versionId,omitempty"`
+	LegalHold bool   `json:"legalHold"`
+}
+
+// ChangeEvent is a change data capture event, published for every successful change to an object.
+type ChangeEvent struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// VersionID is the version created by the change. Empty if the bucket is not versioned.
+	VersionID string `json:"versionId,omitempty"`
+	// Size is the size of the new object version in bytes. 0 for deletes.
+	Size int64 `json:"size"`
+	// Operation is ChangeOperationWrite or ChangeOperationDelete.
+	Operation string    `json:"operation"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WatchResponse represents the response for watch create requests.
+type WatchResponse struct {
+	WatchID string `json:"watchId"`
+	// CancelSubject is the subject to send a request to in order to cancel the watch.
+	CancelSubject string `json:"cancelSubject"`
+	// RenewSubject is the subject to send a request to in order to renew the lease of the watch.
+	RenewSubject string `json:"renewSubject"`
+	// LeaseMs is how long the watch lives without being renewed, in milliseconds.
+	LeaseMs int64 `json:"leaseMs"`
+}
+
+// WatchNotification is delivered to the deliver subject of a watch for every matching change.
+type WatchNotification struct {
+	ChangeEvent
+	// Sequence is the stream sequence of the change event, used to resume a watch. 0 without JetStream.
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Payload is the content of the new object version, only set if the watch includes payloads.
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// ScrubReport is the result of an integrity scrub of the buckets owned by a node.
+type ScrubReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Completed is false if the scrub was interrupted by shutdown, or could not list the buckets.
+	Completed bool  `json:"completed"`
+	Buckets   int   `json:"buckets"`
+	Objects   int64 `json:"objects"`
+	Bytes     int64 `json:"bytes"`
+	// Verified is the number of objects whose stored checksum was verified, the others were only checked for size.
+	Verified int64 `json:"verified"`
+	// Corrupt are the objects whose content does not match their size or stored checksum.
+	Corrupt []ScrubFinding `json:"corrupt"`
+	// Unreadable are the objects (or buckets) that could not be listed or read.
+	Unreadable []ScrubFinding `json:"unreadable"`
+	// DriftedBuckets are the buckets whose versioning or lifecycle settings differ from the expected ones.
+	DriftedBuckets []blob.BucketSettingsDrift `json:"driftedBuckets"`
+	// Truncated is true if more findings were detected than the report keeps, the metrics count them all.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ScrubFinding is an object (or a bucket, when Key is empty) that failed an integrity check.
+type ScrubFinding struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	Error     string `json:"error"`
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// versionCleanupStore is the part of the blob client used by the version cleanup.
+type versionCleanupStore interface {
+	ListBuckets(ctx context.Context) ([]blob.BucketInfo, error)
+	blob.VersionCleaner
+}
+
+// StartVersionCleanup applies the version cleanup rules to the buckets owned by this node in the background, the first
+// time right away, if blob.azure.clientVersionCleanup asks the blob storage to apply them itself (see blob.VersionCleaner).
+// Otherwise they are left to the lifecycle rules of the provider. Must be called after the shard state is initialized.
+//
+// params:
+//   - ctx: The context that stops the cleanup when cancelled
+func StartVersionCleanup(ctx context.Context) {
+	if !globalConfig.Blob.Azure.ClientVersionCleanup {
+		return
+	}
+	store, ok := globalBlobClient.(versionCleanupStore)
+	if !ok {
+		return
+	}
+	interval := globalConfig.Blob.Azure.VersionCleanupInterval
+	go runVersionCleanup(ctx, store, interval, globalConfig.Blob.BlobOperationTimeout, globalConfig.ShardCount, func() []uint16 {
+		state := GetGlobalState()
+		if state == nil {
+			return nil
+		}
+		return state.GetShardIDs()
+	})
+	log.Info().Dur("interval", interval).Msg("Blob version cleanup enabled")
+}
+
+// runVersionCleanup cleans the versions of the owned buckets right away, then every interval, until ctx is cancelled.
+func runVersionCleanup(ctx context.Context, store versionCleanupStore, interval, opTimeout time.Duration, shardCount uint16, ownedShards func() []uint16) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cleanOwnedVersions(ctx, store, opTimeout, shardCount, ownedShards())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanOwnedVersions applies the version cleanup rules to the buckets of the owned shards. Failures are logged,
+// the other buckets are still cleaned.
+//
+// params:
+//   - ctx: The context bounding the cleanup
+//   - store: The blob storage to clean
+//   - opTimeout: The timeout of listing the buckets
+//   - shardCount: The number of shards buckets are spread over
+//   - ownedShards: The shards owned by this node
+//
+// return:
+//   - int: The number of buckets cleaned
+func cleanOwnedVersions(ctx context.Context, store versionCleanupStore, opTimeout time.Duration, shardCount uint16, ownedShards []uint16) int {
+	listCtx, cancel := context.WithTimeout(ctx, opTimeout)
+	buckets, err := store.ListBuckets(listCtx)
+	cancel()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list buckets to clean versions of")
+		return 0
+	}
+
+	owned := make(map[uint16]bool)
+	for _, shardID := range ownedShards {
+		owned[shardID] = true
+	}
+	cleaned := 0
+	for _, bucket := range buckets {
+		if ctx.Err() != nil {
+			break
+		}
+		if !owned[bucketShard(bucket.Name, shardCount)] {
+			continue
+		}
+		if err := store.CleanVersions(ctx, bucket.Name, time.Now()); err != nil {
+			log.Error().Err(err).Str("bucket", bucket.Name).Msg("Failed to clean blob versions")
+			continue
+		}
+		cleaned++
+	}
+	log.Debug().Int("buckets", cleaned).Msg("Blob version cleanup completed")
+	return cleaned
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// versionCleanupTestStore is an in-memory version cleanup store, recording the buckets cleaned.
+type versionCleanupTestStore struct {
+	*memoryStore
+	listErr error
+	// failing are the buckets CleanVersions fails to clean.
+	failing map[string]bool
+	// cleanedMu guards cleaned, CleanVersions may run in the cleanup goroutine.
+	cleanedMu sync.Mutex
+	cleaned   []string
+}
+
+func newVersionCleanupTestStore(buckets ...string) *versionCleanupTestStore {
+	return &versionCleanupTestStore{memoryStore: newMemoryStore(buckets...), failing: make(map[string]bool)}
+}
+
+func (s *versionCleanupTestStore) ListBuckets(ctx context.Context) ([]blob.BucketInfo, error) {
+	if s.listErr != nil {
+		return nil, s.listErr
+	}
+	return s.memoryStore.ListBuckets(ctx)
+}
+
+func (s *versionCleanupTestStore) CleanVersions(ctx context.Context, bucketName string, now time.Time) error {
+	if s.failing[bucketName] {
+		return fmt.Errorf("failed to clean versions of bucket %s", bucketName)
+	}
+	s.cleanedMu.Lock()
+	defer s.cleanedMu.Unlock()
+	s.cleaned = append(s.cleaned, bucketName)
+	return nil
+}
+
+func (s *versionCleanupTestStore) cleanedBuckets() []string {
+	s.cleanedMu.Lock()
+	defer s.cleanedMu.Unlock()
+	cleaned := append([]string(nil), s.cleaned...)
+	sort.Strings(cleaned)
+	return cleaned
+}
+
+func TestCleanOwnedVersions_OwnedBucketsOnly(t *testing.T) {
+	const shardCount = 8
+	store := newVersionCleanupTestStore("orders")
+	owned := bucketShard("orders", shardCount)
+	// Find a bucket owned by another shard
+	other := ""
+	for i := 0; other == ""; i++ {
+		if name := fmt.Sprintf("bucket-%d", i); bucketShard(name, shardCount) != owned {
+			other = name
+		}
+	}
+	store.buckets[other] = map[string][]byte{}
+
+	cleaned := cleanOwnedVersions(context.Background(), store, time.Second, shardCount, []uint16{owned})
+	if cleaned != 1 {
+		t.Errorf("Expected 1 bucket cleaned, got %d", cleaned)
+	}
+	if got := store.cleanedBuckets(); fmt.Sprint(got) != "[orders]" {
+		t.Errorf("Expected only the owned bucket to be cleaned, got %v", got)
+	}
+
+	if cleaned := cleanOwnedVersions(context.Background(), store, time.Second, shardCount, nil); cleaned != 0 {
+		t.Errorf("Expected no bucket cleaned without owned shards, got %d", cleaned)
+	}
+}
+
+func TestCleanOwnedVersions_ContinuesAfterFailure(t *testing.T) {
+	store := newVersionCleanupTestStore("orders", "users")
+	store.failing["orders"] = true
+
+	cleaned := cleanOwnedVersions(context.Background(), store, time.Second, 1, allShards(1)())
+	if cleaned != 1 {
+		t.Errorf("Expected 1 bucket cleaned, got %d", cleaned)
+	}
+	if got := store.cleanedBuckets(); fmt.Sprint(got) != "[users]" {
+		t.Errorf("Expected the bucket after the failed one to be cleaned, got %v", got)
+	}
+}
+
+func TestCleanOwnedVersions_ListBucketsError(t *testing.T) {
+	store := newVersionCleanupTestStore("orders")
+	store.listErr = errors.New("blob storage unavailable")
+
+	if cleaned := cleanOwnedVersions(context.Background(), store, time.Second, 1, allShards(1)()); cleaned != 0 {
+		t.Errorf("Expected no bucket cleaned, got %d", cleaned)
+	}
+	if got := store.cleanedBuckets(); len(got) != 0 {
+		t.Errorf("Expected no bucket cleaned, got %v", got)
+	}
+}
+
+func TestCleanOwnedVersions_Cancelled(t *testing.T) {
+	store := newVersionCleanupTestStore("orders", "users")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if cleaned := cleanOwnedVersions(ctx, store, time.Second, 1, allShards(1)()); cleaned != 0 {
+		t.Errorf("Expected no bucket cleaned once cancelled, got %d", cleaned)
+	}
+}
+
+func TestRunVersionCleanup_StopsWhenCancelled(t *testing.T) {
+	store := newVersionCleanupTestStore("orders")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runVersionCleanup(ctx, store, time.Hour, time.Second, 1, allShards(1))
+		close(done)
+	}()
+
+	// The first cleanup runs right away
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.cleanedBuckets()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the version cleanup to stop once cancelled")
+	}
+	if got := store.cleanedBuckets(); fmt.Sprint(got) != "[orders]" {
+		t.Errorf("Expected the owned bucket to be cleaned on start, got %v", got)
+	}
+}
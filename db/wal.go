@@ -0,0 +1,468 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"NimbusDb/metrics"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// globalWAL is the write-ahead log. Nil if the write-ahead log is disabled.
+	// It is set once during initialization and never modified.
+	globalWAL *writeAheadLog
+)
+
+// walSignatureHeader is the header holding the HMAC of a logged write.
+const walSignatureHeader = "Nimbus-Signature"
+
+// blobWriteFunc applies an acknowledged write to blob storage and returns the created version ID.
+type blobWriteFunc func(ctx context.Context, bucketName, fileName string, data []byte) (string, error)
+
+// writeAheadLog acknowledges writes once they are appended to a JetStream stream,
+// and applies them to blob storage in the background with one flusher per shard.
+// The stream uses work queue retention, so a write leaves the stream once applied.
+// Writes of a shard are applied in append order, and at least once.
+// Pending writes are read back from the stream, only their stream sequence is kept in memory.
+// Anyone who can publish to the log subjects could otherwise write to any bucket, so each write is signed
+// with an HMAC of the signing key, and the flushers drop writes without a valid signature.
+// This type is thread-safe.
+type writeAheadLog struct {
+	js            jetstream.JetStream
+	stream        jetstream.Stream
+	subjectPrefix string
+	retryDelay    time.Duration
+	opTimeout     time.Duration
+	signingKey    []byte
+	// catchUpTimeout bounds the startup wait for the writes left pending by a previous run.
+	catchUpTimeout time.Duration
+	write          blobWriteFunc
+	overlay        *writeOverlay
+	// consumers are the durable consumers of the flushers, per owned shard. Read-only after creation.
+	consumers map[uint16]jetstream.Consumer
+	// lastSeq is the stream sequence of the last write appended per shard. The map is read-only after creation.
+	lastSeq map[uint16]*atomic.Uint64
+	// usage holds the quota usage recorded for the writes appended by this run, reverted if they are dropped.
+	usage   pendingUsage
+	flushed *metrics.Counter
+	failed  *metrics.Counter
+	dropped *metrics.Counter
+	// rejected counts logged writes dropped because their signature is missing or invalid.
+	rejected *metrics.Counter
+}
+
+// InitializeWAL sets up the write-ahead log if it is enabled: the stream holding pending writes
+// and the consumers of the flushers of the owned shards are created.
+// Must be called after InitializeGlobals and the global state, the flushers are started with StartWALFlushers.
+func InitializeWAL() {
+	cfg := globalConfig.WAL
+	if !cfg.Enabled {
+		return
+	}
+	state := GetGlobalState()
+	if state == nil {
+		log.Fatal().Msg("Global state must be initialized before InitializeWAL")
+	}
+
+	js, err := jetstream.New(globalNATSConn)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create JetStream context for the write-ahead log")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+	w, err := newWriteAheadLog(ctx, js, cfg, globalConfig.Blob.BlobOperationTimeout, state.GetShardIDs(), globalBlobClient.WriteFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("stream", cfg.Stream).Msg("Failed to create the write-ahead log")
+	}
+	globalWAL = w
+	log.Info().Str("stream", cfg.Stream).Str("subjectPrefix", cfg.SubjectPrefix).Msg("Write-ahead log enabled")
+}
+
+// newWriteAheadLog creates (or updates) the write-ahead log stream and the flusher consumers of the owned shards.
+// Consumers are created or updated in place, the consumers of other shards belong to their owners and are left alone.
+// A write that was being applied when the node stopped is redelivered once its ack wait expires.
+//
+// params:
+//   - ctx: Context for the stream and consumer creation
+//   - js: The JetStream context
+//   - cfg: The write-ahead log configuration
+//   - opTimeout: The timeout of a single blob write
+//   - shardIDs: The shards owned by this node, each gets its own subject and flusher
+//   - write: The function applying logged writes to blob storage
+//
+// return:
+//   - *writeAheadLog: The write-ahead log
+//   - error: An error if the stream or a consumer could not be created
+func newWriteAheadLog(ctx context.Context, js jetstream.JetStream, cfg configurations.WALConfig, opTimeout time.Duration, shardIDs []uint16, write blobWriteFunc) (*writeAheadLog, error) {
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      cfg.Stream,
+		Subjects:  []string{cfg.SubjectPrefix + ".>"},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream %s: %w", cfg.Stream, err)
+	}
+
+	if cfg.SigningKey == "" {
+		return nil, fmt.Errorf("the write-ahead log requires a signing key")
+	}
+
+	w := &writeAheadLog{
+		js:             js,
+		stream:         stream,
+		subjectPrefix:  cfg.SubjectPrefix,
+		retryDelay:     cfg.RetryDelay,
+		opTimeout:      opTimeout,
+		signingKey:     []byte(cfg.SigningKey),
+		catchUpTimeout: cfg.CatchUpTimeout,
+		write:          write,
+		overlay:        newWriteOverlay(),
+		consumers:      make(map[uint16]jetstream.Consumer, len(shardIDs)),
+		lastSeq:        make(map[uint16]*atomic.Uint64, len(shardIDs)),
+		flushed:        metrics.GetCounter("nimbus_wal_flushed_writes_total", nil),
+		failed:         metrics.GetCounter("nimbus_wal_flush_errors_total", nil),
+		dropped:        metrics.GetCounter("nimbus_wal_dropped_writes_total", nil),
+		rejected:       metrics.GetCounter("nimbus_wal_rejected_writes_total", nil),
+	}
+
+	for _, shardID := range shardIDs {
+		name := fmt.Sprintf("wal_shard_%d", shardID)
+		consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+			Durable:       name,
+			FilterSubject: w.subject(shardID),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			// One write in flight per shard keeps writes in append order
+			MaxAckPending: 1,
+			AckWait:       2 * opTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create consumer %s: %w", name, err)
+		}
+		w.consumers[shardID] = consumer
+		w.lastSeq[shardID] = &atomic.Uint64{}
+	}
+
+	metrics.RegisterGaugeFunc("nimbus_wal_pending_objects", nil, func() float64 {
+		return float64(w.overlay.size())
+	})
+	return w, nil
+}
+
+// subject returns the subject the writes of a shard are appended to.
+func (w *writeAheadLog) subject(shardID uint16) string {
+	return fmt.Sprintf("%s.%d", w.subjectPrefix, shardID)
+}
+
+// sign returns the hex HMAC-SHA256 of a logged write: its subject, target object, message ID and content.
+// Fields are length-prefixed, so no two different writes have the same signed bytes.
+func (w *writeAheadLog) sign(subject, bucketName, fileName, id string, data []byte) string {
+	mac := hmac.New(sha256.New, w.signingKey)
+	var size [8]byte
+	for _, field := range [][]byte{[]byte(subject), []byte(bucketName), []byte(fileName), []byte(id), data} {
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		mac.Write(size[:])
+		mac.Write(field)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether a logged write was signed with the signing key of the log.
+func (w *writeAheadLog) verify(msg jetstream.Msg) bool {
+	h := msg.Headers()
+	got, err := hex.DecodeString(h.Get(walSignatureHeader))
+	if err != nil {
+		return false
+	}
+	expected, _ := hex.DecodeString(w.sign(msg.Subject(), h.Get("bucketName"), h.Get("fileName"), h.Get(jetstream.MsgIDHeader), msg.Data()))
+	return hmac.Equal(got, expected)
+}
+
+// append appends a write to the log of its shard and waits for the stream ack.
+// The write is visible to reads through the overlay from then on, its content is read back from the stream.
+//
+// params:
+//   - ctx: Context for the append, bounded by the client deadline
+//   - shardID: The shard of the write
+//   - bucketName: The bucket to write to
+//   - fileName: The file to write
+//   - data: The file content
+//   - usage: The usage change of the write, recorded for the bucket quota once appended and reverted if the write is dropped
+//
+// return:
+//   - error: An error if the write could not be appended, it is then not applied
+func (w *writeAheadLog) append(ctx context.Context, shardID uint16, bucketName, fileName string, data []byte, usage quotaWrite) error {
+	lastSeq, ok := w.lastSeq[shardID]
+	if !ok {
+		return fmt.Errorf("shard %d is not owned by this node", shardID)
+	}
+	id := nuid.Next()
+	// The content is kept in memory until the stream has it, the write could be flushed before the ack is received
+	w.overlay.add(bucketName, fileName, id, data)
+
+	msg := nats.NewMsg(w.subject(shardID))
+	msg.Header.Set("bucketName", bucketName)
+	msg.Header.Set("fileName", fileName)
+	msg.Header.Set(jetstream.MsgIDHeader, id)
+	msg.Header.Set(walSignatureHeader, w.sign(msg.Subject, bucketName, fileName, id, data))
+	msg.Data = data
+	// Recorded before the flusher can drop the write and revert it
+	w.usage.record(id, bucketName, usage)
+	ack, err := w.js.PublishMsg(ctx, msg)
+	if err != nil {
+		w.usage.revert(id)
+		w.overlay.remove(bucketName, fileName, id)
+		return err
+	}
+
+	w.overlay.release(bucketName, fileName, id, w.loadMsg(ack.Sequence))
+	// Appends of a shard are made by its handler goroutine one at a time, so sequences only grow
+	lastSeq.Store(ack.Sequence)
+	return nil
+}
+
+// loadMsg returns the function reading the content of a logged write back from the stream.
+// Once flushed, the write leaves the stream and the function returns an error wrapping os.ErrNotExist.
+func (w *writeAheadLog) loadMsg(seq uint64) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), w.opTimeout)
+		defer cancel()
+		msg, err := w.stream.GetMsg(ctx, seq)
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return nil, fmt.Errorf("%w: write %d already flushed", os.ErrNotExist, seq)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read write %d from the write-ahead log: %w", seq, err)
+		}
+		return msg.Data, nil
+	}
+}
+
+// waitFlushed waits until every write appended to the log of a shard so far has been applied to blob storage.
+//
+// params:
+//   - ctx: Context bounding the wait
+//   - shardID: The shard to wait for
+//
+// return:
+//   - error: An error if ctx expired first or the flusher state could not be read
+func (w *writeAheadLog) waitFlushed(ctx context.Context, shardID uint16) error {
+	consumer, ok := w.consumers[shardID]
+	if !ok {
+		return nil
+	}
+	target := w.lastSeq[shardID].Load()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		info, err := consumer.Info(ctx)
+		if err != nil {
+			return err
+		}
+		if info.AckFloor.Stream >= target || (info.NumPending == 0 && info.NumAckPending == 0) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// lookup returns the content of the latest pending write of an object.
+// A nil write-ahead log (disabled) has no pending writes.
+func (w *writeAheadLog) lookup(bucketName, fileName string) ([]byte, bool, error) {
+	if w == nil {
+		return nil, false, nil
+	}
+	return w.overlay.get(bucketName, fileName)
+}
+
+// has reports whether an object has pending writes. A nil write-ahead log has no pending writes.
+func (w *writeAheadLog) has(bucketName, fileName string) bool {
+	return w != nil && w.overlay.has(bucketName, fileName)
+}
+
+// StartWALFlushers starts the shard flushers of the write-ahead log, and waits until the writes
+// left pending by a previous run are applied, so reads are consistent once the node serves requests.
+// Startup fails if they are not applied within wal.catchUpTimeout.
+// Does nothing if the write-ahead log is disabled. Must be called after InitializeWAL.
+//
+// params:
+//   - ctx: The context that stops the flushers when cancelled
+func StartWALFlushers(ctx context.Context) {
+	if globalWAL == nil {
+		return
+	}
+	if err := globalWAL.start(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Failed to replay the write-ahead log")
+	}
+}
+
+// start starts the shard flushers and waits until every write in the log has been applied, for up to the catch-up timeout.
+func (w *writeAheadLog) start(ctx context.Context) error {
+	for shardID, consumer := range w.consumers {
+		go w.runFlusher(ctx, shardID, consumer)
+	}
+
+	waitCtx := ctx
+	if w.catchUpTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, w.catchUpTimeout)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(w.consumers))
+	for shardID, consumer := range w.consumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := waitCaughtUp(waitCtx, shardID, consumer); err != nil {
+				if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+					err = fmt.Errorf("pending writes not applied within %s: %w", w.catchUpTimeout, err)
+				}
+				errs <- fmt.Errorf("shard %d: %w", shardID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// walProgressInterval is how often the startup wait logs the writes a shard still has to apply.
+const walProgressInterval = 10 * time.Second
+
+// waitCaughtUp waits until a flusher consumer has no pending or unacknowledged writes, logging its progress.
+func waitCaughtUp(ctx context.Context, shardID uint16, consumer jetstream.Consumer) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	lastProgress := time.Now()
+	for {
+		info, err := consumer.Info(ctx)
+		if err != nil {
+			return err
+		}
+		if info.NumPending == 0 && info.NumAckPending == 0 {
+			return nil
+		}
+		if time.Since(lastProgress) >= walProgressInterval {
+			log.Info().Uint16("shardID", shardID).Uint64("pending", info.NumPending).Int("inFlight", info.NumAckPending).Msg("Waiting for the write-ahead log to be applied")
+			lastProgress = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// runFlusher applies the writes of a shard to blob storage, one at a time in append order, until ctx is cancelled.
+func (w *writeAheadLog) runFlusher(ctx context.Context, shardID uint16, consumer jetstream.Consumer) {
+	for ctx.Err() == nil {
+		msg, err := consumer.Next(jetstream.FetchMaxWait(time.Second))
+		if err != nil {
+			if !errors.Is(err, nats.ErrTimeout) && ctx.Err() == nil {
+				log.Error().Err(err).Uint16("shardID", shardID).Msg("Failed to fetch from the write-ahead log")
+				sleepCtx(ctx, w.retryDelay)
+			}
+			continue
+		}
+		w.flush(ctx, shardID, msg)
+	}
+}
+
+// flush applies a logged write to blob storage, retrying until it succeeds or ctx is cancelled.
+// Writes without a valid signature are dropped.
+// Writes that can never succeed (invalid or missing bucket) are dropped and published to the dead-letter subject,
+// and their usage is reverted. Once applied, the write is acknowledged (removing it from the stream) and published as a change event.
+func (w *writeAheadLog) flush(ctx context.Context, shardID uint16, msg jetstream.Msg) {
+	bucketName, fileName := msg.Headers().Get("bucketName"), msg.Headers().Get("fileName")
+	id := msg.Headers().Get(jetstream.MsgIDHeader)
+
+	// Not written by a node holding the signing key, dropped without being published anywhere
+	if !w.verify(msg) {
+		log.Error().Uint16("shardID", shardID).Str("subject", msg.Subject()).Str("bucketName", bucketName).Str("fileName", fileName).Msg("Dropping logged write without a valid signature")
+		if err := msg.Term(); err != nil {
+			log.Warn().Err(err).Uint16("shardID", shardID).Msg("Failed to terminate rejected write")
+		}
+		// The overlay is left alone, the forged message ID could be the one of a pending write
+		w.rejected.Inc()
+		return
+	}
+
+	for {
+		opCtx, cancel := context.WithTimeout(context.Background(), w.opTimeout)
+		versionID, err := w.write(opCtx, bucketName, fileName, msg.Data())
+		cancel()
+
+		if err == nil {
+			ackCtx, cancel := context.WithTimeout(context.Background(), w.opTimeout)
+			if err := msg.DoubleAck(ackCtx); err != nil {
+				// The write is applied again when redelivered, which only creates an identical version
+				log.Warn().Err(err).Uint16("shardID", shardID).Str("fileName", fileName).Msg("Failed to acknowledge flushed write")
+			}
+			cancel()
+			w.overlay.remove(bucketName, fileName, id)
+			w.usage.applied(id)
+			w.flushed.Inc()
+			writeApplied(shardID, bucketName, fileName, versionID, msg.Data())
+			return
+		}
+
+		if isPermanentWriteError(err) {
+			globalDeadLetters.publish(shardID, bucketName, fileName, msg.Data(), err)
+			if err := msg.Term(); err != nil {
+				log.Warn().Err(err).Uint16("shardID", shardID).Msg("Failed to terminate dropped write")
+			}
+			w.overlay.remove(bucketName, fileName, id)
+			w.usage.revert(id)
+			w.dropped.Inc()
+			return
+		}
+
+		w.failed.Inc()
+		log.Warn().Err(err).Uint16("shardID", shardID).Str("bucketName", bucketName).Str("fileName", fileName).Dur("retryDelay", w.retryDelay).Msg("Failed to flush logged write, retrying")
+		if !sleepCtx(ctx, w.retryDelay) {
+			// Redelivered on the next start
+			_ = msg.Nak()
+			return
+		}
+		// Keep the write assigned to this flusher while retrying
+		_ = msg.InProgress()
+	}
+}
+
+// sleepCtx waits for d, or until ctx is cancelled.
+//
+// return:
+//   - bool: False if ctx was cancelled
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
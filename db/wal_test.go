@@ -0,0 +1,343 @@
+package db
+
+import (
+	"NimbusDb/blob"
+	"NimbusDb/configurations"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeBlobWrites records the writes applied by the write-ahead log flushers.
+type fakeBlobWrites struct {
+	mu       sync.Mutex
+	writes   []string
+	failures int
+	// missingBucket is a bucket whose writes fail permanently.
+	missingBucket string
+}
+
+func (f *fakeBlobWrites) write(ctx context.Context, bucketName, fileName string, data []byte) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if bucketName == f.missingBucket {
+		return "", blob.ErrBucketNotFound
+	}
+	if f.failures > 0 {
+		f.failures--
+		return "", errors.New("blob storage unavailable")
+	}
+	f.writes = append(f.writes, fmt.Sprintf("%s/%s=%s", bucketName, fileName, data))
+	return fmt.Sprintf("v%d", len(f.writes)), nil
+}
+
+func (f *fakeBlobWrites) applied() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.writes...)
+}
+
+var testWALConfig = configurations.WALConfig{
+	Enabled:       true,
+	SubjectPrefix: "nimbus.wal",
+	Stream:        "NIMBUS_WAL",
+	RetryDelay:    10 * time.Millisecond,
+	SigningKey:    "wal-secret",
+}
+
+func newTestWAL(t *testing.T, js jetstream.JetStream, store *fakeBlobWrites) *writeAheadLog {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	w, err := newWriteAheadLog(ctx, js, testWALConfig, 5*time.Second, []uint16{0, 1}, store.write)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return w
+}
+
+// startTestWAL starts the flushers and waits for the log to be applied.
+func startTestWAL(t *testing.T, w *writeAheadLog) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	if err := w.start(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func appendTestWrite(t *testing.T, w *writeAheadLog, shardID uint16, fileName, data string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.append(ctx, shardID, "orders", fileName, []byte(data), quotaWrite{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestWriteAheadLog_ReadsSeePendingWrites(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	store := &fakeBlobWrites{}
+	w := newTestWAL(t, js, store)
+
+	appendTestWrite(t, w, 0, "order-1", "first")
+	appendTestWrite(t, w, 0, "order-1", "second")
+
+	data, ok, _ := w.lookup("orders", "order-1")
+	if !ok || string(data) != "second" {
+		t.Errorf("Expected pending write 'second', got '%s'", data)
+	}
+	if len(store.applied()) != 0 {
+		t.Errorf("Expected no write applied before the flushers start, got %v", store.applied())
+	}
+}
+
+func TestWriteAheadLog_FlushesInOrder(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	store := &fakeBlobWrites{}
+	w := newTestWAL(t, js, store)
+
+	appendTestWrite(t, w, 0, "order-1", "a")
+	appendTestWrite(t, w, 0, "order-1", "b")
+	appendTestWrite(t, w, 0, "order-2", "c")
+	startTestWAL(t, w)
+
+	expected := []string{"orders/order-1=a", "orders/order-1=b", "orders/order-2=c"}
+	applied := store.applied()
+	if fmt.Sprint(applied) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, applied)
+	}
+	if _, ok, _ := w.lookup("orders", "order-1"); ok {
+		t.Error("Expected no pending write once flushed")
+	}
+}
+
+func TestWriteAheadLog_ResumesAfterRestart(t *testing.T) {
+	_, js := runJetStreamServer(t)
+
+	// The first run logs writes and stops before applying them
+	crashed := newTestWAL(t, js, &fakeBlobWrites{})
+	appendTestWrite(t, crashed, 0, "order-1", "a")
+	appendTestWrite(t, crashed, 1, "order-2", "b")
+
+	// The next run applies them before serving requests
+	store := &fakeBlobWrites{}
+	restarted := newTestWAL(t, js, store)
+	startTestWAL(t, restarted)
+
+	applied := store.applied()
+	if len(applied) != 2 {
+		t.Fatalf("Expected 2 writes applied, got %v", applied)
+	}
+
+	// Applied writes leave the stream and are not applied again
+	again := &fakeBlobWrites{}
+	startTestWAL(t, newTestWAL(t, js, again))
+	if len(again.applied()) != 0 {
+		t.Errorf("Expected no write applied twice, got %v", again.applied())
+	}
+}
+
+func TestWriteAheadLog_RetriesFailedWrites(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	store := &fakeBlobWrites{failures: 3}
+	w := newTestWAL(t, js, store)
+
+	appendTestWrite(t, w, 0, "order-1", "a")
+	appendTestWrite(t, w, 0, "order-1", "b")
+	startTestWAL(t, w)
+
+	expected := []string{"orders/order-1=a", "orders/order-1=b"}
+	if fmt.Sprint(store.applied()) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, store.applied())
+	}
+}
+
+func TestWriteAheadLog_RevertsUsageOfDroppedWrites(t *testing.T) {
+	q := setTestQuota(t)
+	_, js := runJetStreamServer(t)
+	store := &fakeBlobWrites{missingBucket: "orders"}
+	w := newTestWAL(t, js, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.append(ctx, 0, "orders", "order-1", []byte("lost"), quotaWrite{bytes: 4, objects: 1}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := q.bytes.Load(); got != 4 {
+		t.Errorf("Expected the appended write to be recorded as 4 bytes, got %d", got)
+	}
+	startTestWAL(t, w)
+
+	if got, objects := q.bytes.Load(), q.objects.Load(); got != 0 || objects != 0 {
+		t.Errorf("Expected the usage of the dropped write to be reverted, got %d bytes and %d objects", got, objects)
+	}
+}
+
+func TestWriteAheadLog_RejectsUnsignedWrites(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	store := &fakeBlobWrites{}
+	w := newTestWAL(t, js, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Published by a client that can reach the log subjects, unsigned or signed with another key
+	forged := nats.NewMsg(w.subject(0))
+	forged.Header.Set("bucketName", "orders")
+	forged.Header.Set("fileName", "order-1")
+	forged.Header.Set(jetstream.MsgIDHeader, "forged")
+	forged.Data = []byte("forged")
+	if _, err := js.PublishMsg(ctx, forged); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	other := &writeAheadLog{signingKey: []byte("other-secret")}
+	forged.Header.Set(jetstream.MsgIDHeader, "forged-2")
+	forged.Header.Set(walSignatureHeader, other.sign(forged.Subject, "orders", "order-1", "forged-2", forged.Data))
+	if _, err := js.PublishMsg(ctx, forged); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	appendTestWrite(t, w, 0, "order-2", "signed")
+	startTestWAL(t, w)
+
+	expected := []string{"orders/order-2=signed"}
+	if fmt.Sprint(store.applied()) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, store.applied())
+	}
+}
+
+func TestWriteAheadLog_CatchUpTimeout(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	// Blob storage never comes back, so the pending write is never applied
+	store := &fakeBlobWrites{failures: 1 << 30}
+	w := newTestWAL(t, js, store)
+	w.catchUpTimeout = 200 * time.Millisecond
+	appendTestWrite(t, w, 0, "order-1", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := w.start(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the catch-up timeout to expire, got %v", err)
+	}
+}
+
+func TestWriteAheadLog_WaitFlushed(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	store := &fakeBlobWrites{}
+	w := newTestWAL(t, js, store)
+	startTestWAL(t, w)
+
+	appendTestWrite(t, w, 1, "order-1", "a")
+	appendTestWrite(t, w, 1, "order-2", "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.waitFlushed(ctx, 1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(store.applied()) != 2 {
+		t.Errorf("Expected 2 writes applied after the flush, got %v", store.applied())
+	}
+}
+
+func TestWriteAheadLog_ConsumersOfOwnedShards(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Another node owns shard 0, and has an unacknowledged write in flight
+	other, err := newWriteAheadLog(ctx, js, testWALConfig, 5*time.Second, []uint16{0}, (&fakeBlobWrites{}).write)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	appendTestWrite(t, other, 0, "order-1", "a")
+	if _, err := other.consumers[0].Next(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	w, err := newWriteAheadLog(ctx, js, testWALConfig, 5*time.Second, []uint16{1}, (&fakeBlobWrites{}).write)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(w.consumers) != 1 || w.consumers[1] == nil {
+		t.Errorf("Expected a consumer for shard 1 only, got %v", w.consumers)
+	}
+	info, err := other.consumers[0].Info(ctx)
+	if err != nil {
+		t.Fatalf("Expected the consumer of shard 0 to be kept, got %v", err)
+	}
+	if info.NumAckPending != 1 {
+		t.Errorf("Expected the write in flight on shard 0 to stay assigned, got %d pending acks", info.NumAckPending)
+	}
+	if err := w.append(ctx, 0, "orders", "order-2", []byte("b"), quotaWrite{}); err == nil {
+		t.Error("Expected appending to a shard not owned by the node to fail")
+	}
+}
+
+func TestWriteAheadLog_PendingWritesReadFromStream(t *testing.T) {
+	_, js := runJetStreamServer(t)
+	w := newTestWAL(t, js, &fakeBlobWrites{})
+	appendTestWrite(t, w, 0, "order-1", "logged")
+
+	w.overlay.mu.RLock()
+	kept := w.overlay.pending[overlayKey("orders", "order-1")][0].data
+	w.overlay.mu.RUnlock()
+	if kept != nil {
+		t.Errorf("Expected the content of a logged write not to be kept in memory, got '%s'", kept)
+	}
+	if data, ok, err := w.lookup("orders", "order-1"); err != nil || !ok || string(data) != "logged" {
+		t.Errorf("Expected pending write 'logged', got '%s' (found %t, %v)", data, ok, err)
+	}
+
+	startTestWAL(t, w)
+	if _, ok, err := w.overlay.get("orders", "order-1"); ok || err != nil {
+		t.Errorf("Expected no pending write once flushed, got found %t (%v)", ok, err)
+	}
+}
+
+func TestWriteAheadLog_NilLookup(t *testing.T) {
+	var w *writeAheadLog
+	if _, ok, _ := w.lookup("orders", "order-1"); ok {
+		t.Error("Expected no pending write when the write-ahead log is disabled")
+	}
+}
+
+func TestWALOverlay(t *testing.T) {
+	o := newWriteOverlay()
+
+	if _, ok, _ := o.get("orders", "order-1"); ok {
+		t.Error("Expected no pending write")
+	}
+
+	o.add("orders", "order-1", "w1", []byte("first"))
+	o.add("orders", "order-1", "w2", []byte("second"))
+	if data, ok, _ := o.get("orders", "order-1"); !ok || string(data) != "second" {
+		t.Errorf("Expected 'second', got '%s'", data)
+	}
+
+	// Applying the older write keeps the newer one visible
+	o.remove("orders", "order-1", "w1")
+	if data, ok, _ := o.get("orders", "order-1"); !ok || string(data) != "second" {
+		t.Errorf("Expected 'second', got '%s'", data)
+	}
+
+	// A failed append of the newest write falls back to the previous pending write
+	o.add("orders", "order-1", "w3", []byte("third"))
+	o.remove("orders", "order-1", "w3")
+	if data, ok, _ := o.get("orders", "order-1"); !ok || string(data) != "second" {
+		t.Errorf("Expected 'second', got '%s'", data)
+	}
+
+	o.remove("orders", "order-1", "w2")
+	if _, ok, _ := o.get("orders", "order-1"); ok {
+		t.Error("Expected no pending write")
+	}
+	if o.size() != 0 {
+		t.Errorf("Expected empty overlay, got %d objects", o.size())
+	}
+}
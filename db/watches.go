@@ -0,0 +1,518 @@
+package db
+
+import (
+	"NimbusDb/auth"
+	"NimbusDb/configurations"
+	"NimbusDb/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+	"github.com/rs/zerolog/log"
+)
+
+// WatchIDHeader is the header carrying the watch ID on every message delivered to a watch.
+const WatchIDHeader = "Nimbus-Watch-Id"
+
+// inboxPrefix is the subject prefix of the NATS client inboxes, request replies are sent to these subjects.
+const inboxPrefix = "_INBOX."
+
+var (
+	// globalWatches holds the watches served by this node. Nil if change data capture is disabled.
+	// It is set once during initialization, the registry itself is thread-safe.
+	globalWatches *watchRegistry
+)
+
+// watchRequest holds the parsed headers of a watch create request.
+type watchRequest struct {
+	bucketName     string
+	prefix         string
+	deliverSubject string
+	includePayload bool
+	// startSequence and startTime resume the watch from the change event stream. At most one is set.
+	startSequence uint64
+	startTime     time.Time
+}
+
+// resumes returns true if the watch starts from past change events rather than from new ones.
+func (r *watchRequest) resumes() bool {
+	return r.startSequence > 0 || !r.startTime.IsZero()
+}
+
+// watch delivers the change events of a bucket and key prefix to a subject.
+type watch struct {
+	id             string
+	bucketName     string
+	prefix         string
+	deliverSubject string
+	includePayload bool
+	// stop stops receiving change events.
+	stop func()
+	// cancelSub receives the cancel requests of the watch.
+	cancelSub *nats.Subscription
+	// renewSub receives the lease renewals of the watch.
+	renewSub *nats.Subscription
+	// lease expires the watch when it is not renewed. Guarded by the registry mutex.
+	lease *time.Timer
+}
+
+// matches returns true if the change event is in the bucket and key prefix of the watch.
+func (w *watch) matches(event ChangeEvent) bool {
+	return event.Bucket == w.bucketName && strings.HasPrefix(event.Key, w.prefix)
+}
+
+// watchRegistry holds the watches served by this node.
+// A watch lives for a lease renewed by its client, so the subscriptions and consumers of abandoned watches are released.
+// This type is thread-safe.
+type watchRegistry struct {
+	mu       sync.Mutex
+	watches  map[string]*watch
+	leaseTTL time.Duration
+	expired  *metrics.Counter
+}
+
+// newWatchRegistry creates an empty watch registry and registers the active watches metric.
+//
+// params:
+//   - leaseTTL: How long a watch lives without being renewed
+func newWatchRegistry(leaseTTL time.Duration) *watchRegistry {
+	r := &watchRegistry{
+		watches:  make(map[string]*watch),
+		leaseTTL: leaseTTL,
+		expired:  metrics.GetCounter("nimbus_watches_expired_total", nil),
+	}
+	metrics.RegisterGaugeFunc("nimbus_watches_active", nil, func() float64 {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return float64(len(r.watches))
+	})
+	return r
+}
+
+// startWatchHandlers subscribes to the subject used by clients to create watches.
+// All data nodes listen through the watch queue group, the node serving the create request serves the watch.
+//
+// return:
+//   - []*nats.Subscription: All subscriptions created for watch handlers
+func startWatchHandlers() []*nats.Subscription {
+	subject := globalConfig.NATS.SubjectPrefix + ".watch.create"
+	sub, err := globalNATSConn.QueueSubscribe(subject, configurations.WatchHandlersQueueGroup, createWatch)
+	if err != nil {
+		log.Fatal().Err(err).Str("subject", subject).Msg("Failed to start NATS watch handler")
+	}
+	return []*nats.Subscription{sub}
+}
+
+// createWatch handles requests to watch a bucket and key prefix.
+// The requester needs the read action on the bucket and prefix if authorization is configured.
+// Notifications are only delivered to the inbox of the requester, or under the watch subject prefix of its tenant.
+func createWatch(msg *nats.Msg) {
+	if globalWatches == nil {
+		RespondWithNatsError(msg, ErrorCodeBadRequest, "watches require change data capture to be enabled")
+		return
+	}
+
+	req, err := parseWatchRequest(msg, globalConfig.NATS.SubjectPrefix)
+	if err != nil {
+		RespondWithNatsError(msg, ErrorCodeBadRequest, err.Error())
+		return
+	}
+	if req.resumes() && globalCDC.js == nil {
+		RespondWithNatsError(msg, ErrorCodeBadRequest, "resuming a watch requires change data capture with JetStream")
+		return
+	}
+
+	tenantName, err := globalTenants.authorize(msg, req.bucketName)
+	if err != nil {
+		RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+		return
+	}
+	if err := checkDeliverSubject(msg.Reply, req.deliverSubject, globalTenants.watchSubjectPrefix(tenantName)); err != nil {
+		log.Warn().Err(err).Str("bucketName", req.bucketName).Str("deliverSubject", req.deliverSubject).Msg("Rejected watch deliver subject")
+		RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+		return
+	}
+	if _, err := globalAuthorizer.authorize(msg, req.bucketName, req.prefix, auth.ActionRead); err != nil {
+		log.Warn().Err(err).Str("bucketName", req.bucketName).Str("prefix", req.prefix).Msg("Rejected forbidden watch")
+		RespondWithNatsError(msg, ErrorCodeForbidden, err.Error())
+		return
+	}
+
+	w, err := globalWatches.start(req)
+	if err != nil {
+		log.Error().Err(err).Str("bucketName", req.bucketName).Msg("Failed to start watch")
+		RespondWithNatsError(msg, ErrorCodeInternalServerError, err.Error())
+		return
+	}
+	log.Info().Str("watchId", w.id).Str("bucketName", w.bucketName).Str("prefix", w.prefix).Str("deliverSubject", w.deliverSubject).Msg("Watch started")
+
+	respondWithJSON(msg, WatchResponse{
+		WatchID:       w.id,
+		CancelSubject: w.cancelSub.Subject,
+		RenewSubject:  w.renewSub.Subject,
+		LeaseMs:       globalWatches.leaseTTL.Milliseconds(),
+	})
+}
+
+// parseWatchRequest extracts and validates the headers of a watch create request.
+//
+// params:
+//   - msg: The watch create request
+//   - subjectPrefix: The NimbusDb subject prefix, deliver subjects cannot be NimbusDb subjects
+//
+// return:
+//   - *watchRequest: The parsed request
+//   - error: An error if a header is missing or invalid
+func parseWatchRequest(msg *nats.Msg, subjectPrefix string) (*watchRequest, error) {
+	h := msg.Header
+	req := &watchRequest{
+		bucketName:     h.Get("bucketName"),
+		prefix:         h.Get("prefix"),
+		deliverSubject: h.Get("deliverSubject"),
+	}
+
+	if req.bucketName == "" {
+		return nil, errors.New("missing 'bucketName' header")
+	}
+	if req.deliverSubject == "" {
+		return nil, errors.New("missing 'deliverSubject' header")
+	}
+	if strings.ContainsAny(req.deliverSubject, " \t*>") {
+		return nil, fmt.Errorf("invalid 'deliverSubject' header, cannot contain spaces or wildcards: %s", req.deliverSubject)
+	}
+	if strings.HasPrefix(req.deliverSubject, subjectPrefix+".") {
+		return nil, fmt.Errorf("invalid 'deliverSubject' header, cannot be a NimbusDb subject: %s", req.deliverSubject)
+	}
+
+	if s := h.Get("includePayload"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'includePayload' header: %s", s)
+		}
+		req.includePayload = b
+	}
+
+	// --- startSequence (optional) ---
+	if s := h.Get("startSequence"); s != "" {
+		seq, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || seq == 0 {
+			return nil, fmt.Errorf("invalid 'startSequence' header: %s", s)
+		}
+		req.startSequence = seq
+	}
+
+	// --- startTime (optional, unix epoch milliseconds) ---
+	if s := h.Get("startTime"); s != "" {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid 'startTime' header: %s", s)
+		}
+		req.startTime = time.UnixMilli(ms)
+	}
+
+	if req.startSequence > 0 && !req.startTime.IsZero() {
+		return nil, errors.New("'startSequence' and 'startTime' headers are mutually exclusive")
+	}
+	return req, nil
+}
+
+// checkDeliverSubject checks that a watch delivers to a subject of the requester, so a watch cannot publish
+// change events (and their payloads) to the subjects of other clients or services.
+// The subject must be under the inbox of the requester (the first token after _INBOX. of its reply subject),
+// or under the watch subject prefix of its tenant.
+//
+// params:
+//   - reply: The reply subject of the watch create request
+//   - deliverSubject: The requested deliver subject
+//   - tenantPrefix: The watch subject prefix of the tenant of the requester, empty if it has none
+//
+// return:
+//   - error: An error wrapping ErrForbidden if the subject belongs to neither
+func checkDeliverSubject(reply, deliverSubject, tenantPrefix string) error {
+	if inbox := requesterInbox(reply); inbox != "" && underSubject(deliverSubject, inbox) {
+		return nil
+	}
+	if tenantPrefix != "" && underSubject(deliverSubject, strings.TrimSuffix(tenantPrefix, ".")) {
+		return nil
+	}
+	return fmt.Errorf("%w: 'deliverSubject' %s must be under the inbox of the requester or the watch subject prefix of its tenant", ErrForbidden, deliverSubject)
+}
+
+// requesterInbox returns the inbox of a requester, _INBOX. followed by the first token of the reply subject
+// (shared by the replies of a connection), or empty if the reply is not an inbox subject.
+func requesterInbox(reply string) string {
+	token, _, _ := strings.Cut(strings.TrimPrefix(reply, inboxPrefix), ".")
+	if !strings.HasPrefix(reply, inboxPrefix) || token == "" {
+		return ""
+	}
+	return inboxPrefix + token
+}
+
+// underSubject returns true if subject has at least one token after prefix.
+func underSubject(subject, prefix string) bool {
+	return strings.HasPrefix(subject, prefix+".") && len(subject) > len(prefix)+1
+}
+
+// start starts a watch: it subscribes to the change events of the bucket and to the cancel and renew subjects of the watch.
+// The watch expires if its lease is not renewed.
+//
+// params:
+//   - req: The watch request
+//
+// return:
+//   - *watch: The started watch
+//   - error: An error if the change events could not be subscribed to
+func (r *watchRegistry) start(req *watchRequest) (*watch, error) {
+	w := &watch{
+		id:             nuid.Next(),
+		bucketName:     req.bucketName,
+		prefix:         req.prefix,
+		deliverSubject: req.deliverSubject,
+		includePayload: req.includePayload,
+	}
+
+	var err error
+	if globalCDC.js != nil {
+		w.stop, err = consumeStream(w, req)
+	} else {
+		w.stop, err = subscribeEvents(w)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	subjectPrefix := globalConfig.NATS.SubjectPrefix + ".watch." + w.id
+	w.cancelSub, err = globalNATSConn.Subscribe(subjectPrefix+".cancel", func(msg *nats.Msg) {
+		r.cancel(w.id)
+		log.Info().Str("watchId", w.id).Msg("Watch cancelled")
+		RespondWithNatsSuccess(msg)
+	})
+	if err != nil {
+		w.stop()
+		return nil, err
+	}
+	w.renewSub, err = globalNATSConn.Subscribe(subjectPrefix+".renew", func(msg *nats.Msg) {
+		if !r.renew(w.id) {
+			RespondWithNatsError(msg, ErrorCodeNotFound, "watch expired")
+			return
+		}
+		RespondWithNatsSuccess(msg)
+	})
+	if err != nil {
+		w.stop()
+		_ = w.cancelSub.Unsubscribe()
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.watches[w.id] = w
+	w.lease = time.AfterFunc(r.leaseTTL, func() { r.expire(w.id) })
+	r.mu.Unlock()
+	return w, nil
+}
+
+// renew extends the lease of a watch.
+//
+// params:
+//   - id: The watch ID
+//
+// return:
+//   - bool: False if the watch does not exist or its lease already expired
+func (r *watchRegistry) renew(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.watches[id]
+	if !ok {
+		return false
+	}
+	// Reset returns false if the lease fired, the watch is being expired
+	return w.lease.Reset(r.leaseTTL)
+}
+
+// expire stops a watch whose lease was not renewed, and tells its client with a 410 message on the deliver subject.
+// Does nothing if the watch was cancelled meanwhile.
+func (r *watchRegistry) expire(id string) {
+	w := r.cancel(id)
+	if w == nil {
+		return
+	}
+	r.expired.Inc()
+	notifyWatchClosed(w, ErrorCodeGone, "watch expired, lease was not renewed")
+	log.Info().Str("watchId", id).Str("bucketName", w.bucketName).Msg("Watch expired")
+}
+
+// subscribeEvents receives the new change events of the watched bucket with core NATS.
+func subscribeEvents(w *watch) (func(), error) {
+	sub, err := globalNATSConn.Subscribe(cdcSubject(globalCDC.subjectPrefix, w.bucketName), func(msg *nats.Msg) {
+		w.deliver(msg.Data, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = sub.Unsubscribe()
+	}, nil
+}
+
+// consumeStream receives the change events of the watched bucket from the JetStream stream,
+// starting from the requested sequence or time, or from new events.
+// Stopping deletes the consumer rather than leaving it to the server inactivity threshold.
+func consumeStream(w *watch, req *watchRequest) (func(), error) {
+	cfg := jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{cdcSubject(globalCDC.subjectPrefix, w.bucketName)},
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+	}
+	switch {
+	case req.startSequence > 0:
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = req.startSequence
+	case !req.startTime.IsZero():
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = &req.startTime
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+	defer cancel()
+	consumer, err := globalCDC.js.OrderedConsumer(ctx, globalConfig.CDC.Stream, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := consumer.Consume(func(m jetstream.Msg) {
+		var sequence uint64
+		if md, err := m.Metadata(); err == nil {
+			sequence = md.Sequence.Stream
+		}
+		w.deliver(m.Data(), sequence)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		cc.Stop()
+		info := consumer.CachedInfo()
+		if info == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+		defer cancel()
+		if err := globalCDC.js.DeleteConsumer(ctx, globalConfig.CDC.Stream, info.Name); err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+			log.Warn().Err(err).Str("watchId", w.id).Str("consumer", info.Name).Msg("Failed to delete watch consumer")
+		}
+	}, nil
+}
+
+// deliver sends a change event to the deliver subject of the watch if it matches the watched key prefix.
+// If the watch includes payloads, the changed object version is read and sent along.
+// Events that cannot be read are skipped and logged, payloads that cannot be read are left out.
+//
+// params:
+//   - data: The JSON encoded change event
+//   - sequence: The stream sequence of the event, 0 without JetStream
+func (w *watch) deliver(data []byte, sequence uint64) {
+	var event ChangeEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Error().Err(err).Str("watchId", w.id).Msg("Failed to decode change event")
+		return
+	}
+	if !w.matches(event) {
+		return
+	}
+
+	notification := WatchNotification{ChangeEvent: event, Sequence: sequence}
+	if w.includePayload && event.Operation == ChangeOperationWrite {
+		ctx, cancel := context.WithTimeout(context.Background(), globalConfig.Blob.BlobOperationTimeout)
+		payload, err := globalBlobClient.ReadFile(ctx, event.Bucket, event.Key, event.VersionID)
+		cancel()
+		if err != nil {
+			log.Warn().Err(err).Str("watchId", w.id).Str("bucketName", event.Bucket).Str("fileName", event.Key).Msg("Failed to read payload for watch")
+		} else {
+			notification.Payload = payload
+		}
+	}
+
+	b, err := json.Marshal(notification)
+	if err != nil {
+		log.Error().Err(err).Str("watchId", w.id).Msg("Failed to encode watch notification")
+		return
+	}
+	out := newResponseMsg(SuccessCode, "", b)
+	out.Subject = w.deliverSubject
+	out.Header.Set(WatchIDHeader, w.id)
+	if err := globalNATSConn.PublishMsg(out); err != nil {
+		log.Error().Err(err).Str("watchId", w.id).Msg("Failed to deliver watch notification")
+	}
+}
+
+// cancel stops a watch and removes it from the registry. Does nothing if the watch does not exist.
+//
+// params:
+//   - id: The watch ID
+//
+// return:
+//   - *watch: The stopped watch, nil if it did not exist
+func (r *watchRegistry) cancel(id string) *watch {
+	r.mu.Lock()
+	w, ok := r.watches[id]
+	if ok {
+		delete(r.watches, id)
+		w.lease.Stop()
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	w.stop()
+	_ = w.cancelSub.Unsubscribe()
+	_ = w.renewSub.Unsubscribe()
+	return w
+}
+
+// notifyWatchClosed publishes an error status message to the deliver subject of a stopped watch.
+//
+// params:
+//   - w: The stopped watch
+//   - code: The status code
+//   - message: The error message
+func notifyWatchClosed(w *watch, code int, message string) {
+	out := newResponseMsg(code, message, nil)
+	out.Subject = w.deliverSubject
+	out.Header.Set(WatchIDHeader, w.id)
+	if err := globalNATSConn.PublishMsg(out); err != nil {
+		log.Error().Err(err).Str("watchId", w.id).Msg("Failed to notify watch client")
+	}
+}
+
+// StopWatches stops all watches served by this node, and tells their clients with a 503 message
+// on the deliver subject, so they can resume the watch on another node.
+// Does nothing if change data capture is disabled. Must be called before draining the NATS connection.
+func StopWatches() {
+	if globalWatches == nil {
+		return
+	}
+
+	globalWatches.mu.Lock()
+	ids := make([]string, 0, len(globalWatches.watches))
+	for id := range globalWatches.watches {
+		ids = append(ids, id)
+	}
+	globalWatches.mu.Unlock()
+
+	stopped := 0
+	for _, id := range ids {
+		// A watch expiring meanwhile was already notified
+		if w := globalWatches.cancel(id); w != nil {
+			notifyWatchClosed(w, ErrorCodeServiceUnavailable, "watch closed, node is shutting down")
+			stopped++
+		}
+	}
+	log.Info().Int("watches", stopped).Msg("Watches stopped")
+}
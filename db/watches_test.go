@@ -0,0 +1,242 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func newWatchRequestMsg(headers map[string]string) *nats.Msg {
+	msg := nats.NewMsg("nimbus.watch.create")
+	for k, v := range headers {
+		msg.Header.Set(k, v)
+	}
+	return msg
+}
+
+func TestParseWatchRequest(t *testing.T) {
+	msg := newWatchRequestMsg(map[string]string{
+		"bucketName":     "results",
+		"prefix":         "patient-1/",
+		"deliverSubject": "app.watch.results",
+		"includePayload": "true",
+		"startTime":      "1700000000000",
+	})
+
+	req, err := parseWatchRequest(msg, "nimbus")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if req.bucketName != "results" {
+		t.Errorf("Expected bucket 'results', got '%s'", req.bucketName)
+	}
+	if req.prefix != "patient-1/" {
+		t.Errorf("Expected prefix 'patient-1/', got '%s'", req.prefix)
+	}
+	if req.deliverSubject != "app.watch.results" {
+		t.Errorf("Expected deliver subject 'app.watch.results', got '%s'", req.deliverSubject)
+	}
+	if !req.includePayload {
+		t.Error("Expected includePayload to be true")
+	}
+	if !req.startTime.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("Expected start time %v, got %v", time.UnixMilli(1700000000000), req.startTime)
+	}
+	if !req.resumes() {
+		t.Error("Expected the watch to resume")
+	}
+}
+
+func TestParseWatchRequest_Defaults(t *testing.T) {
+	req, err := parseWatchRequest(newWatchRequestMsg(map[string]string{
+		"bucketName":     "results",
+		"deliverSubject": "app.watch.results",
+	}), "nimbus")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if req.prefix != "" {
+		t.Errorf("Expected empty prefix, got '%s'", req.prefix)
+	}
+	if req.includePayload {
+		t.Error("Expected includePayload to be false")
+	}
+	if req.resumes() {
+		t.Error("Expected the watch not to resume")
+	}
+}
+
+func TestParseWatchRequest_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"missing bucket", map[string]string{"deliverSubject": "app.watch"}},
+		{"missing deliver subject", map[string]string{"bucketName": "results"}},
+		{"wildcard deliver subject", map[string]string{"bucketName": "results", "deliverSubject": "app.*"}},
+		{"nimbus deliver subject", map[string]string{"bucketName": "results", "deliverSubject": "nimbus.shards.0.op"}},
+		{"invalid includePayload", map[string]string{"bucketName": "results", "deliverSubject": "app.watch", "includePayload": "maybe"}},
+		{"invalid startSequence", map[string]string{"bucketName": "results", "deliverSubject": "app.watch", "startSequence": "0"}},
+		{"invalid startTime", map[string]string{"bucketName": "results", "deliverSubject": "app.watch", "startTime": "yesterday"}},
+		{"both starts", map[string]string{"bucketName": "results", "deliverSubject": "app.watch", "startSequence": "5", "startTime": "1700000000000"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseWatchRequest(newWatchRequestMsg(tt.headers), "nimbus"); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestCheckDeliverSubject(t *testing.T) {
+	tests := []struct {
+		name           string
+		reply          string
+		deliverSubject string
+		tenantPrefix   string
+		expectForbid   bool
+	}{
+		{"requester inbox", "_INBOX.abc.1", "_INBOX.abc.watch", "", false},
+		{"requester inbox without token", "_INBOX.abc", "_INBOX.abc.watch", "", false},
+		{"tenant prefix", "_INBOX.abc.1", "acme.watches.orders", "acme.watches", false},
+		{"tenant prefix with trailing dot", "", "acme.watches.orders", "acme.watches.", false},
+		{"inbox of another client", "_INBOX.abc.1", "_INBOX.other.watch", "", true},
+		{"inbox itself", "_INBOX.abc.1", "_INBOX.abc", "", true},
+		{"other subject", "_INBOX.abc.1", "app.watch.results", "", true},
+		{"service subject", "_INBOX.abc.1", "billing.invoices.create", "acme.watches", true},
+		{"tenant prefix itself", "_INBOX.abc.1", "acme.watches", "acme.watches", true},
+		{"prefix of a token", "_INBOX.abc.1", "acme.watchesx.orders", "acme.watches", true},
+		{"custom inbox", "replies.abc.1", "replies.abc.watch", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDeliverSubject(tt.reply, tt.deliverSubject, tt.tenantPrefix)
+			if tt.expectForbid {
+				if !errors.Is(err, ErrForbidden) {
+					t.Errorf("Expected ErrForbidden, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Expected the deliver subject to be allowed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWatch_Matches(t *testing.T) {
+	w := &watch{bucketName: "results", prefix: "patient-1/"}
+
+	tests := []struct {
+		event    ChangeEvent
+		expected bool
+	}{
+		{ChangeEvent{Bucket: "results", Key: "patient-1/blood"}, true},
+		{ChangeEvent{Bucket: "results", Key: "patient-2/blood"}, false},
+		{ChangeEvent{Bucket: "orders", Key: "patient-1/blood"}, false},
+	}
+
+	for _, tt := range tests {
+		if matches := w.matches(tt.event); matches != tt.expected {
+			t.Errorf("Expected matches(%s/%s) to be %t, got %t", tt.event.Bucket, tt.event.Key, tt.expected, matches)
+		}
+	}
+}
+
+func TestStopWatches_Disabled(t *testing.T) {
+	// Must not panic when change data capture is disabled
+	StopWatches()
+}
+
+func TestWatchRegistry_LeaseExpiry(t *testing.T) {
+	for _, jetStream := range []bool{false, true} {
+		name := "core"
+		if jetStream {
+			name = "jetstream"
+		}
+		t.Run(name, func(t *testing.T) {
+			nc, js := runJetStreamServer(t)
+			cfg := &configurations.Config{}
+			cfg.NATS.SubjectPrefix = "nimbus"
+			cfg.Blob.BlobOperationTimeout = 5 * time.Second
+			cfg.CDC = configurations.CDCConfig{Enabled: true, SubjectPrefix: "nimbus.cdc", JetStream: jetStream, Stream: "NIMBUS_CDC", MaxAge: time.Hour, WatchLease: 200 * time.Millisecond}
+			previousConfig, previousConn := globalConfig, globalNATSConn
+			globalConfig, globalNATSConn = cfg, nc
+			defer func() {
+				globalConfig, globalNATSConn, globalCDC, globalWatches = previousConfig, previousConn, nil, nil
+			}()
+			InitializeCDC()
+			expiredBefore := globalWatches.expired.Value()
+
+			deliveries, err := nc.SubscribeSync("client.watch")
+			if err != nil {
+				t.Fatalf("SubscribeSync() failed: %v", err)
+			}
+			w, err := globalWatches.start(&watchRequest{bucketName: "results", deliverSubject: "client.watch"})
+			if err != nil {
+				t.Fatalf("start() failed: %v", err)
+			}
+
+			// Renewing keeps the watch past its lease
+			subscriptions := 0
+			for i := 0; i < 4; i++ {
+				time.Sleep(100 * time.Millisecond)
+				resp, err := nc.Request(w.renewSub.Subject, nil, time.Second)
+				if err != nil {
+					t.Fatalf("Renew request failed: %v", err)
+				}
+				if got := resp.Header.Get(StatusHeader); got != strconv.Itoa(SuccessCode) {
+					t.Fatalf("Expected %s header to be %d, got %q", StatusHeader, SuccessCode, got)
+				}
+				subscriptions = nc.NumSubscriptions()
+			}
+
+			// Without renewal, the watch expires and its client is told
+			msg, err := deliveries.NextMsg(2 * time.Second)
+			if err != nil {
+				t.Fatalf("Expected expiry message on the deliver subject, got %v", err)
+			}
+			if got := msg.Header.Get(StatusHeader); got != strconv.Itoa(ErrorCodeGone) {
+				t.Errorf("Expected %s header to be %d, got %q", StatusHeader, ErrorCodeGone, got)
+			}
+			if got := msg.Header.Get(WatchIDHeader); got != w.id {
+				t.Errorf("Expected %s header to be %s, got %s", WatchIDHeader, w.id, got)
+			}
+			if expired := globalWatches.expired.Value() - expiredBefore; expired != 1 {
+				t.Errorf("Expected 1 expired watch, got %d", expired)
+			}
+			if globalWatches.cancel(w.id) != nil {
+				t.Error("Expected expired watch to be removed from the registry")
+			}
+			// The change events, cancel and renew subscriptions of the watch are released
+			if got := nc.NumSubscriptions(); got != subscriptions-3 {
+				t.Errorf("Expected %d subscriptions once the watch expired, got %d", subscriptions-3, got)
+			}
+			if _, err := nc.Request(w.renewSub.Subject, nil, time.Second); err != nats.ErrNoResponders {
+				t.Errorf("Expected no responders to renew an expired watch, got %v", err)
+			}
+
+			if jetStream {
+				stream, err := js.Stream(context.Background(), "NIMBUS_CDC")
+				if err != nil {
+					t.Fatalf("Stream() failed: %v", err)
+				}
+				info, err := stream.Info(context.Background())
+				if err != nil {
+					t.Fatalf("Info() failed: %v", err)
+				}
+				if info.State.Consumers != 0 {
+					t.Errorf("Expected the consumer of the expired watch to be deleted, got %d consumers", info.State.Consumers)
+				}
+			}
+		})
+	}
+}
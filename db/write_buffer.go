@@ -0,0 +1,316 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"NimbusDb/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// globalWriteBuffer is the local disk write-behind buffer. Nil if the write buffer is disabled.
+	// It is set once during initialization and never modified.
+	globalWriteBuffer *writeBuffer
+)
+
+// errWriteBufferFull is returned when a write would take the pending writes of the buffer over their byte limit.
+var errWriteBufferFull = errors.New("write buffer full")
+
+// bufferShard is the segment log of a shard and its writes not yet uploaded, in append order.
+// Only the positions of the pending writes are kept in memory, their data is read back from the log.
+type bufferShard struct {
+	log *segmentLog
+	// mu guards pending, and keeps appends to the log and the queue in the same order.
+	mu      sync.Mutex
+	pending []recordRef
+	// notify wakes the uploader when a write is appended.
+	notify chan struct{}
+	// appended is the sequence of the last write appended, applied the sequence of the last write uploaded (or dropped).
+	appended atomic.Uint64
+	applied  atomic.Uint64
+}
+
+// writeBuffer acknowledges writes once they are appended and fsynced to a segment log on local disk,
+// and uploads them to blob storage in the background with one uploader per shard.
+// Writes of a shard are uploaded in append order, and at least once: the logs are replayed on startup.
+// Pending writes stay on disk until uploaded, reads and uploads read them back from the segment logs.
+// This type is thread-safe.
+type writeBuffer struct {
+	retryDelay time.Duration
+	opTimeout  time.Duration
+	write      blobWriteFunc
+	overlay    *writeOverlay
+	// usage holds the quota usage recorded for the writes appended by this run, reverted if they are dropped.
+	usage pendingUsage
+	// maxPendingBytes is the limit of pendingBytes, the data size of the writes not yet uploaded.
+	maxPendingBytes int64
+	pendingBytes    atomic.Int64
+	// shards is read-only after creation.
+	shards   map[uint16]*bufferShard
+	uploaded *metrics.Counter
+	failed   *metrics.Counter
+	dropped  *metrics.Counter
+}
+
+// StartWriteBuffer opens the segment logs of the write buffer if it is enabled, and starts the shard uploaders.
+// Writes left in the logs by a previous run are loaded first, so reads return them once the node serves requests,
+// even if blob storage is unavailable. Must be called after InitializeGlobals and before the shard handlers start.
+//
+// params:
+//   - ctx: The context that stops the uploaders when cancelled. Writes not uploaded by then are replayed on the next start.
+func StartWriteBuffer(ctx context.Context) {
+	cfg := globalConfig.WriteBuffer
+	if !cfg.Enabled {
+		return
+	}
+
+	b, err := newWriteBuffer(cfg, globalConfig.Blob.BlobOperationTimeout, globalConfig.ShardCount, globalBlobClient.WriteFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("dir", cfg.Dir).Msg("Failed to open the write buffer")
+	}
+	b.start(ctx)
+	globalWriteBuffer = b
+	log.Info().Str("dir", cfg.Dir).Int("replayed", b.overlay.size()).Msg("Write buffer enabled")
+}
+
+// newWriteBuffer opens the segment log of every shard and loads the writes that were not uploaded.
+//
+// params:
+//   - cfg: The write buffer configuration
+//   - opTimeout: The timeout of a single blob write
+//   - shardCount: The number of shards, each gets its own segment log and uploader
+//   - write: The function uploading buffered writes to blob storage
+//
+// return:
+//   - *writeBuffer: The write buffer, its uploaders are started with start
+//   - error: An error if a segment log could not be opened
+func newWriteBuffer(cfg configurations.WriteBufferConfig, opTimeout time.Duration, shardCount uint16, write blobWriteFunc) (*writeBuffer, error) {
+	b := &writeBuffer{
+		retryDelay:      cfg.RetryDelay,
+		opTimeout:       opTimeout,
+		write:           write,
+		overlay:         newWriteOverlay(),
+		maxPendingBytes: cfg.MaxPendingBytes,
+		shards:          make(map[uint16]*bufferShard, shardCount),
+		uploaded:        metrics.GetCounter("nimbus_write_buffer_uploaded_writes_total", nil),
+		failed:          metrics.GetCounter("nimbus_write_buffer_upload_errors_total", nil),
+		dropped:         metrics.GetCounter("nimbus_write_buffer_dropped_writes_total", nil),
+	}
+
+	for shardID := uint16(0); shardID < shardCount; shardID++ {
+		segLog, refs, err := openSegmentLog(filepath.Join(cfg.Dir, fmt.Sprintf("shard-%d", shardID)), cfg.SegmentSize)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", shardID, err)
+		}
+		s := &bufferShard{log: segLog, pending: refs, notify: make(chan struct{}, 1)}
+		for _, ref := range refs {
+			b.overlay.addRef(ref.bucketName, ref.fileName, overlayID(shardID, ref.seq), loadRecord(ref))
+			b.pendingBytes.Add(int64(ref.size))
+			s.appended.Store(ref.seq)
+		}
+		if len(refs) > 0 {
+			s.applied.Store(refs[0].seq - 1)
+		}
+		b.shards[shardID] = s
+	}
+
+	metrics.RegisterGaugeFunc("nimbus_write_buffer_pending_objects", nil, func() float64 {
+		return float64(b.overlay.size())
+	})
+	metrics.RegisterGaugeFunc("nimbus_write_buffer_pending_bytes", nil, func() float64 {
+		return float64(b.pendingBytes.Load())
+	})
+	return b, nil
+}
+
+// loadRecord returns the function reading the data of a buffered write back from its segment log.
+func loadRecord(ref recordRef) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		rec, err := readRecord(ref)
+		return rec.data, err
+	}
+}
+
+// overlayID returns the overlay ID of a buffered write, unique across shards.
+func overlayID(shardID uint16, seq uint64) string {
+	return fmt.Sprintf("%d-%d", shardID, seq)
+}
+
+// append appends a write to the segment log of its shard. Once it returns, the write is durable
+// and visible to reads through the overlay.
+// Writes are rejected while the writes not yet uploaded hold more than the configured bytes.
+//
+// params:
+//   - shardID: The shard of the write
+//   - bucketName: The bucket to write to
+//   - fileName: The file to write
+//   - data: The file content
+//   - usage: The usage change of the write, recorded for the bucket quota once appended and reverted if the write is dropped
+//
+// return:
+//   - error: errWriteBufferFull if the buffer is full, or an error if the write could not be appended and synced to disk.
+//     The write is then not uploaded.
+func (b *writeBuffer) append(shardID uint16, bucketName, fileName string, data []byte, usage quotaWrite) error {
+	s, ok := b.shards[shardID]
+	if !ok {
+		return fmt.Errorf("shard %d has no write buffer", shardID)
+	}
+	size := int64(len(data))
+	if b.pendingBytes.Add(size) > b.maxPendingBytes {
+		b.pendingBytes.Add(-size)
+		return fmt.Errorf("%w: %d bytes pending upload", errWriteBufferFull, b.pendingBytes.Load())
+	}
+
+	s.mu.Lock()
+	ref, err := s.log.append(bucketName, fileName, data)
+	if err != nil {
+		s.mu.Unlock()
+		b.pendingBytes.Add(-size)
+		return err
+	}
+	b.overlay.addRef(bucketName, fileName, overlayID(shardID, ref.seq), loadRecord(ref))
+	// Recorded before the uploader sees the write, which could drop it and revert it
+	b.usage.record(overlayID(shardID, ref.seq), bucketName, usage)
+	s.pending = append(s.pending, ref)
+	s.appended.Store(ref.seq)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// lookup returns the content of the latest buffered write of an object, read back from its segment log.
+// A nil write buffer (disabled) has no buffered writes.
+func (b *writeBuffer) lookup(bucketName, fileName string) ([]byte, bool, error) {
+	if b == nil {
+		return nil, false, nil
+	}
+	return b.overlay.get(bucketName, fileName)
+}
+
+// has reports whether an object has buffered writes. A nil write buffer has no buffered writes.
+func (b *writeBuffer) has(bucketName, fileName string) bool {
+	return b != nil && b.overlay.has(bucketName, fileName)
+}
+
+// waitFlushed waits until every write appended to the buffer of a shard so far has been uploaded to blob storage.
+//
+// params:
+//   - ctx: Context bounding the wait
+//   - shardID: The shard to wait for
+//
+// return:
+//   - error: An error if ctx expired first
+func (b *writeBuffer) waitFlushed(ctx context.Context, shardID uint16) error {
+	s, ok := b.shards[shardID]
+	if !ok {
+		return nil
+	}
+	target := s.appended.Load()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for s.applied.Load() < target {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// start starts the shard uploaders.
+func (b *writeBuffer) start(ctx context.Context) {
+	for shardID, s := range b.shards {
+		go b.runUploader(ctx, shardID, s)
+	}
+}
+
+// runUploader uploads the writes of a shard to blob storage, one at a time in append order, until ctx is cancelled.
+func (b *writeBuffer) runUploader(ctx context.Context, shardID uint16, s *bufferShard) {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-ctx.Done():
+			case <-s.notify:
+			}
+			continue
+		}
+		ref := s.pending[0]
+		s.mu.Unlock()
+
+		if !b.upload(ctx, shardID, ref) {
+			return
+		}
+
+		s.mu.Lock()
+		s.pending[0] = recordRef{}
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+		b.pendingBytes.Add(-int64(ref.size))
+		s.applied.Store(ref.seq)
+		if err := s.log.commit(ref.seq); err != nil {
+			// The write is uploaded again on the next start, which only creates an identical version
+			log.Warn().Err(err).Uint16("shardID", shardID).Msg("Failed to checkpoint the write buffer")
+		}
+	}
+}
+
+// upload reads a buffered write back from the segment log and uploads it to blob storage, retrying until it succeeds or ctx is cancelled.
+// Writes that can never succeed (invalid or missing bucket, or unreadable record) are dropped and published to the dead-letter subject,
+// and their usage is reverted.
+// Once uploaded, the write is published as a change event.
+//
+// return:
+//   - bool: False if ctx was cancelled before the write was uploaded or dropped
+func (b *writeBuffer) upload(ctx context.Context, shardID uint16, ref recordRef) bool {
+	id := overlayID(shardID, ref.seq)
+	rec, err := readRecord(ref)
+	if err != nil {
+		globalDeadLetters.publish(shardID, ref.bucketName, ref.fileName, nil, err)
+		b.overlay.remove(ref.bucketName, ref.fileName, id)
+		b.usage.revert(id)
+		b.dropped.Inc()
+		return true
+	}
+	for {
+		opCtx, cancel := context.WithTimeout(context.Background(), b.opTimeout)
+		versionID, err := b.write(opCtx, rec.bucketName, rec.fileName, rec.data)
+		cancel()
+
+		if err == nil {
+			b.overlay.remove(rec.bucketName, rec.fileName, id)
+			b.usage.applied(id)
+			b.uploaded.Inc()
+			writeApplied(shardID, rec.bucketName, rec.fileName, versionID, rec.data)
+			return true
+		}
+
+		if isPermanentWriteError(err) {
+			globalDeadLetters.publish(shardID, rec.bucketName, rec.fileName, rec.data, err)
+			b.overlay.remove(rec.bucketName, rec.fileName, id)
+			b.usage.revert(id)
+			b.dropped.Inc()
+			return true
+		}
+
+		b.failed.Inc()
+		log.Warn().Err(err).Uint16("shardID", shardID).Str("bucketName", rec.bucketName).Str("fileName", rec.fileName).Dur("retryDelay", b.retryDelay).Msg("Failed to upload buffered write, retrying")
+		if !sleepCtx(ctx, b.retryDelay) {
+			return false
+		}
+	}
+}
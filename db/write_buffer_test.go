@@ -0,0 +1,174 @@
+package db
+
+import (
+	"NimbusDb/configurations"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testWriteBufferConfig(dir string) configurations.WriteBufferConfig {
+	return configurations.WriteBufferConfig{
+		Enabled:         true,
+		Dir:             dir,
+		SegmentSize:     1 << 20,
+		RetryDelay:      10 * time.Millisecond,
+		MaxPendingBytes: 1 << 20,
+	}
+}
+
+func newTestWriteBuffer(t *testing.T, dir string, store *fakeBlobWrites) *writeBuffer {
+	t.Helper()
+	b, err := newWriteBuffer(testWriteBufferConfig(dir), 5*time.Second, 2, store.write)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() {
+		for _, s := range b.shards {
+			_ = s.log.close()
+		}
+	})
+	return b
+}
+
+// flushTestWriteBuffer waits until the writes of a shard are uploaded.
+func flushTestWriteBuffer(t *testing.T, b *writeBuffer, shardID uint16) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.waitFlushed(ctx, shardID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestWriteBuffer_UploadsInOrderWithRetries(t *testing.T) {
+	store := &fakeBlobWrites{failures: 2}
+	b := newTestWriteBuffer(t, t.TempDir(), store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.start(ctx)
+
+	for i, data := range []string{"1", "2", "3"} {
+		if err := b.append(1, "orders", "o", []byte(data), quotaWrite{}); err != nil {
+			t.Fatalf("Expected no error on append %d, got %v", i, err)
+		}
+	}
+	if data, ok, _ := b.lookup("orders", "o"); !ok || string(data) != "3" {
+		t.Errorf("Expected pending read to return 3, got %q (found %t)", data, ok)
+	}
+
+	flushTestWriteBuffer(t, b, 1)
+	applied := store.applied()
+	if len(applied) != 3 || applied[0] != "orders/o=1" || applied[2] != "orders/o=3" {
+		t.Errorf("Expected writes applied in order, got %v", applied)
+	}
+	if _, ok, _ := b.lookup("orders", "o"); ok {
+		t.Error("Expected no pending write once uploaded")
+	}
+}
+
+func TestWriteBuffer_RevertsUsageOfDroppedWrites(t *testing.T) {
+	q := setTestQuota(t)
+	store := &fakeBlobWrites{missingBucket: "orders"}
+	b := newTestWriteBuffer(t, t.TempDir(), store)
+
+	if err := b.append(0, "orders", "a", []byte("1234"), quotaWrite{bytes: 4, objects: 1}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := q.bytes.Load(); got != 4 {
+		t.Errorf("Expected the appended write to be recorded as 4 bytes, got %d", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.start(ctx)
+	flushTestWriteBuffer(t, b, 0)
+
+	if got, objects := q.bytes.Load(), q.objects.Load(); got != 0 || objects != 0 {
+		t.Errorf("Expected the usage of the dropped write to be reverted, got %d bytes and %d objects", got, objects)
+	}
+}
+
+func TestWriteBuffer_ReplaysWritesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := &fakeBlobWrites{}
+
+	// Not started: blob storage is unreachable before the node stops
+	b := newTestWriteBuffer(t, dir, store)
+	if err := b.append(0, "orders", "a", []byte("1"), quotaWrite{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := b.append(0, "orders", "b", []byte("2"), quotaWrite{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, s := range b.shards {
+		_ = s.log.close()
+	}
+
+	b = newTestWriteBuffer(t, dir, store)
+	if data, ok, _ := b.lookup("orders", "b"); !ok || string(data) != "2" {
+		t.Errorf("Expected replayed write to be readable before upload, got %q (found %t)", data, ok)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.start(ctx)
+	flushTestWriteBuffer(t, b, 0)
+
+	applied := store.applied()
+	if len(applied) != 2 || applied[0] != "orders/a=1" || applied[1] != "orders/b=2" {
+		t.Errorf("Expected replayed writes applied in order, got %v", applied)
+	}
+}
+
+func TestWriteBuffer_RejectsWritesWhenFull(t *testing.T) {
+	store := &fakeBlobWrites{}
+	cfg := testWriteBufferConfig(t.TempDir())
+	cfg.MaxPendingBytes = 8
+	b, err := newWriteBuffer(cfg, 5*time.Second, 1, store.write)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer b.shards[0].log.close()
+
+	if err := b.append(0, "orders", "a", []byte("12345"), quotaWrite{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := b.append(0, "orders", "b", []byte("6789"), quotaWrite{}); !errors.Is(err, errWriteBufferFull) {
+		t.Errorf("Expected errWriteBufferFull, got %v", err)
+	}
+	if b.has("orders", "b") {
+		t.Error("Expected the rejected write not to be pending")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.start(ctx)
+	flushTestWriteBuffer(t, b, 0)
+	if got := b.pendingBytes.Load(); got != 0 {
+		t.Errorf("Expected no pending bytes once uploaded, got %d", got)
+	}
+	if err := b.append(0, "orders", "b", []byte("6789"), quotaWrite{}); err != nil {
+		t.Errorf("Expected the write to be accepted once the buffer drained, got %v", err)
+	}
+}
+
+func TestWriteBuffer_ReadsPendingWritesFromDisk(t *testing.T) {
+	b := newTestWriteBuffer(t, t.TempDir(), &fakeBlobWrites{})
+	data := []byte("logged")
+	if err := b.append(0, "orders", "o", data, quotaWrite{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The buffer keeps no reference to the data of the request
+	copy(data, "reused")
+
+	if got, ok, err := b.lookup("orders", "o"); err != nil || !ok || string(got) != "logged" {
+		t.Errorf("Expected pending read to return logged, got %q (found %t, %v)", got, ok, err)
+	}
+}
+
+func TestWriteBuffer_NilLookup(t *testing.T) {
+	var b *writeBuffer
+	if _, ok, _ := b.lookup("orders", "o"); ok {
+		t.Error("Expected a nil write buffer to have no pending writes")
+	}
+}
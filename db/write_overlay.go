@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// pendingWrite is an acknowledged write not yet applied to blob storage.
+// Its content is either kept in data, or read back with load from where the write is stored.
+type pendingWrite struct {
+	id   string
+	data []byte
+	// load reads the content of the write. It returns an error wrapping os.ErrNotExist
+	// if the write was applied and its stored copy deleted in the meantime.
+	load func() ([]byte, error)
+}
+
+// writeOverlay indexes acknowledged writes that are not yet applied to blob storage (logged in the write-ahead log
+// or the write buffer, or queued as async writes), so reads see them before they reach blob storage.
+// Each object keeps its pending writes in append order, the last one is the current content.
+// This type is thread-safe.
+type writeOverlay struct {
+	mu      sync.RWMutex
+	pending map[string][]pendingWrite
+}
+
+// newWriteOverlay creates an empty overlay.
+func newWriteOverlay() *writeOverlay {
+	return &writeOverlay{pending: make(map[string][]pendingWrite)}
+}
+
+// overlayKey returns the key of an object in the overlay.
+func overlayKey(bucketName, fileName string) string {
+	return bucketName + "/" + fileName
+}
+
+// add records a pending write. Must be called before the write is handed over (appended to the log or queued),
+// so it can never be applied before it is in the overlay.
+func (o *writeOverlay) add(bucketName, fileName, id string, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	k := overlayKey(bucketName, fileName)
+	o.pending[k] = append(o.pending[k], pendingWrite{id: id, data: data})
+}
+
+// addRef records a pending write whose content is not kept in memory but read back with load when the object is read.
+// Like add, it must be called before the write is handed over.
+func (o *writeOverlay) addRef(bucketName, fileName, id string, load func() ([]byte, error)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	k := overlayKey(bucketName, fileName)
+	o.pending[k] = append(o.pending[k], pendingWrite{id: id, load: load})
+}
+
+// release drops the in-memory content of a pending write, it is read back with load from then on.
+// Does nothing if the write is not pending anymore.
+func (o *writeOverlay) release(bucketName, fileName, id string, load func() ([]byte, error)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	writes := o.pending[overlayKey(bucketName, fileName)]
+	for i := range writes {
+		if writes[i].id == id {
+			writes[i].data, writes[i].load = nil, load
+			return
+		}
+	}
+}
+
+// remove forgets a pending write, once applied to blob storage or if it could not be handed over.
+// Other pending writes of the object are kept.
+func (o *writeOverlay) remove(bucketName, fileName, id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	k := overlayKey(bucketName, fileName)
+	writes := o.pending[k]
+	for i, w := range writes {
+		if w.id == id {
+			writes = append(writes[:i], writes[i+1:]...)
+			break
+		}
+	}
+	if len(writes) == 0 {
+		delete(o.pending, k)
+		return
+	}
+	o.pending[k] = writes
+}
+
+// get returns the content of the latest pending write of an object, reading it back if it is not kept in memory.
+//
+// return:
+//   - []byte: The content of the latest pending write
+//   - bool: False if the object has no pending write
+//   - error: An error if the content of the write could not be read back
+func (o *writeOverlay) get(bucketName, fileName string) ([]byte, bool, error) {
+	o.mu.RLock()
+	writes := o.pending[overlayKey(bucketName, fileName)]
+	if len(writes) == 0 {
+		o.mu.RUnlock()
+		return nil, false, nil
+	}
+	w := writes[len(writes)-1]
+	o.mu.RUnlock()
+
+	if w.load == nil {
+		return w.data, true, nil
+	}
+	data, err := w.load()
+	if errors.Is(err, os.ErrNotExist) {
+		// Applied since, blob storage has it
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// has reports whether an object has pending writes.
+func (o *writeOverlay) has(bucketName, fileName string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return len(o.pending[overlayKey(bucketName, fileName)]) > 0
+}
+
+// size returns the number of objects with pending writes.
+func (o *writeOverlay) size() int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return len(o.pending)
+}
@@ -1,6 +1,7 @@
 package health
 
 import (
+	"NimbusDb/metrics"
 	"context"
 	"fmt"
 	"net/http"
@@ -15,6 +16,8 @@ const (
 	HealthPath = "/health"
 	// ReadinessPath is the path for the readiness endpoint
 	ReadinessPath = "/ready"
+	// MetricsPath is the path for the metrics endpoint (Prometheus text format)
+	MetricsPath = "/metrics"
 )
 
 var (
@@ -40,6 +43,7 @@ func IsReady() bool {
 }
 
 // StartHealthServer starts a lightweight HTTP server for health and readiness checks.
+// The same server exposes the node metrics on MetricsPath.
 // The server runs in a separate goroutine and listens on the specified port.
 //
 // params:
@@ -49,6 +53,7 @@ func StartHealthServer(ctx context.Context, port int) {
 	mux := http.NewServeMux()
 	mux.HandleFunc(HealthPath, handleHealth)
 	mux.HandleFunc(ReadinessPath, handleReadiness)
+	mux.HandleFunc(MetricsPath, metrics.Handler)
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
@@ -22,6 +22,12 @@ import (
 var banner string
 
 func main() {
+	// Run the backup, restore or point-in-time restore command instead of the server (stdout is kept for reports)
+	if len(os.Args) > 1 && configurations.IsBackupCommand(os.Args[1]) {
+		runBackupCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	// Print ASCII art banner
 	fmt.Print(banner)
 
@@ -54,28 +60,64 @@ func main() {
 	// setup NATS client
 	nc := connectNATS(cfg)
 
-	// setup blob client
+	// setup blob storage of the configured provider
 	ctx := context.Background()
-	blobClient, err := blob.NewClient(ctx, cfg)
+	blobClient, err := blob.NewStorage(ctx, cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create blob client")
 	}
 
-	db.InitializeGlobals(cfg, nc, blobClient)
-	systemSubscriptions := db.StartSystemHandlers()
+	// create configured buckets and repair any drift
+	provisionBuckets(ctx, cfg, blobClient)
+
+	// setup the replica blob client if replication is enabled
+	var replicaClient blob.Storage
+	if cfg.Blob.Replica.Enabled() {
+		replicaClient, err = blob.NewReplicaClient(ctx, cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create replica blob client")
+		}
+	}
 
-	var shardHandlers []*db.ShardHandlerInfo
+	db.InitializeGlobals(cfg, nc, blobClient)
 
+	// the shards owned by this node
 	switch args.GetMode() {
 	case configurations.ModeSingle:
 		db.InitializeSingleModeState()
-		shardHandlers = db.StartShardHandlers()
 	case configurations.ModeDistributed:
 		log.Fatal().Msg("Distributed mode is not supported yet")
 	default:
 		log.Fatal().Msgf("Invalid mode: %s", args.GetMode())
 	}
 
+	db.InitializeCDC()
+	db.InitializeWAL()
+	systemSubscriptions := db.StartSystemHandlers()
+
+	// Create context for graceful shutdown
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+
+	// count bucket usage for quotas before serving requests
+	db.StartQuotaRefresher(shutdownCtx)
+
+	// replicate applied writes to the replica endpoint, and catch up with the writes it missed
+	db.StartReplication(shutdownCtx, replicaClient)
+
+	// apply writes left in the write-ahead log by a previous run before serving requests
+	db.StartWALFlushers(shutdownCtx)
+
+	// load writes left in the write buffer by a previous run before serving requests, they are uploaded in the background
+	db.StartWriteBuffer(shutdownCtx)
+
+	shardHandlers := db.StartShardHandlers()
+
+	// verify the objects and settings of the buckets of the owned shards in the background
+	db.StartScrubber(shutdownCtx)
+
+	// apply the version cleanup rules the blob provider does not apply itself, to the buckets of the owned shards
+	db.StartVersionCleanup(shutdownCtx)
+
 	// Collect all subscriptions for graceful shutdown
 	subscriptions := make([]*nats.Subscription, 0, len(systemSubscriptions)+len(shardHandlers))
 	subscriptions = append(subscriptions, systemSubscriptions...)
@@ -83,9 +125,6 @@ func main() {
 		subscriptions = append(subscriptions, handler.Subscription)
 	}
 
-	// Create context for graceful shutdown
-	shutdownCtx, cancel := context.WithCancel(context.Background())
-
 	// Start health check server (port is set in config, defaults to 8080)
 	health.StartHealthServer(shutdownCtx, cfg.HealthPort)
 
@@ -106,6 +145,9 @@ func main() {
 	// Cancel context to trigger health server shutdown
 	cancel()
 
+	// Stop watches so their clients can resume them on another node
+	db.StopWatches()
+
 	// Drain NATS connection and unsubscribe from all subscriptions
 	drainNats(nc, subscriptions, shardHandlers, cfg)
 
@@ -131,6 +173,37 @@ func connectNATS(cfg *configurations.Config) *nats.Conn {
 	return nc
 }
 
+// provisionBuckets creates the configured buckets and repairs their versioning and lifecycle rules if they have drifted.
+// Exits the process if a bucket cannot be provisioned, since writes to it would not be recoverable.
+//
+// params:
+//   - ctx: Context for the operation
+//   - cfg: The application configuration containing the buckets to provision
+//   - blobClient: The blob storage used to provision the buckets
+func provisionBuckets(ctx context.Context, cfg *configurations.Config, blobClient blob.Storage) {
+	for _, bucketName := range cfg.Buckets {
+		report, err := blobClient.ProvisionBucket(ctx, bucketName)
+		if err != nil {
+			log.Fatal().Err(err).Str("bucket", bucketName).Msg("Failed to provision bucket")
+		}
+
+		switch {
+		case report.Created:
+			log.Info().Str("bucket", bucketName).Msg("Created bucket with versioning and lifecycle rules")
+		case report.Changed():
+			event := log.Warn().Str("bucket", bucketName)
+			if report.VersioningRepaired() {
+				event = event.Str("previousVersioning", report.PreviousVersioning)
+			}
+			event.Bool("versioningRepaired", report.VersioningRepaired()).
+				Strs("repairedLifecycleRules", report.RepairedLifecycleRules).
+				Msg("Repaired bucket settings drift")
+		default:
+			log.Debug().Str("bucket", bucketName).Msg("Bucket is up to date")
+		}
+	}
+}
+
 // drainNats gracefully shuts down NATS by unsubscribing from all subscriptions,
 // waiting for in-flight messages to complete, closing channels, and then draining the connection with a timeout.
 func drainNats(nc *nats.Conn, subscriptions []*nats.Subscription, shardHandlers []*db.ShardHandlerInfo, cfg *configurations.Config) {
@@ -146,12 +219,29 @@ func drainNats(nc *nats.Conn, subscriptions []*nats.Subscription, shardHandlers
 	log.Info().Msg("Waiting for in-flight messages to complete...")
 	time.Sleep(cfg.NATS.ShutdownGracePeriod)
 
-	// Now it's safe to close all shard handler channels
-	log.Info().Msg("Closing shard handler channels...")
+	// Now it's safe to close all shard handler queues
+	log.Info().Msg("Closing shard handler queues...")
 	for _, handler := range shardHandlers {
-		close(handler.Channel)
+		handler.CloseQueue()
 	}
 
+	// Wait for the handlers to finish their queued requests, which can still queue async writes
+	log.Info().Msg("Waiting for shard handlers to exit...")
+	handlersDeadline := time.After(cfg.NATS.NatsDrainTimeout)
+waitHandlers:
+	for _, handler := range shardHandlers {
+		select {
+		case <-handler.Done():
+		case <-handlersDeadline:
+			log.Warn().Dur("timeout", cfg.NATS.NatsDrainTimeout).Msg("Timed out waiting for shard handlers to exit")
+			break waitHandlers
+		}
+	}
+
+	// Apply the queued async writes, failed ones are published to the dead-letter subject before NATS is drained
+	log.Info().Msg("Applying queued async writes...")
+	db.DrainAsyncWrites(cfg.NATS.NatsDrainTimeout)
+
 	// Drain the NATS connection to allow in-flight messages to complete
 	// Use a timeout to prevent hanging indefinitely
 	log.Info().Msg("Draining NATS connection...")
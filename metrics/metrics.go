@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Labels holds the label names and values of a metric series.
+type Labels map[string]string
+
+// Counter is a monotonically increasing metric.
+// This type is thread-safe.
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n.
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// Gauge is a metric that can go up and down.
+// This type is thread-safe.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set sets the gauge to the given value.
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// series is a single metric series (name + labels) in the registry.
+type series struct {
+	name      string
+	labels    string
+	counter   *Counter
+	gauge     *Gauge
+	gaugeFunc func() float64
+}
+
+var (
+	// mu guards registry.
+	mu sync.RWMutex
+	// registry holds all metric series by their name and rendered labels.
+	registry = make(map[string]*series)
+)
+
+// GetCounter returns the counter for the given name and labels, creating it on first use.
+// Callers on hot paths should keep the returned counter instead of looking it up per event.
+//
+// params:
+//   - name: The metric name (e.g. nimbus_shard_requests_shed_total)
+//   - labels: The labels of the series. Can be nil.
+//
+// return:
+//   - *Counter: The counter for the series
+func GetCounter(name string, labels Labels) *Counter {
+	s := getOrCreate(name, labels, func(s *series) { s.counter = &Counter{} })
+	if s.counter == nil {
+		log.Fatal().Str("metric", name).Msg("Metric is already registered with a different type")
+	}
+	return s.counter
+}
+
+// GetGauge returns the gauge for the given name and labels, creating it on first use.
+//
+// params:
+//   - name: The metric name
+//   - labels: The labels of the series. Can be nil.
+//
+// return:
+//   - *Gauge: The gauge for the series
+func GetGauge(name string, labels Labels) *Gauge {
+	s := getOrCreate(name, labels, func(s *series) { s.gauge = &Gauge{} })
+	if s.gauge == nil {
+		log.Fatal().Str("metric", name).Msg("Metric is already registered with a different type")
+	}
+	return s.gauge
+}
+
+// RegisterGaugeFunc registers a gauge whose value is computed by fn every time metrics are collected.
+// Registering the same name and labels again replaces the previous function.
+//
+// params:
+//   - name: The metric name
+//   - labels: The labels of the series. Can be nil.
+//   - fn: The function returning the current value. Must be thread-safe and cheap.
+func RegisterGaugeFunc(name string, labels Labels, fn func() float64) {
+	s := getOrCreate(name, labels, func(s *series) { s.gaugeFunc = fn })
+	mu.Lock()
+	s.gaugeFunc = fn
+	mu.Unlock()
+}
+
+// getOrCreate returns the series for name and labels, initializing it with init if it does not exist yet.
+func getOrCreate(name string, labels Labels, init func(s *series)) *series {
+	rendered := renderLabels(labels)
+	key := name + rendered
+
+	mu.RLock()
+	s, ok := registry[key]
+	mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if s, ok = registry[key]; ok {
+		return s
+	}
+	s = &series{name: name, labels: rendered}
+	init(s)
+	registry[key] = s
+	return s
+}
+
+// renderLabels renders labels in the Prometheus text format, sorted by label name.
+func renderLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", name, labels[name])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// WritePrometheus writes all registered metrics to w in the Prometheus text exposition format.
+//
+// params:
+//   - w: The writer to write the metrics to
+//
+// return:
+//   - error: An error if writing failed
+func WritePrometheus(w io.Writer) error {
+	mu.RLock()
+	all := make([]*series, 0, len(registry))
+	for _, s := range registry {
+		all = append(all, s)
+	}
+	mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].labels < all[j].labels
+	})
+
+	lastName := ""
+	for _, s := range all {
+		metricType := "gauge"
+		if s.counter != nil {
+			metricType = "counter"
+		}
+		if s.name != lastName {
+			if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", s.name, metricType); err != nil {
+				return err
+			}
+			lastName = s.name
+		}
+
+		var err error
+		switch {
+		case s.counter != nil:
+			_, err = fmt.Fprintf(w, "%s%s %d\n", s.name, s.labels, s.counter.Value())
+		case s.gauge != nil:
+			_, err = fmt.Fprintf(w, "%s%s %g\n", s.name, s.labels, s.gauge.Value())
+		default:
+			mu.RLock()
+			fn := s.gaugeFunc
+			mu.RUnlock()
+			_, err = fmt.Fprintf(w, "%s%s %g\n", s.name, s.labels, fn())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves all registered metrics in the Prometheus text exposition format.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	if err := WritePrometheus(w); err != nil {
+		log.Error().Err(err).Msg("Failed to write metrics response")
+	}
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGetCounter_SameSeries(t *testing.T) {
+	c1 := GetCounter("test_same_series_total", Labels{"shard": "1"})
+	c2 := GetCounter("test_same_series_total", Labels{"shard": "1"})
+	if c1 != c2 {
+		t.Error("GetCounter() should return the same counter for the same name and labels")
+	}
+
+	c3 := GetCounter("test_same_series_total", Labels{"shard": "2"})
+	if c1 == c3 {
+		t.Error("GetCounter() should return different counters for different labels")
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	GetCounter("test_write_total", Labels{"shard": "3", "reason": "queue_full"}).Add(2)
+	GetGauge("test_write_gauge", nil).Set(1.5)
+	RegisterGaugeFunc("test_write_func", Labels{"shard": "3"}, func() float64 { return 7 })
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus() failed: %v", err)
+	}
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE test_write_total counter\n",
+		`test_write_total{reason="queue_full",shard="3"} 2` + "\n",
+		"# TYPE test_write_gauge gauge\n",
+		"test_write_gauge 1.5\n",
+		`test_write_func{shard="3"} 7` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected output to contain %q, got:\n%s", e, out)
+		}
+	}
+}